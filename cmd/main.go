@@ -1,23 +1,51 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/probablysamir/chunk-store/internal/bench"
+	"github.com/probablysamir/chunk-store/internal/catalog"
 	"github.com/probablysamir/chunk-store/internal/chunker"
 	"github.com/probablysamir/chunk-store/internal/cloudstorage"
 	"github.com/probablysamir/chunk-store/internal/config"
 	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+	"github.com/probablysamir/chunk-store/internal/server"
 	"golang.org/x/term"
 )
 
 // parseCloudProviders converts comma-separated provider string to slice
 func parseCloudProviders(providersStr string) []config.CloudProvider {
+	providers := parseProviderList(providersStr)
+	if len(providers) == 0 {
+		providers = []config.CloudProvider{config.GoogleDrive}
+	}
+
+	return providers
+}
+
+// parseProviderList converts a comma-separated provider string to a slice,
+// warning about and skipping unknown names
+func parseProviderList(providersStr string) []config.CloudProvider {
 	if providersStr == "" {
-		return []config.CloudProvider{config.GoogleDrive}
+		return nil
 	}
 
 	providerNames := strings.Split(providersStr, ",")
@@ -36,146 +64,1714 @@ func parseCloudProviders(providersStr string) []config.CloudProvider {
 			providers = append(providers, config.MEGACloud)
 		case "ipfs":
 			providers = append(providers, config.IPFS)
+		case "webdav", "nextcloud":
+			providers = append(providers, config.WebDAV)
 		default:
 			fmt.Printf("Warning: Unknown provider '%s', ignoring\n", name)
 		}
 	}
 
-	if len(providers) == 0 {
-		providers = []config.CloudProvider{config.GoogleDrive}
+	return providers
+}
+
+// buildCloudStrategy creates the distribution strategy for the selected providers
+// using the replication and load balancing settings from the configuration
+func buildCloudStrategy(providersStr string, cfg *config.Config) cloudstorage.CloudDistributionStrategy {
+	providers := parseCloudProviders(providersStr)
+	strategy := cloudstorage.CustomCloudStrategyWithAccounts(providers, len(cfg.GetEnabledGoogleDriveAccounts()))
+	strategy.ReplicationCount = cfg.CloudConfig.ReplicationCount
+	strategy.LoadBalancing = cfg.CloudConfig.LoadBalancing
+	strategy.Seed = cfg.CloudConfig.LoadBalancingSeed
+	strategy.Placement = cfg.CloudConfig.Placement
+	strategy.Weights = cfg.CloudConfig.ProviderWeights
+	return strategy
+}
+
+// splitChunkSize returns the configured chunk size, or chunker.AutoChunkSize when it is 0 ("auto")
+func splitChunkSize(cfg *config.Config) int64 {
+	if cfg.ChunkConfig.ChunkSize == 0 {
+		return chunker.AutoChunkSize
 	}
+	return cfg.ChunkConfig.ChunkSize
+}
 
-	return providers
+// Exit codes, so scripts can tell failure categories apart
+const (
+	exitFailure   = 1 // Any other failure
+	exitConfig    = 2 // Invalid flags or flag combinations, or settings that don't match the manifest
+	exitAuth      = 3 // Wrong password, or cloud credentials rejected or misconfigured
+	exitNetwork   = 4 // Cloud provider unreachable or unavailable
+	exitIntegrity = 5 // Missing chunk, or data that doesn't match its hash or can't be decrypted
+)
+
+// exitCode maps an error to the exit code of its category
+func exitCode(err error) int {
+	var urlErr *url.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, encryption.ErrSchemeMismatch):
+		return exitConfig
+	case errors.Is(err, encryption.ErrIncorrectPassword), errors.Is(err, cloudstorage.ErrAuthFailed), errors.Is(err, cloudstorage.ErrBadCredentials):
+		return exitAuth
+	case errors.Is(err, manifest.ErrHashMismatch), errors.Is(err, manifest.ErrChunkMissing), errors.Is(err, encryption.ErrDecryptFailed), errors.Is(err, manifest.ErrBadIndex):
+		return exitIntegrity
+	case errors.Is(err, cloudstorage.ErrProviderUnavailable), errors.As(err, &urlErr), errors.As(err, &opErr):
+		return exitNetwork
+	default:
+		return exitFailure
+	}
+}
+
+// fail logs msg and err and exits with the code for err's category
+func fail(msg string, err error) {
+	exitWith(exitCode(err), msg, err)
+}
+
+// heldLock is the manifest lock held by this run, if any
+var heldLock *manifest.Lock
+
+// runReport records the chunks this run uploads or downloads for -report, if asked for
+var (
+	runReport     *cloudstorage.Report
+	runReportPath string
+)
+
+// saveReport writes runReport, also when the run fails
+func saveReport() {
+	if runReport == nil {
+		return
+	}
+	if err := runReport.Save(runReportPath); err != nil {
+		log.Printf("Warning: failed to write report: %v", err)
+		return
+	}
+	fmt.Printf("Report written to %s\n", runReportPath)
+}
+
+// exitWith logs v, writes the report, releases the manifest lock and exits with code
+func exitWith(code int, v ...any) {
+	log.Print(v...)
+	saveReport()
+	if heldLock != nil {
+		heldLock.Release()
+	}
+	os.Exit(code)
+}
+
+// printProviders prints which providers have a working client and how many accounts each has
+func printProviders(cfg *config.Config) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tIMPLEMENTED\tACCOUNTS (enabled/configured)")
+	for _, provider := range cloudstorage.KnownProviders() {
+		implemented := "planned"
+		if cloudstorage.IsImplemented(provider) {
+			implemented = "yes"
+		}
+		enabled, configured := cfg.GetAccountCounts(provider)
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\n", provider, implemented, enabled, configured)
+	}
+	w.Flush()
+}
+
+// exportChecksums writes the manifest's chunk checksums to outPath, or stdout when empty
+func exportChecksums(manifestPath, outPath, format string) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	if outPath == "" {
+		return manifest.ExportChecksums(m, os.Stdout, format)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = manifest.ExportChecksums(m, f, format)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checksums written to %s\n", outPath)
+	return nil
+}
+
+// exportRecipe writes a recipe for restoring the file of the manifest at
+// manifestPath with curl and openssl to outPath, with public links to its
+// chunks from the cloud
+func exportRecipe(manifestPath, outPath, format, providers string, cfg *config.Config) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Connect only once the manifest turns out to be restorable by a recipe
+	var uploader *cloudstorage.CloudUploader
+	links := func(c manifest.ChunkInfo) ([]string, error) {
+		if uploader == nil {
+			if uploader, err = cloudstorage.CreateCloudUploader(buildCloudStrategy(providers, cfg), cfg); err != nil {
+				return nil, fmt.Errorf("cloud setup failed: %w", err)
+			}
+			fmt.Println("Sharing chunks...")
+		}
+		return uploader.ChunkLinks(c)
+	}
+	if err := manifest.ExportRecipe(m, f, format, links); err != nil {
+		f.Close()
+		os.Remove(outPath)
+		return err
+	}
+	fmt.Printf("Recipe written to %s\n", outPath)
+	return nil
+}
+
+// manifestInfo is the summary printed by -mode info
+type manifestInfo struct {
+	OriginalName     string                    `json:"original_name"`
+	FileHash         string                    `json:"file_hash,omitempty"`
+	HashAlgo         string                    `json:"hash_algo"`
+	MerkleRoot       string                    `json:"merkle_root,omitempty"`
+	MerkleValid      *bool                     `json:"merkle_valid,omitempty"` // Whether the chunk hashes still match MerkleRoot
+	CreatedTime      string                    `json:"created_time"`
+	ToolVersion      string                    `json:"tool_version,omitempty"`
+	Compatibility    string                    `json:"compatibility_warning,omitempty"`
+	BaseOffset       int64                     `json:"base_offset,omitempty"`
+	FileSize         int64                     `json:"file_size,omitempty"`
+	ChunkSize        int64                     `json:"chunk_size,omitempty"`
+	NumChunks        int                       `json:"num_chunks,omitempty"`
+	ChunkingMode     string                    `json:"chunking_mode,omitempty"`
+	ChunkNaming      string                    `json:"chunk_naming,omitempty"`
+	StripMetadata    bool                      `json:"strip_metadata,omitempty"`
+	DistributionMode string                    `json:"distribution_mode"`
+	CloudFolders     []manifest.CloudFolder    `json:"cloud_folders,omitempty"`
+	Tags             map[string]string         `json:"tags,omitempty"`
+	Compression      *chunker.CompressionStats `json:"compression,omitempty"`
+	ErasureData      int                       `json:"erasure_data_shards,omitempty"`
+	ErasureParity    int                       `json:"erasure_parity_shards,omitempty"`
+	ParityChunks     int                       `json:"parity_chunks,omitempty"`
+	chunker.StatsResult
+}
+
+// printInfo prints a summary of the manifest at manifestPath, as JSON if asJSON is set
+func printInfo(manifestPath string, asJSON bool) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	info := manifestInfo{
+		OriginalName:     m.OriginalName,
+		FileHash:         m.FileHash,
+		HashAlgo:         m.HashAlgo,
+		MerkleRoot:       m.MerkleRoot,
+		CreatedTime:      m.CreatedTime,
+		ToolVersion:      m.ToolVersion,
+		Compatibility:    m.CompatibilityWarning(),
+		BaseOffset:       m.BaseOffset,
+		FileSize:         m.FileSize,
+		ChunkSize:        m.ChunkSize,
+		NumChunks:        m.NumChunks,
+		ChunkingMode:     m.ChunkingMode,
+		ChunkNaming:      m.ChunkNaming,
+		StripMetadata:    m.StripMetadata,
+		DistributionMode: m.DistributionMode,
+		CloudFolders:     m.CloudFolders,
+		Tags:             m.Tags,
+		StatsResult:      chunker.ManifestStats(m),
+	}
+	if info.HashAlgo == "" {
+		info.HashAlgo = manifest.HashSHA256
+	}
+	if m.MerkleRoot != "" {
+		valid := manifest.VerifyMerkle(m) == nil
+		info.MerkleValid = &valid
+	}
+	if stats := chunker.Compression(m); stats.Chunks > 0 {
+		info.Compression = &stats
+	}
+	if m.Erasure != nil {
+		info.ErasureData, info.ErasureParity = m.Erasure.DataShards, m.Erasure.ParityShards
+		info.ParityChunks = len(m.Erasure.Parity)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if info.StripMetadata {
+		fmt.Fprintln(w, "File:\t(name and timestamps stripped)")
+	} else {
+		fmt.Fprintf(w, "File:\t%s\n", info.OriginalName)
+		fmt.Fprintf(w, "Created:\t%s\n", info.CreatedTime)
+	}
+	if info.ToolVersion != "" {
+		fmt.Fprintf(w, "Created by:\tchunk-store %s\n", info.ToolVersion)
+	} else {
+		fmt.Fprintln(w, "Created by:\t(not recorded, an older chunk-store)")
+	}
+	if info.Compatibility != "" {
+		fmt.Fprintf(w, "Warning:\t%s\n", info.Compatibility)
+	}
+	fmt.Fprintf(w, "Size:\t%d bytes\n", info.TotalSize)
+	if info.FileSize > 0 {
+		fmt.Fprintf(w, "Range:\tbytes %d-%d of a %d byte file\n", info.BaseOffset, info.BaseOffset+info.PlainSize, info.FileSize)
+	}
+	fmt.Fprintf(w, "Chunks:\t%d\n", info.ChunkCount)
+	if info.ChunkSize > 0 && info.ChunkingMode == manifest.ChunkingRecord {
+		fmt.Fprintf(w, "Chunk size:\t%d bytes, extended to the end of a line\n", info.ChunkSize)
+	} else if info.ChunkSize > 0 && info.NumChunks > 0 {
+		fmt.Fprintf(w, "Chunk size:\t%d bytes, to split into %d chunks\n", info.ChunkSize, info.NumChunks)
+	} else if info.ChunkSize > 0 {
+		fmt.Fprintf(w, "Chunk size:\t%d bytes\n", info.ChunkSize)
+	}
+	if info.ChunkCount > 0 {
+		fmt.Fprintf(w, "Chunk sizes:\t%d min, %d avg, %d max bytes\n", info.MinChunkSize, info.AvgChunkSize, info.MaxChunkSize)
+	}
+	fmt.Fprintf(w, "Stored size:\t%d bytes\n", info.StoredSize)
+	if info.ChunkNaming != manifest.NamingHash {
+		fmt.Fprintf(w, "Chunk names:\t%s of the chunk hash\n", info.ChunkNaming)
+	}
+	fmt.Fprintf(w, "Encrypted:\t%t\n", info.Encrypted)
+	fmt.Fprintf(w, "Distribution:\t%s\n", info.DistributionMode)
+	if info.FileHash != "" {
+		fmt.Fprintf(w, "File hash:\t%s (%s)\n", info.FileHash, info.HashAlgo)
+	}
+	if info.MerkleValid != nil {
+		status := "matches chunks"
+		if !*info.MerkleValid {
+			status = "DOES NOT match chunks"
+		}
+		fmt.Fprintf(w, "Merkle root:\t%s (%s)\n", info.MerkleRoot, status)
+	}
+	if info.Compression != nil {
+		fmt.Fprintf(w, "Compression:\t%s\n", formatCompression(*info.Compression))
+	}
+	if info.ErasureParity > 0 {
+		fmt.Fprintf(w, "Parity:\t%d Reed-Solomon parity chunks per %d chunks, %d in all\n", info.ErasureParity, info.ErasureData, info.ParityChunks)
+	}
+	if len(info.Providers) > 0 {
+		names := make([]string, 0, len(info.Providers))
+		for p := range info.Providers {
+			names = append(names, p)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "Providers:\t")
+		for _, p := range names {
+			ps := info.Providers[p]
+			fmt.Fprintf(w, "  %s\t%d chunk copies, %.1f MB\n", p, ps.Chunks, float64(ps.Bytes)/(1024*1024))
+		}
+		if info.NotUploaded > 0 {
+			fmt.Fprintf(w, "  (not uploaded)\t%d chunks\n", info.NotUploaded)
+		}
+	}
+	if len(info.CloudFolders) > 0 {
+		fmt.Fprintln(w, "Folders:\t")
+		for _, f := range info.CloudFolders {
+			fmt.Fprintf(w, "  %s/%s\t%s\n", f.Provider, f.Account, f.Folder)
+		}
+	}
+	if len(info.Tags) > 0 {
+		keys := make([]string, 0, len(info.Tags))
+		for k := range info.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintln(w, "Tags:\t")
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %s\t%s\n", k, info.Tags[k])
+		}
+	}
+	return w.Flush()
+}
+
+// formatCompression summarizes compression stats on one line
+func formatCompression(s chunker.CompressionStats) string {
+	return fmt.Sprintf("%d of %d chunks compressed (%d incompressible, %d no gain), %.1f MB stored as %.1f MB (%.0f%%)",
+		s.Compressed, s.Chunks, s.Incompressible, s.NoGain,
+		float64(s.PlainBytes)/(1024*1024), float64(s.StoredBytes)/(1024*1024), s.Ratio()*100)
+}
+
+// dedupeReport prints chunk-level redundancy within and across the
+// comma-separated inputs. Inputs ending in .json are read as manifests, others
+// are chunked with the configured chunk size and hash algorithm.
+func dedupeReport(inputs string, cfg *config.Config, top int, asJSON bool) error {
+	if inputs == "" {
+		return fmt.Errorf("dedupe-report needs -in file1,file2 or -in manifest1.json,manifest2.json")
+	}
+
+	analyzer := chunker.NewDedupeAnalyzer()
+	opts := chunker.SplitOptions{
+		ChunkSize:       splitChunkSize(cfg),
+		HashAlgo:        cfg.ChunkConfig.HashAlgo,
+		RecordBoundary:  cfg.ChunkConfig.RecordBoundary,
+		RecordOvershoot: cfg.ChunkConfig.RecordOvershoot,
+	}
+	for _, path := range strings.Split(inputs, ",") {
+		path = strings.TrimSpace(path)
+		var err error
+		if strings.HasSuffix(strings.ToLower(path), ".json") {
+			err = analyzer.AddManifest(path)
+		} else {
+			err = analyzer.AddFile(path, opts)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	report := analyzer.Report(top)
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	mb := func(n int64) float64 { return float64(n) / (1024 * 1024) }
+	saved := 0.0
+	if report.TotalBytes > 0 {
+		saved = float64(report.SavedBytes) * 100 / float64(report.TotalBytes)
+	}
+	fmt.Printf("Chunks: %d total, %d unique\n", report.TotalChunks, report.UniqueChunks)
+	fmt.Printf("Data:   %.1f MB total, %.1f MB unique, %.1f MB (%.1f%%) saved by deduplication\n",
+		mb(report.TotalBytes), mb(report.UniqueBytes), mb(report.SavedBytes), saved)
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tCHUNK SIZE\tCHUNKS\tUNIQUE\tSHARED\tSIZE\tSAVED WITHIN FILE")
+	for _, f := range report.Files {
+		name := f.Name
+		if f.FromManifest {
+			name += " (manifest)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f MB\t%.1f MB\n", name, f.ChunkSize, f.Chunks, f.UniqueChunks, f.SharedChunks, mb(f.Bytes), mb(f.SavedBytes))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.TopChunks) == 0 {
+		fmt.Println("\nNo repeated chunks")
+		return nil
+	}
+	fmt.Println("\nMost repeated chunks:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tSIZE\tCOUNT\tFILES")
+	for _, c := range report.TopChunks {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", c.Hash, c.Size, c.Count, c.Files)
+	}
+	return w.Flush()
+}
+
+// addToCatalog adds a manifest written by this run to the catalog, warning if it can't
+func addToCatalog(catalogPath, manifestPath string) {
+	if err := catalog.AddManifest(catalogPath, manifestPath); err != nil {
+		log.Printf("Warning: Failed to add manifest to catalog: %v", err)
+		return
+	}
+	fmt.Printf("Added to catalog %s\n", catalogPath)
+}
+
+// catalogQuery builds a catalog query from the search flags
+func catalogQuery(name string, tags map[string]string, since, until string) (catalog.Query, error) {
+	q := catalog.Query{Name: name, Tags: tags}
+	var err error
+	if since != "" {
+		if q.Since, err = catalog.ParseDate(since); err != nil {
+			return q, err
+		}
+	}
+	if until != "" {
+		if q.Until, err = catalog.ParseDate(until); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// searchCatalog prints the catalog entries matching q, as JSON if asJSON is set
+func searchCatalog(catalogPath string, q catalog.Query, asJSON bool) error {
+	entries, err := catalog.Search(catalogPath, q)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if entries == nil {
+			entries = []catalog.Entry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No matching manifests")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tCREATED\tMODE\tTAGS\tMANIFEST")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%.1f MB\t%s\t%s\t%s\t%s\n", e.OriginalName, float64(e.TotalSize)/(1024*1024), e.CreatedTime, e.DistributionMode, formatTags(e.Tags), e.Location)
+	}
+	return w.Flush()
+}
+
+// formatTags formats tags as sorted key=value pairs
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// cloudChunkSource sets up the cloud clients and returns a source reading the
+// chunks of the manifest at manifestPath straight from the cloud, fetching its
+// shards first if it has any
+func cloudChunkSource(providers string, cfg *config.Config, manifestPath string) chunker.ChunkSource {
+	uploader, err := cloudstorage.CreateCloudUploader(buildCloudStrategy(providers, cfg), cfg)
+	if err != nil {
+		fail("Cloud setup failed: ", err)
+	}
+	uploader.SetReport(runReport)
+	if err := uploader.DownloadManifestShards(manifestPath); err != nil {
+		fail("Failed to download manifest shards: ", err)
+	}
+	return uploader.ReadChunk
+}
+
+// verifyAndRecord verifies the file described by manifestPath, reading its
+// chunks from source or chunksPath, and records the result in the manifest
+func verifyAndRecord(manifestPath, chunksPath string, source chunker.ChunkSource, encConfig *encryption.EncryptionConfig, cfg *config.Config) error {
+	verifyErr := chunker.VerifyFile(manifestPath, chunksPath, encConfig, chunker.AssembleOptions{
+		Lookahead:     cfg.PerformanceConfig.AssemblyLookahead,
+		IOWorkers:     cfg.PerformanceConfig.ThreadsIO,
+		CryptoWorkers: cfg.PerformanceConfig.ThreadsCrypto,
+		Source:        source,
+	})
+
+	// Only integrity results say something about the archive, a wrong
+	// password or an unreachable provider doesn't
+	if verifyErr == nil || exitCode(verifyErr) == exitIntegrity {
+		if err := manifest.RecordVerification(manifestPath, time.Now(), verifyErr); err != nil {
+			return fmt.Errorf("failed to record verification: %w", err)
+		}
+	}
+	return verifyErr
+}
+
+// auditEntry is a line of -mode audit
+type auditEntry struct {
+	OriginalName string `json:"original_name"`
+	Location     string `json:"location"`
+	LastVerified string `json:"last_verified,omitempty"`
+	LastRun      string `json:"last_run,omitempty"`    // When verification last ran, successful or not
+	LastResult   string `json:"last_result,omitempty"` // "ok" or the error of the last run
+	Overdue      bool   `json:"overdue"`               // Not verified successfully within the window
+	Failing      bool   `json:"failing"`               // The last verification run failed
+}
+
+// auditManifests lists the comma-separated manifests in inputs, or every
+// manifest in the catalog when inputs is empty, with when each was last
+// verified, and returns how many weren't verified within the last days days
+// or failed their last verification
+func auditManifests(inputs, catalogPath string, days int, asJSON bool) (int, error) {
+	if days <= 0 {
+		return 0, fmt.Errorf("-audit-days must be positive")
+	}
+
+	var locations []string
+	if inputs != "" {
+		locations = strings.Split(inputs, ",")
+	} else {
+		entries, err := catalog.Search(catalogPath, catalog.Query{})
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			locations = append(locations, e.Location)
+		}
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	entries := []auditEntry{}
+	flagged := 0
+	for _, location := range locations {
+		m, err := manifest.ReadManifestRoot(location)
+		if err != nil {
+			return 0, fmt.Errorf("can't read manifest %s: %w", location, err)
+		}
+
+		e := auditEntry{OriginalName: m.OriginalName, Location: location, LastVerified: m.LastVerified, Overdue: !m.VerifiedSince(cutoff)}
+		if v, ok := m.LastVerification(); ok {
+			e.LastRun = v.Time
+			e.LastResult = "ok"
+			if !v.OK {
+				e.LastResult = v.Error
+				e.Failing = true
+			}
+		}
+		if e.Overdue || e.Failing {
+			flagged++
+		}
+		entries = append(entries, e)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return flagged, enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tNAME\tLAST VERIFIED\tLAST RUN\tMANIFEST")
+	for _, e := range entries {
+		status := "ok"
+		switch {
+		case e.Failing:
+			status = "FAILING"
+		case e.Overdue:
+			status = "OVERDUE"
+		}
+		lastVerified := e.LastVerified
+		if lastVerified == "" {
+			lastVerified = "never"
+		}
+		lastRun := "-"
+		if e.LastRun != "" {
+			lastRun = e.LastRun + " " + e.LastResult
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status, e.OriginalName, lastVerified, lastRun, e.Location)
+	}
+	return flagged, w.Flush()
+}
+
+// mergeManifests merges the comma-separated manifests in inputs into outPath
+func mergeManifests(inputs, outPath string) error {
+	if inputs == "" || outPath == "" {
+		return fmt.Errorf("merge needs -in manifest1.json,manifest2.json and -out merged.json")
+	}
+
+	var manifests []manifest.Manifest
+	for _, path := range strings.Split(inputs, ",") {
+		m, err := manifest.ReadManifest(strings.TrimSpace(path))
+		if err != nil {
+			return fmt.Errorf("failed to read manifest %s: %w", path, err)
+		}
+		manifests = append(manifests, m)
+	}
+
+	merged, err := manifest.Merge(manifests...)
+	if err != nil {
+		return err
+	}
+
+	err = manifest.Save(merged, outPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d manifests into %s (%d chunks)\n", len(manifests), outPath, len(merged.Chunks))
+	return nil
+}
+
+// scratchDownloadDir returns the directory under scratch that the chunks of
+// the manifest at manifestPath are downloaded into. It is named after the
+// manifest's Merkle root or file hash, or its path when it has neither, so
+// every run for the same file uses the same directory.
+func scratchDownloadDir(scratch, manifestPath string) string {
+	id := manifestPath
+	if abs, err := filepath.Abs(manifestPath); err == nil {
+		id = abs
+	}
+	if m, err := manifest.ReadManifestRoot(manifestPath); err == nil {
+		if m.MerkleRoot != "" {
+			id = m.MerkleRoot
+		} else if m.FileHash != "" {
+			id = m.FileHash
+		}
+	}
+	sum := sha256.Sum256([]byte(id))
+	name := strings.TrimSuffix(filepath.Base(manifestPath), filepath.Ext(manifestPath))
+	return filepath.Join(scratch, fmt.Sprintf("chunk-store-download-%s-%x", name, sum[:8]))
+}
+
+// fetchManifest downloads the manifest at manifestURL, with its shards, into a
+// temporary directory and returns the local copy's path and a cleanup function
+func fetchManifest(manifestURL string) (string, func(), error) {
+	m, err := manifest.ReadManifest(manifestURL)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "chunk-store-manifest-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	path := filepath.Join(dir, "manifest.json")
+	if err := manifest.Save(m, path); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
+
+// compactManifest rewrites the manifest at path with its chunk list compacted,
+// converting it to format unless that is empty
+func compactManifest(path, format string) error {
+	m, err := manifest.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+	compacted, res, err := manifest.Compact(m)
+	if err != nil {
+		return err
+	}
+	if format != "" {
+		compacted.Format = format
+	}
+	if err := manifest.Save(compacted, path); err != nil {
+		return err
+	}
+	fmt.Printf("Compacted %s: %d chunks, removed %d duplicate entries and %d duplicate replica records\n",
+		path, len(compacted.Chunks), res.Entries, res.Replicas)
+	return nil
+}
+
+// fixIndex reports the duplicate, missing and out of range chunk indexes of
+// the manifest at path, and with manifest.FixIndexRenumber rewrites it with
+// the chunks numbered 0 to N-1
+func fixIndex(path, strategy, format string) error {
+	m, err := manifest.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+	p := manifest.FindIndexProblems(m)
+	if p.OK() {
+		fmt.Printf("Every chunk of %s has its own index, nothing to fix\n", path)
+		return nil
+	}
+	fmt.Printf("%s has %d chunks with %d duplicate, %d missing and %d out of range indexes\n",
+		path, len(m.Chunks), len(p.Duplicates), len(p.Missing), len(p.OutOfRange))
+	for _, list := range []struct {
+		what    string
+		indexes []int
+	}{{"Duplicate", p.Duplicates}, {"Missing", p.Missing}, {"Out of range", p.OutOfRange}} {
+		if len(list.indexes) > 0 {
+			fmt.Printf("  %-13s %s\n", list.what+":", formatIndexes(list.indexes, 10))
+		}
+	}
+	if strategy != manifest.FixIndexRenumber {
+		return fmt.Errorf("%w: left unchanged, renumber the chunks with -index-fix %s", manifest.ErrBadIndex, manifest.FixIndexRenumber)
+	}
+
+	fixed, res, err := manifest.FixIndexes(m)
+	if err != nil {
+		return err
+	}
+	if format != "" {
+		fixed.Format = format
+	}
+	if err := manifest.Save(fixed, path); err != nil {
+		return err
+	}
+	fmt.Printf("Fixed %s: %d chunks, merged %d repeated entries and renumbered %d chunks\n",
+		path, len(fixed.Chunks), res.Folded, res.Renumbered)
+	return nil
+}
+
+// formatIndexes lists up to limit indexes, counting the rest
+func formatIndexes(indexes []int, limit int) string {
+	parts := make([]string, 0, limit+1)
+	for i, index := range indexes {
+		if i == limit {
+			parts = append(parts, fmt.Sprintf("and %d more", len(indexes)-limit))
+			break
+		}
+		parts = append(parts, strconv.Itoa(index))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// readPassword prompts for a password without echoing it
+func readPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	return string(password), err
+}
+
+// keyringPassword returns the keyring ID and password of the file described
+// by manifestPath if its manifest records one and the OS keyring has it
+func keyringPassword(manifestPath string) (string, string, bool) {
+	m, err := manifest.ReadManifestRoot(manifestPath)
+	if err != nil || m.KeyringID == "" {
+		return "", "", false
+	}
+	password, err := encryption.KeyringPassword(m.KeyringID)
+	if err != nil {
+		fmt.Printf("⚠️  %v, asking for it instead\n", err)
+		return "", "", false
+	}
+	fmt.Println("Using the file's password from the OS keyring")
+	return m.KeyringID, password, true
+}
+
+// rekey prompts for a new password and rewraps the manifest's file key with it
+func rekey(manifestPath string, oldConfig *encryption.EncryptionConfig) error {
+	// Check the current password before asking for a new one
+	err := chunker.CheckPassword(manifestPath, "", oldConfig)
+	if err != nil && !errors.Is(err, chunker.ErrNoPasswordCheck) {
+		return err
+	}
+
+	password, err := readPassword("Enter new password: ")
+	if err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	confirm, err := readPassword("Confirm new password: ")
+	if err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	if password != confirm {
+		return fmt.Errorf("new passwords don't match")
+	}
+	if password == "" {
+		return fmt.Errorf("new password cannot be empty")
+	}
+
+	return chunker.Rekey(manifestPath, oldConfig, encryption.CreateEncryptionConfig(password, true))
+}
+
+// parseSizeList parses a comma-separated list of positive integers, such as "1,4,16"
+func parseSizeList(list string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(list, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid value %q in %q", field, list)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// runBenchmark measures throughput on synthetic data and prints a summary.
+// With -cloud, transfers are measured against the first account of the first provider.
+func runBenchmark(cfg *config.Config, sizeMB int, chunkSizesMB, concurrency string, withCloud bool, providersStr string) error {
+	sizes, err := parseSizeList(chunkSizesMB)
+	if err != nil {
+		return fmt.Errorf("invalid -bench-chunk-sizes: %w", err)
+	}
+	workers, err := parseSizeList(concurrency)
+	if err != nil {
+		return fmt.Errorf("invalid -bench-concurrency: %w", err)
+	}
+
+	opts := bench.Options{
+		DataSize:    int64(sizeMB) * 1024 * 1024,
+		Concurrency: workers,
+	}
+	for _, size := range sizes {
+		opts.ChunkSizes = append(opts.ChunkSizes, int64(size)*1024*1024)
+	}
+
+	if withCloud {
+		provider := parseCloudProviders(providersStr)[0]
+		clients, err := cloudstorage.CreateClients(provider, cfg)
+		if err != nil {
+			return err
+		}
+		if len(clients) == 0 {
+			return fmt.Errorf("no enabled %s accounts configured", provider)
+		}
+		var name string
+		for account := range clients {
+			if name == "" || account < name {
+				name = account
+			}
+		}
+		if err := clients[name].Initialize(); err != nil {
+			return fmt.Errorf("failed to initialize %s for account '%s': %w", provider, name, err)
+		}
+		opts.Client = clients[name]
+		fmt.Printf("Measuring transfers against %s account '%s'\n", provider, name)
+	}
+
+	fmt.Printf("Benchmarking with %d MB of synthetic data...\n", sizeMB)
+	best := make(map[string]bench.Result)
+	err = bench.Run(opts, func(r bench.Result) {
+		fmt.Printf("  %-10s chunk %4d MB  x%-3d %10.1f MB/s\n", r.Name, r.ChunkSize/(1024*1024), r.Concurrency, r.MBPerSec())
+		if r.MBPerSec() > best[r.Name].MBPerSec() {
+			best[r.Name] = r
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("Best results:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEST\tCHUNK SIZE\tCONCURRENCY\tMB/s")
+	for _, name := range []string{"chunk+hash", "aes-gcm", "upload", "download"} {
+		if r, ok := best[name]; ok {
+			fmt.Fprintf(w, "%s\t%d MB\t%d\t%.1f\n", r.Name, r.ChunkSize/(1024*1024), r.Concurrency, r.MBPerSec())
+		}
+	}
+	return w.Flush()
 }
 
 func main() {
-	mode := flag.String("mode", "", "split or assemble")
-	input := flag.String("in", "", "input file path")
+	mode := flag.String("mode", "", "split, assemble, verify, verify-cloud, audit, reindex, recover, serve, info, dedupe-report, catalog-add, catalog-list, catalog-search, tui, checkpw, rekey, providers, export-checksums, export-recipe, merge, compact-manifest, fix-index or bench")
+	input := flag.String("in", "", "input file path or http(s) URL (comma-separated manifests for merge, files or manifests for dedupe-report)")
 	out := flag.String("out", "", "output directory or file")
-	manifestPath := flag.String("manifest", "manifest.json", "manifest file path")
-	chunksPath := flag.String("chunkspath", "chunks", "chunks file path")
+	manifestPath := flag.String("manifest", "manifest.json", "manifest file path, or an http(s) URL to read it from")
+	chunksPath := flag.String("chunkspath", "chunks", "chunks directory; with assemble, verify or checkpw, a comma-separated list of directories to look for each chunk in, in order")
 	encrypt := flag.Bool("encrypt", false, "enable encryption for split mode")
 	decrypt := flag.Bool("decrypt", false, "enable decryption for assemble mode")
 	cloudMode := flag.Bool("cloud", false, "enable cloud distribution mode")
 	cloudDownload := flag.Bool("cloud-download", false, "download chunks from cloud for assembly")
+	cloudStream := flag.Bool("cloud-stream", false, "assemble straight from the cloud, reading chunks into memory instead of downloading them to disk first")
+	forceDownload := flag.Bool("force-download", false, "with -cloud-download, download every chunk again, even ones already present locally")
 	cloudCleanup := flag.Bool("cloud-cleanup", false, "remove local chunks after successful cloud upload")
-	cloudProviders := flag.String("cloud-providers", "gdrive", "comma-separated list of cloud providers to use (gdrive,dropbox,onedrive,mega,ipfs)")
+	unsafeCleanup := flag.Bool("unsafe-cleanup", false, "with -cloud-cleanup, skip downloading and verifying the uploaded chunks first")
+	cleanupDir := flag.Bool("cleanup-dir", false, "with -cloud-cleanup, also remove the emptied chunks directory")
+	cleanupManifest := flag.Bool("cleanup-manifest", false, "with -cloud-cleanup, also remove the local manifest (keep a copy elsewhere to restore the file)")
+	providerOrder := flag.String("provider-order", "", "comma-separated providers to download chunk copies from first, in order; unlisted providers are tried last")
+	cloudProviders := flag.String("cloud-providers", "gdrive", "comma-separated list of cloud providers to use (gdrive,webdav,dropbox,onedrive,mega,ipfs; default: providers from config)")
 	configFile := flag.String("config", "config.json", "path to configuration file")
+	store := flag.String("store", "", "shared content-addressed chunk store directory (deduplicates chunks across files)")
+	reportPath := flag.String("report", "", "write what happened to every chunk uploaded or downloaded (account, retries, time, status, md5) to this JSON file")
+	recipeFormat := flag.String("recipe-format", manifest.RecipeFormatShell, "export-recipe format: sh or urls")
+	indexFix := flag.String("index-fix", manifest.FixIndexReject, "with -mode fix-index, reject (only report the problems) or renumber (number the chunks 0 to N-1 in index order)")
+	checksumFormat := flag.String("checksum-format", manifest.ChecksumFormatSHA256Sum, "checksum export format: sha256sum or bagit")
+	replication := flag.Int("replication", 0, "number of copies per chunk (overrides config)")
+	loadBalancing := flag.String("load-balancing", "", "load balancing strategy: round_robin, random or size_based (overrides config)")
+	flattenEncryption := flag.Bool("flatten-encryption", false, "store chunk nonces in the manifest instead of prepending them, so chunk files are pure ciphertext (overrides config)")
+	useKeyring := flag.Bool("keyring", false, "with -encrypt, encrypt the file with its own random password kept in the OS keyring instead of asking for one (overrides config)")
+	splitOffset := flag.Int64("offset", 0, "with -mode split, start splitting at this byte offset of the input")
+	splitLength := flag.Int64("length", -1, "with -mode split, split only this many bytes from -offset (default: to the end of the input)")
+	sinceManifest := flag.String("since-manifest", "", "with -mode split -cloud, only upload chunks not already uploaded for this earlier manifest of the file")
+	numChunks := flag.Int("num-chunks", 0, "with -mode split, split into this many chunks of equal size instead of chunks of chunk_size")
+	replaceOnHashChange := flag.Bool("replace-on-hash-change", false, "with -since-manifest, delete the cloud copies of the earlier manifest's chunks that the new manifest no longer uses")
+	keepOrphans := flag.Bool("keep-orphans", false, "with -replace-on-hash-change, only report the cloud copies the new manifest no longer uses instead of deleting them")
+	preflight := flag.Bool("preflight", false, "with -mode split -cloud, upload one chunk to every account, download it back and check it before uploading the rest")
+	auditDays := flag.Int("audit-days", 30, "with -mode audit, how recently archives must have been verified")
+	outputMode := flag.String("output-mode", chunker.OutputOverwrite, "with -mode assemble, what to do with an existing output: create (fail), overwrite, or append (resume after the chunks already in it)")
+	maxOutputSize := flag.Int64("max-output-size", 0, "with -mode assemble, refuse manifests whose output would be larger than this many bytes (default: no limit)")
+	skipVerify := flag.Bool("skip-verify", false, "assemble without checking chunk and file hashes (faster, but corruption goes unnoticed)")
+	accountProgress := flag.Bool("account-progress", false, "show a progress line per account while uploading (overrides config)")
+	seed := flag.Int64("seed", 0, "seed for random load balancing, for a reproducible chunk layout (overrides config)")
+	tmpDir := flag.String("tmpdir", "", "scratch directory for downloaded chunks and assembly staging (overrides config)")
+	useMmap := flag.Bool("mmap", false, "memory-map the input file when splitting (overrides config)")
+	hashAlgo := flag.String("hash-algo", "", "chunk hash algorithm for split mode: sha256 or blake3 (overrides config)")
+	compression := flag.String("compression", "", "chunk compression for split mode: deflate, or empty for none (overrides config)")
+	recordBoundary := flag.Bool("record-boundary", false, "with -mode split, extend chunks to the end of a line so NDJSON/CSV records aren't split (overrides config)")
+	threadsIO := flag.Int("threads-io", 0, "chunks read or written in parallel when splitting and assembling (default: one per CPU, overrides config)")
+	threadsCrypto := flag.Int("threads-crypto", 0, "chunks hashed, compressed and encrypted or decrypted in parallel (default: one per CPU, overrides config)")
+	manifestFormat := flag.String("manifest-format", "", "json, compact-json or binary; format of manifests written by split, reindex or serve, or to convert to with -mode compact-manifest (overrides config)")
+	stripMetadata := flag.Bool("strip-metadata", false, "with -mode split, leave the file name and timestamps out of the manifest (overrides config)")
+	chunkExtension := flag.String("chunk-extension", "", "with -mode split, reindex, recover or serve, extension of chunk files, e.g. .dat (default: .chunk, overrides config)")
+	chunkHeaders := flag.Bool("chunk-headers", false, "with -mode split, prepend a header with each chunk's place in the file to its chunk file, so -mode recover can rebuild a lost manifest (overrides config)")
+	hmacNames := flag.Bool("hmac-names", false, "with -mode split, name chunks by a keyed HMAC of their hash so stored names don't reveal content hashes (overrides config)")
+	catalogPath := flag.String("catalog", "", "catalog file for the catalog modes (default catalog.json); with split or reindex, also add the manifest to it")
+	catalogName := flag.String("name", "", "with -mode catalog-search, match original names containing this; with -mode recover, the original name of the file to recover")
+	catalogSince := flag.String("since", "", "with -mode catalog-search, match manifests created on or after this date (YYYY-MM-DD or RFC 3339)")
+	catalogUntil := flag.String("until", "", "with -mode catalog-search, match manifests created before this date (YYYY-MM-DD or RFC 3339)")
+	dedupeTop := flag.Int("top", 10, "with -mode dedupe-report, how many of the most repeated chunks to list")
+	listen := flag.String("listen", "127.0.0.1:8080", "with -mode serve, address to listen on")
+	dataDir := flag.String("data-dir", "chunk-store-data", "with -mode serve, directory for the chunks and manifests of served files")
+	asJSON := flag.Bool("json", false, "with -mode info, dedupe-report or the catalog modes, print JSON")
+	tags := make(map[string]string)
+	flag.Func("tag", "key=value tag to record in the manifest when splitting, or to match with catalog-search (repeatable)", func(tag string) error {
+		key, value, err := manifest.ParseTag(tag)
+		if err != nil {
+			return err
+		}
+		tags[key] = value
+		return nil
+	})
+	benchSize := flag.Int("bench-size", 256, "MB of synthetic data per benchmark run")
+	benchChunkSizes := flag.String("bench-chunk-sizes", "1,4,16,64", "comma-separated chunk sizes in MB to benchmark")
+	benchConcurrency := flag.String("bench-concurrency", "1,2,4,8", "comma-separated worker counts to benchmark")
 	flag.Parse()
 
 	// Load configuration
 	cfg, err := config.LoadConfig(*configFile)
+	if errors.Is(err, config.ErrInvalidEnv) {
+		exitWith(exitConfig, err)
+	}
 	if err != nil {
 		log.Printf("Warning: Failed to load config file: %v", err)
 		log.Println("Using default configuration...")
 		cfg = config.DefaultConfig()
 	}
 
-	var encConfig *encryption.EncryptionConfig
+	// Command-line overrides take precedence over the environment and config file
+	providersSet := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cloud-providers":
+			providersSet = true
+		case "provider-order":
+			cfg.CloudConfig.ProviderOrder = parseProviderList(*providerOrder)
+		case "replication":
+			if err := config.ValidateReplicationCount(*replication); err != nil {
+				exitWith(exitConfig, "Invalid -replication: ", err)
+			}
+			cfg.CloudConfig.ReplicationCount = *replication
+		case "load-balancing":
+			if err := config.ValidateLoadBalancing(*loadBalancing); err != nil {
+				exitWith(exitConfig, "Invalid -load-balancing: ", err)
+			}
+			cfg.CloudConfig.LoadBalancing = *loadBalancing
+		case "flatten-encryption":
+			cfg.EncryptionConfig.FlattenEncryption = *flattenEncryption
+		case "keyring":
+			cfg.EncryptionConfig.Keyring = *useKeyring
+		case "account-progress":
+			cfg.CloudConfig.AccountProgress = *accountProgress
+		case "seed":
+			cfg.CloudConfig.LoadBalancingSeed = seed
+		case "tmpdir":
+			cfg.PerformanceConfig.ScratchDir = *tmpDir
+		case "mmap":
+			cfg.PerformanceConfig.Mmap = *useMmap
+		case "compression":
+			if err := config.ValidateCompression(*compression); err != nil {
+				exitWith(exitConfig, "Invalid -compression: ", err)
+			}
+			cfg.ChunkConfig.Compression = *compression
+		case "record-boundary":
+			cfg.ChunkConfig.RecordBoundary = *recordBoundary
+		case "strip-metadata":
+			cfg.ManifestConfig.StripMetadata = *stripMetadata
+		case "threads-io":
+			cfg.PerformanceConfig.ThreadsIO = *threadsIO
+		case "threads-crypto":
+			cfg.PerformanceConfig.ThreadsCrypto = *threadsCrypto
+		case "manifest-format":
+			if err := config.ValidateManifestFormat(*manifestFormat); err != nil {
+				exitWith(exitConfig, "Invalid -manifest-format: ", err)
+			}
+			cfg.ManifestConfig.Format = *manifestFormat
+		case "hmac-names":
+			cfg.ChunkConfig.HMACNames = *hmacNames
+		case "chunk-headers":
+			cfg.ChunkConfig.ChunkHeaders = *chunkHeaders
+		case "chunk-extension":
+			if err := config.ValidateChunkExtension(*chunkExtension); err != nil {
+				exitWith(exitConfig, "Invalid -chunk-extension: ", err)
+			}
+			cfg.ChunkConfig.ChunkExtension = *chunkExtension
+		case "hash-algo":
+			if err := config.ValidateHashAlgo(*hashAlgo); err != nil {
+				exitWith(exitConfig, "Invalid -hash-algo: ", err)
+			}
+			cfg.ChunkConfig.HashAlgo = *hashAlgo
+		}
+	})
+	// Without -cloud-providers, use the providers from the environment or config file
+	if !providersSet && len(cfg.CloudConfig.Providers) > 0 {
+		names := make([]string, len(cfg.CloudConfig.Providers))
+		for i, p := range cfg.CloudConfig.Providers {
+			names[i] = string(p)
+		}
+		*cloudProviders = strings.Join(names, ",")
+	}
 
-	if *encrypt || *decrypt {
-		fmt.Print("Enter encryption/decryption password: ")
-		password, err := term.ReadPassword(int(syscall.Stdin))
+	// With -out - the assembled file goes to stdout, so send everything else to stderr
+	stdout := os.Stdout
+	if *mode == "assemble" && *out == "-" {
+		os.Stdout = os.Stderr
+	}
+
+	// Reject flags that don't apply to the mode before asking for a password
+	if *mode == "split" && *decrypt {
+		exitWith(exitConfig, "Cannot use -decrypt flag with split mode")
+	}
+	if *mode == "assemble" && *encrypt {
+		exitWith(exitConfig, "Cannot use -encrypt flag with assemble mode")
+	}
+	if *sinceManifest != "" && (*mode != "split" || !*cloudMode) {
+		exitWith(exitConfig, "-since-manifest only applies to -mode split with -cloud")
+	}
+	if *replaceOnHashChange && *sinceManifest == "" {
+		exitWith(exitConfig, "-replace-on-hash-change needs the earlier manifest in -since-manifest")
+	}
+	if *keepOrphans && !*replaceOnHashChange {
+		exitWith(exitConfig, "-keep-orphans only applies with -replace-on-hash-change")
+	}
+	if *numChunks < 0 || (*numChunks > 0 && *mode != "split") {
+		exitWith(exitConfig, "-num-chunks must be a positive number of chunks and only applies to -mode split")
+	}
+	if *preflight && (*mode != "split" || !*cloudMode) {
+		exitWith(exitConfig, "-preflight only applies to -mode split with -cloud")
+	}
+	if strings.Contains(*chunksPath, ",") {
+		switch {
+		case *mode != "assemble" && *mode != "verify" && *mode != "checkpw":
+			exitWith(exitConfig, "-chunkspath can only list several directories with -mode assemble, verify or checkpw")
+		case *cloudDownload:
+			exitWith(exitConfig, "-cloud-download needs a single -chunkspath directory to download into")
+		}
+	}
+	if *mode == "tui" && *out == "-" {
+		exitWith(exitConfig, "-mode tui can't assemble to stdout, name the output file with -out")
+	}
+	if *reportPath != "" {
+		switch {
+		case *mode == "split" && *cloudMode:
+			runReport = cloudstorage.NewReport(cloudstorage.ReportUpload, *manifestPath)
+		case (*mode == "assemble" && (*cloudDownload || *cloudStream)) || (*mode == "verify" && *cloudStream):
+			runReport = cloudstorage.NewReport(cloudstorage.ReportDownload, *manifestPath)
+		default:
+			exitWith(exitConfig, "-report only applies to -mode split -cloud, assemble -cloud-download or -cloud-stream, and verify -cloud-stream")
+		}
+		runReportPath = *reportPath
+		defer saveReport()
+	}
+	if *cloudStream && *cloudDownload {
+		exitWith(exitConfig, "Use either -cloud-stream or -cloud-download, not both")
+	}
+	if err := chunker.ValidateOutputMode(*outputMode); err != nil {
+		exitWith(exitConfig, "Invalid -output-mode: ", err)
+	}
+	if *mode == "assemble" && *out == "-" && *outputMode != chunker.OutputOverwrite {
+		exitWith(exitConfig, "-output-mode only applies when assembling to a file")
+	}
+	if *maxOutputSize < 0 {
+		exitWith(exitConfig, "-max-output-size can't be negative")
+	}
+	if *maxOutputSize > 0 && *mode != "assemble" {
+		exitWith(exitConfig, "-max-output-size only applies to -mode assemble")
+	}
+
+	// A manifest shared at a URL is fetched once, before asking for a password
+	// if it turns out to be encrypted
+	if manifest.IsURL(*manifestPath) {
+		switch *mode {
+		case "assemble", "verify", "verify-cloud", "info", "export-checksums", "checkpw":
+		default:
+			exitWith(exitConfig, "-manifest can only be a URL with -mode assemble, verify, verify-cloud, info, export-checksums or checkpw")
+		}
+		fmt.Printf("Fetching manifest from %s\n", *manifestPath)
+		localManifest, cleanup, err := fetchManifest(*manifestPath)
 		if err != nil {
-			log.Fatal("Failed to read password:", err)
+			fail("Failed to fetch manifest: ", err)
 		}
-		fmt.Println()
+		defer cleanup()
+		*manifestPath = localManifest
+
+		// Only assembling and verifying read chunk contents
+		readsChunks := *mode == "assemble" || *mode == "verify"
+		if m, err := manifest.ReadManifestRoot(localManifest); err == nil && m.Encrypted && readsChunks && !*decrypt {
+			fmt.Println("The file is encrypted")
+			*decrypt = true
+		}
+	}
+
+	var encConfig *encryption.EncryptionConfig
+	var keyringID string    // Keyring entry of the password, recorded in the manifest by split
+	var newKeyringID string // Keyring entry made for this split, removed again if it fails
 
-		encConfig = encryption.CreateEncryptionConfig(string(password), *encrypt || *decrypt)
+	if *encrypt || *decrypt || *mode == "checkpw" || *mode == "rekey" {
+		// Files split with a keyring password get it from the keyring, and
+		// anything else, or a keyring that can't be used, falls back to asking
+		var password string
+		fromKeyring := false
+		switch {
+		case *mode == "split" && *sinceManifest != "":
+			// An incremental split must keep the previous file's password
+			keyringID, password, fromKeyring = keyringPassword(*sinceManifest)
+		case *mode == "split" && *encrypt && cfg.EncryptionConfig.Keyring:
+			id, pw, err := encryption.NewKeyringPassword()
+			if err != nil {
+				fmt.Printf("⚠️  %v, asking for a password instead\n", err)
+				break
+			}
+			keyringID, password, fromKeyring = id, pw, true
+			newKeyringID = id
+			fmt.Println("Encrypting with a new random password kept in the OS keyring")
+		case *mode != "split" && *mode != "serve":
+			keyringID, password, fromKeyring = keyringPassword(*manifestPath)
+		}
+
+		if !fromKeyring {
+			prompt := "Enter encryption/decryption password: "
+			if *mode == "rekey" {
+				prompt = "Enter current password: "
+			}
+			var err error
+			password, err = readPassword(prompt)
+			if err != nil {
+				fail("Failed to read password: ", err)
+			}
+		}
+
+		encConfig = encryption.CreateEncryptionConfig(password, true)
 	} else {
 		encConfig = encryption.CreateEncryptionConfig("", false)
 	}
 
+	// Modes that write the manifest hold its lock until they finish, so two
+	// runs on the same manifest can't interleave their writes
+	switch *mode {
+	case "split", "reindex", "recover", "rekey", "verify", "compact-manifest", "fix-index":
+		lock, err := manifest.AcquireLock(*manifestPath)
+		if err != nil {
+			fail("", err)
+		}
+		heldLock = lock
+		defer heldLock.Release()
+	}
+
 	switch *mode {
 	case "split":
-		if *decrypt {
-			log.Fatal("Cannot use -decrypt flag with split mode")
+		// Use configurable chunk size and manifest layout from config
+		splitOpts := chunker.SplitOptions{
+			ChunkSize:         splitChunkSize(cfg),
+			NumChunks:         *numChunks,
+			ManifestShardSize: cfg.ManifestConfig.ShardSize,
+			StripMetadata:     cfg.ManifestConfig.StripMetadata,
+			ManifestFormat:    cfg.ManifestConfig.Format,
+			ChunkStore:        *store,
+			HashAlgo:          cfg.ChunkConfig.HashAlgo,
+			Mmap:              cfg.PerformanceConfig.Mmap,
+			DirectKey:         cfg.EncryptionConfig.DirectKey,
+			FlattenEncryption: cfg.EncryptionConfig.FlattenEncryption,
+			AAD:               encryption.AADChunkHash,
+			NonceSize:         cfg.EncryptionConfig.NonceSize,
+			Tags:              tags,
+			Compression:       cfg.ChunkConfig.Compression,
+			BufferSize:        cfg.PerformanceConfig.IOBufferSize,
+			ReadAhead:         cfg.PerformanceConfig.SplitReadAhead,
+			CryptoWorkers:     cfg.PerformanceConfig.ThreadsCrypto,
+			IOWorkers:         cfg.PerformanceConfig.ThreadsIO,
+			RecordBoundary:    cfg.ChunkConfig.RecordBoundary,
+			RecordOvershoot:   cfg.ChunkConfig.RecordOvershoot,
+			HMACNames:         cfg.ChunkConfig.HMACNames,
+			NameKey:           cfg.ChunkConfig.NameKey,
+			ChunkHeaders:      cfg.ChunkConfig.ChunkHeaders,
+			ChunkExtension:    cfg.ChunkConfig.ChunkExtension,
+			ErasureData:       cfg.ChunkConfig.ErasureData,
+			ErasureParity:     cfg.ChunkConfig.ErasureParity,
+			KeyringID:         keyringID,
+		}
+
+		// Encrypt with the previous manifest's key, so its uploaded chunks can be reused
+		if *sinceManifest != "" {
+			prev, err := manifest.ReadManifestRoot(*sinceManifest)
+			if err != nil {
+				fail("Failed to read previous manifest: ", err)
+			}
+			if prev.Encrypted != *encrypt {
+				exitWith(exitConfig, fmt.Sprintf("Previous manifest has encrypted=%t, split with the same -encrypt setting to upload incrementally", prev.Encrypted))
+			}
+			if prev.PasswordCheck != "" && *encrypt {
+				if err := encConfig.VerifyPasswordCheck(prev.PasswordCheck); err != nil {
+					fail("Password doesn't match the previous manifest: ", err)
+				}
+			}
+			splitOpts.WrappedKey = prev.WrappedKey
+			splitOpts.AAD = prev.AAD
+			splitOpts.NonceSize = prev.NonceSize
+			// Keep the chunk names of unchanged chunks too
+			if prev.ChunkNaming == manifest.NamingHMAC && splitOpts.HMACNames && splitOpts.NameKey == "" && *store == "" {
+				splitOpts.NameKey = prev.NameKey
+			}
 		}
 
-		// Use configurable chunk size from config
-		err := chunker.SplitFileWithChunkSize(*input, *out, *manifestPath, encConfig, cfg.ChunkConfig.ChunkSize)
+		var err error
+		if *splitOffset != 0 || *splitLength >= 0 {
+			err = chunker.SplitFileRange(*input, *splitOffset, *splitLength, *out, *manifestPath, encConfig, splitOpts)
+		} else {
+			err = chunker.SplitFileWithOptions(*input, *out, *manifestPath, encConfig, splitOpts)
+		}
 		if err != nil {
-			log.Fatal("Split failed:", err)
+			if newKeyringID != "" {
+				encryption.DeleteKeyringPassword(newKeyringID)
+			}
+			fail("Split failed: ", err)
+		}
+		// Report the size actually used, which may have been picked automatically
+		chunkSize := cfg.ChunkConfig.ChunkSize
+		var compressionStats chunker.CompressionStats
+		if m, err := manifest.ReadManifest(*manifestPath); err == nil {
+			if m.ChunkSize > 0 {
+				chunkSize = m.ChunkSize
+			}
+			compressionStats = chunker.Compression(m)
 		}
 		if *encrypt {
-			fmt.Printf("File split and encrypted (chunk size: %.1f MB)\n", float64(cfg.ChunkConfig.ChunkSize)/(1024*1024))
+			fmt.Printf("File split and encrypted (chunk size: %.1f MB)\n", float64(chunkSize)/(1024*1024))
 		} else {
-			fmt.Printf("File split successfully (chunk size: %.1f MB)\n", float64(cfg.ChunkConfig.ChunkSize)/(1024*1024))
+			fmt.Printf("File split successfully (chunk size: %.1f MB)\n", float64(chunkSize)/(1024*1024))
+		}
+		if compressionStats.Chunks > 0 {
+			fmt.Println(formatCompression(compressionStats))
 		}
 
 		// Upload to cloud if requested
 		if *cloudMode {
 			fmt.Println("Uploading to cloud...")
-			providers := parseCloudProviders(*cloudProviders)
-			strategy := cloudstorage.CustomCloudStrategy(providers)
+			strategy := buildCloudStrategy(*cloudProviders, cfg)
 
-		fmt.Printf("Using providers: %v\n", providers)
+			fmt.Printf("Using providers: %v (replication: %d, load balancing: %s)\n",
+				strategy.Providers, strategy.ReplicationCount, strategy.LoadBalancing)
 
-		uploader, err := cloudstorage.CreateCloudUploader(strategy, cfg)
-		if err != nil {
-			log.Fatal("Cloud uploader setup failed:", err)
-		}
+			uploader, err := cloudstorage.CreateCloudUploader(strategy, cfg)
+			if err != nil {
+				fail("Cloud uploader setup failed: ", err)
+			}
 
-		err = uploader.UploadChunks(*out, *manifestPath)
-		if err != nil {
-			log.Fatal("Upload failed:", err)
-		}
+			chunkDir := *out
+			if *store != "" {
+				chunkDir = *store
+			}
+
+			// Catch credential, scope and folder problems before a long upload
+			if *preflight {
+				fmt.Println("Trying a round trip with one chunk on every account...")
+				err := uploader.Preflight(chunkDir, *manifestPath, func(m manifest.Manifest, c manifest.ChunkInfo, stored []byte) error {
+					return chunker.VerifyStoredChunk(m, c, stored, encConfig)
+				})
+				if err != nil {
+					fail("Preflight failed, nothing was uploaded: ", err)
+				}
+			}
+
+			// kill -USR1 pauses the upload to free bandwidth, and resumes it
+			uploader.SetReport(runReport)
+			stopPause := handlePause(uploader)
+			if *sinceManifest != "" {
+				err = uploader.UploadChunksIncremental(chunkDir, *manifestPath, *sinceManifest)
+			} else {
+				err = uploader.UploadChunks(chunkDir, *manifestPath)
+			}
+			stopPause()
+			if err != nil {
+				fail("Upload failed: ", err)
+			}
 			fmt.Println("Upload complete!")
 
+			// Chunks that changed since the earlier manifest left copies nothing uses
+			if *replaceOnHashChange {
+				if *keepOrphans {
+					orphans, err := cloudstorage.ReadOrphans(*manifestPath, *sinceManifest)
+					if err != nil {
+						fail("Failed to find orphaned chunks: ", err)
+					}
+					fmt.Printf("Kept %d cloud copies only %s uses\n", len(orphans), *sinceManifest)
+				} else {
+					deleted, err := uploader.DeleteOrphans(*manifestPath, *sinceManifest)
+					if err != nil {
+						log.Printf("Warning: %v", err)
+					}
+					fmt.Printf("Deleted %d cloud copies only %s used\n", deleted, *sinceManifest)
+				}
+			}
+
+			// Only clean up once every uploaded copy has been downloaded and checked
+			var verifiedErr error
+			if *cloudCleanup && *store == "" && !*unsafeCleanup {
+				fmt.Println("Verifying uploaded chunks before cleanup...")
+				verifiedErr = uploader.VerifyUploads(chunkDir, *manifestPath)
+			}
+
 			// Clean up local chunks if requested
-			if *cloudCleanup {
+			if *cloudCleanup && *store != "" {
+				log.Println("Warning: -cloud-cleanup is ignored with -store, chunks in a shared store may be used by other files")
+			} else if *cloudCleanup && verifiedErr != nil {
+				log.Printf("Warning: keeping local chunks, upload verification failed: %v", verifiedErr)
+			} else if *cloudCleanup {
 				fmt.Println("Cleaning up local chunks...")
-				err = chunker.CleanupChunks(*out)
+				cleanupOpts := chunker.CleanupOptions{RemoveDir: *cleanupDir, Extension: cfg.ChunkConfig.ChunkExtension}
+				if *cleanupManifest {
+					cleanupOpts.ManifestPath = *manifestPath
+				}
+				_, err = chunker.CleanupChunksWithOptions(*out, cleanupOpts)
 				if err != nil {
 					log.Printf("Warning: Failed to cleanup chunks: %v", err)
 				}
 			}
 		}
+		if *catalogPath != "" && !*cleanupManifest {
+			addToCatalog(*catalogPath, *manifestPath)
+		}
 	case "assemble":
-		if *encrypt {
-			log.Fatal("Cannot use -encrypt flag with assemble mode")
+		// A shared store replaces the per-file chunks directory
+		if *store != "" {
+			*chunksPath = *store
+		}
+
+		// Fetch each chunk from the cloud as it is assembled, without staging it on disk
+		var chunkSource chunker.ChunkSource
+		if *cloudStream {
+			chunkSource = cloudChunkSource(*cloudProviders, cfg, *manifestPath)
 		}
 
 		// Download from cloud if requested
+		var scratchChunks string
 		if *cloudDownload {
+			// Refuse an oversized manifest before downloading its chunks
+			if err := chunker.CheckOutputSize(*manifestPath, chunker.AssembleOptions{MaxOutputSize: *maxOutputSize}); err != nil {
+				fail("Assemble refused: ", err)
+			}
+
+			// Check the password before downloading anything
+			if *decrypt {
+				err := chunker.CheckPassword(*manifestPath, *chunksPath, encConfig)
+				if err != nil && !errors.Is(err, chunker.ErrNoPasswordCheck) {
+					fail("Password check failed: ", err)
+				}
+			}
+
 			fmt.Println("Downloading from cloud...")
-			providers := parseCloudProviders(*cloudProviders)
-			strategy := cloudstorage.CustomCloudStrategy(providers)
+			strategy := buildCloudStrategy(*cloudProviders, cfg)
 
 			uploader, err := cloudstorage.CreateCloudUploader(strategy, cfg)
 			if err != nil {
-				log.Fatal("Cloud setup failed:", err)
+				fail("Cloud setup failed: ", err)
 			}
+			uploader.SetReport(runReport)
 
-			err = uploader.DownloadChunks(*manifestPath, *chunksPath)
+			// Keep downloaded chunks in the manifest's directory under the
+			// scratch directory, so a failed run resumes there, and remove
+			// them after assembly
+			if cfg.PerformanceConfig.ScratchDir != "" {
+				scratchChunks = scratchDownloadDir(cfg.PerformanceConfig.ScratchDir, *manifestPath)
+				if err := os.MkdirAll(scratchChunks, 0755); err != nil {
+					fail("Failed to create download directory: ", err)
+				}
+				*chunksPath = scratchChunks
+			}
+
+			err = uploader.DownloadChunksWithOptions(*manifestPath, *chunksPath, cloudstorage.DownloadOptions{Force: *forceDownload})
 			if err != nil {
-				log.Fatal("Download failed:", err)
+				if scratchChunks != "" {
+					log.Printf("Chunks downloaded so far are kept in %s, run again to download only the rest", scratchChunks)
+				}
+				fail("Download failed: ", err)
 			}
 			fmt.Println("Download complete!")
 		}
 
-		err := chunker.AssembleFile(*manifestPath, *chunksPath, *out, encConfig)
+		assembleOpts := chunker.AssembleOptions{
+			Lookahead:     cfg.PerformanceConfig.AssemblyLookahead,
+			IOWorkers:     cfg.PerformanceConfig.ThreadsIO,
+			CryptoWorkers: cfg.PerformanceConfig.ThreadsCrypto,
+			ScratchDir:    cfg.PerformanceConfig.ScratchDir,
+			SkipVerify:    *skipVerify,
+			Source:        chunkSource,
+			OutputMode:    *outputMode,
+			BufferSize:    cfg.PerformanceConfig.IOBufferSize,
+			MaxOutputSize: *maxOutputSize,
+		}
+		if *skipVerify {
+			log.Println("Warning: -skip-verify is set, chunk and file hashes are not checked and corrupted data may go unnoticed")
+		}
+		if *out == "-" {
+			err = chunker.AssembleFileToWriter(*manifestPath, *chunksPath, stdout, encConfig, assembleOpts)
+		} else {
+			err = chunker.AssembleFileWithOptions(*manifestPath, *chunksPath, *out, encConfig, assembleOpts)
+		}
 		if err != nil {
-			log.Fatal("Assemble failed:", err)
+			if scratchChunks != "" {
+				log.Printf("Downloaded chunks kept in %s, assemble again with -chunkspath %s", scratchChunks, scratchChunks)
+			}
+			fail("Assemble failed: ", err)
+		}
+		if scratchChunks != "" {
+			os.RemoveAll(scratchChunks)
 		}
 		if *decrypt {
 			fmt.Println("File assembled and decrypted")
 		} else {
 			fmt.Println("File assembled successfully")
 		}
+	case "verify":
+		if *store != "" {
+			*chunksPath = *store
+		}
+		var chunkSource chunker.ChunkSource
+		if *cloudStream {
+			chunkSource = cloudChunkSource(*cloudProviders, cfg, *manifestPath)
+		}
+
+		if err := verifyAndRecord(*manifestPath, *chunksPath, chunkSource, encConfig, cfg); err != nil {
+			fail("Verification failed: ", err)
+		}
+		fmt.Println("File verified, recorded in the manifest")
+	case "verify-cloud":
+		uploader, err := cloudstorage.CreateCloudUploader(buildCloudStrategy(*cloudProviders, cfg), cfg)
+		if err != nil {
+			fail("Cloud setup failed: ", err)
+		}
+		if err := uploader.DownloadManifestShards(*manifestPath); err != nil {
+			fail("Failed to download manifest shards: ", err)
+		}
+		if err := uploader.VerifyCloudPresence(*manifestPath); err != nil {
+			fail("Cloud check failed: ", err)
+		}
+		fmt.Println("Every chunk copy is present in the cloud")
+	case "audit":
+		if *catalogPath == "" {
+			*catalogPath = catalog.DefaultPath
+		}
+		flagged, err := auditManifests(*input, *catalogPath, *auditDays, *asJSON)
+		if err != nil {
+			fail("Audit failed: ", err)
+		}
+		if flagged > 0 {
+			exitWith(exitFailure, fmt.Sprintf("%d archive(s) failed or missed verification in the last %d days", flagged, *auditDays))
+		}
+	case "reindex":
+		// Rebuild a lost manifest from the original file and its existing chunks
+		reindexOpts := chunker.SplitOptions{
+			ChunkSize:         splitChunkSize(cfg),
+			ManifestShardSize: cfg.ManifestConfig.ShardSize,
+			StripMetadata:     cfg.ManifestConfig.StripMetadata,
+			ManifestFormat:    cfg.ManifestConfig.Format,
+			ChunkStore:        *store,
+			HashAlgo:          cfg.ChunkConfig.HashAlgo,
+			NonceSize:         cfg.EncryptionConfig.NonceSize,
+			Tags:              tags,
+			BufferSize:        cfg.PerformanceConfig.IOBufferSize,
+			ReadAhead:         cfg.PerformanceConfig.SplitReadAhead,
+			CryptoWorkers:     cfg.PerformanceConfig.ThreadsCrypto,
+			IOWorkers:         cfg.PerformanceConfig.ThreadsIO,
+			RecordBoundary:    cfg.ChunkConfig.RecordBoundary,
+			RecordOvershoot:   cfg.ChunkConfig.RecordOvershoot,
+			HMACNames:         cfg.ChunkConfig.HMACNames,
+			NameKey:           cfg.ChunkConfig.NameKey,
+			ChunkExtension:    cfg.ChunkConfig.ChunkExtension,
+		}
+		err := chunker.ReindexFile(*input, *chunksPath, *manifestPath, encConfig, reindexOpts)
+		if err != nil {
+			fail("Reindex failed: ", err)
+		}
+		fmt.Printf("Manifest rebuilt: %s\n", *manifestPath)
+		if *catalogPath != "" {
+			addToCatalog(*catalogPath, *manifestPath)
+		}
+	case "recover":
+		// Rebuild a lost manifest from the headers of the chunk files alone
+		res, err := chunker.Recover(*chunksPath, *manifestPath, encConfig, chunker.RecoverOptions{
+			Name:           *catalogName,
+			ManifestFormat: cfg.ManifestConfig.Format,
+			ChunkExtension: cfg.ChunkConfig.ChunkExtension,
+		})
+		if err != nil {
+			fail("Recover failed: ", err)
+		}
+		fmt.Printf("Manifest rebuilt from %d chunks: %s\n", res.Chunks, *manifestPath)
+		if res.Skipped > 0 {
+			fmt.Printf("%d chunk files without a header were skipped\n", res.Skipped)
+		}
+		if res.CountUnknown {
+			fmt.Println("Warning: the chunk count wasn't recorded when splitting, so chunks missing from the end of the file can't be detected")
+		}
+		if *catalogPath != "" {
+			addToCatalog(*catalogPath, *manifestPath)
+		}
+	case "info":
+		err := printInfo(*manifestPath, *asJSON)
+		if err != nil {
+			fail("Info failed: ", err)
+		}
+	case "serve":
+		srv := server.New(cfg, server.Options{
+			DataDir:  *dataDir,
+			Strategy: buildCloudStrategy(*cloudProviders, cfg),
+			SplitOptions: chunker.SplitOptions{
+				ChunkSize:         splitChunkSize(cfg),
+				ManifestShardSize: cfg.ManifestConfig.ShardSize,
+				StripMetadata:     cfg.ManifestConfig.StripMetadata,
+				ManifestFormat:    cfg.ManifestConfig.Format,
+				HashAlgo:          cfg.ChunkConfig.HashAlgo,
+				DirectKey:         cfg.EncryptionConfig.DirectKey,
+				FlattenEncryption: cfg.EncryptionConfig.FlattenEncryption,
+				AAD:               encryption.AADChunkHash,
+				NonceSize:         cfg.EncryptionConfig.NonceSize,
+				Compression:       cfg.ChunkConfig.Compression,
+				BufferSize:        cfg.PerformanceConfig.IOBufferSize,
+				ReadAhead:         cfg.PerformanceConfig.SplitReadAhead,
+				CryptoWorkers:     cfg.PerformanceConfig.ThreadsCrypto,
+				IOWorkers:         cfg.PerformanceConfig.ThreadsIO,
+				RecordBoundary:    cfg.ChunkConfig.RecordBoundary,
+				RecordOvershoot:   cfg.ChunkConfig.RecordOvershoot,
+				HMACNames:         cfg.ChunkConfig.HMACNames,
+				NameKey:           cfg.ChunkConfig.NameKey,
+				ChunkHeaders:      cfg.ChunkConfig.ChunkHeaders,
+				ChunkExtension:    cfg.ChunkConfig.ChunkExtension,
+				ErasureData:       cfg.ChunkConfig.ErasureData,
+				ErasureParity:     cfg.ChunkConfig.ErasureParity,
+			},
+			AssembleOptions: chunker.AssembleOptions{
+				Lookahead:     cfg.PerformanceConfig.AssemblyLookahead,
+				IOWorkers:     cfg.PerformanceConfig.ThreadsIO,
+				CryptoWorkers: cfg.PerformanceConfig.ThreadsCrypto,
+				BufferSize:    cfg.PerformanceConfig.IOBufferSize,
+			},
+		})
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := srv.ListenAndServe(ctx, *listen)
+		stop()
+		if err != nil {
+			fail("Server failed: ", err)
+		}
+	case "dedupe-report":
+		err := dedupeReport(*input, cfg, *dedupeTop, *asJSON)
+		if err != nil {
+			fail("Dedupe report failed: ", err)
+		}
+	case "catalog-add", "catalog-list", "catalog-search":
+		if *catalogPath == "" {
+			*catalogPath = catalog.DefaultPath
+		}
+		var err error
+		switch *mode {
+		case "catalog-add":
+			err = catalog.AddManifest(*catalogPath, *manifestPath)
+			if err == nil {
+				fmt.Printf("Added %s to %s\n", *manifestPath, *catalogPath)
+			}
+		case "catalog-list":
+			err = searchCatalog(*catalogPath, catalog.Query{}, *asJSON)
+		case "catalog-search":
+			var q catalog.Query
+			q, err = catalogQuery(*catalogName, tags, *catalogSince, *catalogUntil)
+			if err != nil {
+				exitWith(exitConfig, "Invalid search: ", err)
+			}
+			err = searchCatalog(*catalogPath, q, *asJSON)
+		}
+		if err != nil {
+			fail("Catalog failed: ", err)
+		}
+	case "tui":
+		if *catalogPath == "" {
+			*catalogPath = catalog.DefaultPath
+		}
+		err := runTUI(*catalogPath, tuiOptions{
+			cfg:         cfg,
+			providers:   *cloudProviders,
+			chunksPath:  *chunksPath,
+			out:         *out,
+			cloudStream: *cloudStream,
+		})
+		if err != nil {
+			fail("TUI failed: ", err)
+		}
+	case "checkpw":
+		err := chunker.CheckPassword(*manifestPath, *chunksPath, encConfig)
+		if err != nil {
+			fail("Password check failed: ", err)
+		}
+		fmt.Println("Password is correct")
+	case "rekey":
+		err := rekey(*manifestPath, encConfig)
+		if err != nil {
+			fail("Rekey failed: ", err)
+		}
+		fmt.Println("Password changed, chunks were not modified")
+	case "providers":
+		printProviders(cfg)
+	case "merge":
+		err := mergeManifests(*input, *out)
+		if err != nil {
+			fail("Merge failed: ", err)
+		}
+	case "compact-manifest":
+		err := compactManifest(*manifestPath, *manifestFormat)
+		if err != nil {
+			fail("Compact failed: ", err)
+		}
+	case "fix-index":
+		if err := manifest.ValidateFixIndex(*indexFix); err != nil {
+			exitWith(exitConfig, err)
+		}
+		if err := fixIndex(*manifestPath, *indexFix, *manifestFormat); err != nil {
+			fail("Fix failed: ", err)
+		}
+	case "bench":
+		err := runBenchmark(cfg, *benchSize, *benchChunkSizes, *benchConcurrency, *cloudMode, *cloudProviders)
+		if err != nil {
+			fail("Benchmark failed: ", err)
+		}
+	case "export-checksums":
+		err := exportChecksums(*manifestPath, *out, *checksumFormat)
+		if err != nil {
+			fail("Export failed: ", err)
+		}
+	case "export-recipe":
+		if *out == "" {
+			exitWith(exitConfig, "-mode export-recipe needs -out, the file to write the recipe to")
+		}
+		if err := manifest.ValidateRecipeFormat(*recipeFormat); err != nil {
+			exitWith(exitConfig, "Invalid -recipe-format: ", err)
+		}
+		err := exportRecipe(*manifestPath, *out, *recipeFormat, *cloudProviders, cfg)
+		if err != nil {
+			fail("Export failed: ", err)
+		}
 	default:
 		fmt.Println("Usage:")
 		fmt.Println("  Split:    -mode split -in input_file -out output_dir [-encrypt] [-cloud]")
 		fmt.Println("  Assemble: -mode assemble -out output_file [-decrypt] [-cloud-download]")
+		fmt.Println("  Reindex:  -mode reindex -in original_file -chunkspath chunks_dir [-encrypt]")
+		fmt.Println("  Recover:  -mode recover -chunkspath chunks_dir [-name original_name] [-decrypt]")
+		fmt.Println("  Serve:    -mode serve [-listen 127.0.0.1:8080] [-data-dir chunk-store-data] [-cloud-providers gdrive]")
+		fmt.Println("  Info:     -mode info -manifest manifest.json [-json]")
+		fmt.Println("  Dedupe:   -mode dedupe-report -in file1,file2,manifest.json [-top 10] [-json]")
+		fmt.Println("  Catalog:  -mode catalog-add -manifest manifest.json [-catalog catalog.json]")
+		fmt.Println("            -mode catalog-list [-json]")
+		fmt.Println("            -mode catalog-search [-name part] [-tag key=value] [-since 2024-01-01] [-until 2025-01-01] [-json]")
+		fmt.Println("  Browse:   -mode tui [-catalog catalog.json] [-chunkspath chunks_dir] [-cloud-stream]")
+		fmt.Println("  Verify:   -mode verify -manifest manifest.json [-decrypt] [-cloud-stream]")
+		fmt.Println("  Audit:    -mode audit [-in a.json,b.json | -catalog catalog.json] [-audit-days 30] [-json]")
+		fmt.Println("  Check:    -mode checkpw -manifest manifest.json")
+		fmt.Println("  Rekey:    -mode rekey -manifest manifest.json")
+		fmt.Println("  List:     -mode providers")
+		fmt.Println("  Merge:    -mode merge -in day1.json,day2.json -out merged.json")
+		fmt.Println("  Compact:  -mode compact-manifest -manifest manifest.json")
+		fmt.Println("  Indexes:  -mode fix-index -manifest manifest.json [-index-fix renumber]")
+		fmt.Println("  Export:   -mode export-checksums -manifest manifest.json [-out SHA256SUMS] [-checksum-format bagit]")
+		fmt.Println("  Recipe:   -mode export-recipe -manifest manifest.json -out restore.sh [-recipe-format urls]")
+		fmt.Println("  Bench:    -mode bench [-bench-size 256] [-bench-chunk-sizes 1,4,16] [-bench-concurrency 1,4] [-cloud]")
 		fmt.Println()
 		fmt.Println("Options:")
 		fmt.Println("  -config:          Configuration file path (default: config.json)")
+		fmt.Println("  -store:           Shared chunk store directory, deduplicates chunks across files")
 		fmt.Println("  -encrypt:         Encrypt chunks when splitting")
+		fmt.Println("  -tag:             key=value tag recorded in the manifest when splitting (repeatable)")
 		fmt.Println("  -decrypt:         Decrypt chunks when assembling")
 		fmt.Println("  -cloud:           Upload chunks to cloud after splitting")
 		fmt.Println("  -cloud-download:  Download chunks from cloud before assembling")
+		fmt.Println("  -cloud-stream:    Assemble straight from cloud without storing chunks on disk")
 		fmt.Println("  -cloud-cleanup:   Remove local chunks after successful cloud upload")
+		fmt.Println("  -unsafe-cleanup:  With -cloud-cleanup, skip verifying uploads before removing local chunks")
+		fmt.Println("  -cleanup-dir:     With -cloud-cleanup, also remove the emptied chunks directory")
+		fmt.Println("  -cleanup-manifest: With -cloud-cleanup, also remove the local manifest")
 		fmt.Println("  -cloud-providers: Comma-separated providers (default: gdrive)")
+		fmt.Println("  -replication:     Copies per chunk (overrides config)")
+		fmt.Println("  -load-balancing:  round_robin, random or size_based (overrides config)")
+		fmt.Println("  -hash-algo:       sha256 or blake3 chunk hashing (overrides config)")
+		fmt.Println("  -tmpdir:          Scratch directory for downloads and assembly staging (overrides config)")
+		fmt.Println("  -mmap:            Memory-map the input file when splitting (overrides config)")
 		fmt.Println()
 		fmt.Println("Configuration:")
 		fmt.Println("  Create config.json to customize chunk size, multiple accounts, etc.")
@@ -188,10 +1784,12 @@ func main() {
 		fmt.Println("  ./chunk-store -mode assemble -out file.mkv -cloud-download -decrypt")
 		fmt.Println()
 		fmt.Println("Supported providers:")
-		fmt.Println("  ✓ Google Drive (multiple accounts supported)")
-		fmt.Println("  - Dropbox (planned)")
-		fmt.Println("  - OneDrive (planned)")
-		fmt.Println("  - MEGA (planned)")
-		fmt.Println("  - IPFS (planned)")
+		for _, provider := range cloudstorage.KnownProviders() {
+			if cloudstorage.IsImplemented(provider) {
+				fmt.Printf("  ✓ %s (%s, multiple accounts supported)\n", cloudstorage.DisplayName(provider), provider)
+			} else {
+				fmt.Printf("  - %s (planned)\n", cloudstorage.DisplayName(provider))
+			}
+		}
 	}
 }
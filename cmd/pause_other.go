@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "github.com/probablysamir/chunk-store/internal/cloudstorage"
+
+// handlePause does nothing where there is no SIGUSR1
+func handlePause(uploader *cloudstorage.CloudUploader) func() {
+	return func() {}
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/probablysamir/chunk-store/internal/cloudstorage"
+)
+
+// handlePause pauses and resumes uploader's upload on each SIGUSR1 until the
+// returned function is called
+func handlePause(uploader *cloudstorage.CloudUploader) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				uploader.TogglePause()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/probablysamir/chunk-store/internal/catalog"
+	"github.com/probablysamir/chunk-store/internal/chunker"
+	"github.com/probablysamir/chunk-store/internal/cloudstorage"
+	"github.com/probablysamir/chunk-store/internal/config"
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// tuiOptions are the settings -mode tui runs its actions with
+type tuiOptions struct {
+	cfg         *config.Config
+	providers   string // Cloud providers to download or stream chunks from
+	chunksPath  string // Where chunks are verified and assembled from, and downloaded to
+	out         string // Output of assemble; empty means the file's original name in the current directory
+	cloudStream bool   // Verify and assemble straight from the cloud instead of chunksPath
+}
+
+// tuiModel is the state of -mode tui: the archives in the catalog and the
+// manifest of the one that is open
+type tuiModel struct {
+	catalogPath string
+	opts        tuiOptions
+	entries     []catalog.Entry
+	cursor      int
+	height      int
+	open        bool              // Showing the details of the selected archive
+	m           manifest.Manifest // Manifest of the open archive
+	loadErr     error             // Why the open archive's manifest couldn't be read
+	status      string            // Outcome of the last action
+}
+
+// tuiDoneMsg reports that an action finished
+type tuiDoneMsg struct {
+	name string
+	err  error
+}
+
+// tuiAction runs an action on the terminal the TUI hands back while it runs,
+// so progress bars and password prompts work as they do on the command line
+type tuiAction struct {
+	run func() error
+}
+
+func (a tuiAction) Run() error {
+	err := a.run()
+	if err != nil {
+		fmt.Printf("\n❌ %v\n", err)
+	}
+	fmt.Print("\nPress Enter to go back...")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	return err
+}
+
+func (tuiAction) SetStdin(io.Reader)  {}
+func (tuiAction) SetStdout(io.Writer) {}
+func (tuiAction) SetStderr(io.Writer) {}
+
+// runTUI browses the archives in the catalog at catalogPath until the user quits
+func runTUI(catalogPath string, opts tuiOptions) error {
+	entries, err := catalog.Search(catalogPath, catalog.Query{})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no archives in %s, add some with -mode catalog-add", catalogPath)
+	}
+
+	model := tuiModel{catalogPath: catalogPath, opts: opts, entries: entries}
+	_, err = tea.NewProgram(model, tea.WithAltScreen()).Run()
+	return err
+}
+
+func (t tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+func (t tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		t.height = msg.Height
+	case tuiDoneMsg:
+		t.status = msg.name + " done"
+		if msg.err != nil {
+			t.status = fmt.Sprintf("%s failed: %v", msg.name, msg.err)
+		}
+		// Verification results are recorded in the manifest
+		t.load()
+	case tea.KeyMsg:
+		if key := msg.String(); key == "ctrl+c" || key == "q" {
+			return t, tea.Quit
+		}
+		if t.open {
+			return t.updateDetails(msg)
+		}
+		return t.updateList(msg)
+	}
+	return t, nil
+}
+
+// updateList handles a key on the list of archives
+func (t tuiModel) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		t.cursor = max(t.cursor-1, 0)
+	case "down", "j":
+		t.cursor = min(t.cursor+1, len(t.entries)-1)
+	case "enter", "right", "l":
+		t.open = true
+		t.status = ""
+		t.load()
+	case "r":
+		entries, err := catalog.Search(t.catalogPath, catalog.Query{})
+		if err != nil {
+			t.status = fmt.Sprintf("Reload failed: %v", err)
+			break
+		}
+		t.entries = entries
+		t.cursor = min(t.cursor, max(len(entries)-1, 0))
+		t.status = ""
+	}
+	return t, nil
+}
+
+// updateDetails handles a key on the details of the open archive
+func (t tuiModel) updateDetails(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if t.loadErr != nil && msg.String() != "esc" {
+		return t, nil
+	}
+	switch msg.String() {
+	case "esc", "backspace", "left", "h":
+		t.open = false
+		t.status = ""
+	case "v":
+		return t, t.run("Verify", t.opts.verify)
+	case "d":
+		if len(chunker.ManifestStats(t.m).Providers) == 0 {
+			t.status = "Nothing to download, no chunk was uploaded"
+			return t, nil
+		}
+		return t, t.run("Download", t.opts.download)
+	case "a":
+		return t, t.run("Assemble", t.opts.assemble)
+	}
+	return t, nil
+}
+
+// run hands the terminal to action on the selected archive's manifest and
+// reports back once it finishes
+func (t tuiModel) run(name string, action func(manifestPath string) error) tea.Cmd {
+	path := t.entries[t.cursor].Location
+	return tea.Exec(tuiAction{run: func() error { return action(path) }}, func(err error) tea.Msg {
+		return tuiDoneMsg{name: name, err: err}
+	})
+}
+
+// load reads the manifest of the selected archive
+func (t *tuiModel) load() {
+	if len(t.entries) == 0 {
+		return
+	}
+	t.m, t.loadErr = manifest.ReadManifest(t.entries[t.cursor].Location)
+}
+
+func (t tuiModel) View() string {
+	var b strings.Builder
+	if t.open {
+		t.viewDetails(&b)
+	} else {
+		t.viewList(&b)
+	}
+	if t.status != "" {
+		fmt.Fprintf(&b, "\n%s\n", t.status)
+	}
+	return b.String()
+}
+
+// viewList renders the list of archives, scrolled to keep the cursor in view
+func (t tuiModel) viewList(b *strings.Builder) {
+	fmt.Fprintf(b, "Archives in %s\n\n", t.catalogPath)
+
+	rows := len(t.entries)
+	if t.height > 0 {
+		rows = max(t.height-6, 1)
+	}
+	start := max(0, min(t.cursor-rows+1, len(t.entries)-rows))
+	for i := start; i < len(t.entries) && i < start+rows; i++ {
+		e := t.entries[i]
+		cursor := " "
+		if i == t.cursor {
+			cursor = ">"
+		}
+		name := e.OriginalName
+		if name == "" {
+			name = filepath.Base(e.Location)
+		}
+		fmt.Fprintf(b, "%s %-40s %10.1f MB %7d chunks  %s\n", cursor, name, float64(e.TotalSize)/(1024*1024), e.ChunkCount, e.CreatedTime)
+	}
+	b.WriteString("\n↑/↓ select • enter open • r reload • q quit\n")
+}
+
+// viewDetails renders the open archive's manifest
+func (t tuiModel) viewDetails(b *strings.Builder) {
+	e := t.entries[t.cursor]
+	fmt.Fprintf(b, "%s\n\n", e.Location)
+	if t.loadErr != nil {
+		fmt.Fprintf(b, "Can't read the manifest: %v\n\nesc back • q quit\n", t.loadErr)
+		return
+	}
+
+	m := t.m
+	stats := chunker.ManifestStats(m)
+	name := m.OriginalName
+	if m.StripMetadata {
+		name = "(name and timestamps stripped)"
+	}
+	fmt.Fprintf(b, "  File:           %s\n", name)
+	if m.CreatedTime != "" {
+		fmt.Fprintf(b, "  Created:        %s\n", m.CreatedTime)
+	}
+	fmt.Fprintf(b, "  Size:           %.1f MB in %d chunks, %.1f MB stored\n",
+		float64(stats.TotalSize)/(1024*1024), stats.ChunkCount, float64(stats.StoredSize)/(1024*1024))
+	fmt.Fprintf(b, "  Encrypted:      %t\n", m.Encrypted)
+	fmt.Fprintf(b, "  Distribution:   %s\n", m.DistributionMode)
+
+	providers := make([]string, 0, len(stats.Providers))
+	for p := range stats.Providers {
+		providers = append(providers, p)
+	}
+	sort.Strings(providers)
+	for i, p := range providers {
+		label := ""
+		if i == 0 {
+			label = "Providers:"
+		}
+		ps := stats.Providers[p]
+		fmt.Fprintf(b, "  %-15s %s: %d chunk copies, %.1f MB\n", label, p, ps.Chunks, float64(ps.Bytes)/(1024*1024))
+	}
+	if stats.NotUploaded > 0 {
+		fmt.Fprintf(b, "  Not uploaded:   %d chunks\n", stats.NotUploaded)
+	}
+
+	lastVerified := m.LastVerified
+	if lastVerified == "" {
+		lastVerified = "never"
+	}
+	fmt.Fprintf(b, "  Last verified:  %s\n", lastVerified)
+	if n := len(m.Verifications); n > 0 {
+		last := m.Verifications[n-1]
+		result := "ok"
+		if !last.OK {
+			result = "failed: " + last.Error
+		}
+		fmt.Fprintf(b, "  Last run:       %s, %s\n", last.Time, result)
+	}
+
+	b.WriteString("\nv verify • d download • a assemble • esc back • q quit\n")
+}
+
+// verify checks the archive at manifestPath and records the result in its
+// manifest, like -mode verify
+func (o tuiOptions) verify(manifestPath string) error {
+	lock, err := manifest.AcquireLock(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	encConfig, err := tuiEncryption(manifestPath)
+	if err != nil {
+		return err
+	}
+	source, err := o.source(manifestPath)
+	if err != nil {
+		return err
+	}
+	if err := verifyAndRecord(manifestPath, o.chunksPath, source, encConfig, o.cfg); err != nil {
+		return err
+	}
+	fmt.Println("File verified, recorded in the manifest")
+	return nil
+}
+
+// download fetches the chunks of the archive at manifestPath from the cloud
+// into the chunks directory, like -mode assemble -cloud-download
+func (o tuiOptions) download(manifestPath string) error {
+	uploader, err := cloudstorage.CreateCloudUploader(buildCloudStrategy(o.providers, o.cfg), o.cfg)
+	if err != nil {
+		return fmt.Errorf("cloud setup failed: %w", err)
+	}
+	fmt.Printf("Downloading chunks to %s...\n", o.chunksPath)
+	if err := uploader.DownloadChunksWithOptions(manifestPath, o.chunksPath, cloudstorage.DownloadOptions{}); err != nil {
+		return err
+	}
+	fmt.Println("Download complete!")
+	return nil
+}
+
+// assemble restores the archive at manifestPath, like -mode assemble. An
+// existing output file is left alone.
+func (o tuiOptions) assemble(manifestPath string) error {
+	m, err := manifest.ReadManifestRoot(manifestPath)
+	if err != nil {
+		return err
+	}
+	out := o.out
+	if out == "" {
+		if m.OriginalName == "" {
+			return fmt.Errorf("the manifest doesn't record the file name, name the output with -out")
+		}
+		out = filepath.Base(m.OriginalName)
+	}
+
+	encConfig, err := tuiEncryption(manifestPath)
+	if err != nil {
+		return err
+	}
+	source, err := o.source(manifestPath)
+	if err != nil {
+		return err
+	}
+	err = chunker.AssembleFileWithOptions(manifestPath, o.chunksPath, out, encConfig, chunker.AssembleOptions{
+		Lookahead:     o.cfg.PerformanceConfig.AssemblyLookahead,
+		IOWorkers:     o.cfg.PerformanceConfig.ThreadsIO,
+		CryptoWorkers: o.cfg.PerformanceConfig.ThreadsCrypto,
+		ScratchDir:    o.cfg.PerformanceConfig.ScratchDir,
+		BufferSize:    o.cfg.PerformanceConfig.IOBufferSize,
+		Source:        source,
+		OutputMode:    chunker.OutputCreate,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Assembled %s\n", out)
+	return nil
+}
+
+// source returns the chunk source of the archive at manifestPath: the cloud
+// with -cloud-stream, otherwise nil for the chunks directory
+func (o tuiOptions) source(manifestPath string) (chunker.ChunkSource, error) {
+	if !o.cloudStream {
+		return nil, nil
+	}
+	uploader, err := cloudstorage.CreateCloudUploader(buildCloudStrategy(o.providers, o.cfg), o.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cloud setup failed: %w", err)
+	}
+	if err := uploader.DownloadManifestShards(manifestPath); err != nil {
+		return nil, fmt.Errorf("failed to download manifest shards: %w", err)
+	}
+	return uploader.ReadChunk, nil
+}
+
+// tuiEncryption returns the decryption settings for the archive at
+// manifestPath, with its password from the OS keyring or asked for
+func tuiEncryption(manifestPath string) (*encryption.EncryptionConfig, error) {
+	m, err := manifest.ReadManifestRoot(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Encrypted {
+		return encryption.CreateEncryptionConfig("", false), nil
+	}
+	_, password, ok := keyringPassword(manifestPath)
+	if !ok {
+		if password, err = readPassword("Enter decryption password: "); err != nil {
+			return nil, err
+		}
+	}
+	return encryption.CreateEncryptionConfig(password, true), nil
+}
@@ -0,0 +1,210 @@
+// Package bench measures chunking, encryption and transfer throughput so
+// chunk size and concurrency can be tuned for a machine. Each measured
+// operation is a plain function, so it is also run by the testing.B
+// benchmarks of this package (go test -bench . ./internal/bench).
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/chunker"
+	"github.com/probablysamir/chunk-store/internal/cloudstorage"
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// Result is the throughput of one benchmark run
+type Result struct {
+	Name        string
+	ChunkSize   int64
+	Concurrency int
+	Bytes       int64
+	Duration    time.Duration
+}
+
+// MBPerSec returns the throughput in MiB per second
+func (r Result) MBPerSec() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1024 * 1024) / r.Duration.Seconds()
+}
+
+// Options selects what to benchmark
+type Options struct {
+	DataSize    int64                    // Bytes of synthetic data per run
+	ChunkSizes  []int64                  // Chunk sizes to sweep
+	Concurrency []int                    // Worker counts to sweep for encryption and transfer
+	Client      cloudstorage.CloudClient // Initialized client to measure upload/download against (optional)
+	TempDir     string                   // Where transfer chunks are staged, defaults to the system temp dir
+}
+
+// SyntheticData returns size bytes of incompressible, non-repeating data
+func SyntheticData(size int64) []byte {
+	data := make([]byte, size)
+	rand.NewChaCha8([32]byte{}).Read(data)
+	return data
+}
+
+// Split chunks and hashes data without storing the chunks
+func Split(data []byte, chunkSize int64) error {
+	discard := func(manifest.ChunkInfo, []byte) error { return nil }
+	_, err := chunker.SplitReader(bytes.NewReader(data), discard, encryption.CreateEncryptionConfig("", false), chunker.SplitOptions{ChunkSize: chunkSize})
+	return err
+}
+
+// Encrypt encrypts data in chunkSize pieces using concurrency workers
+func Encrypt(data []byte, chunkSize int64, concurrency int, encConfig *encryption.EncryptionConfig) error {
+	return forEachChunk(data, chunkSize, concurrency, func(_ int, chunk []byte) error {
+		_, err := encConfig.Encrypt(chunk)
+		return err
+	})
+}
+
+// Transfer uploads data in chunkSize pieces to client using concurrency
+// workers, downloads them again and deletes them. Chunks are staged in dir
+// before the upload timer starts.
+func Transfer(client cloudstorage.CloudClient, dir string, data []byte, chunkSize int64, concurrency int) (upload, download time.Duration, err error) {
+	staging, err := os.MkdirTemp(dir, "chunk-store-bench-")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.RemoveAll(staging)
+
+	var paths []string
+	err = forEachChunk(data, chunkSize, 1, func(i int, chunk []byte) error {
+		path := filepath.Join(staging, fmt.Sprintf("bench-%04d.chunk", i))
+		paths = append(paths, path)
+		return os.WriteFile(path, chunk, 0644)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ids := make([]string, len(paths))
+	defer func() {
+		for _, id := range ids {
+			if id != "" {
+				client.DeleteFile(id)
+			}
+		}
+	}()
+
+	start := time.Now()
+	err = forEach(len(paths), concurrency, func(i int) error {
+		id, err := client.UploadFile(paths[i], filepath.Base(paths[i]))
+		ids[i] = id
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("upload failed: %w", err)
+	}
+	upload = time.Since(start)
+
+	start = time.Now()
+	err = forEach(len(paths), concurrency, func(i int) error {
+		return client.DownloadFile(ids[i], paths[i]+".download")
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("download failed: %w", err)
+	}
+	download = time.Since(start)
+
+	return upload, download, nil
+}
+
+// Run sweeps the configured chunk sizes and concurrency levels and passes
+// each result to report as soon as it is measured
+func Run(opts Options, report func(Result)) error {
+	data := SyntheticData(opts.DataSize)
+	encConfig := encryption.CreateEncryptionConfig("chunk-store-bench", true)
+	size := int64(len(data))
+
+	for _, chunkSize := range opts.ChunkSizes {
+		start := time.Now()
+		if err := Split(data, chunkSize); err != nil {
+			return fmt.Errorf("chunking benchmark failed: %w", err)
+		}
+		report(Result{Name: "chunk+hash", ChunkSize: chunkSize, Concurrency: 1, Bytes: size, Duration: time.Since(start)})
+
+		for _, concurrency := range opts.Concurrency {
+			start := time.Now()
+			if err := Encrypt(data, chunkSize, concurrency, encConfig); err != nil {
+				return fmt.Errorf("encryption benchmark failed: %w", err)
+			}
+			report(Result{Name: "aes-gcm", ChunkSize: chunkSize, Concurrency: concurrency, Bytes: size, Duration: time.Since(start)})
+		}
+
+		if opts.Client == nil {
+			continue
+		}
+		for _, concurrency := range opts.Concurrency {
+			upload, download, err := Transfer(opts.Client, opts.TempDir, data, chunkSize, concurrency)
+			if err != nil {
+				return fmt.Errorf("transfer benchmark failed: %w", err)
+			}
+			report(Result{Name: "upload", ChunkSize: chunkSize, Concurrency: concurrency, Bytes: size, Duration: upload})
+			report(Result{Name: "download", ChunkSize: chunkSize, Concurrency: concurrency, Bytes: size, Duration: download})
+		}
+	}
+
+	return nil
+}
+
+// forEachChunk calls fn for every chunkSize piece of data using concurrency workers
+func forEachChunk(data []byte, chunkSize int64, concurrency int, fn func(i int, chunk []byte) error) error {
+	if chunkSize <= 0 {
+		chunkSize = chunker.DefaultChunkSize
+	}
+	count := int((int64(len(data)) + chunkSize - 1) / chunkSize)
+	return forEach(count, concurrency, func(i int) error {
+		start := int64(i) * chunkSize
+		end := min(start+chunkSize, int64(len(data)))
+		return fn(i, data[start:end])
+	})
+}
+
+// forEach calls fn for 0..n-1 from concurrency workers and returns the first error
+func forEach(n, concurrency int, fn func(i int) error) error {
+	concurrency = max(1, min(concurrency, n))
+
+	indexes := make(chan int)
+	errs := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+)
+
+// benchDataSize is the synthetic data each benchmark iteration processes
+const benchDataSize = 16 << 20
+
+var (
+	benchChunkSizes  = []int64{64 << 10, 1 << 20, 4 << 20}
+	benchConcurrency = []int{1, 4}
+)
+
+// benchData is generated once and shared by every benchmark
+var benchData = sync.OnceValue(func() []byte { return SyntheticData(benchDataSize) })
+
+func chunkSizeName(size int64) string {
+	if size >= 1<<20 {
+		return fmt.Sprintf("chunk=%dMB", size>>20)
+	}
+	return fmt.Sprintf("chunk=%dKB", size>>10)
+}
+
+func BenchmarkSplit(b *testing.B) {
+	data := benchData()
+	for _, chunkSize := range benchChunkSizes {
+		b.Run(chunkSizeName(chunkSize), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := Split(data, chunkSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	data := benchData()
+	encConfig := encryption.CreateEncryptionConfig("chunk-store-bench", true)
+	for _, chunkSize := range benchChunkSizes {
+		for _, concurrency := range benchConcurrency {
+			b.Run(fmt.Sprintf("%s/workers=%d", chunkSizeName(chunkSize), concurrency), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					if err := Encrypt(data, chunkSize, concurrency, encConfig); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkTransfer measures the transfer harness against a client storing
+// files in a local directory, which isolates its own overhead from the network
+func BenchmarkTransfer(b *testing.B) {
+	data := benchData()
+	client := &dirClient{dir: b.TempDir()}
+	for _, concurrency := range benchConcurrency {
+		b.Run(fmt.Sprintf("%s/workers=%d", chunkSizeName(1<<20), concurrency), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := Transfer(client, b.TempDir(), data, 1<<20, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// dirClient is a cloudstorage.CloudClient keeping files in a local directory,
+// with the file name as ID
+type dirClient struct {
+	dir string
+}
+
+func (c *dirClient) Initialize() error { return nil }
+
+func (c *dirClient) UploadFile(localPath, cloudPath string) (string, error) {
+	id := strings.ReplaceAll(cloudPath, "/", "_")
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	return id, os.WriteFile(filepath.Join(c.dir, id), data, 0644)
+}
+
+func (c *dirClient) DownloadFile(fileID, localPath string) error {
+	data, err := os.ReadFile(filepath.Join(c.dir, fileID))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(localPath, data, 0644)
+}
+
+func (c *dirClient) OpenFile(fileID string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(c.dir, fileID))
+}
+
+func (c *dirClient) FindFileByName(fileName string) (string, error) {
+	if _, err := os.Stat(filepath.Join(c.dir, fileName)); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+func (c *dirClient) DeleteFile(fileID string) error {
+	return os.Remove(filepath.Join(c.dir, fileID))
+}
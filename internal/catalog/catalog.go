@@ -0,0 +1,183 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// DefaultPath is the catalog file used when none is given
+const DefaultPath = "catalog.json"
+
+// Entry describes one manifest known to the catalog
+type Entry struct {
+	OriginalName     string            `json:"original_name"`
+	TotalSize        int64             `json:"total_size"`
+	ChunkCount       int               `json:"chunk_count"`
+	CreatedTime      string            `json:"created_time"`
+	DistributionMode string            `json:"distribution_mode"`
+	FileHash         string            `json:"file_hash,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+	Location         string            `json:"location"`   // Absolute path of the manifest
+	AddedTime        string            `json:"added_time"` // When the manifest was last added to the catalog
+}
+
+// Catalog is a JSON index of manifests, so many archives can be searched
+// without opening each manifest
+type Catalog struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Query selects catalog entries. Empty fields match everything.
+type Query struct {
+	Name  string            // Case-insensitive substring of the original name
+	Tags  map[string]string // Tags every entry must have, with these values
+	Since time.Time         // Created at or after
+	Until time.Time         // Created before
+}
+
+// Load reads the catalog at path. A missing file is an empty catalog.
+func Load(path string) (Catalog, error) {
+	var c Catalog
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the catalog to path, replacing it atomically
+func Save(c Catalog, path string) error {
+	data, err := json.MarshalIndent(c, "", "	")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// AddManifest adds the manifest at manifestPath to the catalog at catalogPath,
+// creating the catalog if needed. A manifest already in the catalog is
+// updated in place, so adding it again after a re-split refreshes its entry.
+func AddManifest(catalogPath, manifestPath string) error {
+	location, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	// The root is enough, it carries the totals even when the chunk list is sharded
+	m, err := manifest.ReadManifestRoot(manifestPath)
+	if err != nil {
+		return fmt.Errorf("can't read manifest %s: %w", manifestPath, err)
+	}
+
+	c, err := Load(catalogPath)
+	if err != nil {
+		return err
+	}
+
+	entry := Entry{
+		OriginalName:     m.OriginalName,
+		TotalSize:        m.TotalSize,
+		ChunkCount:       m.ChunkCount,
+		CreatedTime:      m.CreatedTime,
+		DistributionMode: m.DistributionMode,
+		FileHash:         m.FileHash,
+		Tags:             m.Tags,
+		Location:         location,
+		AddedTime:        time.Now().Format(time.RFC3339),
+	}
+
+	replaced := false
+	for i := range c.Entries {
+		if c.Entries[i].Location == location {
+			c.Entries[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		c.Entries = append(c.Entries, entry)
+	}
+
+	return Save(c, catalogPath)
+}
+
+// Search returns the entries of the catalog at catalogPath matching q, oldest first
+func Search(catalogPath string, q Query) ([]Entry, error) {
+	c, err := Load(catalogPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Entry
+	for _, e := range c.Entries {
+		if q.matches(e) {
+			matches = append(matches, e)
+		}
+	}
+
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].CreatedTime < matches[b].CreatedTime
+	})
+	return matches, nil
+}
+
+// matches reports whether e satisfies every condition of q
+func (q Query) matches(e Entry) bool {
+	if q.Name != "" && !strings.Contains(strings.ToLower(e.OriginalName), strings.ToLower(q.Name)) {
+		return false
+	}
+
+	for k, v := range q.Tags {
+		if got, ok := e.Tags[k]; !ok || got != v {
+			return false
+		}
+	}
+
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		created, err := time.Parse(time.RFC3339, e.CreatedTime)
+		if err != nil {
+			return false
+		}
+		if !q.Since.IsZero() && created.Before(q.Since) {
+			return false
+		}
+		if !q.Until.IsZero() && !created.Before(q.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDate parses a query date, either YYYY-MM-DD (local midnight) or RFC 3339
+func ParseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,66 @@
+package chunker
+
+import (
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// bufferPool recycles chunk-sized buffers, so splitting or assembling a large
+// file doesn't allocate, and the GC collect, new buffers for every chunk.
+// Buffers too small for a request are dropped, so each pool should hold
+// buffers of about one size.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+var (
+	// storedBuffers holds buffers of stored chunks: encrypted in a split,
+	// decrypted in an assembly
+	storedBuffers bufferPool
+	// plainBuffers holds buffers of decompressed chunks
+	plainBuffers bufferPool
+)
+
+// get returns a buffer of n bytes, reused if the pool has one large enough
+func (p *bufferPool) get(n int) []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok && cap(*buf) >= n {
+		return (*buf)[:n]
+	}
+	return make([]byte, n)
+}
+
+// put hands buf back for reuse. It must not be used afterwards.
+func (p *bufferPool) put(buf []byte) {
+	if cap(buf) > 0 {
+		p.pool.Put(&buf)
+	}
+}
+
+// releaseChunkData hands the data decodeChunk or decryptChunk returned for c
+// back to the pool it came from, once the caller is done with it. Data that
+// was neither decrypted nor decompressed is the stored chunk itself, which
+// isn't pooled.
+func releaseChunkData(c manifest.ChunkInfo, data []byte) {
+	switch {
+	case c.Compression != "":
+		plainBuffers.put(data)
+	case c.Encrypted:
+		storedBuffers.put(data)
+	}
+}
+
+// flateReaders recycles DEFLATE decompressors and their window buffers
+var flateReaders sync.Pool
+
+// getFlateReader returns a DEFLATE decompressor reading from r
+func getFlateReader(r io.Reader) io.ReadCloser {
+	if fr, ok := flateReaders.Get().(io.ReadCloser); ok {
+		if err := fr.(flate.Resetter).Reset(r, nil); err == nil {
+			return fr
+		}
+	}
+	return flate.NewReader(r)
+}
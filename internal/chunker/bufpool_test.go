@@ -0,0 +1,70 @@
+package chunker
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// BenchmarkChunkBuffers reports the allocations of splitting and assembling
+// encrypted and compressed chunks, which reuse their encryption, decryption
+// and decompression buffers from the pools in bufpool.go. Compare B/op
+// between runs to see what a change to the pools saves.
+func BenchmarkChunkBuffers(b *testing.B) {
+	const size = 64 << 20
+	const chunkSize = 1 << 20
+	cases := []struct {
+		name        string
+		encrypted   bool
+		compression string
+	}{
+		{"encrypted", true, ""},
+		{"deflate", false, manifest.CompressionDeflate},
+		{"deflate+encrypted", true, manifest.CompressionDeflate},
+	}
+
+	for _, tc := range cases {
+		path := writeBenchFile(b, size, tc.compression != "")
+		encConfig := encryption.CreateEncryptionConfig("chunk-store-bench", tc.encrypted)
+		opts := SplitOptions{ChunkSize: chunkSize, Compression: tc.compression}
+
+		keep, source := memChunks(b)
+		discard := func(manifest.ChunkInfo, []byte) error { return nil }
+
+		split := func(sink ChunkSink) (manifest.Manifest, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return manifest.Manifest{}, err
+			}
+			defer f.Close()
+			return SplitReader(f, sink, encConfig, opts)
+		}
+		m, err := split(keep)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run("split/"+tc.name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := split(discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("assemble/"+tc.name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := AssembleWriter(m, source, io.Discard, encConfig, AssembleOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
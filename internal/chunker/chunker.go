@@ -1,39 +1,154 @@
 package chunker
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/erasure"
 	"github.com/probablysamir/chunk-store/internal/manifest"
 	"github.com/schollz/progressbar/v3"
 )
 
 const DefaultChunkSize = 1 * 1024 * 1024
 
+// AutoChunkSize as a chunk size picks one from the input size with ComputeAutoChunkSize
+const AutoChunkSize = -1
+
+// Bounds and target used by ComputeAutoChunkSize
+const (
+	autoChunkTarget = 1000              // Aim for about this many chunks
+	autoChunkMin    = 64 * 1024         // Smallest automatic chunk size
+	autoChunkMax    = 256 * 1024 * 1024 // Largest automatic chunk size
+)
+
+// ComputeAutoChunkSize picks a chunk size that splits a file of fileSize bytes
+// into about 1000 chunks, rounded up to a power of two and clamped to
+// 64 KiB..256 MiB. An unknown (negative) size gets DefaultChunkSize.
+func ComputeAutoChunkSize(fileSize int64) int64 {
+	if fileSize < 0 {
+		return DefaultChunkSize
+	}
+	size := int64(autoChunkMin)
+	for size < fileSize/autoChunkTarget && size < autoChunkMax {
+		size *= 2
+	}
+	return size
+}
+
+// numChunksSize returns the chunk size that splits fileSize bytes into n
+// chunks, the last one possibly shorter. Rounding up can leave fewer than n
+// chunks when n doesn't divide fileSize, e.g. 10 bytes into 6 chunks of 2.
+func numChunksSize(fileSize int64, n int) (int64, error) {
+	if fileSize < 0 {
+		return 0, fmt.Errorf("can't split into %d chunks without knowing the input size", n)
+	}
+	if int64(n) > fileSize {
+		return 0, fmt.Errorf("can't split %d bytes into %d chunks", fileSize, n)
+	}
+	return (fileSize + int64(n) - 1) / int64(n), nil
+}
+
 func SplitFile(path, outDir, manifestPath string, encConfig *encryption.EncryptionConfig) error {
 	return SplitFileWithChunkSize(path, outDir, manifestPath, encConfig, DefaultChunkSize)
 }
 
 func SplitFileWithChunkSize(path, outDir, manifestPath string, encConfig *encryption.EncryptionConfig, chunkSize int64) error {
-	inFile, err := os.Open(path)
-	if err != nil {
-		return err
+	return SplitFileWithOptions(path, outDir, manifestPath, encConfig, SplitOptions{ChunkSize: chunkSize})
+}
+
+// SplitOptions tunes how a file is split
+type SplitOptions struct {
+	ChunkSize         int64             // Size of each chunk in bytes, or AutoChunkSize to pick one from the file size
+	NumChunks         int               // Split into this many chunks instead, of ceil(file size / NumChunks) bytes each; needs a known input size
+	ManifestShardSize int               // Max chunks per manifest shard; 0 writes a single manifest file
+	ChunkStore        string            // Shared content-addressed store to write chunks into instead of outDir
+	HashAlgo          string            // Chunk and file hash algorithm (manifest.HashSHA256 or manifest.HashBLAKE3); empty means SHA-256
+	Mmap              bool              // Memory-map the input and chunk it in place instead of copying it through a buffer
+	DirectKey         bool              // Encrypt chunks with the password-derived key instead of a random file key wrapped in the manifest
+	AAD               string            // What encrypted chunks are bound to as additional authenticated data, encryption.AADChunkHash or empty for nothing. Ignored with ChunkStore.
+	NonceSize         int               // Bytes of GCM nonce encrypted chunks get, recorded in the manifest (default: encryption.DefaultNonceSize). Not with ChunkStore.
+	FlattenEncryption bool              // Store each chunk's nonce in the manifest instead of prepending it, so chunk files are pure ciphertext
+	Tags              map[string]string // Key/value tags recorded in the manifest
+	WrappedKey        string            // Encrypt with this file key from an earlier manifest instead of a new one, so chunks can be shared with it
+	KeyringID         string            // OS keyring ID of the password the file is encrypted with, recorded in the manifest
+	BufferSize        int               // Bytes of input buffered between reads when not memory-mapped (default: DefaultIOBufferSize, negative for unbuffered)
+	ReadAhead         int               // Chunks read ahead of the crypto workers, when not memory-mapped
+	CryptoWorkers     int               // Chunks hashed, compressed and encrypted in parallel (default: one per CPU)
+	IOWorkers         int               // Chunks handed to the sink in parallel (default: one per CPU)
+	Compression       string            // Compress chunks before encryption (manifest.CompressionDeflate), skipping ones that won't compress; empty stores them as-is
+	RecordBoundary    bool              // Extend each chunk to the end of its last line, so newline-delimited records (NDJSON, CSV) aren't split
+	RecordOvershoot   int64             // With RecordBoundary, how far past ChunkSize a chunk may grow to reach a newline (default: ChunkSize)
+	HMACNames         bool              // Name chunks by an HMAC of their hash (manifest.NamingHMAC), so stored names don't reveal content hashes
+	NameKey           string            // Hex key for HMACNames; empty uses the ChunkStore's key, or a new key per file
+	StripMetadata     bool              // Leave the file name and timestamps out of the manifest, see manifest.Manifest.StripMetadata
+	ManifestFormat    string            // Manifest file format (manifest.Format*); empty picks one from the manifest path
+	ChunkHeaders      bool              // Prepend a header with the chunk's place in the file to each chunk file, so Recover can rebuild a lost manifest
+	ChunkExtension    string            // Extension of chunk files, with the dot, recorded in the manifest (default: manifest.DefaultChunkExtension)
+	ErasureData       int               // Chunks per Reed-Solomon parity group, see ErasureParity
+	ErasureParity     int               // Parity chunks written for every ErasureData chunks, any ErasureParity of which can be rebuilt (0 for none). Only when splitting into chunk files, not with ChunkStore.
+}
+
+// chunkExtension returns the extension of the chunk files opts writes
+func chunkExtension(opts SplitOptions) string {
+	if opts.ChunkExtension == "" {
+		return manifest.DefaultChunkExtension
 	}
-	defer inFile.Close()
+	return opts.ChunkExtension
+}
 
-	// Get file info for progress bar
-	fileInfo, err := inFile.Stat()
+// SplitFileWithOptions splits a file, or the body of an http(s) URL, into chunks using the given options.
+// If the split fails, chunk files created by this run are removed again so
+// no orphaned chunks are left behind without a manifest.
+func SplitFileWithOptions(path, outDir, manifestPath string, encConfig *encryption.EncryptionConfig, opts SplitOptions) error {
+	input, fileSize, originalName, err := openSource(path)
 	if err != nil {
 		return err
 	}
-	fileSize := fileInfo.Size()
+	defer input.Close()
+
+	return splitToDir(input, fileSize, originalName, outDir, manifestPath, encConfig, opts, nil)
+}
+
+// SplitReaderToDir splits everything read from r into chunk files in outDir
+// like SplitFileWithOptions, recording originalName in the manifest. The size
+// isn't known up front, so AutoChunkSize falls back to DefaultChunkSize.
+func SplitReaderToDir(r io.Reader, originalName, outDir, manifestPath string, encConfig *encryption.EncryptionConfig, opts SplitOptions) error {
+	return splitToDir(r, -1, originalName, outDir, manifestPath, encConfig, opts, nil)
+}
+
+// splitToDir implements SplitFileWithOptions, SplitReaderToDir and
+// SplitFileRange for an input of fileSize bytes, -1 when unknown. rng is the
+// part of the file input covers, nil for all of it.
+func splitToDir(input io.Reader, fileSize int64, originalName, outDir, manifestPath string, encConfig *encryption.EncryptionConfig, opts SplitOptions, rng *fileRange) (err error) {
+	if opts.NumChunks > 0 {
+		if opts.ChunkSize, err = numChunksSize(fileSize, opts.NumChunks); err != nil {
+			return err
+		}
+	} else if opts.ChunkSize == AutoChunkSize {
+		opts.ChunkSize = ComputeAutoChunkSize(fileSize)
+	}
+	// Parity is computed per file, a shared store's chunks belong to many
+	if opts.ErasureParity > 0 {
+		if opts.ChunkStore != "" {
+			return fmt.Errorf("parity chunks can't be used with a shared chunk store")
+		}
+		if err := erasure.Validate(opts.ErasureData, opts.ErasureParity); err != nil {
+			return err
+		}
+	}
 
-	// Create progress bar
+	// Create progress bar, indeterminate when a URL doesn't report its size
 	bar := progressbar.NewOptions64(fileSize,
 		progressbar.OptionSetDescription("Splitting file..."),
 		progressbar.OptionSetWidth(50),
@@ -46,72 +161,217 @@ func SplitFileWithChunkSize(path, outDir, manifestPath string, encConfig *encryp
 	)
 
 	os.MkdirAll(outDir, 0755)
-	var chunks []manifest.ChunkInfo
-	buf := make([]byte, chunkSize)
-	index := 0
 
-	for {
-		n, err := inFile.Read(buf)
-		// If entire file is read
-		if n == 0 && err == io.EOF {
-			break
+	// Chunk files that didn't exist before this run, removed again on failure
+	var created []string
+	var createdMu sync.Mutex
+	defer func() {
+		if err != nil {
+			removeChunkFiles(created)
 		}
+	}()
 
-		if err != nil && err != io.EOF {
+	chunkPath := func(id string) string {
+		if opts.ChunkStore != "" {
+			return manifest.StorePath(opts.ChunkStore, id)
+		}
+		return filepath.Join(outDir, id+chunkExtension(opts))
+	}
+
+	sink := func(c manifest.ChunkInfo, data []byte) error {
+		path := chunkPath(c.ID)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 			return err
 		}
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+			createdMu.Lock()
+			created = append(created, path)
+			createdMu.Unlock()
+		}
+		// Chunks are written in parallel, so a chunk that is still being
+		// written must never be seen half-written when checking the store
+		return writeFileAtomic(path, data)
+	}
 
-		data := buf[:n]
-		bar.Add(len(data))
+	// Files sharing a store share its name key, so their chunks still dedupe
+	if opts.HMACNames && opts.NameKey == "" && opts.ChunkStore != "" {
+		if opts.NameKey, err = storeNameKey(opts.ChunkStore, true); err != nil {
+			return err
+		}
+	}
 
-		// Encrypt if needed
-		encryptedData, err := encConfig.Encrypt(data)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt chunk: %w", err)
+	var reuse reuseFunc
+	if opts.ChunkStore != "" {
+		reuse = func(id, hexHash string) (int64, string, bool, error) {
+			return reuseStoredChunk(chunkPath(id), hexHash, opts.HashAlgo, encConfig)
 		}
+	}
 
-		// Hash the original data
-		hash := sha256.Sum256(data)
-		id := fmt.Sprintf("%x", hash[:8])
+	// Chunk the file in place when it can be mapped, otherwise read it through a buffer
+	var r io.Reader = io.TeeReader(input, bar)
+	if inFile, ok := input.(*os.File); ok && opts.Mmap {
+		data, unmap, mapErr := mapFile(inFile, fileSize)
+		if mapErr == nil {
+			defer unmap()
+			r = &mappedReader{data: data, onRead: func(n int) { bar.Add(n) }}
+		} else {
+			fmt.Printf("Memory mapping unavailable (%v), using buffered reads\n", mapErr)
+		}
+	}
 
-		chunkPath := filepath.Join(outDir, id+".chunk")
-		err = os.WriteFile(chunkPath, encryptedData, 0644)
-		if err != nil {
+	// Every chunk's header records how many chunks there are, when that is
+	// known from the size up front
+	var header *chunkHeader
+	if opts.ChunkHeaders {
+		count := 0
+		if fileSize >= 0 && !opts.RecordBoundary {
+			size := opts.ChunkSize
+			if size <= 0 {
+				size = DefaultChunkSize
+			}
+			count = int((fileSize + size - 1) / size)
+		}
+		if header, err = newChunkHeader(originalName, count, opts); err != nil {
 			return err
 		}
+	}
 
-		chunks = append(chunks, manifest.ChunkInfo{
-			ID:         id,
-			Hash:       fmt.Sprintf("%x", hash[:]),
-			Index:      index,
-			Encrypted:  encConfig.Enabled,
-			Size:       int64(len(encryptedData)),
-			CloudPaths: []string{}, // Will be populated when uploaded to cloud
-			Providers:  []string{}, // Will be populated when uploaded to cloud
+	m, err := splitStream(r, sink, reuse, header, encConfig, opts)
+	if err != nil {
+		return err
+	}
+	if header != nil {
+		if err := recordRepeats(&m, chunkPath); err != nil {
+			return err
+		}
+	}
+	if fileSize < 0 {
+		// An indeterminate bar only stops its spinner once finished
+		bar.Finish()
+	}
+	if opts.ErasureParity > 0 {
+		err = addParity(&m, outDir, opts, func(path string) {
+			created = append(created, path)
 		})
-		index++
+		if err != nil {
+			return err
+		}
+	}
+	m.OriginalName = originalName
+	if rng != nil {
+		m.BaseOffset = rng.offset
+		m.FileSize = rng.fileSize
+	}
+	return manifest.Save(m, manifestPath)
+}
+
+// reuseStoredChunk checks whether a chunk already exists in a shared store and
+// returns its stored size and SHA-256. Encrypted chunks are only reused if they
+// decrypt with the current key, since the store may be shared by different passwords.
+func reuseStoredChunk(chunkPath, hexHash, hashAlgo string, encConfig *encryption.EncryptionConfig) (int64, string, bool, error) {
+	info, err := os.Stat(chunkPath)
+	if os.IsNotExist(err) {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+
+	// Unencrypted chunks are stored as-is, so a SHA-256 content hash is the stored hash
+	if !encConfig.Enabled && (hashAlgo == "" || hashAlgo == manifest.HashSHA256) {
+		return info.Size(), hexHash, true, nil
+	}
+
+	stored, err := os.ReadFile(chunkPath)
+	if err != nil {
+		return 0, "", false, err
+	}
+	data, err := encConfig.DecryptTo(nil, stored, encConfig.ChunkAAD(hexHash))
+	if err != nil {
+		return 0, "", false, fmt.Errorf("chunk %s already exists in the store but was encrypted with a different password", hexHash)
+	}
+	hash, err := manifest.HashData(hashAlgo, data)
+	if err != nil {
+		return 0, "", false, err
+	}
+	if hash != hexHash {
+		return 0, "", false, fmt.Errorf("%w on stored chunk: %s", manifest.ErrHashMismatch, hexHash)
+	}
+
+	storedHash := sha256.Sum256(stored)
+	return info.Size(), fmt.Sprintf("%x", storedHash[:]), true, nil
+}
+
+// DefaultAssemblyLookahead is how many chunks are prefetched ahead of the writer
+const DefaultAssemblyLookahead = 4
+
+// DefaultIOBufferSize is how much input and output is buffered when no size is set
+const DefaultIOBufferSize = 1 << 20
+
+// ioBufferSize resolves a configured buffer size, where 0 means
+// DefaultIOBufferSize and a negative size means unbuffered (0)
+func ioBufferSize(size int) int {
+	switch {
+	case size == 0:
+		return DefaultIOBufferSize
+	case size < 0:
+		return 0
 	}
-	return manifest.WriteManifest(chunks, manifestPath, filepath.Base(path), encConfig.Enabled)
+	return size
+}
+
+// AssembleOptions tunes how a file is assembled
+type AssembleOptions struct {
+	Lookahead     int                        // Chunks fetched and decrypted ahead of the writer, which bounds the memory used
+	OnChunk       func(c manifest.ChunkInfo) // Called after each chunk is written, e.g. for progress
+	ScratchDir    string                     // Where the output is staged before being moved into place (default: the output's directory)
+	SkipVerify    bool                       // Skip chunk and whole-file hash checks; encrypted chunks are still authenticated by AES-GCM
+	Source        ChunkSource                // Fetches stored chunks instead of reading them from chunksPath, e.g. straight from the cloud
+	OutputMode    string                     // What to do with an existing output file, see Output*; only used by AssembleFileWithOptions
+	BufferSize    int                        // Bytes of output buffered between writes (default: DefaultIOBufferSize, negative for unbuffered)
+	IOWorkers     int                        // Chunks fetched from the source in parallel (default: one per CPU)
+	CryptoWorkers int                        // Chunks decrypted and verified in parallel (default: one per CPU)
+	MaxOutputSize int64                      // Refuse to write an output larger than this many bytes (0 for no limit), see ErrOutputTooLarge
+}
+
+// chunkResult carries a prefetched chunk to the ordered writer
+type chunkResult struct {
+	chunk manifest.ChunkInfo
+	data  []byte
+	hole  int64 // Length of an all-zero chunk to skip over instead of writing
+	err   error
 }
 
 func AssembleFile(manifestPath, chunksPath, outputPath string, encConfig *encryption.EncryptionConfig) error {
+	return AssembleFileWithOptions(manifestPath, chunksPath, outputPath, encConfig, AssembleOptions{})
+}
+
+// AssembleFileWithOptions reads, decrypts and verifies up to opts.Lookahead chunks in
+// parallel while a single writer appends them to the output in Index order.
+// chunksPath may be a comma-separated list of directories, each chunk being
+// read from the first one that has it. Chunks missing from chunksPath are
+// rebuilt from parity first when the manifest has it, see RepairChunks.
+func AssembleFileWithOptions(manifestPath, chunksPath, outputPath string, encConfig *encryption.EncryptionConfig, opts AssembleOptions) error {
+	if err := ValidateOutputMode(opts.OutputMode); err != nil {
+		return err
+	}
+
 	m, err := manifest.ReadManifest(manifestPath)
 	if err != nil {
 		return err
 	}
-
-	// Check if encryption settings match
-	if m.Encrypted && !encConfig.Enabled {
-		return fmt.Errorf("file was encrypted but no decryption key provided")
+	if err := checkEncryption(m, encConfig); err != nil {
+		return err
 	}
-	if !m.Encrypted && encConfig.Enabled {
-		return fmt.Errorf("file was not encrypted but decryption key provided")
+	if err := manifest.CheckIndexes(m); err != nil {
+		return err
 	}
 
-	// Sorting manifest json before fetching data
-	sort.Slice(m.Chunks, func(i, j int) bool {
-		return m.Chunks[i].Index < m.Chunks[j].Index
-	})
+	if opts.OutputMode == OutputCreate {
+		if _, err := os.Lstat(outputPath); err == nil {
+			return fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+		}
+	}
 
 	// Create progress bar for assembly
 	bar := progressbar.NewOptions(len(m.Chunks),
@@ -130,60 +390,555 @@ func AssembleFile(manifestPath, chunksPath, outputPath string, encConfig *encryp
 		return err
 	}
 
-	outFile, err := os.Create(outputPath)
+	// Refuse up front rather than fill the disk and fail mid-write
+	if err := checkOutputSpace(m, outputPath, opts); err != nil {
+		return err
+	}
+
+	source := opts.Source
+	if source == nil {
+		if err := RepairChunks(m, chunksPath); err != nil {
+			return err
+		}
+		source = localSource(m, chunksPath)
+	}
+
+	onChunk := opts.OnChunk
+	opts.OnChunk = func(c manifest.ChunkInfo) {
+		bar.Add(1)
+		if onChunk != nil {
+			onChunk(c)
+		}
+	}
+
+	if m.IsRange() {
+		return layerOntoOutput(m, source, outputPath, encConfig, opts)
+	}
+	if opts.OutputMode == OutputAppend {
+		return appendToOutput(m, source, outputPath, encConfig, opts, bar)
+	}
+
+	// Assemble into a staging file and move it into place once verified, so a
+	// failed assembly never leaves a truncated output behind. Staging next to
+	// the output keeps the final rename on the same volume.
+	scratchDir := opts.ScratchDir
+	if scratchDir == "" {
+		scratchDir = dir
+	}
+	outFile, err := os.CreateTemp(scratchDir, "."+filepath.Base(outputPath)+".partial-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	stagingPath := outFile.Name()
+	defer func() {
+		outFile.Close()
+		os.Remove(stagingPath)
+	}()
+
+	err = AssembleWriter(m, source, outFile, encConfig, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := outFile.Chmod(0644); err != nil {
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+
+	// The output may have appeared while assembling
+	if opts.OutputMode == OutputCreate {
+		if _, err := os.Lstat(outputPath); err == nil {
+			return fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+		}
+	}
+	return moveFile(stagingPath, outputPath)
+}
+
+// appendToOutput assembles straight into outputPath, keeping the whole chunks
+// a previous, interrupted run already wrote to it, so a restore can resume
+// where it stopped. Unlike a staged assembly, a failure leaves the partial
+// output in place for the next attempt.
+func appendToOutput(m manifest.Manifest, source ChunkSource, outputPath string, encConfig *encryption.EncryptionConfig, opts AssembleOptions, bar *progressbar.ProgressBar) error {
+	outFile, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
 	defer outFile.Close()
 
-	for _, c := range m.Chunks {
-		chunkPath := filepath.Join(chunksPath, c.ID+".chunk")
-		encryptedData, err := os.ReadFile(chunkPath)
-		if err != nil {
+	resume, err := resumeOutput(m, outFile, opts.SkipVerify)
+	if err != nil {
+		return err
+	}
+	if resume.chunks > 0 {
+		fmt.Printf("Resuming after %d of %d chunks already in %s\n", resume.chunks, len(m.Chunks), outputPath)
+		bar.Add(resume.chunks)
+	}
+
+	// Drop any partly written chunk after the verified prefix
+	if err := outFile.Truncate(resume.offset); err != nil {
+		return err
+	}
+	if _, err := outFile.Seek(resume.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := assembleWriter(m, sortedChunks(m, resume.chunks), source, outFile, encConfig, opts, resume); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+// AssembleFileToWriter assembles the file described by manifestPath from the
+// chunks in chunksPath and streams it to w instead of a file, e.g. to pipe it
+// into another program. The whole-file hash is checked over the streamed
+// bytes, but only once they have all been written: on error, discard the output.
+func AssembleFileToWriter(manifestPath, chunksPath string, w io.Writer, encConfig *encryption.EncryptionConfig, opts AssembleOptions) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if _, _, err := checkOutputSize(m, opts); err != nil {
+		return err
+	}
+
+	source := opts.Source
+	if source == nil {
+		if err := RepairChunks(m, chunksPath); err != nil {
 			return err
 		}
+		source = localSource(m, chunksPath)
+	}
+	return AssembleWriter(m, source, w, encConfig, opts)
+}
 
-		// Decrypt if needed
-		data, err := encConfig.Decrypt(encryptedData)
-		if err != nil {
-			return fmt.Errorf("failed to decrypt chunk %s: %w", c.ID, err)
+// readChunkFile reads a stored chunk, reporting a missing file as ErrChunkMissing
+func readChunkFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", manifest.ErrChunkMissing, path)
+	}
+	return data, err
+}
+
+// chunkDirs splits chunksPath, a comma-separated list of directories to look
+// for chunks in, in order
+func chunkDirs(chunksPath string) []string {
+	var dirs []string
+	for _, dir := range strings.Split(chunksPath, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
 		}
+	}
+	if len(dirs) == 0 {
+		return []string{chunksPath}
+	}
+	return dirs
+}
 
-		// Verify hash matches
-		hash := sha256.Sum256(data)
-		hexHash := fmt.Sprintf("%x", hash[:])
-		if c.Hash != hexHash {
-			return fmt.Errorf("hash mismatch on chunk id: %s", c.ID)
+// findChunkFile returns the path of a chunk of m in the first of dirs that
+// has it, and false if none does
+func findChunkFile(m manifest.Manifest, dirs []string, c manifest.ChunkInfo) (string, bool) {
+	for _, dir := range dirs {
+		path := m.ChunkPath(dir, c)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
 		}
+	}
+	return "", false
+}
 
-		_, err = outFile.Write(data)
-		if err != nil {
-			return err
+// localSource returns a ChunkSource that reads the chunks of m from
+// chunksPath, looking through each of its directories in turn when it lists
+// several (see chunkDirs)
+func localSource(m manifest.Manifest, chunksPath string) ChunkSource {
+	dirs := chunkDirs(chunksPath)
+	if len(dirs) == 1 {
+		return func(c manifest.ChunkInfo) ([]byte, error) {
+			return readChunkFile(m.ChunkPath(dirs[0], c))
 		}
+	}
+	return func(c manifest.ChunkInfo) ([]byte, error) {
+		path, ok := findChunkFile(m, dirs, c)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is in none of %s", manifest.ErrChunkMissing, c.ID, strings.Join(dirs, ", "))
+		}
+		return readChunkFile(path)
+	}
+}
 
-		bar.Add(1)
+// writeFileAtomic writes data to path through a temporary file, so path is
+// either left as it was or holds all of data
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// moveFile renames src to dst, copying instead when they are on different volumes
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("failed to move assembled file into place: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// ErrNoPasswordCheck is returned by CheckPassword when the manifest has no
+// password check and none of its chunks are available locally
+var ErrNoPasswordCheck = errors.New("manifest has no password check and no chunks are available locally")
+
+// CheckPassword verifies the password in encConfig against a manifest without
+// assembling. It uses the manifest's password check when present and falls
+// back to decrypting the first chunk found in chunksPath, which may list
+// several directories like for AssembleFileWithOptions.
+func CheckPassword(manifestPath, chunksPath string, encConfig *encryption.EncryptionConfig) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	if !m.Encrypted {
+		return fmt.Errorf("file was not encrypted, no password needed")
+	}
+	if err := checkEncryption(m, encConfig); err != nil {
+		return err
+	}
+
+	if m.PasswordCheck != "" {
+		return encConfig.VerifyPasswordCheck(m.PasswordCheck)
+	}
+	if m.WrappedKey != "" {
+		_, err := encConfig.UnwrapKey(m.WrappedKey)
+		return err
+	}
+
+	dirs := chunkDirs(chunksPath)
+	for _, c := range m.Chunks {
+		if c.Zero || !c.Encrypted {
+			continue
+		}
+		chunkPath, ok := findChunkFile(m, dirs, c)
+		if !ok {
+			continue
+		}
+		if _, err := loadChunk(chunkPath, c, m.HashAlgo, encConfig.WithNonceSize(m.NonceSize)); err != nil {
+			return fmt.Errorf("%w (chunk %s could not be decrypted)", encryption.ErrIncorrectPassword, c.ID)
+		}
+		return nil
+	}
+
+	return ErrNoPasswordCheck
+}
+
+// VerifyStoredChunk decrypts and decompresses c, a chunk of m, from its
+// stored form, as read from a chunk file or the cloud, and checks it against
+// its hash
+func VerifyStoredChunk(m manifest.Manifest, c manifest.ChunkInfo, stored []byte, encConfig *encryption.EncryptionConfig) error {
+	if err := checkEncryption(m, encConfig); err != nil {
+		return err
+	}
+	if err := checkChunkEncryption(m, c); err != nil {
+		return err
+	}
+	key, err := chunkKey(m, encConfig)
+	if err != nil {
+		return err
+	}
+	data, err := decodeChunk(stored, c, m.HashAlgo, key)
+	if err == nil {
+		releaseChunkData(c, data)
+	}
+	return err
+}
+
+// Rekey changes the password of an encrypted manifest by rewrapping its file
+// key with newConfig. Chunk data is untouched. The manifest is only rewritten
+// once the rewrapped key has been checked to unwrap with the new password.
+func Rekey(manifestPath string, oldConfig, newConfig *encryption.EncryptionConfig) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	if !m.Encrypted {
+		return fmt.Errorf("file was not encrypted, no password to change")
+	}
+	if m.WrappedKey == "" {
+		return fmt.Errorf("manifest has no wrapped file key (split with direct_key or an older version), re-split the file to change its password")
+	}
+
+	fileKey, err := oldConfig.UnwrapKey(m.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	wrapped, err := newConfig.WrapKey(fileKey)
+	if err != nil {
+		return err
+	}
+	check, err := newConfig.CreatePasswordCheck()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the new password recovers the same key before replacing the old one
+	rewrapped, err := newConfig.UnwrapKey(wrapped)
+	if err != nil || !bytes.Equal(rewrapped, fileKey) {
+		return fmt.Errorf("rewrapped file key could not be verified, manifest left unchanged")
+	}
+
+	m.WrappedKey = wrapped
+	m.PasswordCheck = check
+	m.KeyringID = "" // The keyring holds the old password
+	return manifest.Save(m, manifestPath)
+}
+
+// chunkKey returns the config that decrypts a manifest's chunks: the file key
+// unwrapped with the password, or the password-derived key itself for
+// manifests without a wrapped key, binding chunks to the manifest's AAD
+func chunkKey(m manifest.Manifest, encConfig *encryption.EncryptionConfig) (*encryption.EncryptionConfig, error) {
+	if !encConfig.Enabled {
+		return encConfig, nil
+	}
+	if m.WrappedKey == "" {
+		return encConfig.WithAAD(m.AAD).WithNonceSize(m.NonceSize), nil
+	}
+	fileKey, err := encConfig.UnwrapKey(m.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return encConfig.WithKey(fileKey).WithAAD(m.AAD).WithNonceSize(m.NonceSize), nil
+}
+
+// isZero reports whether data consists only of zero bytes
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return len(data) > 0
+}
+
+// verifyZeroChunk checks that a chunk marked as all-zero matches its recorded hash
+func verifyZeroChunk(c manifest.ChunkInfo, hashAlgo string) error {
+	h, err := manifest.NewHasher(hashAlgo)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(h, zeroReader{}, c.PlainSize); err != nil {
+		return err
+	}
+	if c.Hash != fmt.Sprintf("%x", h.Sum(nil)) {
+		return fmt.Errorf("%w on chunk id: %s", manifest.ErrHashMismatch, c.ID)
 	}
 	return nil
 }
 
+// loadChunk reads a chunk file, decrypts it if needed and verifies its hash
+func loadChunk(chunkPath string, c manifest.ChunkInfo, hashAlgo string, encConfig *encryption.EncryptionConfig) ([]byte, error) {
+	encryptedData, err := os.ReadFile(chunkPath)
+	if err != nil {
+		return nil, err
+	}
+	return decodeChunk(encryptedData, c, hashAlgo, encConfig)
+}
+
+// decodeChunk decrypts a stored chunk if needed and verifies its hash
+func decodeChunk(encryptedData []byte, c manifest.ChunkInfo, hashAlgo string, encConfig *encryption.EncryptionConfig) ([]byte, error) {
+	data, err := decryptChunk(encryptedData, c, encConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify hash matches
+	hexHash, err := manifest.HashData(hashAlgo, data)
+	if err != nil {
+		return nil, err
+	}
+	if c.Hash != hexHash {
+		releaseChunkData(c, data)
+		return nil, fmt.Errorf("%w on chunk id: %s", manifest.ErrHashMismatch, c.ID)
+	}
+
+	return data, nil
+}
+
+// decryptChunk decrypts and decompresses a stored chunk if needed, without
+// verifying its hash. Decrypted or decompressed data is in a pooled buffer,
+// see releaseChunkData. Whether it is decrypted follows the chunk's own
+// Encrypted flag, so a manifest can mix encrypted and plain chunks.
+func decryptChunk(encryptedData []byte, c manifest.ChunkInfo, encConfig *encryption.EncryptionConfig) ([]byte, error) {
+	encryptedData, err := stripChunkHeader(encryptedData, c)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Encrypted {
+		return decompressChunk(encryptedData, c)
+	}
+	if !encConfig.Enabled {
+		return nil, fmt.Errorf("chunk %s is encrypted but no decryption key provided", c.ID)
+	}
+
+	// Decrypt with the nonce from the manifest if it isn't in the chunk
+	var data []byte
+	buf := storedBuffers.get(len(encryptedData))[:0]
+	if c.Nonce != "" {
+		nonce, decodeErr := base64.StdEncoding.DecodeString(c.Nonce)
+		if decodeErr != nil {
+			return nil, fmt.Errorf("invalid nonce for chunk %s: %w", c.ID, decodeErr)
+		}
+		data, err = encConfig.DecryptWithNonceTo(buf, encryptedData, nonce, encConfig.ChunkAAD(c.Hash))
+	} else {
+		data, err = encConfig.DecryptTo(buf, encryptedData, encConfig.ChunkAAD(c.Hash))
+	}
+	if err != nil {
+		storedBuffers.put(buf)
+		return nil, fmt.Errorf("failed to decrypt chunk %s: %w", c.ID, err)
+	}
+	if c.Compression == "" {
+		return data, nil
+	}
+	plain, err := decompressChunk(data, c)
+	storedBuffers.put(data)
+	return plain, err
+}
+
+// removeChunkFiles deletes the chunk files written by a failed split
+func removeChunkFiles(paths []string) {
+	var failed int
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("\nWarning: split failed and %d of %d partial chunk files could not be removed\n", failed, len(paths))
+	} else if len(paths) > 0 {
+		fmt.Printf("\nSplit failed, removed %d partial chunk files\n", len(paths))
+	}
+}
+
+// CleanupOptions selects what CleanupChunksWithOptions removes besides chunk files
+type CleanupOptions struct {
+	RemoveDir    bool   // Remove the chunks directory if it is empty afterwards
+	ManifestPath string // Manifest to remove as well (optional)
+	Extension    string // Extension of the chunk files, with the dot (default: manifest.DefaultChunkExtension)
+}
+
+// CleanupResult reports what a cleanup removed
+type CleanupResult struct {
+	Deleted int // Chunk files removed
+	Failed  int // Chunk files that could not be removed
+}
+
+// cleanupAttempts is how many times removing a file is tried before giving up
+const cleanupAttempts = 3
+
 // CleanupChunks removes all chunk files from the specified directory
 func CleanupChunks(chunksPath string) error {
+	_, err := CleanupChunksWithOptions(chunksPath, CleanupOptions{})
+	return err
+}
+
+// CleanupChunksWithOptions removes all chunk files from the specified directory.
+// A file that can't be removed is retried and then skipped, so one locked file
+// doesn't leave the rest behind; all failures are returned together.
+func CleanupChunksWithOptions(chunksPath string, opts CleanupOptions) (CleanupResult, error) {
+	var result CleanupResult
+
 	entries, err := os.ReadDir(chunksPath)
 	if err != nil {
-		return fmt.Errorf("failed to read chunks directory: %w", err)
+		return result, fmt.Errorf("failed to read chunks directory: %w", err)
+	}
+
+	ext := opts.Extension
+	if ext == "" {
+		ext = manifest.DefaultChunkExtension
 	}
 
-	var deletedCount int
+	var errs []error
 	for _, entry := range entries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".chunk" {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ext {
 			chunkPath := filepath.Join(chunksPath, entry.Name())
-			if err := os.Remove(chunkPath); err != nil {
-				return fmt.Errorf("failed to remove chunk %s: %w", entry.Name(), err)
+			if err := removeWithRetry(chunkPath); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove chunk %s: %w", entry.Name(), err))
+				result.Failed++
+				continue
 			}
-			deletedCount++
+			result.Deleted++
 		}
 	}
 
-	fmt.Printf("Cleaned up %d chunk files\n", deletedCount)
-	return nil
+	if result.Failed > 0 {
+		fmt.Printf("Cleaned up %d chunk files, %d could not be removed\n", result.Deleted, result.Failed)
+	} else {
+		fmt.Printf("Cleaned up %d chunk files\n", result.Deleted)
+	}
+
+	if opts.RemoveDir && result.Failed == 0 {
+		// os.Remove only removes empty directories, so other files are never lost
+		if err := os.Remove(chunksPath); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove chunks directory: %w", err))
+		}
+	}
+
+	if opts.ManifestPath != "" && result.Failed == 0 {
+		if err := removeWithRetry(opts.ManifestPath); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove manifest: %w", err))
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// removeWithRetry removes a file, retrying briefly in case it is temporarily locked
+func removeWithRetry(path string) error {
+	var err error
+	for attempt := 1; attempt <= cleanupAttempts; attempt++ {
+		err = os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if attempt < cleanupAttempts {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+	}
+	return err
 }
@@ -0,0 +1,178 @@
+package chunker
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+const (
+	// compressSampleSize is how much of the start of a chunk is compressed to
+	// estimate whether compressing all of it is worth the CPU
+	compressSampleSize = 8 * 1024
+	// compressSampleRatio is the largest compressed / plain size of the sample
+	// for which the whole chunk is compressed. Video, archives and other
+	// already-compressed data lands well above it.
+	compressSampleRatio = 0.9
+)
+
+// ValidateCompression checks that algo is a supported chunk compression. Empty means none.
+func ValidateCompression(algo string) error {
+	switch algo {
+	case "", manifest.CompressionDeflate:
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression: %s (expected %s)", algo, manifest.CompressionDeflate)
+	}
+}
+
+// compressor compresses chunks for a split, skipping chunks that a sample
+// shows won't compress. It reuses its buffers, so it isn't safe for concurrent use.
+type compressor struct {
+	sample *flate.Writer
+	full   *flate.Writer
+	buf    bytes.Buffer
+}
+
+// newCompressor returns a compressor for algo, or nil when algo is empty
+func newCompressor(algo string) (*compressor, error) {
+	if err := ValidateCompression(algo); err != nil || algo == "" {
+		return nil, err
+	}
+	sample, err := flate.NewWriter(io.Discard, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	full, err := flate.NewWriter(io.Discard, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return &compressor{sample: sample, full: full}, nil
+}
+
+// compress sets chunk's compression fields and returns the data to store,
+// which is data itself unless compressing it made it smaller. The result is
+// only valid until the next call.
+func (c *compressor) compress(data []byte, chunk *manifest.ChunkInfo) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	// Estimate from the start of the chunk first, a small chunk is its own sample
+	if len(data) > compressSampleSize {
+		n, err := c.deflate(c.sample, data[:compressSampleSize])
+		if err != nil {
+			return nil, err
+		}
+		ratio := float64(n) / compressSampleSize
+		if ratio > compressSampleRatio {
+			chunk.CompressDecision = manifest.CompressDecisionIncompressible
+			chunk.CompressRatio = ratio
+			return data, nil
+		}
+	}
+
+	n, err := c.deflate(c.full, data)
+	if err != nil {
+		return nil, err
+	}
+	chunk.CompressRatio = float64(n) / float64(len(data))
+	if n >= len(data) {
+		chunk.CompressDecision = manifest.CompressDecisionNoGain
+		return data, nil
+	}
+	chunk.Compression = manifest.CompressionDeflate
+	chunk.CompressDecision = manifest.CompressDecisionCompressed
+	return c.buf.Bytes(), nil
+}
+
+// deflate compresses data into c.buf with w and returns the compressed size
+func (c *compressor) deflate(w *flate.Writer, data []byte) (int, error) {
+	c.buf.Reset()
+	w.Reset(&c.buf)
+	if _, err := w.Write(data); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return c.buf.Len(), nil
+}
+
+// decompressChunk undoes a chunk's compression, into a buffer from
+// plainBuffers when the chunk is compressed. The output is capped at the
+// chunk's plain size, so corrupt data can't expand without bound.
+func decompressChunk(data []byte, c manifest.ChunkInfo) ([]byte, error) {
+	switch c.Compression {
+	case "":
+		return data, nil
+	case manifest.CompressionDeflate:
+		r := getFlateReader(bytes.NewReader(data))
+		defer flateReaders.Put(r)
+		out := plainBuffers.get(int(c.PlainSize))
+		n, err := io.ReadFull(r, out)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			plainBuffers.put(out)
+			return nil, fmt.Errorf("%w: chunk %s decompressed to %d bytes, expected %d", manifest.ErrHashMismatch, c.ID, n, c.PlainSize)
+		}
+		if err == nil {
+			// Anything past the plain size means the chunk is corrupt too
+			var extra [1]byte
+			if n, err = io.ReadFull(r, extra[:]); n > 0 {
+				plainBuffers.put(out)
+				return nil, fmt.Errorf("%w: chunk %s decompressed to more than the expected %d bytes", manifest.ErrHashMismatch, c.ID, c.PlainSize)
+			}
+		}
+		if err != io.EOF {
+			plainBuffers.put(out)
+			return nil, fmt.Errorf("%w: chunk %s doesn't decompress: %v", manifest.ErrHashMismatch, c.ID, err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("chunk %s uses unsupported compression %s", c.ID, c.Compression)
+	}
+}
+
+// CompressionStats sums up how a file's chunks were compressed
+type CompressionStats struct {
+	Chunks         int   `json:"chunks"` // Chunks compression was considered for
+	Compressed     int   `json:"compressed"`
+	Incompressible int   `json:"incompressible"` // Skipped after compressing a sample
+	NoGain         int   `json:"no_gain"`        // Compressed but not smaller, stored as-is
+	PlainBytes     int64 `json:"plain_bytes"`    // Original size of the considered chunks
+	StoredBytes    int64 `json:"stored_bytes"`   // What they take up stored, including encryption overhead
+}
+
+// Ratio returns stored / plain size, below 1 when compression saved space
+func (s CompressionStats) Ratio() float64 {
+	if s.PlainBytes == 0 {
+		return 1
+	}
+	return float64(s.StoredBytes) / float64(s.PlainBytes)
+}
+
+// Compression returns the compression stats of a manifest's chunks. Chunks
+// split without compression aren't counted.
+func Compression(m manifest.Manifest) CompressionStats {
+	var s CompressionStats
+	for _, c := range m.Chunks {
+		if c.CompressDecision == "" {
+			continue
+		}
+		s.Chunks++
+		s.PlainBytes += c.PlainSize
+		s.StoredBytes += c.Size
+		switch c.CompressDecision {
+		case manifest.CompressDecisionCompressed:
+			s.Compressed++
+		case manifest.CompressDecisionIncompressible:
+			s.Incompressible++
+		case manifest.CompressDecisionNoGain:
+			s.NoGain++
+		}
+	}
+	return s
+}
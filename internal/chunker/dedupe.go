@@ -0,0 +1,201 @@
+package chunker
+
+import (
+	"bufio"
+	"io"
+	"sort"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// DedupeReport summarizes how much chunk-level redundancy there is within and
+// across a set of files, to judge whether deduplication is worth enabling
+type DedupeReport struct {
+	Files        []DedupeFile  `json:"files"`
+	TotalChunks  int           `json:"total_chunks"`
+	UniqueChunks int           `json:"unique_chunks"`
+	TotalBytes   int64         `json:"total_bytes"`
+	UniqueBytes  int64         `json:"unique_bytes"`
+	SavedBytes   int64         `json:"saved_bytes"` // TotalBytes - UniqueBytes
+	TopChunks    []DedupeChunk `json:"top_chunks"`  // Most repeated chunks, most references first
+}
+
+// DedupeFile is the part of a DedupeReport for one file
+type DedupeFile struct {
+	Name         string `json:"name"`
+	FromManifest bool   `json:"from_manifest"` // Chunks were read from a manifest instead of chunking the file
+	ChunkSize    int64  `json:"chunk_size,omitempty"`
+	Chunks       int    `json:"chunks"`
+	UniqueChunks int    `json:"unique_chunks"` // Distinct chunks within this file
+	SharedChunks int    `json:"shared_chunks"` // Distinct chunks that also occur in another file
+	Bytes        int64  `json:"bytes"`
+	SavedBytes   int64  `json:"saved_bytes"` // Bytes saved by deduplicating within this file alone
+}
+
+// DedupeChunk is a chunk that occurs more than once
+type DedupeChunk struct {
+	Hash  string `json:"hash"`
+	Size  int64  `json:"size"`
+	Count int    `json:"count"` // References across all files
+	Files int    `json:"files"` // Files referencing it
+}
+
+// DedupeAnalyzer collects chunk hashes from files and manifests for a DedupeReport
+type DedupeAnalyzer struct {
+	files  []DedupeFile
+	chunks map[string]*dedupeEntry // Keyed by hash algorithm and hash, so algorithms never collide
+	order  []string                // Keys in first-seen order, for a stable report
+}
+
+// dedupeEntry counts the references to one distinct chunk
+type dedupeEntry struct {
+	hash  string
+	size  int64
+	count int
+	files map[int]bool // Indexes into DedupeAnalyzer.files
+}
+
+// NewDedupeAnalyzer creates an empty analyzer
+func NewDedupeAnalyzer() *DedupeAnalyzer {
+	return &DedupeAnalyzer{chunks: make(map[string]*dedupeEntry)}
+}
+
+// AddFile chunks a file, or the body of an http(s) URL, with opts.ChunkSize,
+// opts.HashAlgo and opts.RecordBoundary and adds its chunks. Nothing is written.
+func (a *DedupeAnalyzer) AddFile(path string, opts SplitOptions) error {
+	input, fileSize, name, err := openSource(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	chunkSize := opts.ChunkSize
+	if chunkSize == AutoChunkSize {
+		chunkSize = ComputeAutoChunkSize(fileSize)
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	hashAlgo := opts.HashAlgo
+	if hashAlgo == "" {
+		hashAlgo = manifest.HashSHA256
+	}
+
+	var overshoot int64
+	if opts.RecordBoundary {
+		overshoot = opts.RecordOvershoot
+		if overshoot <= 0 {
+			overshoot = chunkSize
+		}
+	}
+	br := bufio.NewReader(input)
+
+	var chunks []manifest.ChunkInfo
+	buf := make([]byte, chunkSize, chunkSize+overshoot)
+	for {
+		n, err := io.ReadFull(br, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		data := buf[:n]
+		if opts.RecordBoundary && int64(n) == chunkSize && data[n-1] != '\n' {
+			if data, err = extendToNewline(br, data, overshoot); err != nil {
+				return err
+			}
+		}
+
+		hash, err := manifest.HashData(hashAlgo, data)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, manifest.ChunkInfo{Hash: hash, PlainSize: int64(len(data))})
+	}
+
+	a.add(DedupeFile{Name: name, ChunkSize: chunkSize}, hashAlgo, chunks)
+	return nil
+}
+
+// AddManifest adds the chunks recorded in an existing manifest
+func (a *DedupeAnalyzer) AddManifest(manifestPath string) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	hashAlgo := m.HashAlgo
+	if hashAlgo == "" {
+		hashAlgo = manifest.HashSHA256
+	}
+	a.add(DedupeFile{Name: m.OriginalName, FromManifest: true, ChunkSize: m.ChunkSize}, hashAlgo, m.Chunks)
+	return nil
+}
+
+// add records the chunks of one file
+func (a *DedupeAnalyzer) add(file DedupeFile, hashAlgo string, chunks []manifest.ChunkInfo) {
+	index := len(a.files)
+	seen := make(map[string]bool)
+	for _, c := range chunks {
+		size := c.PlainSize
+		if size == 0 {
+			size = c.Size
+		}
+		file.Chunks++
+		file.Bytes += size
+
+		key := hashAlgo + ":" + c.Hash
+		if seen[key] {
+			file.SavedBytes += size
+		} else {
+			seen[key] = true
+			file.UniqueChunks++
+		}
+
+		e, ok := a.chunks[key]
+		if !ok {
+			e = &dedupeEntry{hash: c.Hash, size: size, files: make(map[int]bool)}
+			a.chunks[key] = e
+			a.order = append(a.order, key)
+		}
+		e.count++
+		e.files[index] = true
+	}
+	a.files = append(a.files, file)
+}
+
+// Report computes the report over everything added so far, listing up to top
+// of the most repeated chunks
+func (a *DedupeAnalyzer) Report(top int) DedupeReport {
+	r := DedupeReport{
+		Files:     append([]DedupeFile(nil), a.files...),
+		TopChunks: []DedupeChunk{},
+	}
+
+	for _, key := range a.order {
+		e := a.chunks[key]
+		r.TotalChunks += e.count
+		r.UniqueChunks++
+		r.TotalBytes += e.size * int64(e.count)
+		r.UniqueBytes += e.size
+
+		if len(e.files) > 1 {
+			for i := range e.files {
+				r.Files[i].SharedChunks++
+			}
+		}
+		if e.count > 1 {
+			r.TopChunks = append(r.TopChunks, DedupeChunk{Hash: e.hash, Size: e.size, Count: e.count, Files: len(e.files)})
+		}
+	}
+	r.SavedBytes = r.TotalBytes - r.UniqueBytes
+
+	sort.SliceStable(r.TopChunks, func(i, j int) bool {
+		return r.TopChunks[i].Count > r.TopChunks[j].Count
+	})
+	if top >= 0 && len(r.TopChunks) > top {
+		r.TopChunks = r.TopChunks[:top]
+	}
+	return r
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package chunker
+
+// freeSpace returns the bytes available on the volume holding dir. ok is
+// false where that can't be read, as here.
+func freeSpace(dir string) (free int64, ok bool, err error) {
+	return 0, false, nil
+}
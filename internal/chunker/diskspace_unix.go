@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package chunker
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the volume
+// holding dir. ok is false where that can't be read.
+func freeSpace(dir string) (free int64, ok bool, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true, nil
+}
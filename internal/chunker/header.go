@@ -0,0 +1,160 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// ChunkHeaderVersion is the version of the chunk headers written by SplitOptions.ChunkHeaders
+const ChunkHeaderVersion = 1
+
+// chunkHeaderMagic starts every chunk header. It is followed by the version
+// byte, the length of the header body as a big-endian uint32, and the body,
+// which is JSON. The stored chunk follows the header unchanged.
+var chunkHeaderMagic = []byte("CSCH")
+
+// chunkHeaderPrefix is the size of the magic, version and length before the body
+const chunkHeaderPrefix = 4 + 1 + 4
+
+// chunkHeader describes a chunk in its own file, so the chunk list can be
+// rebuilt from the chunk files alone (see Recover). It sits outside the
+// encrypted data and only holds what the manifest would record anyway.
+type chunkHeader struct {
+	File        string `json:"file"`                  // SHA-256 of the original file name, or a random ID, shared by the file's chunks
+	Index       int    `json:"index"`                 // Position of the chunk in the file
+	Repeats     []int  `json:"repeats,omitempty"`     // Later positions with the same content, which have no chunk file of their own
+	Count       int    `json:"count,omitempty"`       // Chunks in the file, 0 when it wasn't known while splitting
+	PlainSize   int64  `json:"plain_size"`            // Size of the chunk before compression and encryption
+	ChunkSize   int64  `json:"chunk_size"`            // Chunk size the file was split with
+	HashAlgo    string `json:"hash_algo"`             // Algorithm of the chunk and file hashes
+	Encrypted   bool   `json:"encrypted,omitempty"`   // Whether the stored chunk is encrypted
+	Nonce       string `json:"nonce,omitempty"`       // Base64 nonce of a flattened encrypted chunk
+	WrappedKey  string `json:"wrapped_key,omitempty"` // File key wrapped by the password the file was split with
+	Compression string `json:"compression,omitempty"` // What the chunk was compressed with before encryption
+	AAD         string `json:"aad,omitempty"`         // What the encrypted chunk is bound to, see SplitOptions.AAD
+	NonceSize   int    `json:"nonce_size,omitempty"`  // Bytes of GCM nonce the chunk was encrypted with, 0 for 12
+	Hash        string `json:"hash,omitempty"`        // Hash of the chunk, recorded when it is needed to decrypt it
+}
+
+// newChunkHeader returns the header template for the chunks of a file. The
+// file is identified by the hash of its name, or a random ID when the name
+// is unknown or left out of the manifest. count is 0 if not known.
+func newChunkHeader(name string, count int, opts SplitOptions) (*chunkHeader, error) {
+	if name != "" && !opts.StripMetadata {
+		return &chunkHeader{File: headerFileID(name), Count: count}, nil
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &chunkHeader{File: hex.EncodeToString(id), Count: count}, nil
+}
+
+// headerFileID returns the ID chunk headers record for a file name
+func headerFileID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// encode returns the header in its stored form
+func (h chunkHeader) encode() ([]byte, error) {
+	body, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, chunkHeaderPrefix, chunkHeaderPrefix+len(body))
+	copy(buf, chunkHeaderMagic)
+	buf[4] = ChunkHeaderVersion
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(body)))
+	return append(buf, body...), nil
+}
+
+// readChunkHeader reads a chunk header from the start of r and returns it
+// with its size in bytes
+func readChunkHeader(r io.Reader) (chunkHeader, int64, error) {
+	var h chunkHeader
+	prefix := make([]byte, chunkHeaderPrefix)
+	if _, err := io.ReadFull(r, prefix); err != nil || !bytes.Equal(prefix[:4], chunkHeaderMagic) {
+		return h, 0, fmt.Errorf("no chunk header")
+	}
+	if prefix[4] > ChunkHeaderVersion {
+		return h, 0, fmt.Errorf("chunk header version %d is newer than this version supports (%d)", prefix[4], ChunkHeaderVersion)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint32(prefix[5:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return h, 0, fmt.Errorf("truncated chunk header: %w", err)
+	}
+	if err := json.Unmarshal(body, &h); err != nil {
+		return h, 0, fmt.Errorf("invalid chunk header: %w", err)
+	}
+	return h, int64(chunkHeaderPrefix + len(body)), nil
+}
+
+// stripChunkHeader returns the stored chunk after the header of c, if it has one
+func stripChunkHeader(data []byte, c manifest.ChunkInfo) ([]byte, error) {
+	if c.HeaderSize == 0 {
+		return data, nil
+	}
+	if int64(len(data)) < c.HeaderSize || !bytes.HasPrefix(data, chunkHeaderMagic) {
+		return nil, fmt.Errorf("%w: chunk %s is missing its header", manifest.ErrHashMismatch, c.ID)
+	}
+	return data[c.HeaderSize:], nil
+}
+
+// recordRepeats adds the later positions of chunks whose content repeats to
+// the header of the chunk file storing them, and updates the stored size and
+// hash of their manifest entries to match
+func recordRepeats(m *manifest.Manifest, chunkPath func(id string) string) error {
+	entries := make(map[string][]int)
+	for i, c := range m.Chunks {
+		if c.HeaderSize > 0 {
+			entries[c.ID] = append(entries[c.ID], i)
+		}
+	}
+
+	for id, positions := range entries {
+		if len(positions) < 2 {
+			continue
+		}
+		path := chunkPath(id)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		h, size, err := readChunkHeader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		h.Repeats = nil
+		for _, i := range positions[1:] {
+			h.Repeats = append(h.Repeats, m.Chunks[i].Index)
+		}
+		prefix, err := h.encode()
+		if err != nil {
+			return err
+		}
+		stored := append(prefix, data[size:]...)
+		if err := writeFileAtomic(path, stored); err != nil {
+			return err
+		}
+
+		storedHash := sha256.Sum256(stored)
+		for _, i := range positions {
+			m.Chunks[i].HeaderSize = int64(len(prefix))
+			m.Chunks[i].Size = int64(len(stored))
+			m.Chunks[i].CipherHash = fmt.Sprintf("%x", storedHash[:])
+		}
+	}
+	return nil
+}
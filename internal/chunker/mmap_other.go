@@ -0,0 +1,10 @@
+//go:build !unix
+
+package chunker
+
+import "os"
+
+// mapFile isn't supported on this platform, so splitting uses buffered reads
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
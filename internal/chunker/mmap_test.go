@@ -0,0 +1,101 @@
+package chunker
+
+import (
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// writeBenchFile writes size bytes to a file in a temporary directory and
+// returns its path. Compressible data repeats a line of text, other data is
+// random.
+func writeBenchFile(b *testing.B, size int64, compressible bool) string {
+	b.Helper()
+	data := make([]byte, size)
+	if compressible {
+		line := []byte(`{"id": 12345, "name": "chunk-store", "tags": ["backup", "archive"]}` + "\n")
+		for i := range data {
+			data[i] = line[i%len(line)]
+		}
+	} else {
+		rand.NewChaCha8([32]byte{}).Read(data)
+	}
+	path := filepath.Join(b.TempDir(), "input.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// memChunks returns a ChunkSink that keeps copies of stored chunks in memory
+// and a ChunkSource that reads them back, so benchmarks of split and
+// assembly I/O don't touch chunk files
+func memChunks(b *testing.B) (keep ChunkSink, source ChunkSource) {
+	b.Helper()
+	var mu sync.Mutex
+	chunks := make(map[string][]byte)
+	keep = func(c manifest.ChunkInfo, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		chunks[c.ID] = append([]byte(nil), data...)
+		return nil
+	}
+	source = func(c manifest.ChunkInfo) ([]byte, error) { return chunks[c.ID], nil }
+	return keep, source
+}
+
+// BenchmarkSplitRead compares chunking and hashing a file read through a
+// buffer with chunking it in place from a memory mapping. Chunks are
+// discarded, so only reading and hashing are measured.
+func BenchmarkSplitRead(b *testing.B) {
+	const size = 64 << 20
+	path := writeBenchFile(b, size, false)
+	discard := func(manifest.ChunkInfo, []byte) error { return nil }
+	encConfig := encryption.CreateEncryptionConfig("", false)
+	opts := SplitOptions{ChunkSize: 1 << 20}
+
+	b.Run("buffered", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = SplitReader(f, discard, encConfig, opts)
+			f.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("mmap", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			data, unmap, err := mapFile(f, size)
+			if errors.Is(err, errMmapUnsupported) {
+				f.Close()
+				b.Skip("memory mapping isn't supported on this platform")
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = SplitReader(&mappedReader{data: data}, discard, encConfig, opts)
+			unmap()
+			f.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package chunker
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of f read-only and returns the mapping and a
+// function that unmaps it
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, errMmapUnsupported
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,53 @@
+package chunker
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+)
+
+// storeNameKeyFile holds a shared store's key for HMAC chunk names
+const storeNameKeyFile = "name.key"
+
+// storeNameKey returns the hex key for HMAC chunk names in store, generating
+// and saving one first if create is set and the store doesn't have one yet
+func storeNameKey(store string, create bool) (string, error) {
+	path := filepath.Join(store, storeNameKeyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key := strings.TrimSpace(string(data))
+		if _, err := hex.DecodeString(key); err != nil || key == "" {
+			return "", fmt.Errorf("invalid chunk name key in %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return "", fmt.Errorf("failed to read the store's chunk name key: %w", err)
+	}
+
+	raw, err := encryption.GenerateRandomKey()
+	if err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+	if err := os.MkdirAll(store, 0755); err != nil {
+		return "", err
+	}
+	// Another split may have created the key meanwhile, so only write a new file
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return storeNameKey(store, false)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		f.Close()
+		return "", err
+	}
+	return key, f.Close()
+}
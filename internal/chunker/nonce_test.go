@@ -0,0 +1,71 @@
+package chunker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+func TestSplitNonceSize(t *testing.T) {
+	data := make([]byte, 3*4096+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	input := filepath.Join(t.TempDir(), "input.bin")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, flatten := range []bool{false, true} {
+		dir := t.TempDir()
+		chunksDir := filepath.Join(dir, "chunks")
+		manifestPath := filepath.Join(dir, "manifest.json")
+		encConfig := encryption.CreateEncryptionConfig("pw", true)
+		opts := SplitOptions{ChunkSize: 4096, NonceSize: 24, FlattenEncryption: flatten}
+		if err := SplitFileWithOptions(input, chunksDir, manifestPath, encConfig, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := manifest.ReadManifest(manifestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.NonceSize != 24 {
+			t.Fatalf("flatten=%v: manifest records nonce size %d, want 24", flatten, m.NonceSize)
+		}
+		for _, c := range m.Chunks {
+			if want := c.PlainSize + 24 + 16; !flatten && c.Size != want {
+				t.Fatalf("chunk %d is %d bytes, want %d", c.Index, c.Size, want)
+			}
+			if nonce, _ := base64.StdEncoding.DecodeString(c.Nonce); flatten && len(nonce) != 24 {
+				t.Fatalf("chunk %d has a %d byte nonce, want 24", c.Index, len(nonce))
+			}
+		}
+
+		output := filepath.Join(dir, "output.bin")
+		if err := AssembleFileWithOptions(manifestPath, chunksDir, output, encConfig, AssembleOptions{}); err != nil {
+			t.Fatalf("flatten=%v: %v", flatten, err)
+		}
+		if got, _ := os.ReadFile(output); !bytes.Equal(got, data) {
+			t.Fatalf("flatten=%v: assembled file differs from the input", flatten)
+		}
+	}
+}
+
+func TestCheckNonceSize(t *testing.T) {
+	nonce := base64.StdEncoding.EncodeToString(make([]byte, 24))
+	if err := encryption.CheckNonce(nonce, 24); err != nil {
+		t.Fatal(err)
+	}
+	if err := encryption.CheckNonce(nonce, 0); !errors.Is(err, encryption.ErrSchemeMismatch) {
+		t.Fatalf("got %v for a 24 byte nonce in a manifest without a nonce size, want ErrSchemeMismatch", err)
+	}
+	if err := encryption.ValidateNonceSize(8); err == nil {
+		t.Fatal("nonce size 8 was accepted")
+	}
+}
@@ -0,0 +1,215 @@
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// What assembly does with an existing output file
+const (
+	OutputOverwrite = "overwrite" // Replace it once the new output is verified (default)
+	OutputCreate    = "create"    // Fail if it exists
+	OutputAppend    = "append"    // Keep the chunks already written to it and continue after them
+)
+
+// ErrOutputExists is returned in OutputCreate mode when the output already exists
+var ErrOutputExists = errors.New("output already exists")
+
+// ValidateOutputMode checks that mode is an output mode. Empty means OutputOverwrite.
+func ValidateOutputMode(mode string) error {
+	switch mode {
+	case "", OutputOverwrite, OutputCreate, OutputAppend:
+		return nil
+	default:
+		return fmt.Errorf("invalid output mode: %s (expected %s, %s or %s)", mode, OutputCreate, OutputOverwrite, OutputAppend)
+	}
+}
+
+// resumeState is where an appended assembly picks up
+type resumeState struct {
+	chunks   int       // Chunks, in Index order, already in the output
+	offset   int64     // Bytes of the output they cover
+	fileHash hash.Hash // Whole-file hash over those bytes, nil when not verifying
+}
+
+// resumeOutput works out how many whole chunks of m an existing partial
+// output holds. Unless skipVerify is set, each of them is read back and
+// checked against its chunk hash, so a resumed restore never builds on bad
+// data. Bytes after the last whole chunk are left for the caller to truncate.
+func resumeOutput(m manifest.Manifest, f *os.File, skipVerify bool) (resumeState, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return resumeState{}, err
+	}
+	size := info.Size()
+
+	var state resumeState
+	if !skipVerify {
+		if state.fileHash, err = manifest.NewHasher(m.HashAlgo); err != nil {
+			return resumeState{}, err
+		}
+	}
+
+	chunks := append([]manifest.ChunkInfo(nil), m.Chunks...)
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Index < chunks[j].Index
+	})
+
+	var buf []byte
+	for _, c := range chunks {
+		plainSize := c.PlainSize
+		if plainSize == 0 && !c.Encrypted {
+			plainSize = c.Size
+		}
+		if plainSize == 0 || state.offset+plainSize > size {
+			break
+		}
+
+		if !skipVerify {
+			if int64(cap(buf)) < plainSize {
+				buf = make([]byte, plainSize)
+			}
+			data := buf[:plainSize]
+			if _, err := f.ReadAt(data, state.offset); err != nil && err != io.EOF {
+				return resumeState{}, err
+			}
+			sum, err := manifest.HashData(m.HashAlgo, data)
+			if err != nil {
+				return resumeState{}, err
+			}
+			if sum != c.Hash {
+				return resumeState{}, fmt.Errorf("%w: existing output doesn't match chunk %d at offset %d, assemble it again with output mode %s",
+					manifest.ErrHashMismatch, c.Index, state.offset, OutputOverwrite)
+			}
+			state.fileHash.Write(data)
+		}
+
+		state.chunks++
+		state.offset += plainSize
+	}
+	return state, nil
+}
+
+// ErrOutputTooLarge is returned when assembling would write more than
+// AssembleOptions.MaxOutputSize or the free space of the output's volume
+var ErrOutputTooLarge = errors.New("output too large")
+
+// OutputSize returns the size of the file assembling m produces, and how many
+// of its bytes are written rather than left as holes. The chunks are summed
+// as well as TotalSize read, so a corrupt or crafted manifest can't claim to
+// be smaller than its chunks.
+func OutputSize(m manifest.Manifest) (size, written int64, err error) {
+	var total int64
+	for _, c := range m.Chunks {
+		plainSize := c.PlainSize
+		if plainSize == 0 {
+			plainSize = c.Size
+		}
+		if plainSize < 0 || total > math.MaxInt64-plainSize {
+			return 0, 0, fmt.Errorf("%w: chunk %d claims %d bytes", ErrOutputTooLarge, c.Index, plainSize)
+		}
+		total += plainSize
+		if !c.Zero {
+			written += plainSize
+		}
+	}
+	if m.TotalSize < 0 || m.BaseOffset < 0 || m.FileSize < 0 {
+		return 0, 0, fmt.Errorf("%w: manifest claims a negative size", ErrOutputTooLarge)
+	}
+	size = max(total, m.TotalSize)
+	if m.IsRange() {
+		if m.BaseOffset > math.MaxInt64-size {
+			return 0, 0, fmt.Errorf("%w: range ends past the largest possible file", ErrOutputTooLarge)
+		}
+		size = max(m.BaseOffset+size, m.FileSize)
+	}
+	return size, written, nil
+}
+
+// CheckOutputSize reads the manifest at manifestPath and checks that
+// assembling it stays within opts.MaxOutputSize, e.g. before downloading its
+// chunks
+func CheckOutputSize(manifestPath string, opts AssembleOptions) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	_, _, err = checkOutputSize(m, opts)
+	return err
+}
+
+// checkOutputSize checks that assembling m stays within opts.MaxOutputSize,
+// returning OutputSize
+func checkOutputSize(m manifest.Manifest, opts AssembleOptions) (size, written int64, err error) {
+	size, written, err = OutputSize(m)
+	if err != nil {
+		return 0, 0, err
+	}
+	if opts.MaxOutputSize > 0 && size > opts.MaxOutputSize {
+		return 0, 0, fmt.Errorf("%w: the manifest describes %d bytes, more than the limit of %d", ErrOutputTooLarge, size, opts.MaxOutputSize)
+	}
+	return size, written, nil
+}
+
+// checkOutputSpace checks that assembling m into outputPath stays within
+// opts.MaxOutputSize and fits in the free space of the volumes written to:
+// the staging file's and, when it is moved there from another directory, the
+// output's. Bytes an appended or layered output already holds are counted
+// as available. Volumes whose free space can't be read aren't checked.
+func checkOutputSpace(m manifest.Manifest, outputPath string, opts AssembleOptions) error {
+	size, written, err := checkOutputSize(m, opts)
+	if err != nil {
+		return err
+	}
+
+	var existing int64
+	if info, err := os.Stat(outputPath); err == nil {
+		existing = info.Size()
+	}
+	dir := filepath.Dir(outputPath)
+	scratchDir := opts.ScratchDir
+	if scratchDir == "" {
+		scratchDir = dir
+	}
+
+	switch {
+	case m.IsRange():
+		// The range is staged, then written over the existing file
+		if err := needSpace(scratchDir, written); err != nil {
+			return err
+		}
+		return needSpace(dir, size-existing)
+	case opts.OutputMode == OutputAppend:
+		return needSpace(dir, written-existing)
+	}
+	if err := needSpace(scratchDir, written); err != nil {
+		return err
+	}
+	if scratchDir != dir {
+		return needSpace(dir, written)
+	}
+	return nil
+}
+
+// needSpace checks that dir's volume has need bytes free
+func needSpace(dir string, need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	free, ok, err := freeSpace(dir)
+	if err != nil || !ok {
+		return nil
+	}
+	if need > free {
+		return fmt.Errorf("%w: assembling needs %d bytes but only %d are free in %s", ErrOutputTooLarge, need, free, dir)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/probablysamir/chunk-store/internal/erasure"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// addParity computes opts.ErasureParity Reed-Solomon parity chunks for every
+// opts.ErasureData stored chunks of m in dir, writes them there and records
+// them in m.Erasure. Every parity file written is passed to created.
+func addParity(m *manifest.Manifest, dir string, opts SplitOptions, created func(path string)) error {
+	coder, err := erasure.New(opts.ErasureData, opts.ErasureParity)
+	if err != nil {
+		return err
+	}
+
+	m.Erasure = &manifest.Erasure{DataShards: opts.ErasureData, ParityShards: opts.ErasureParity}
+	for g, group := range m.ChunkGroups() {
+		shards, missing := readGroup(*m, []string{dir}, group, nil)
+		if len(missing) > 0 {
+			return fmt.Errorf("%w: chunk %s changed before its parity was computed", manifest.ErrChunkMissing, group[missing[0]].ID)
+		}
+		shards = append(shards, make([][]byte, opts.ErasureParity)...)
+		if err := coder.Encode(shards); err != nil {
+			return err
+		}
+
+		for j, data := range shards[len(group):] {
+			sum := sha256.Sum256(data)
+			hexHash := fmt.Sprintf("%x", sum[:])
+			c := manifest.ChunkInfo{
+				ID:         manifest.ParityIDPrefix + hexHash[:16],
+				Hash:       hexHash,
+				CipherHash: hexHash,
+				Index:      g*opts.ErasureParity + j,
+				Size:       int64(len(data)),
+				Status:     manifest.ChunkStatusLocal,
+				CloudPaths: []string{},
+				Providers:  []string{},
+			}
+			path := m.ChunkPath(dir, c)
+			if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+				created(path)
+			}
+			if err := writeFileAtomic(path, data); err != nil {
+				return fmt.Errorf("failed to write parity chunk: %w", err)
+			}
+			m.Erasure.Parity = append(m.Erasure.Parity, c)
+		}
+	}
+	return nil
+}
+
+// readGroup reads the stored data chunks of a parity group from dirs,
+// followed by its parity chunks, all padded to the size of the largest.
+// Chunks that are missing or damaged are left nil and their positions
+// returned. Positions past the last chunk of the file count as empty chunks,
+// which are never missing.
+func readGroup(m manifest.Manifest, dirs []string, group, parity []manifest.ChunkInfo) ([][]byte, []int) {
+	all := append(group[:len(group):len(group)], parity...)
+	var size int64
+	for _, c := range all {
+		size = max(size, c.Size)
+	}
+
+	shards := make([][]byte, len(all))
+	var missing []int
+	for i, c := range all {
+		if c.ID == "" {
+			shards[i] = make([]byte, size)
+			continue
+		}
+		data, ok := readStoredChunk(m, dirs, c)
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		shards[i] = append(data, make([]byte, size-int64(len(data)))...)
+	}
+	return shards, missing
+}
+
+// readStoredChunk reads the stored form of c from the first of dirs that has
+// it, reporting whether it was found intact
+func readStoredChunk(m manifest.Manifest, dirs []string, c manifest.ChunkInfo) ([]byte, bool) {
+	path, ok := findChunkFile(m, dirs, c)
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !storedIntact(c, data) {
+		return nil, false
+	}
+	return data, true
+}
+
+// storedIntact reports whether data is the stored form of c, by its size and
+// the SHA-256 of stored chunk files when the manifest has it
+func storedIntact(c manifest.ChunkInfo, data []byte) bool {
+	if int64(len(data)) != c.Size {
+		return false
+	}
+	if c.CipherHash == "" {
+		return true
+	}
+	sum := sha256.Sum256(data)
+	return c.CipherHash == fmt.Sprintf("%x", sum[:])
+}
+
+// RepairChunks rebuilds the chunks of m that are missing or damaged in
+// chunksPath from the others in their parity group and the group's parity
+// chunks, writing them into its first directory (see chunkDirs). It does
+// nothing for manifests without parity. When a group has lost more chunks
+// than it has parity chunks, the error wraps manifest.ErrChunkMissing and
+// lists which of the group's chunks and parity chunks are missing.
+func RepairChunks(m manifest.Manifest, chunksPath string) error {
+	if m.Erasure == nil {
+		return nil
+	}
+	if err := manifest.ValidateErasure(m); err != nil {
+		return err
+	}
+	coder, err := erasure.New(m.Erasure.DataShards, m.Erasure.ParityShards)
+	if err != nil {
+		return err
+	}
+	dirs := chunkDirs(chunksPath)
+
+	groups := m.ChunkGroups()
+	var unrecoverable []string
+	repaired := 0
+	for g, group := range groups {
+		// Only groups with a chunk missing need their parity read
+		complete := true
+		for _, c := range group {
+			if c.ID == "" {
+				continue
+			}
+			if _, ok := readStoredChunk(m, dirs, c); !ok {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			continue
+		}
+
+		parity := m.Erasure.GroupParity(g)
+		shards, missing := readGroup(m, dirs, group, parity)
+		if err := coder.Reconstruct(shards); err != nil {
+			unrecoverable = append(unrecoverable, describeLostShards(g, group, parity, missing))
+			continue
+		}
+
+		for _, i := range missing {
+			if i >= len(group) {
+				continue // Parity is only needed again if chunks go missing again
+			}
+			c := group[i]
+			data := shards[i][:c.Size]
+			if !storedIntact(c, data) {
+				return fmt.Errorf("%w: chunk %s rebuilt from parity doesn't match its hash", manifest.ErrHashMismatch, c.ID)
+			}
+			if err := writeFileAtomic(m.ChunkPath(dirs[0], c), data); err != nil {
+				return fmt.Errorf("failed to write rebuilt chunk %s: %w", c.ID, err)
+			}
+			repaired++
+		}
+	}
+
+	if repaired > 0 {
+		fmt.Printf("Rebuilt %d missing chunks from parity\n", repaired)
+	}
+	if len(unrecoverable) > 0 {
+		return fmt.Errorf("%w: too many chunks lost to rebuild from parity (%d data and %d parity chunks per group, any %d rebuild the rest):\n  %s",
+			manifest.ErrChunkMissing, m.Erasure.DataShards, m.Erasure.ParityShards, m.Erasure.DataShards, strings.Join(unrecoverable, "\n  "))
+	}
+	return nil
+}
+
+// describeLostShards names the chunks and parity chunks of group g at the
+// positions in missing
+func describeLostShards(g int, group, parity []manifest.ChunkInfo, missing []int) string {
+	var chunks, parities []string
+	for _, i := range missing {
+		if i < len(group) {
+			chunks = append(chunks, fmt.Sprintf("%d (%s)", group[i].Index, group[i].ID))
+		} else {
+			parities = append(parities, parity[i-len(group)].ID)
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "group %d: missing chunks %s", g, strings.Join(chunks, ", "))
+	if len(parities) > 0 {
+		fmt.Fprintf(&b, " and parity %s", strings.Join(parities, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package chunker
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// splitWithParity splits 10.5 chunks of random data with 4+2 parity, so the
+// last of the 3 groups is short, and returns the manifest and its paths
+func splitWithParity(t *testing.T, encrypted bool) (m manifest.Manifest, input, chunksDir, manifestPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	data := make([]byte, 10*4096+2048)
+	rand.New(rand.NewSource(1)).Read(data)
+	input = filepath.Join(dir, "input.bin")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chunksDir = filepath.Join(dir, "chunks")
+	manifestPath = filepath.Join(dir, "manifest.json")
+	encConfig := encryption.CreateEncryptionConfig("pw", encrypted)
+	opts := SplitOptions{ChunkSize: 4096, ErasureData: 4, ErasureParity: 2}
+	if err := SplitFileWithOptions(input, chunksDir, manifestPath, encConfig, opts); err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Erasure == nil || len(m.Erasure.Parity) != 6 {
+		t.Fatalf("expected 6 parity chunks, got %+v", m.Erasure)
+	}
+	return m, input, chunksDir, manifestPath
+}
+
+func TestAssembleRebuildsFromParity(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		m, input, chunksDir, manifestPath := splitWithParity(t, encrypted)
+
+		// Two chunks of the first group, one of the short last group and a
+		// parity chunk of the middle one, with another chunk damaged
+		os.Remove(m.ChunkPath(chunksDir, m.Chunks[0]))
+		os.Remove(m.ChunkPath(chunksDir, m.Chunks[3]))
+		os.Remove(m.ChunkPath(chunksDir, m.Chunks[10]))
+		os.Remove(m.ChunkPath(chunksDir, m.Erasure.Parity[2]))
+		if err := os.WriteFile(m.ChunkPath(chunksDir, m.Chunks[5]), []byte("damaged"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		output := filepath.Join(t.TempDir(), "output.bin")
+		encConfig := encryption.CreateEncryptionConfig("pw", encrypted)
+		if err := AssembleFileWithOptions(manifestPath, chunksDir, output, encConfig, AssembleOptions{}); err != nil {
+			t.Fatalf("encrypted=%v: %v", encrypted, err)
+		}
+		want, _ := os.ReadFile(input)
+		got, _ := os.ReadFile(output)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("encrypted=%v: assembled file differs from the input", encrypted)
+		}
+	}
+}
+
+func TestRepairChunksNamesLostShards(t *testing.T) {
+	m, _, chunksDir, _ := splitWithParity(t, false)
+
+	// Three losses in a group with two parity chunks
+	for _, c := range []manifest.ChunkInfo{m.Chunks[4], m.Chunks[6], m.Erasure.Parity[3]} {
+		os.Remove(m.ChunkPath(chunksDir, c))
+	}
+
+	err := RepairChunks(m, chunksDir)
+	if !errors.Is(err, manifest.ErrChunkMissing) {
+		t.Fatalf("got %v, want ErrChunkMissing", err)
+	}
+	for _, want := range []string{"group 1", "4 (" + m.Chunks[4].ID + ")", "6 (" + m.Chunks[6].ID + ")", m.Erasure.Parity[3].ID} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %s", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "group 0") || strings.Contains(err.Error(), "group 2") {
+		t.Errorf("error %q mentions an intact group", err)
+	}
+}
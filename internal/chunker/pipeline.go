@@ -0,0 +1,62 @@
+package chunker
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// Split and assembly run as pipelines of stages connected by bounded
+// channels, so disk or network I/O and CPU work overlap and can be sized to
+// the hardware separately:
+//
+// Split:
+//  1. Read: one goroutine reads the input in order, each chunk into a buffer
+//     of its own, up to CryptoWorkers + ReadAhead chunks ahead.
+//  2. Crypto: CryptoWorkers goroutines hash, compress and encrypt chunks.
+//  3. Collect: the manifest is built in chunk order, and repeated chunks are
+//     recorded with the copy stored first.
+//  4. Store: IOWorkers goroutines hand chunks to the sink, e.g. write them
+//     to disk, in any order.
+//
+// Assembly:
+//  1. Fetch: IOWorkers goroutines read stored chunks from their source, a
+//     chunk directory or the cloud.
+//  2. Crypto: CryptoWorkers goroutines decrypt, decompress and verify them.
+//  3. Write: the output is written in chunk order, at most Lookahead chunks
+//     behind the fetch stage.
+
+// errPipelineStopped is the result of work skipped because the pipeline was stopped
+var errPipelineStopped = errors.New("pipeline stopped")
+
+// workerCount resolves a configured number of workers, where 0 means one per CPU
+func workerCount(n int) int {
+	if n <= 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+// splitJob is a chunk read by the split pipeline, on its way to a crypto worker
+type splitJob struct {
+	index  int
+	data   []byte
+	result chan<- splitChunk
+}
+
+// splitChunk is a chunk encoded by a crypto worker
+type splitChunk struct {
+	info   manifest.ChunkInfo
+	data   []byte // Plain data, in a read buffer released once the chunk is stored
+	stored []byte // Stored form, nil when the chunk is already stored
+	pooled bool   // stored is from storedBuffers, to be put back once the chunk is stored
+	err    error
+}
+
+// assembleJob is a chunk on its way through the assembly pipeline
+type assembleJob struct {
+	chunk  manifest.ChunkInfo
+	stored []byte
+	result chan<- chunkResult
+}
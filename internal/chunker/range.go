@@ -0,0 +1,92 @@
+package chunker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// fileRange is the part of a file a range split covers
+type fileRange struct {
+	offset   int64 // Where the range starts
+	fileSize int64 // Size of the whole file
+}
+
+// SplitFileRange splits only length bytes of the file at path, starting at
+// offset, like SplitFileWithOptions; a negative length runs to the end of the
+// file. The manifest records the offset and the file's size, and assembling it
+// writes the chunks over that range of an existing copy of the file, so a
+// changed region, such as an appended tail, can be re-chunked and restored
+// without processing the rest. The file hash only covers the range.
+func SplitFileRange(path string, offset, length int64, outDir, manifestPath string, encConfig *encryption.EncryptionConfig, opts SplitOptions) error {
+	if isURL(path) {
+		return fmt.Errorf("a range can only be split from a local file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if length < 0 {
+		length = size - offset
+	}
+	if offset < 0 || offset > size || length > size-offset {
+		return fmt.Errorf("range of %d bytes at offset %d is outside %s (%d bytes)", length, offset, path, size)
+	}
+
+	input := io.NewSectionReader(f, offset, length)
+	return splitToDir(input, length, filepath.Base(path), outDir, manifestPath, encConfig, opts, &fileRange{offset: offset, fileSize: size})
+}
+
+// layerOntoOutput assembles a range manifest and writes it over its range of
+// the existing outputPath, which is then cut or extended to the size of the
+// file the range was split from. The range is assembled and verified in a
+// staging file first, so the output is only touched once it is known good.
+func layerOntoOutput(m manifest.Manifest, source ChunkSource, outputPath string, encConfig *encryption.EncryptionConfig, opts AssembleOptions) error {
+	if opts.OutputMode == OutputAppend {
+		return fmt.Errorf("a range manifest can't be appended, it is written over its range of %s", outputPath)
+	}
+	outFile, err := os.OpenFile(outputPath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("a range manifest is layered onto an existing copy of the file: %w", err)
+	}
+	defer outFile.Close()
+
+	scratchDir := opts.ScratchDir
+	if scratchDir == "" {
+		scratchDir = filepath.Dir(outputPath)
+	}
+	staging, err := os.CreateTemp(scratchDir, "."+filepath.Base(outputPath)+".range-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer func() {
+		staging.Close()
+		os.Remove(staging.Name())
+	}()
+
+	if err := AssembleWriter(m, source, staging, encConfig, opts); err != nil {
+		return err
+	}
+	if _, err := staging.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(io.NewOffsetWriter(outFile, m.BaseOffset), staging); err != nil {
+		return fmt.Errorf("failed to write range into %s: %w", outputPath, err)
+	}
+	if err := outFile.Truncate(m.FileSize); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
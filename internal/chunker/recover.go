@@ -0,0 +1,238 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// RecoverOptions tunes how Recover rebuilds a manifest
+type RecoverOptions struct {
+	Name           string // Original name, or header file ID, of the file to recover when chunksDir holds chunks of several files
+	ManifestFormat string // Manifest file format (manifest.Format*); empty picks one from the manifest path
+	ChunkExtension string // Extension of the chunk files, with the dot (default: manifest.DefaultChunkExtension)
+}
+
+// RecoverResult describes what Recover found
+type RecoverResult struct {
+	Chunks       int  // Chunks in the rebuilt manifest
+	Skipped      int  // Chunk files without a readable header
+	CountUnknown bool // The chunk count wasn't recorded, so missing trailing chunks can't be noticed
+}
+
+// recoveredChunk is a chunk file found by Recover
+type recoveredChunk struct {
+	id, path   string
+	header     chunkHeader
+	headerSize int64
+}
+
+// Recover rebuilds a lost manifest from chunk files split with
+// SplitOptions.ChunkHeaders, using the place in the file each header records.
+// Every chunk is read, decrypted and hashed again. The password must be the
+// one the file was split with, since that is what the headers' wrapped key is
+// encrypted with. Chunk files without a header are skipped.
+func Recover(chunksDir, manifestPath string, encConfig *encryption.EncryptionConfig, opts RecoverOptions) (RecoverResult, error) {
+	var res RecoverResult
+	ext := opts.ChunkExtension
+	if ext == "" {
+		ext = manifest.DefaultChunkExtension
+	}
+	paths, err := filepath.Glob(filepath.Join(chunksDir, "*"+ext))
+	if err != nil {
+		return res, err
+	}
+
+	// Group the chunk files by the file they belong to
+	files := make(map[string][]recoveredChunk)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return res, err
+		}
+		h, size, err := readChunkHeader(f)
+		f.Close()
+		if err != nil {
+			res.Skipped++
+			continue
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ext)
+		files[h.File] = append(files[h.File], recoveredChunk{id: id, path: path, header: h, headerSize: size})
+	}
+
+	fileID := ""
+	if opts.Name != "" {
+		fileID = headerFileID(opts.Name)
+		if _, ok := files[fileID]; !ok {
+			fileID = opts.Name
+		}
+	} else if len(files) == 1 {
+		for id := range files {
+			fileID = id
+		}
+	}
+	found, ok := files[fileID]
+	if !ok {
+		if len(files) == 0 {
+			return res, fmt.Errorf("no chunk files with headers in %s", chunksDir)
+		}
+		ids := make([]string, 0, len(files))
+		for id, chunks := range files {
+			ids = append(ids, fmt.Sprintf("%s (%d chunk files)", id, len(chunks)))
+		}
+		sort.Strings(ids)
+		if opts.Name != "" {
+			return res, fmt.Errorf("no chunks of %s in %s, which has chunks of: %s", opts.Name, chunksDir, strings.Join(ids, ", "))
+		}
+		return res, fmt.Errorf("%s has chunks of several files, pick one by its name or file ID: %s", chunksDir, strings.Join(ids, ", "))
+	}
+
+	// Place every chunk, and the positions that repeat it, in the file
+	first := found[0].header
+	positions := make(map[int]int)
+	last := -1
+	for i, c := range found {
+		h := c.header
+		if h.Count != first.Count || h.ChunkSize != first.ChunkSize || h.HashAlgo != first.HashAlgo || h.WrappedKey != first.WrappedKey || h.AAD != first.AAD || h.NonceSize != first.NonceSize {
+			return res, fmt.Errorf("chunk headers of %s and %s disagree about the file", found[0].path, c.path)
+		}
+		for _, index := range append([]int{h.Index}, h.Repeats...) {
+			if prev, ok := positions[index]; ok && found[prev].id != c.id {
+				return res, fmt.Errorf("chunk %d is claimed by both %s and %s", index, found[prev].path, c.path)
+			}
+			positions[index] = i
+			last = max(last, index)
+		}
+	}
+
+	count := first.Count
+	if count == 0 {
+		count = last + 1
+		res.CountUnknown = true
+	}
+	var missing []int
+	for index := 0; index < count; index++ {
+		if _, ok := positions[index]; !ok {
+			missing = append(missing, index)
+		}
+	}
+	if len(missing) > 0 {
+		return res, fmt.Errorf("%w: %d of %d chunks have no chunk file, starting with chunk %d", manifest.ErrChunkMissing, len(missing), count, missing[0])
+	}
+	if last >= count {
+		return res, fmt.Errorf("chunk %d is past the %d chunks the headers record", last, count)
+	}
+
+	// Encrypted chunks are decrypted with the file key the headers carry
+	dataKey := encConfig
+	encrypted := false
+	for _, c := range found {
+		encrypted = encrypted || c.header.Encrypted
+	}
+	if encrypted && !encConfig.Enabled {
+		return res, fmt.Errorf("the chunks are encrypted, a password is needed to recover them")
+	}
+	if encrypted && first.WrappedKey != "" {
+		fileKey, err := encConfig.UnwrapKey(first.WrappedKey)
+		if err != nil {
+			return res, err
+		}
+		dataKey = encConfig.WithKey(fileKey)
+	}
+	if encrypted {
+		if err := encryption.CheckAAD(first.AAD); err != nil {
+			return res, err
+		}
+		if err := encryption.ValidateNonceSize(first.NonceSize); err != nil {
+			return res, err
+		}
+		dataKey = dataKey.WithAAD(first.AAD).WithNonceSize(first.NonceSize)
+	}
+
+	hashAlgo := first.HashAlgo
+	if hashAlgo == "" {
+		hashAlgo = manifest.HashSHA256
+	}
+	fileHash, err := manifest.NewHasher(hashAlgo)
+	if err != nil {
+		return res, err
+	}
+
+	// Read every chunk in order to fill in its hashes and sizes
+	chunks := make([]manifest.ChunkInfo, 0, count)
+	for index := 0; index < count; index++ {
+		c := found[positions[index]]
+		stored, err := os.ReadFile(c.path)
+		if err != nil {
+			return res, err
+		}
+		storedHash := sha256.Sum256(stored)
+		chunk := manifest.ChunkInfo{
+			ID:          c.id,
+			Index:       index,
+			Encrypted:   c.header.Encrypted,
+			Nonce:       c.header.Nonce,
+			Compression: c.header.Compression,
+			PlainSize:   c.header.PlainSize,
+			HeaderSize:  c.headerSize,
+			Size:        int64(len(stored)),
+			CipherHash:  fmt.Sprintf("%x", storedHash[:]),
+			Status:      manifest.ChunkStatusLocal,
+			CloudPaths:  []string{},
+			Providers:   []string{},
+		}
+		if chunk.Compression != "" {
+			chunk.CompressDecision = manifest.CompressDecisionCompressed
+		}
+
+		// Chunks bound to their hashes need the header's hash to decrypt
+		chunk.Hash = c.header.Hash
+		data, err := decryptChunk(stored, chunk, dataKey)
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", c.path, err)
+		}
+		if chunk.Hash, err = manifest.HashData(hashAlgo, data); err != nil {
+			return res, err
+		}
+		if c.header.Hash != "" && chunk.Hash != c.header.Hash {
+			return res, fmt.Errorf("%w: %s doesn't match the hash in its header", manifest.ErrHashMismatch, c.path)
+		}
+		chunk.Zero = isZero(data)
+		fileHash.Write(data)
+		chunks = append(chunks, chunk)
+	}
+
+	m := manifest.NewManifest(chunks, "", encrypted, "local")
+	if opts.Name != "" && fileID == headerFileID(opts.Name) {
+		m.OriginalName = opts.Name
+	}
+	m.Format = opts.ManifestFormat
+	if ext != manifest.DefaultChunkExtension {
+		m.ChunkExtension = ext
+	}
+	m.HashAlgo = hashAlgo
+	m.ChunkSize = first.ChunkSize
+	m.FileHash = fmt.Sprintf("%x", fileHash.Sum(nil))
+	if m.MerkleRoot, err = manifest.ComputeMerkleRoot(m); err != nil {
+		return res, err
+	}
+	if encrypted {
+		m.WrappedKey = first.WrappedKey
+		m.Cipher = encConfig.Cipher()
+		m.KDF = encConfig.KDF()
+		m.AAD = first.AAD
+		m.NonceSize = first.NonceSize
+		if m.PasswordCheck, err = encConfig.CreatePasswordCheck(); err != nil {
+			return res, err
+		}
+	}
+
+	res.Chunks = len(chunks)
+	return res, manifest.Save(m, manifestPath)
+}
@@ -0,0 +1,105 @@
+package chunker
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// ReindexFile rebuilds a lost manifest for chunks that already exist in
+// chunksDir (or opts.ChunkStore). The original file is chunked again with the
+// same options to recover the chunk order, and each chunk is matched to its
+// existing chunk file by hash. No chunk files are written or modified.
+//
+// opts must match the original split (chunk size and hash algorithm).
+// Encrypted chunks can only be matched if they were split with DirectKey or
+// into a shared store, since a per-file key was only stored in the lost
+// manifest. Compressed chunks can't be matched. Chunks named by HMAC need the
+// same name key, which a shared store keeps in its name.key file.
+func ReindexFile(path, chunksDir, manifestPath string, encConfig *encryption.EncryptionConfig, opts SplitOptions) error {
+	input, fileSize, originalName, err := openSource(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	if opts.ChunkSize == AutoChunkSize {
+		opts.ChunkSize = ComputeAutoChunkSize(fileSize)
+	}
+
+	chunkPath := func(id string) string {
+		if opts.ChunkStore != "" {
+			return manifest.StorePath(opts.ChunkStore, id)
+		}
+		return filepath.Join(chunksDir, id+chunkExtension(opts))
+	}
+
+	// Chunks named by HMAC can only be found again with the same key
+	if opts.HMACNames && opts.NameKey == "" {
+		if opts.ChunkStore == "" {
+			return fmt.Errorf("chunks named by HMAC can only be matched with the name key of the lost manifest (name_key)")
+		}
+		if opts.NameKey, err = storeNameKey(opts.ChunkStore, false); err != nil {
+			return err
+		}
+	}
+
+	// Existing chunks were encrypted with the password itself, if at all. Whether
+	// a chunk was compressed was only recorded in the lost manifest, so chunks
+	// are matched as stored uncompressed.
+	opts.DirectKey = true
+	opts.Compression = ""
+
+	// Whether chunks were bound to their hashes was also only recorded in the
+	// lost manifest, so the first encrypted chunk found tells. Chunks in a
+	// shared store never are.
+	var (
+		aadMu    sync.Mutex
+		aad      string
+		aadKnown = !encConfig.Enabled || opts.ChunkStore != ""
+	)
+	reuse := func(id, hexHash string) (int64, string, bool, error) {
+		aadMu.Lock()
+		scheme, known := aad, aadKnown
+		aadMu.Unlock()
+		size, cipherHash, found, err := reuseStoredChunk(chunkPath(id), hexHash, opts.HashAlgo, encConfig.WithAAD(scheme).WithNonceSize(opts.NonceSize))
+		if err != nil && !known {
+			scheme = encryption.AADChunkHash
+			size, cipherHash, found, err = reuseStoredChunk(chunkPath(id), hexHash, opts.HashAlgo, encConfig.WithAAD(scheme).WithNonceSize(opts.NonceSize))
+		}
+		if err == nil && found && !known {
+			aadMu.Lock()
+			aad, aadKnown = scheme, true
+			aadMu.Unlock()
+		}
+		if err != nil && encConfig.Enabled {
+			return 0, "", false, fmt.Errorf("%s can't be decrypted: wrong password, or it was encrypted with a per-file key that was only stored in the lost manifest", chunkPath(id))
+		}
+		if err != nil {
+			return 0, "", false, err
+		}
+		if !found {
+			return 0, "", false, fmt.Errorf("%w: chunk file %s not found (check the chunk size and hash algorithm match the original split)", manifest.ErrChunkMissing, chunkPath(id))
+		}
+		return size, cipherHash, true, nil
+	}
+
+	// Every chunk either matches an existing file or fails in reuse, so
+	// nothing ever reaches the sink
+	sink := func(c manifest.ChunkInfo, data []byte) error {
+		return fmt.Errorf("%w: chunk file %s not found", manifest.ErrChunkMissing, chunkPath(c.ID))
+	}
+
+	m, err := splitStream(input, sink, reuse, nil, encConfig, opts)
+	if err != nil {
+		return err
+	}
+	m.OriginalName = originalName
+	if m.Encrypted {
+		m.AAD = aad
+	}
+	return manifest.Save(m, manifestPath)
+}
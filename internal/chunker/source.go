@@ -0,0 +1,61 @@
+package chunker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isURL reports whether an input path is an http(s) URL rather than a local file
+func isURL(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
+
+// openSource opens the input to split and returns it with its size (-1 when
+// unknown) and the name to record as the manifest's OriginalName. URLs are
+// streamed straight from the response body without touching the disk.
+func openSource(input string) (io.ReadCloser, int64, string, error) {
+	if !isURL(input) {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, "", err
+		}
+		return f, info.Size(), filepath.Base(input), nil
+	}
+
+	u, err := url.Parse(input)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("invalid url: %w", err)
+	}
+
+	// The default client follows redirects
+	resp, err := http.Get(input)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to download %s: %w", input, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, "", fmt.Errorf("failed to download %s: %s", input, resp.Status)
+	}
+
+	// Name the file after the final URL path, following any redirects
+	if resp.Request != nil && resp.Request.URL != nil {
+		u = resp.Request.URL
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = u.Hostname()
+	}
+
+	return resp.Body, resp.ContentLength, name, nil
+}
@@ -0,0 +1,80 @@
+package chunker
+
+import "github.com/probablysamir/chunk-store/internal/manifest"
+
+// StatsResult summarizes a manifest's chunks
+type StatsResult struct {
+	TotalSize    int64                    `json:"total_size"`  // The manifest's total_size, which Save computes from the stored chunk sizes
+	PlainSize    int64                    `json:"plain_size"`  // Size of the original data the chunks hold, before compression and encryption
+	StoredSize   int64                    `json:"stored_size"` // What the chunks take up stored, including compression and encryption
+	ChunkCount   int                      `json:"chunk_count"`
+	AvgChunkSize int64                    `json:"avg_chunk_size"` // Chunk sizes are of the original data
+	MinChunkSize int64                    `json:"min_chunk_size"`
+	MaxChunkSize int64                    `json:"max_chunk_size"`
+	Encrypted    bool                     `json:"encrypted"`
+	Providers    map[string]ProviderStats `json:"providers,omitempty"`    // Copies per cloud provider, empty when nothing was uploaded
+	NotUploaded  int                      `json:"not_uploaded,omitempty"` // Chunks without a cloud copy, only counted once something was uploaded
+}
+
+// ProviderStats counts the chunk copies stored on one cloud provider
+type ProviderStats struct {
+	Chunks int   `json:"chunks"`
+	Bytes  int64 `json:"bytes"` // Stored size of those chunks
+}
+
+// Stats reads the manifest at manifestPath and summarizes its chunks
+func Stats(manifestPath string) (StatsResult, error) {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return StatsResult{}, err
+	}
+	return ManifestStats(m), nil
+}
+
+// ManifestStats summarizes the chunks of an already loaded manifest
+func ManifestStats(m manifest.Manifest) StatsResult {
+	s := StatsResult{
+		TotalSize:  m.TotalSize,
+		ChunkCount: len(m.Chunks),
+		Encrypted:  m.Encrypted,
+	}
+
+	var plainTotal int64
+	notUploaded := 0
+	for i, c := range m.Chunks {
+		plainSize := c.PlainSize
+		if plainSize == 0 && !c.Encrypted {
+			plainSize = c.Size
+		}
+		plainTotal += plainSize
+		s.StoredSize += c.Size
+		if i == 0 || plainSize < s.MinChunkSize {
+			s.MinChunkSize = plainSize
+		}
+		if plainSize > s.MaxChunkSize {
+			s.MaxChunkSize = plainSize
+		}
+
+		if len(c.Providers) == 0 {
+			notUploaded++
+			continue
+		}
+		if s.Providers == nil {
+			s.Providers = make(map[string]ProviderStats)
+		}
+		for _, p := range c.Providers {
+			ps := s.Providers[p]
+			ps.Chunks++
+			ps.Bytes += c.Size
+			s.Providers[p] = ps
+		}
+	}
+	s.PlainSize = plainTotal
+	if s.ChunkCount > 0 {
+		s.AvgChunkSize = plainTotal / int64(s.ChunkCount)
+	}
+	if s.Providers != nil {
+		s.NotUploaded = notUploaded
+	}
+	return s
+}
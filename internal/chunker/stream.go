@@ -0,0 +1,920 @@
+package chunker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// ChunkSink stores a chunk produced by SplitReader. data is the stored
+// (possibly encrypted) form of the chunk and is only valid during the call.
+// It is called from SplitOptions.IOWorkers goroutines at once, so chunks may
+// be stored out of order.
+type ChunkSink func(c manifest.ChunkInfo, data []byte) error
+
+// ChunkSource returns the stored (possibly encrypted) form of a chunk for AssembleWriter
+type ChunkSource func(c manifest.ChunkInfo) ([]byte, error)
+
+// errMmapUnsupported is returned by mapFile when a file can't be memory-mapped
+var errMmapUnsupported = errors.New("memory mapping not supported")
+
+// mappedReader hands out chunks of a memory-mapped file without copying them
+type mappedReader struct {
+	data   []byte
+	offset int
+	onRead func(n int) // Progress callback
+}
+
+// nextChunk returns a slice of up to n bytes referencing the mapping, or io.EOF
+func (r *mappedReader) nextChunk(n int64) ([]byte, error) {
+	if r.offset >= len(r.data) {
+		return nil, io.EOF
+	}
+	end := r.offset + int(min(n, int64(len(r.data)-r.offset)))
+	chunk := r.data[r.offset:end]
+	r.offset = end
+	if r.onRead != nil {
+		r.onRead(len(chunk))
+	}
+	return chunk, nil
+}
+
+// extendToNewline grows chunk, the slice last returned by nextChunk, up to and
+// including the next newline, by at most max bytes
+func (r *mappedReader) extendToNewline(chunk []byte, max int64) []byte {
+	window := r.data[r.offset:]
+	if int64(len(window)) > max {
+		window = window[:max]
+	}
+	n := len(window)
+	if i := bytes.IndexByte(window, '\n'); i >= 0 {
+		n = i + 1
+	}
+	r.offset += n
+	if r.onRead != nil {
+		r.onRead(n)
+	}
+	return chunk[:len(chunk)+n]
+}
+
+// extendToNewline appends what br holds up to and including the next newline
+// to data, reading at most max bytes. It stops early at the end of the input.
+func extendToNewline(br *bufio.Reader, data []byte, max int64) ([]byte, error) {
+	for max > 0 {
+		if br.Buffered() == 0 {
+			if _, err := br.Peek(1); err == io.EOF {
+				return data, nil
+			} else if err != nil {
+				return data, err
+			}
+		}
+		window, _ := br.Peek(int(min(int64(br.Buffered()), max)))
+		n := len(window)
+		found := false
+		if i := bytes.IndexByte(window, '\n'); i >= 0 {
+			n, found = i+1, true
+		}
+		data = append(data, window[:n]...)
+		br.Discard(n)
+		if found {
+			return data, nil
+		}
+		max -= int64(n)
+	}
+	return data, nil
+}
+
+// Read copies from the mapping, for callers that don't use nextChunk
+func (r *mappedReader) Read(p []byte) (int, error) {
+	chunk, err := r.nextChunk(int64(len(p)))
+	return copy(p, chunk), err
+}
+
+// reuseFunc reports whether a chunk is already stored and, if so, its stored size and hash
+type reuseFunc func(id, hexHash string) (size int64, cipherHash string, reused bool, err error)
+
+// SplitReader splits everything read from r into chunks and hands each
+// distinct chunk to sink once. It returns the manifest describing
+// the chunks; the caller sets OriginalName before saving it. The size of r
+// isn't known, so opts.NumChunks can't be used.
+func SplitReader(r io.Reader, sink ChunkSink, encConfig *encryption.EncryptionConfig, opts SplitOptions) (manifest.Manifest, error) {
+	if opts.NumChunks > 0 {
+		_, err := numChunksSize(-1, opts.NumChunks)
+		return manifest.Manifest{}, err
+	}
+	var header *chunkHeader
+	if opts.ChunkHeaders {
+		var err error
+		if header, err = newChunkHeader("", 0, opts); err != nil {
+			return manifest.Manifest{}, err
+		}
+	}
+	return splitStream(r, sink, nil, header, encConfig, opts)
+}
+
+// splitStream implements SplitReader. If reuse is set it is consulted before
+// encrypting a chunk, and sink isn't called for chunks it reports as stored.
+// With opts.ChunkHeaders, header is the template of the chunk headers.
+func splitStream(r io.Reader, sink ChunkSink, reuse reuseFunc, header *chunkHeader, encConfig *encryption.EncryptionConfig, opts SplitOptions) (manifest.Manifest, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	// A chunk's nonce is only recorded in the manifest of the run that wrote it
+	if opts.FlattenEncryption && opts.ChunkStore != "" {
+		return manifest.Manifest{}, fmt.Errorf("flattened encryption can't be used with a shared chunk store")
+	}
+	// Whether a stored chunk is compressed is also only recorded in a manifest
+	if opts.Compression != "" && opts.ChunkStore != "" {
+		return manifest.Manifest{}, fmt.Errorf("compression can't be used with a shared chunk store")
+	}
+	// A chunk header describes the chunk's place in a single file
+	if opts.ChunkHeaders && opts.ChunkStore != "" {
+		return manifest.Manifest{}, fmt.Errorf("chunk headers can't be used with a shared chunk store")
+	}
+	// Every file in a shared store has to find the chunks of the others
+	if chunkExtension(opts) != manifest.DefaultChunkExtension && opts.ChunkStore != "" {
+		return manifest.Manifest{}, fmt.Errorf("a chunk extension other than %s can't be used with a shared chunk store", manifest.DefaultChunkExtension)
+	}
+	if err := ValidateCompression(opts.Compression); err != nil {
+		return manifest.Manifest{}, err
+	}
+
+	hashAlgo := opts.HashAlgo
+	if hashAlgo == "" {
+		hashAlgo = manifest.HashSHA256
+	}
+	fileHash, err := manifest.NewHasher(hashAlgo)
+	if err != nil {
+		return manifest.Manifest{}, err
+	}
+
+	// Chunks are encrypted with a random file key wrapped by the password, so the
+	// password can change without re-encrypting them. A shared store is encrypted
+	// with the password itself so chunks still dedupe across files.
+	dataKey := encConfig
+	var wrappedKey string
+	if encConfig.Enabled && !opts.DirectKey && opts.ChunkStore == "" && opts.WrappedKey != "" {
+		fileKey, err := encConfig.UnwrapKey(opts.WrappedKey)
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+		wrappedKey = opts.WrappedKey
+		dataKey = encConfig.WithKey(fileKey)
+	} else if encConfig.Enabled && !opts.DirectKey && opts.ChunkStore == "" {
+		fileKey, err := encryption.GenerateRandomKey()
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+		wrappedKey, err = encConfig.WrapKey(fileKey)
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+		dataKey = encConfig.WithKey(fileKey)
+	}
+
+	// Chunks are bound to their hashes unless they go to a shared store, whose
+	// chunks are shared with files split before there was AAD
+	if dataKey.Enabled && opts.ChunkStore == "" && opts.AAD != "" {
+		if err := encryption.CheckAAD(opts.AAD); err != nil {
+			return manifest.Manifest{}, err
+		}
+		dataKey = dataKey.WithAAD(opts.AAD)
+	}
+
+	// Chunks in a shared store must all have the nonce size the store's
+	// chunks were encrypted with, so only files of their own can change it
+	if err := encryption.ValidateNonceSize(opts.NonceSize); err != nil {
+		return manifest.Manifest{}, err
+	}
+	if opts.NonceSize != 0 && opts.NonceSize != encryption.DefaultNonceSize && opts.ChunkStore != "" {
+		return manifest.Manifest{}, fmt.Errorf("a nonce size other than %d can't be used with a shared chunk store", encryption.DefaultNonceSize)
+	}
+	if dataKey.Enabled && opts.NonceSize != encryption.DefaultNonceSize {
+		dataKey = dataKey.WithNonceSize(opts.NonceSize)
+	}
+
+	if header != nil {
+		h := *header
+		h.ChunkSize, h.HashAlgo, h.WrappedKey = chunkSize, hashAlgo, wrappedKey
+		header = &h
+	}
+
+	// Chunk IDs are the hash unless they are keyed. The key is kept in the
+	// manifest, which lists the chunk hashes anyway.
+	naming, nameKey := manifest.NamingHash, ""
+	if opts.HMACNames {
+		naming, nameKey = manifest.NamingHMAC, opts.NameKey
+		if nameKey == "" {
+			key, err := encryption.GenerateRandomKey()
+			if err != nil {
+				return manifest.Manifest{}, err
+			}
+			nameKey = hex.EncodeToString(key)
+		}
+	}
+
+	// With record boundaries a chunk that doesn't end a line is extended to
+	// the next newline. A line that doesn't end within the overshoot is split.
+	var overshoot int64
+	if opts.RecordBoundary {
+		overshoot = opts.RecordOvershoot
+		if overshoot <= 0 {
+			overshoot = chunkSize
+		}
+	}
+
+	// A mapped file is chunked in place; other readers are copied into buffers.
+	// Reads are buffered too, so small chunks don't each cost a read call.
+	mapped, _ := r.(*mappedReader)
+	var br *bufio.Reader
+	if mapped == nil {
+		if size := ioBufferSize(opts.BufferSize); size > 0 {
+			br = bufio.NewReaderSize(r, size)
+			r = br
+		} else if opts.RecordBoundary {
+			// Finding the next newline needs to look ahead
+			br = bufio.NewReader(r)
+			r = br
+		}
+	}
+
+	// readChunk returns the next chunk, read into buf unless the file is
+	// mapped, or io.EOF after the last one
+	readChunk := func(buf []byte) ([]byte, error) {
+		var data []byte
+		if mapped != nil {
+			var err error
+			if data, err = mapped.nextChunk(chunkSize); err != nil {
+				return nil, err
+			}
+		} else {
+			// Fill the whole buffer so chunk boundaries don't depend on how
+			// the reader splits its reads; only the last chunk may be short
+			n, err := io.ReadFull(r, buf[:chunkSize])
+			if err == io.EOF {
+				return nil, err
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			data = buf[:n]
+		}
+
+		if opts.RecordBoundary && int64(len(data)) == chunkSize && data[len(data)-1] != '\n' {
+			if mapped != nil {
+				return mapped.extendToNewline(data, overshoot), nil
+			}
+			return extendToNewline(br, data, overshoot)
+		}
+		return data, nil
+	}
+
+	// Chunks are read in order, hashed, compressed and encrypted by the crypto
+	// workers and stored by the I/O workers, see pipeline.go. Every chunk in
+	// flight has a buffer of its own, read ahead of the workers.
+	cryptoWorkers := workerCount(opts.CryptoWorkers)
+	ioWorkers := workerCount(opts.IOWorkers)
+	done := make(chan struct{})
+	defer close(done)
+
+	next := func() ([]byte, error) { return readChunk(nil) }
+	release := func([]byte) {}
+	if mapped == nil {
+		next, release = readAhead(readChunk, cryptoWorkers+opts.ReadAhead, chunkSize+overshoot, done)
+	}
+
+	// claim reports whether the chunk at index is the first occurrence of id
+	// seen so far, so repeated content is only encrypted once
+	var claimMu sync.Mutex
+	claimed := make(map[string]int)
+	claim := func(id string, index int) bool {
+		claimMu.Lock()
+		defer claimMu.Unlock()
+		if first, ok := claimed[id]; ok && first < index {
+			return false
+		}
+		claimed[id] = index
+		return true
+	}
+
+	// encode turns the data of the chunk at index into its manifest entry and
+	// stored form, leaving stored nil when the chunk doesn't need storing
+	encode := func(comp *compressor, index int, data []byte) splitChunk {
+		// Hash the original data
+		hexHash, err := manifest.HashData(hashAlgo, data)
+		if err != nil {
+			return splitChunk{err: err}
+		}
+		// A shared store is keyed by the full hash so chunks dedupe across files
+		id, err := manifest.ChunkName(naming, nameKey, hexHash, opts.ChunkStore != "")
+		if err != nil {
+			return splitChunk{err: err}
+		}
+
+		chunk := manifest.ChunkInfo{
+			ID:         id,
+			Hash:       hexHash,
+			Index:      index,
+			Encrypted:  encConfig.Enabled,
+			PlainSize:  int64(len(data)),
+			Zero:       isZero(data),
+			Status:     manifest.ChunkStatusLocal,
+			CloudPaths: []string{}, // Will be populated when uploaded to cloud
+			Providers:  []string{}, // Will be populated when uploaded to cloud
+		}
+		res := splitChunk{info: chunk, data: data}
+
+		// An earlier chunk with the same content is stored instead
+		if !claim(id, index) {
+			return res
+		}
+		if reuse != nil {
+			var reused bool
+			res.info.Size, res.info.CipherHash, reused, err = reuse(id, hexHash)
+			if err != nil || reused {
+				res.err = err
+				return res
+			}
+		}
+
+		// Compress first, encrypted data doesn't compress
+		stored := data
+		if comp != nil {
+			if stored, err = comp.compress(data, &res.info); err != nil {
+				return splitChunk{err: fmt.Errorf("failed to compress chunk: %w", err)}
+			}
+			// The compressor's buffer is reused for its next chunk
+			if !dataKey.Enabled && res.info.Compression != "" {
+				stored = append(storedBuffers.get(len(stored))[:0], stored...)
+			}
+		}
+
+		// Encrypt if needed, keeping the nonce in the manifest when flattened
+		var encryptedData, nonce, buf []byte
+		if dataKey.Enabled {
+			buf = storedBuffers.get(len(stored) + dataKey.Overhead())[:0]
+		}
+		aad := dataKey.ChunkAAD(hexHash)
+		if opts.FlattenEncryption {
+			encryptedData, nonce, err = dataKey.EncryptDetachedTo(buf, stored, aad)
+		} else {
+			encryptedData, err = dataKey.EncryptTo(buf, stored, aad)
+		}
+		if err != nil {
+			return splitChunk{err: fmt.Errorf("failed to encrypt chunk: %w", err)}
+		}
+		if nonce != nil {
+			res.info.Nonce = base64.StdEncoding.EncodeToString(nonce)
+		}
+
+		// The header goes in front of the stored chunk, outside the encryption
+		if header != nil {
+			h := *header
+			h.Index, h.PlainSize = index, res.info.PlainSize
+			h.Encrypted, h.Nonce, h.Compression = dataKey.Enabled, res.info.Nonce, res.info.Compression
+			if aad != nil {
+				h.AAD, h.Hash = dataKey.AAD, hexHash
+			}
+			if dataKey.Enabled {
+				h.NonceSize = dataKey.NonceSize
+			}
+			prefix, err := h.encode()
+			if err != nil {
+				return splitChunk{err: err}
+			}
+			res.info.HeaderSize = int64(len(prefix))
+			if dataKey.Enabled || res.info.Compression != "" {
+				storedBuffers.put(encryptedData)
+			}
+			encryptedData = append(prefix, encryptedData...)
+		}
+
+		res.info.Size = int64(len(encryptedData))
+		storedHash := sha256.Sum256(encryptedData)
+		res.info.CipherHash = fmt.Sprintf("%x", storedHash[:])
+		res.stored = encryptedData
+		// Unless it is the plain data itself, the stored form is pooled
+		res.pooled = dataKey.Enabled || res.info.Compression != "" || header != nil
+		return res
+	}
+
+	// Read stage: queue each chunk's result in order and hand it to the crypto workers
+	pending := make(chan chan splitChunk, cryptoWorkers+opts.ReadAhead)
+	jobs := make(chan splitJob, cryptoWorkers)
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for index := 0; ; index++ {
+			data, err := next()
+			if err == io.EOF {
+				return
+			}
+			result := make(chan splitChunk, 1)
+			select {
+			case pending <- result:
+			case <-done:
+				return
+			}
+			if err != nil {
+				result <- splitChunk{err: err}
+				return
+			}
+			select {
+			case jobs <- splitJob{index: index, data: data, result: result}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Crypto stage
+	for i := 0; i < cryptoWorkers; i++ {
+		comp, err := newCompressor(opts.Compression)
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+		go func() {
+			for job := range jobs {
+				select {
+				case <-done:
+					job.result <- splitChunk{err: errPipelineStopped}
+				default:
+					job.result <- encode(comp, job.index, job.data)
+				}
+			}
+		}()
+	}
+
+	// I/O stage: store chunks as the manifest is built
+	writes := make(chan splitChunk, ioWorkers)
+	writeErr := make(chan error, 1)
+	var writers sync.WaitGroup
+	for i := 0; i < ioWorkers; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for w := range writes {
+				if err := sink(w.info, w.stored); err != nil {
+					select {
+					case writeErr <- err:
+					default:
+					}
+				}
+				release(w.data)
+				if w.pooled {
+					storedBuffers.put(w.stored)
+				}
+			}
+		}()
+	}
+	stopWriters := func() error {
+		close(writes)
+		writers.Wait()
+		select {
+		case err := <-writeErr:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	// Build the manifest in order. Chunks already stored by this run are
+	// recorded with the same stored chunk, so repeated content is stored once.
+	var chunks []manifest.ChunkInfo
+	written := make(map[string]manifest.ChunkInfo)
+	for result := range pending {
+		r := <-result
+		if r.err != nil {
+			stopWriters()
+			return manifest.Manifest{}, r.err
+		}
+
+		fileHash.Write(r.data)
+
+		chunk := r.info
+		if prev, ok := written[chunk.ID]; ok {
+			chunk.Size, chunk.CipherHash, chunk.Nonce, chunk.HeaderSize = prev.Size, prev.CipherHash, prev.Nonce, prev.HeaderSize
+			chunk.Compression, chunk.CompressDecision, chunk.CompressRatio = prev.Compression, prev.CompressDecision, prev.CompressRatio
+			release(r.data)
+		} else if r.stored == nil {
+			release(r.data)
+		} else {
+			select {
+			case writes <- splitChunk{info: chunk, data: r.data, stored: r.stored, pooled: r.pooled}:
+			case err := <-writeErr:
+				stopWriters()
+				return manifest.Manifest{}, err
+			}
+		}
+
+		chunks = append(chunks, chunk)
+		written[chunk.ID] = chunk
+	}
+	if err := stopWriters(); err != nil {
+		return manifest.Manifest{}, err
+	}
+
+	m := manifest.NewManifest(chunks, "", encConfig.Enabled, "local")
+	m.ShardSize = opts.ManifestShardSize
+	m.StripMetadata = opts.StripMetadata
+	m.Format = opts.ManifestFormat
+	m.ChunkSize = chunkSize
+	m.NumChunks = opts.NumChunks
+	m.ChunkingMode = manifest.ChunkingFixed
+	if opts.RecordBoundary {
+		m.ChunkingMode = manifest.ChunkingRecord
+	}
+	m.ChunkNaming = naming
+	m.NameKey = nameKey
+	if ext := chunkExtension(opts); ext != manifest.DefaultChunkExtension {
+		m.ChunkExtension = ext
+	}
+	m.Tags = opts.Tags
+	m.HashAlgo = hashAlgo
+	m.FileHash = fmt.Sprintf("%x", fileHash.Sum(nil))
+	m.MerkleRoot, err = manifest.ComputeMerkleRoot(m)
+	if err != nil {
+		return manifest.Manifest{}, err
+	}
+	m.WrappedKey = wrappedKey
+	m.KeyringID = opts.KeyringID
+	if encConfig.Enabled {
+		m.Cipher = encConfig.Cipher()
+		m.KDF = encConfig.KDF()
+		m.AAD = dataKey.AAD
+		m.NonceSize = dataKey.NonceSize
+		m.PasswordCheck, err = encConfig.CreatePasswordCheck()
+		if err != nil {
+			return manifest.Manifest{}, err
+		}
+	}
+	if opts.ChunkStore != "" {
+		m.ChunkLayout = manifest.LayoutCAS
+	}
+	return m, nil
+}
+
+// AssembleWriter fetches the chunks of m from source, decrypts and verifies
+// them, and writes the original data to w in Index order. Up to
+// opts.Lookahead chunks are fetched in parallel ahead of the writer. If w
+// can seek, all-zero chunks are skipped over instead of written. With
+// opts.SkipVerify, chunk and whole-file hashes aren't checked.
+func AssembleWriter(m manifest.Manifest, source ChunkSource, w io.Writer, encConfig *encryption.EncryptionConfig, opts AssembleOptions) error {
+	if err := manifest.CheckIndexes(m); err != nil {
+		return err
+	}
+	return assembleWriter(m, sortedChunks(m, 0), source, w, encConfig, opts, resumeState{})
+}
+
+// chunkIter calls fn with chunks to assemble in Index order, stopping at the
+// first error, which it returns
+type chunkIter func(fn func(manifest.ChunkInfo) error) error
+
+// sortedChunks returns a chunkIter over m.Chunks sorted by Index, after the first skip
+func sortedChunks(m manifest.Manifest, skip int) chunkIter {
+	chunks := append([]manifest.ChunkInfo(nil), m.Chunks...)
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Index < chunks[j].Index
+	})
+	chunks = chunks[skip:]
+	return func(fn func(manifest.ChunkInfo) error) error {
+		for _, c := range chunks {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkEncryption checks that encConfig matches how m's chunks were encrypted,
+// and that no chunk's Encrypted flag contradicts the manifest, before any
+// chunk is read. An encrypted manifest may hold plain chunks.
+func checkEncryption(m manifest.Manifest, encConfig *encryption.EncryptionConfig) error {
+	if m.Encrypted && !encConfig.Enabled {
+		return fmt.Errorf("file was encrypted but no decryption key provided")
+	}
+	if !m.Encrypted && encConfig.Enabled {
+		return fmt.Errorf("file was not encrypted but decryption key provided")
+	}
+	for _, c := range m.Chunks {
+		if err := checkChunkEncryption(m, c); err != nil {
+			return err
+		}
+	}
+	if m.Encrypted {
+		if err := encryption.CheckAAD(m.AAD); err != nil {
+			return err
+		}
+		if err := encryption.ValidateNonceSize(m.NonceSize); err != nil {
+			return fmt.Errorf("%w: %v", encryption.ErrSchemeMismatch, err)
+		}
+		return encConfig.CheckScheme(m.Cipher, m.KDF)
+	}
+	return nil
+}
+
+// checkChunkEncryption checks that c's Encrypted flag doesn't contradict m
+func checkChunkEncryption(m manifest.Manifest, c manifest.ChunkInfo) error {
+	switch {
+	case c.Encrypted && !m.Encrypted:
+		return fmt.Errorf("chunk %d (%s) is marked encrypted but the manifest isn't", c.Index, c.ID)
+	case c.Nonce != "" && !c.Encrypted:
+		return fmt.Errorf("chunk %d (%s) has a nonce but isn't marked encrypted", c.Index, c.ID)
+	case c.Nonce != "":
+		if err := encryption.CheckNonce(c.Nonce, m.NonceSize); err != nil {
+			return fmt.Errorf("chunk %d (%s): %w", c.Index, c.ID, err)
+		}
+	}
+	return nil
+}
+
+// assembleWriter is AssembleWriter over the chunks of m that chunks yields,
+// continuing resume.fileHash over the resume.chunks chunks already in the
+// output, which chunks must skip
+func assembleWriter(m manifest.Manifest, chunks chunkIter, source ChunkSource, w io.Writer, encConfig *encryption.EncryptionConfig, opts AssembleOptions, resume resumeState) error {
+	if err := checkEncryption(m, encConfig); err != nil {
+		return err
+	}
+
+	// Fail fast on a wrong password instead of on the first chunk
+	if m.PasswordCheck != "" {
+		if err := encConfig.VerifyPasswordCheck(m.PasswordCheck); err != nil {
+			return err
+		}
+	}
+	encConfig, err := chunkKey(m, encConfig)
+	if err != nil {
+		return err
+	}
+
+	fileHash := resume.fileHash
+	if fileHash == nil {
+		if fileHash, err = manifest.NewHasher(m.HashAlgo); err != nil {
+			return err
+		}
+	}
+	if opts.SkipVerify {
+		fileHash = nil
+	}
+
+	lookahead := opts.Lookahead
+	if lookahead < 1 {
+		lookahead = DefaultAssemblyLookahead
+	}
+
+	// Pipes and sockets implement Seek but fail on it, so probe once up front
+	var start int64
+	seeker, seekable := w.(io.Seeker)
+	if seekable {
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	// Buffer writes so small chunks don't each cost a write call. Chunks
+	// larger than the buffer are written straight through.
+	out := io.Writer(w)
+	var bw *bufio.Writer
+	if size := ioBufferSize(opts.BufferSize); size > 0 {
+		bw = bufio.NewWriterSize(w, size)
+		out = bw
+	}
+	flush := func() error {
+		if bw == nil {
+			return nil
+		}
+		return bw.Flush()
+	}
+
+	// Each chunk gets its own result channel, queued in Index order. The queue's
+	// capacity bounds how many chunks are held in memory ahead of the writer.
+	// Chunks go through the fetch and crypto stages, see pipeline.go.
+	pending := make(chan chan chunkResult, lookahead)
+	ioWorkers := workerCount(opts.IOWorkers)
+	cryptoWorkers := workerCount(opts.CryptoWorkers)
+	fetches := make(chan assembleJob, ioWorkers)
+	decodes := make(chan assembleJob, cryptoWorkers)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(pending)
+		defer close(fetches)
+		err := chunks(func(c manifest.ChunkInfo) error {
+			result := make(chan chunkResult, 1)
+			select {
+			case pending <- result:
+			case <-done:
+				return errPipelineStopped
+			}
+			select {
+			case fetches <- assembleJob{chunk: c, result: result}:
+			case <-done:
+				return errPipelineStopped
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errPipelineStopped) {
+			// Hand the writer the error in place of the next chunk
+			result := make(chan chunkResult, 1)
+			result <- chunkResult{err: err}
+			select {
+			case pending <- result:
+			case <-done:
+			}
+		}
+	}()
+
+	// Fetch stage
+	var fetchers sync.WaitGroup
+	for i := 0; i < ioWorkers; i++ {
+		fetchers.Add(1)
+		go func() {
+			defer fetchers.Done()
+			for job := range fetches {
+				c := job.chunk
+				if c.Zero {
+					var err error
+					if !opts.SkipVerify {
+						err = verifyZeroChunk(c, m.HashAlgo)
+					}
+					job.result <- chunkResult{chunk: c, hole: c.PlainSize, err: err}
+					continue
+				}
+				stored, err := source(c)
+				if err != nil {
+					job.result <- chunkResult{err: err}
+					continue
+				}
+				job.stored = stored
+				select {
+				case decodes <- job:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		fetchers.Wait()
+		close(decodes)
+	}()
+
+	// Crypto stage
+	for i := 0; i < cryptoWorkers; i++ {
+		go func() {
+			for job := range decodes {
+				var data []byte
+				var err error
+				if opts.SkipVerify {
+					data, err = decryptChunk(job.stored, job.chunk, encConfig)
+				} else {
+					data, err = decodeChunk(job.stored, job.chunk, m.HashAlgo, encConfig)
+				}
+				job.result <- chunkResult{chunk: job.chunk, data: data, err: err}
+			}
+		}()
+	}
+
+	var offset int64
+	holes := false
+	for result := range pending {
+		r := <-result
+		if r.err != nil {
+			return r.err
+		}
+
+		// Chunks larger than the manifest says can't get past the limit either
+		if n := int64(len(r.data)) + r.hole; opts.MaxOutputSize > 0 && start+offset+n > opts.MaxOutputSize {
+			return fmt.Errorf("%w: chunk %d would take the output past the limit of %d bytes", ErrOutputTooLarge, r.chunk.Index, opts.MaxOutputSize)
+		}
+
+		if r.hole > 0 && seekable {
+			// Leave a hole so the output stays sparse on filesystems that support it
+			if err = flush(); err == nil {
+				_, err = seeker.Seek(r.hole, io.SeekCurrent)
+			}
+			offset += r.hole
+			holes = true
+		} else if r.hole > 0 {
+			var n int64
+			n, err = io.CopyN(out, zeroReader{}, r.hole)
+			offset += n
+		} else {
+			var n int
+			n, err = out.Write(r.data)
+			offset += int64(n)
+		}
+		if err != nil {
+			return err
+		}
+
+		if fileHash != nil {
+			if r.hole > 0 {
+				io.CopyN(fileHash, zeroReader{}, r.hole)
+			} else {
+				fileHash.Write(r.data)
+			}
+		}
+
+		if r.hole == 0 {
+			releaseChunkData(r.chunk, r.data)
+		}
+
+		if opts.OnChunk != nil {
+			opts.OnChunk(r.chunk)
+		}
+	}
+
+	// Everything must be written before the output is complete
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// Seeking past trailing holes doesn't extend a file, so set its final size
+	if t, ok := w.(interface{ Truncate(int64) error }); ok && holes {
+		if err := t.Truncate(start + offset); err != nil {
+			return err
+		}
+	}
+
+	if fileHash != nil && m.FileHash != "" && fmt.Sprintf("%x", fileHash.Sum(nil)) != m.FileHash {
+		return fmt.Errorf("whole-file %w: assembled output doesn't match the original file", manifest.ErrHashMismatch)
+	}
+	return nil
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// readResult is a chunk read ahead by readAhead
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// readAhead calls read in a goroutine up to depth chunks ahead of the caller,
+// each into its own buffer of bufSize bytes, until read fails or returns
+// io.EOF. next returns the chunks in order, and release hands a chunk's buffer
+// back for reuse once the caller is done with it. Closing done stops reading.
+func readAhead(read func(buf []byte) ([]byte, error), depth int, bufSize int64, done <-chan struct{}) (next func() ([]byte, error), release func([]byte)) {
+	// One buffer more than the depth, for the chunk the caller holds
+	free := make(chan []byte, depth+1)
+	for i := 0; i <= depth; i++ {
+		free <- make([]byte, 0, bufSize)
+	}
+	results := make(chan readResult, depth)
+
+	go func() {
+		defer close(results)
+		for {
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-done:
+				return
+			}
+			data, err := read(buf)
+			select {
+			case results <- readResult{data, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	next = func() ([]byte, error) {
+		r, ok := <-results
+		if !ok {
+			return nil, io.EOF
+		}
+		return r.data, r.err
+	}
+	release = func(data []byte) {
+		if data != nil {
+			free <- data[:0]
+		}
+	}
+	return next, release
+}
@@ -0,0 +1,80 @@
+package chunker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// benchBufferSizes are the io_buffer_size settings compared by the buffering
+// benchmarks, by name
+var benchBufferSizes = []struct {
+	name string
+	size int
+}{
+	{"unbuffered", -1},
+	{"default", 0},
+	{"4MB", 4 << 20},
+}
+
+// BenchmarkSmallChunkIO measures reading the input of a split and writing
+// the output of an assembly with 4 KiB chunks, where every chunk costs a
+// system call without a buffer. Chunks are kept in memory in between, so
+// only the file I/O differs.
+func BenchmarkSmallChunkIO(b *testing.B) {
+	const size = 16 << 20
+	const chunkSize = 4 << 10
+	path := writeBenchFile(b, size, false)
+	encConfig := encryption.CreateEncryptionConfig("", false)
+
+	keep, source := memChunks(b)
+	discard := func(manifest.ChunkInfo, []byte) error { return nil }
+
+	f, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := SplitReader(f, keep, encConfig, SplitOptions{ChunkSize: chunkSize})
+	f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, buf := range benchBufferSizes {
+		b.Run("read/"+buf.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = SplitReader(f, discard, encConfig, SplitOptions{ChunkSize: chunkSize, BufferSize: buf.size})
+				f.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	out := filepath.Join(b.TempDir(), "output.bin")
+	for _, buf := range benchBufferSizes {
+		b.Run("write/"+buf.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				f, err := os.Create(out)
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = AssembleWriter(m, source, f, encConfig, AssembleOptions{BufferSize: buf.size})
+				f.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
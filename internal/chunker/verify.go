@@ -0,0 +1,244 @@
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+	"github.com/schollz/progressbar/v3"
+)
+
+// ChunkFailure is a chunk that failed verification, and why
+type ChunkFailure struct {
+	Index int
+	ID    string
+	Err   error
+}
+
+// VerifyResult is what VerifyChunks found
+type VerifyResult struct {
+	Chunks   int            // Chunks listed in the manifest
+	Failures []ChunkFailure // Chunks that failed, sorted by Index
+	Merkle   bool           // Whether the Merkle root was checked
+}
+
+// errChunksUnordered stops streaming a chunk list that isn't stored in Index order
+var errChunksUnordered = errors.New("chunk list isn't in Index order")
+
+// VerifyFile checks that the file described by manifestPath can be restored
+// intact. Nothing is written. Every chunk is checked by VerifyChunks, and any
+// failures are listed before an error wrapping the first is returned. Chunks
+// that match their hashes and a matching Merkle root add up to the whole
+// file, so only manifests without a Merkle root get a second, ordered pass
+// over the chunks to check the whole-file hash.
+func VerifyFile(manifestPath, chunksPath string, encConfig *encryption.EncryptionConfig, opts AssembleOptions) error {
+	res, err := VerifyChunks(manifestPath, chunksPath, encConfig, opts)
+	if err != nil {
+		return err
+	}
+	if len(res.Failures) > 0 {
+		for _, f := range res.Failures {
+			fmt.Printf("⚠️  Chunk %d (%s): %v\n", f.Index, f.ID, f.Err)
+		}
+		first := res.Failures[0]
+		return fmt.Errorf("%d of %d chunks failed verification, the first is chunk %d: %w", len(res.Failures), res.Chunks, first.Index, first.Err)
+	}
+	if res.Merkle {
+		return nil
+	}
+
+	fmt.Println("No Merkle root recorded, checking the whole-file hash...")
+	return verifyInOrder(manifestPath, chunksPath, encConfig, opts)
+}
+
+// VerifyChunks reads, decrypts and checks every chunk of the file described
+// by manifestPath against its hash, with opts.IOWorkers chunks read and
+// opts.CryptoWorkers decoded at a time, in no particular order. Unlike
+// assembly it doesn't stop at the first bad chunk: all failures are collected
+// into the result. The Merkle root is checked too, if the manifest records
+// one. The chunk list is streamed from the manifest rather than loaded. The
+// error is for problems that aren't a single chunk's, such as a wrong
+// password or a bad Merkle root.
+func VerifyChunks(manifestPath, chunksPath string, encConfig *encryption.EncryptionConfig, opts AssembleOptions) (VerifyResult, error) {
+	var res VerifyResult
+	m, err := manifest.ReadManifestHeader(manifestPath)
+	if err != nil {
+		return res, err
+	}
+	if err := checkEncryption(m, encConfig); err != nil {
+		return res, err
+	}
+	if m.PasswordCheck != "" {
+		if err := encConfig.VerifyPasswordCheck(m.PasswordCheck); err != nil {
+			return res, err
+		}
+	}
+	key, err := chunkKey(m, encConfig)
+	if err != nil {
+		return res, err
+	}
+
+	source := opts.Source
+	if source == nil {
+		source = localSource(m, chunksPath)
+	}
+
+	bar := progressbar.NewOptions(m.ChunkCount,
+		progressbar.OptionSetDescription("Verifying chunks..."),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Println()
+		}),
+	)
+
+	var mu sync.Mutex
+	failed := func(c manifest.ChunkInfo, err error) {
+		mu.Lock()
+		res.Failures = append(res.Failures, ChunkFailure{Index: c.Index, ID: c.ID, Err: err})
+		mu.Unlock()
+	}
+
+	// Same fetch and crypto stages as assembly, see pipeline.go, without the
+	// ordered writer
+	ioWorkers := workerCount(opts.IOWorkers)
+	cryptoWorkers := workerCount(opts.CryptoWorkers)
+	fetches := make(chan manifest.ChunkInfo, ioWorkers)
+	decodes := make(chan assembleJob, cryptoWorkers)
+
+	var fetchers sync.WaitGroup
+	for i := 0; i < ioWorkers; i++ {
+		fetchers.Add(1)
+		go func() {
+			defer fetchers.Done()
+			for c := range fetches {
+				if c.Zero {
+					if err := verifyZeroChunk(c, m.HashAlgo); err != nil {
+						failed(c, err)
+					}
+					bar.Add(1)
+					continue
+				}
+				stored, err := source(c)
+				if err != nil {
+					failed(c, err)
+					bar.Add(1)
+					continue
+				}
+				decodes <- assembleJob{chunk: c, stored: stored}
+			}
+		}()
+	}
+	go func() {
+		fetchers.Wait()
+		close(decodes)
+	}()
+
+	var decoders sync.WaitGroup
+	for i := 0; i < cryptoWorkers; i++ {
+		decoders.Add(1)
+		go func() {
+			defer decoders.Done()
+			for job := range decodes {
+				data, err := decodeChunk(job.stored, job.chunk, m.HashAlgo, key)
+				if err != nil {
+					failed(job.chunk, err)
+				} else {
+					releaseChunkData(job.chunk, data)
+				}
+				bar.Add(1)
+			}
+		}()
+	}
+
+	// Only the chunk hashes are kept, for the Merkle root
+	var hashes []string
+	ordered := true
+	err = manifest.StreamChunks(manifestPath, func(c manifest.ChunkInfo) error {
+		if c.Index != len(hashes) {
+			ordered = false
+		}
+		hashes = append(hashes, c.Hash)
+		if err := checkChunkEncryption(m, c); err != nil {
+			failed(c, err)
+			bar.Add(1)
+			return nil
+		}
+		fetches <- c
+		return nil
+	})
+	close(fetches)
+	decoders.Wait()
+	res.Chunks = len(hashes)
+	if err != nil {
+		return res, err
+	}
+
+	sort.Slice(res.Failures, func(i, j int) bool {
+		return res.Failures[i].Index < res.Failures[j].Index
+	})
+
+	if res.Chunks != m.ChunkCount {
+		return res, fmt.Errorf("manifest lists %d chunks, records %d", res.Chunks, m.ChunkCount)
+	}
+	if m.MerkleRoot == "" {
+		return res, nil
+	}
+	if ordered {
+		root, err := manifest.MerkleRoot(m.HashAlgo, hashes)
+		if err != nil {
+			return res, err
+		}
+		if root != m.MerkleRoot {
+			return res, fmt.Errorf("%w: Merkle root is %s, manifest records %s", manifest.ErrHashMismatch, root, m.MerkleRoot)
+		}
+	} else {
+		// The hashes must be in Index order, which takes the whole chunk list
+		full, err := manifest.ReadManifest(manifestPath)
+		if err != nil {
+			return res, err
+		}
+		if err := manifest.VerifyMerkle(full); err != nil {
+			return res, err
+		}
+	}
+	res.Merkle = true
+	return res, nil
+}
+
+// verifyInOrder checks the whole-file hash of the file described by
+// manifestPath by passing its chunks through assembly in Index order. The
+// chunk list is streamed from the manifest unless it isn't stored in order.
+func verifyInOrder(manifestPath, chunksPath string, encConfig *encryption.EncryptionConfig, opts AssembleOptions) error {
+	m, err := manifest.ReadManifestHeader(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	source := opts.Source
+	if source == nil {
+		source = localSource(m, chunksPath)
+	}
+	opts.SkipVerify = false
+
+	next := 0
+	chunks := func(fn func(manifest.ChunkInfo) error) error {
+		return manifest.StreamChunks(manifestPath, func(c manifest.ChunkInfo) error {
+			if c.Index != next {
+				return errChunksUnordered
+			}
+			next++
+			return fn(c)
+		})
+	}
+	err = assembleWriter(m, chunks, source, io.Discard, encConfig, opts, resumeState{})
+	if errors.Is(err, errChunksUnordered) {
+		return AssembleFileToWriter(manifestPath, chunksPath, io.Discard, encConfig, opts)
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// accountBars renders one progress line per account below an overall line,
+// redrawing them in place, so a slow or stuck account stands out
+type accountBars struct {
+	mu       sync.Mutex
+	w        io.Writer
+	keys     []string // "provider/account", in display order
+	accounts map[string]*accountBar
+	total    int
+	done     int
+	active   string // Account currently uploading
+	lines    int    // Lines drawn by the last render
+	lastDraw time.Time
+}
+
+// accountBar is the progress of one account
+type accountBar struct {
+	chunks  int
+	bytes   int64
+	failed  int
+	lastErr string
+	busy    time.Duration // Time spent uploading to this account
+	started time.Time     // Start of the current upload, zero when idle
+	file    string
+	percent int64
+}
+
+// accountBarsRedrawInterval limits how often in-file progress redraws the lines
+const accountBarsRedrawInterval = 200 * time.Millisecond
+
+func newAccountBars(w io.Writer, keys []string, total int) *accountBars {
+	ab := &accountBars{
+		w:        w,
+		keys:     keys,
+		accounts: make(map[string]*accountBar),
+		total:    total,
+	}
+	for _, key := range keys {
+		ab.accounts[key] = &accountBar{}
+	}
+	return ab
+}
+
+// start marks an upload of fileName to an account as in progress
+func (ab *accountBars) start(key, fileName string) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	a := ab.account(key)
+	a.started = time.Now()
+	a.file = fileName
+	a.percent = 0
+	ab.active = key
+	ab.render()
+}
+
+// finish records the end of the upload started on an account
+func (ab *accountBars) finish(key string, size int64, err error) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	a := ab.account(key)
+	if !a.started.IsZero() {
+		a.busy += time.Since(a.started)
+	}
+	a.started = time.Time{}
+	if err != nil {
+		a.failed++
+		a.lastErr = err.Error()
+	} else {
+		a.chunks++
+		a.bytes += size
+	}
+	ab.render()
+}
+
+// progress updates how far the active upload has got
+func (ab *accountBars) progress(current, total int64) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	if ab.active == "" || total <= 0 {
+		return
+	}
+	ab.account(ab.active).percent = current * 100 / total
+	if time.Since(ab.lastDraw) >= accountBarsRedrawInterval {
+		ab.render()
+	}
+}
+
+// chunkDone advances the overall line by one chunk
+func (ab *accountBars) chunkDone() {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	ab.done++
+	ab.render()
+}
+
+// account returns the bar of an account, adding it if it wasn't known up front
+func (ab *accountBars) account(key string) *accountBar {
+	a, ok := ab.accounts[key]
+	if !ok {
+		a = &accountBar{}
+		ab.accounts[key] = a
+		ab.keys = append(ab.keys, key)
+	}
+	return a
+}
+
+// render redraws every line over the previous render. The caller holds mu.
+func (ab *accountBars) render() {
+	if ab.lines > 0 {
+		fmt.Fprintf(ab.w, "\x1b[%dA", ab.lines)
+	}
+
+	fmt.Fprintf(ab.w, "\r\x1b[KUploading to cloud... %s %d/%d chunks\n", drawBar(ab.done, ab.total), ab.done, ab.total)
+	for _, key := range ab.keys {
+		a := ab.accounts[key]
+
+		busy := a.busy
+		status := "idle"
+		if !a.started.IsZero() {
+			busy += time.Since(a.started)
+			status = fmt.Sprintf("uploading %s %d%%", a.file, a.percent)
+		}
+		var rate float64
+		if busy > 0 {
+			rate = float64(a.bytes) / (1024 * 1024) / busy.Seconds()
+		}
+		if a.failed > 0 {
+			status = fmt.Sprintf("%s, %d failed (last: %s)", status, a.failed, truncate(a.lastErr, 60))
+		}
+
+		fmt.Fprintf(ab.w, "\r\x1b[K  %-24s %5d chunks %9.1f MB %7.1f MB/s  %s\n", key, a.chunks, float64(a.bytes)/(1024*1024), rate, status)
+	}
+
+	ab.lines = len(ab.keys) + 1
+	ab.lastDraw = time.Now()
+}
+
+// drawBar draws a fixed-width bar for done out of total
+func drawBar(done, total int) string {
+	const width = 30
+	filled := width
+	if total > 0 {
+		filled = min(width, done*width/total)
+	}
+	b := make([]byte, width)
+	for i := range b {
+		b[i] = '-'
+		if i < filled {
+			b[i] = '#'
+		}
+	}
+	return "[" + string(b) + "]"
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
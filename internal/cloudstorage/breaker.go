@@ -0,0 +1,88 @@
+package cloudstorage
+
+import (
+	"sort"
+	"sync"
+)
+
+// defaultBreakerThreshold is how many consecutive failures trip an account
+// when the configuration doesn't say
+const defaultBreakerThreshold = 3
+
+// circuitBreaker stops routing uploads to accounts that keep failing, such as
+// one whose token was revoked mid-run, so they don't slow down every chunk
+// sent their way. Accounts are keyed "provider/account".
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // Consecutive failures that trip an account, 0 never trips
+	failures  map[string]int
+	tripped   map[string]trippedAccount
+}
+
+// trippedAccount records why an account was taken out of rotation
+type trippedAccount struct {
+	Key      string
+	Failures int
+	LastErr  string
+}
+
+// newCircuitBreaker creates a breaker from the configured threshold, where 0
+// means defaultBreakerThreshold and a negative value disables it
+func newCircuitBreaker(threshold int) *circuitBreaker {
+	switch {
+	case threshold == 0:
+		threshold = defaultBreakerThreshold
+	case threshold < 0:
+		threshold = 0
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		failures:  make(map[string]int),
+		tripped:   make(map[string]trippedAccount),
+	}
+}
+
+// allow reports whether uploads may still be routed to an account
+func (cb *circuitBreaker) allow(key string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	_, tripped := cb.tripped[key]
+	return !tripped
+}
+
+// success resets an account's consecutive failure count
+func (cb *circuitBreaker) success(key string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	delete(cb.failures, key)
+}
+
+// failure records a failed upload and reports whether it tripped the account
+func (cb *circuitBreaker) failure(key string, err error) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if _, tripped := cb.tripped[key]; tripped {
+		return false
+	}
+
+	cb.failures[key]++
+	if cb.threshold == 0 || cb.failures[key] < cb.threshold {
+		return false
+	}
+	cb.tripped[key] = trippedAccount{Key: key, Failures: cb.failures[key], LastErr: err.Error()}
+	return true
+}
+
+// trippedAccounts returns the tripped accounts sorted by key
+func (cb *circuitBreaker) trippedAccounts() []trippedAccount {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	tripped := make([]trippedAccount, 0, len(cb.tripped))
+	for _, t := range cb.tripped {
+		tripped = append(tripped, t)
+	}
+	sort.Slice(tripped, func(a, b int) bool {
+		return tripped[a].Key < tripped[b].Key
+	})
+	return tripped
+}
@@ -1,10 +1,18 @@
 package cloudstorage
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/probablysamir/chunk-store/internal/config"
+	"github.com/probablysamir/chunk-store/internal/manifest"
 )
 
 // CloudProvider type is imported from config package
@@ -17,9 +25,75 @@ const (
 	OneDrive    = config.OneDrive
 	MEGACloud   = config.MEGACloud
 	IPFS        = config.IPFS
+	WebDAV      = config.WebDAV
 	Local       = config.Local
 )
 
+// ErrProviderUnavailable means a provider can't be used: it isn't implemented,
+// has no working accounts, or every account is full. Check with errors.Is.
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// ErrAuthFailed means a provider rejected an account's credentials
+var ErrAuthFailed = errors.New("authentication failed")
+
+// ErrBadCredentials means an account's credentials or token file is missing
+// or malformed, found before contacting the provider
+var ErrBadCredentials = errors.New("misconfigured credentials")
+
+// ErrFileNotFound means a file isn't stored on the provider
+var ErrFileNotFound = errors.New("file not found")
+
+// ErrBudgetExceeded means an upload ran past its deadline or used up its
+// retry budget and was stopped
+var ErrBudgetExceeded = errors.New("upload budget exceeded")
+
+// CloudClient is the method set every cloud provider client implements
+type CloudClient interface {
+	// Initialize authenticates and prepares the remote folder/collection
+	Initialize() error
+	// UploadFile uploads a local file and returns the provider-specific file ID
+	UploadFile(localPath, cloudPath string) (string, error)
+	// DownloadFile downloads the file identified by fileID to localPath
+	DownloadFile(fileID, localPath string) error
+	// OpenFile opens the file identified by fileID for reading its content as it downloads
+	OpenFile(fileID string) (io.ReadCloser, error)
+	// FindFileByName looks up a file ID by its name in the chunk folder
+	FindFileByName(fileName string) (string, error)
+	// DeleteFile removes the file identified by fileID
+	DeleteFile(fileID string) error
+}
+
+var (
+	_ CloudClient = (*GoogleDriveClient)(nil)
+	_ CloudClient = (*WebDAVClient)(nil)
+	_ CloudClient = (*DropboxClient)(nil)
+	_ CloudClient = (*IPFSClient)(nil)
+	_ fileStatter = (*GoogleDriveClient)(nil)
+	_ fileStatter = (*WebDAVClient)(nil)
+	_ fileStatter = (*DropboxClient)(nil)
+	_ fileStatter = (*IPFSClient)(nil)
+
+	_ checksumUploader = (*GoogleDriveClient)(nil)
+	_ pinningUploader  = (*IPFSClient)(nil)
+
+	_ credentialChecker = (*GoogleDriveClient)(nil)
+	_ credentialChecker = (*WebDAVClient)(nil)
+	_ credentialChecker = (*DropboxClient)(nil)
+	_ credentialChecker = (*IPFSClient)(nil)
+
+	_ linkSharer = (*GoogleDriveClient)(nil)
+	_ linkSharer = (*WebDAVClient)(nil)
+	_ linkSharer = (*DropboxClient)(nil)
+	_ linkSharer = (*IPFSClient)(nil)
+
+	_ folderReporter = (*GoogleDriveClient)(nil)
+	_ folderReporter = (*WebDAVClient)(nil)
+	_ folderReporter = (*DropboxClient)(nil)
+
+	_ progressReporter = (*GoogleDriveClient)(nil)
+	_ progressReporter = (*DropboxClient)(nil)
+)
+
 // CloudChunkInfo extends chunk info with cloud storage details
 type CloudChunkInfo struct {
 	ID          string        `json:"id"`
@@ -33,12 +107,132 @@ type CloudChunkInfo struct {
 	Encrypted   bool          `json:"encrypted"`
 }
 
+// Replica placement policies
+const (
+	PlacementDefault  = ""         // Replicas follow load balancing and may share a provider or account
+	PlacementDistinct = "distinct" // Every replica on a different account, on different providers while there are any
+)
+
 // CloudDistributionStrategy defines how to distribute chunks
 type CloudDistributionStrategy struct {
 	Providers           []CloudProvider `json:"providers"`
-	ReplicationCount    int             `json:"replication_count"` // How many copies per chunk
-	LoadBalancing       string          `json:"load_balancing"`    // "round_robin", "random", "size_based"
+	ReplicationCount    int             `json:"replication_count"`     // How many copies per chunk
+	LoadBalancing       string          `json:"load_balancing"`        // "round_robin", "random", "size_based"
 	GoogleDriveAccounts int             `json:"google_drive_accounts"` // Number of Google Drive accounts to cycle through
+	Seed                *int64          `json:"seed,omitempty"`        // Seed for "random" load balancing; nil uses a time-seeded source
+	Placement           string          `json:"placement,omitempty"`   // How replicas are placed, PlacementDefault or PlacementDistinct
+
+	// Relative share of new chunks per provider, for weighted round robin.
+	// Unlisted providers weigh 1, and 0 leaves a provider out of uploads while
+	// its copies can still be downloaded. Nil balances providers equally.
+	Weights map[CloudProvider]int `json:"weights,omitempty"`
+
+	// Accounts per provider, for PlacementDistinct; set by CreateCloudUploader.
+	// Providers missing from it count as one account.
+	AccountCounts map[CloudProvider]int `json:"-"`
+
+	rng   *rand.Rand  // Source for "random" load balancing set by SetRand
+	rngMu *sync.Mutex // Guards rng, which isn't safe for concurrent use
+}
+
+// SetRand makes "random" load balancing draw from r instead of the seed or a
+// time-seeded source. Calls are serialized, so r may be shared by goroutines.
+func (cds *CloudDistributionStrategy) SetRand(r *rand.Rand) {
+	cds.rng = r
+	cds.rngMu = &sync.Mutex{}
+}
+
+// randomProviders returns a random permutation of provider indexes for a chunk.
+// With a seed the permutation depends only on the seed and the chunk index, so
+// the same seed always yields the same chunk to provider mapping, whatever
+// order chunks are uploaded in.
+func (cds *CloudDistributionStrategy) randomProviders(chunkIndex int) []int {
+	switch {
+	case cds.rng != nil:
+		cds.rngMu.Lock()
+		defer cds.rngMu.Unlock()
+		return cds.rng.Perm(len(cds.Providers))
+	case cds.Seed != nil:
+		r := rand.New(rand.NewPCG(uint64(*cds.Seed), uint64(chunkIndex)))
+		return r.Perm(len(cds.Providers))
+	default:
+		return rand.Perm(len(cds.Providers))
+	}
+}
+
+// randomIndex returns a random index below n for a chunk, drawn like randomProviders
+func (cds *CloudDistributionStrategy) randomIndex(chunkIndex, n int) int {
+	switch {
+	case cds.rng != nil:
+		cds.rngMu.Lock()
+		defer cds.rngMu.Unlock()
+		return cds.rng.IntN(n)
+	case cds.Seed != nil:
+		r := rand.New(rand.NewPCG(uint64(*cds.Seed), uint64(chunkIndex)))
+		return r.IntN(n)
+	default:
+		return rand.IntN(n)
+	}
+}
+
+// Weight returns the share of new chunks provider gets, see Weights
+func (cds *CloudDistributionStrategy) Weight(provider CloudProvider) int {
+	if w, ok := cds.Weights[provider]; ok {
+		return w
+	}
+	return 1
+}
+
+// Uploadable returns the providers new chunks can go to: those with a weight
+// above 0, in order and without repeats
+func (cds *CloudDistributionStrategy) Uploadable() []CloudProvider {
+	var providers []CloudProvider
+	for _, provider := range cds.Providers {
+		if cds.Weight(provider) > 0 && !slices.Contains(providers, provider) {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// weightedSchedule returns one round of weighted round robin: every
+// uploadable provider as often as its weight, interleaved (smooth weighted
+// round robin) so a heavy provider's turns are spread over the round
+func (cds *CloudDistributionStrategy) weightedSchedule() []CloudProvider {
+	providers := cds.Uploadable()
+	weights := make([]int, len(providers))
+	divisor, total := 0, 0
+	for i, provider := range providers {
+		weights[i] = cds.Weight(provider)
+		divisor = gcd(divisor, weights[i])
+	}
+	for i := range weights {
+		weights[i] /= divisor
+		total += weights[i]
+	}
+
+	schedule := make([]CloudProvider, 0, total)
+	current := make([]int, len(providers))
+	for len(schedule) < total {
+		best := 0
+		for i := range providers {
+			current[i] += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, providers[best])
+	}
+	return schedule
+}
+
+// gcd returns the greatest common divisor of a and b, b when a is 0
+func gcd(a, b int) int {
+	for a != 0 {
+		a, b = b%a, a
+	}
+	return b
 }
 
 // DefaultCloudStrategy returns a basic distribution strategy
@@ -62,7 +256,7 @@ func CustomCloudStrategy(providers []CloudProvider) CloudDistributionStrategy {
 		// Default to Google Drive only if no providers specified
 		providers = []CloudProvider{GoogleDrive}
 	}
-	
+
 	return CloudDistributionStrategy{
 		Providers:           providers,
 		ReplicationCount:    1,
@@ -76,7 +270,7 @@ func CustomCloudStrategyWithAccounts(providers []CloudProvider, gdriveAccounts i
 	if len(providers) == 0 {
 		providers = []CloudProvider{GoogleDrive}
 	}
-	
+
 	return CloudDistributionStrategy{
 		Providers:           providers,
 		ReplicationCount:    1,
@@ -96,8 +290,23 @@ func (cds *CloudDistributionStrategy) GetChunkDestination(chunkIndex int) []Clou
 		return []CloudProvider{Local}
 	}
 
+	if cds.Placement == PlacementDistinct {
+		return cds.distinctDestinations(chunkIndex)
+	}
+
 	destinations := make([]CloudProvider, 0, cds.ReplicationCount)
 
+	// Weighted: the chunk's turn in the schedule picks the first copy, and
+	// further copies go to the other providers first, so a heavy provider
+	// doesn't get every replica of its chunks
+	if cds.Weights != nil {
+		order := cds.providerOrder(chunkIndex)
+		for i := 0; i < cds.ReplicationCount && len(order) > 0; i++ {
+			destinations = append(destinations, order[i%len(order)])
+		}
+		return destinations
+	}
+
 	switch cds.LoadBalancing {
 	case "round_robin":
 		for i := 0; i < cds.ReplicationCount; i++ {
@@ -105,8 +314,11 @@ func (cds *CloudDistributionStrategy) GetChunkDestination(chunkIndex int) []Clou
 			destinations = append(destinations, cds.Providers[providerIndex])
 		}
 	case "random":
-		// Implementation for random distribution would go here
-		fallthrough
+		// Distinct providers in random order, wrapping around if there are more copies than providers
+		order := cds.randomProviders(chunkIndex)
+		for i := 0; i < cds.ReplicationCount; i++ {
+			destinations = append(destinations, cds.Providers[order[i%len(order)]])
+		}
 	default:
 		// Default to round-robin
 		for i := 0; i < cds.ReplicationCount; i++ {
@@ -118,20 +330,149 @@ func (cds *CloudDistributionStrategy) GetChunkDestination(chunkIndex int) []Clou
 	return destinations
 }
 
-// GenerateCloudPath creates a cloud-safe path for the chunk
-func GenerateCloudPath(provider CloudProvider, chunkID string) string {
+// distinctDestinations spreads a chunk's replicas over failure domains: one
+// per provider in load-balancing order first, then further rounds over the
+// providers that have more accounts. A provider appears at most once per
+// account it has, so the uploader can put every replica on its own account.
+// There are fewer destinations than ReplicationCount when there aren't
+// enough accounts.
+func (cds *CloudDistributionStrategy) distinctDestinations(chunkIndex int) []CloudProvider {
+	order := cds.providerOrder(chunkIndex)
+	destinations := make([]CloudProvider, 0, cds.ReplicationCount)
+	for round := 0; len(destinations) < cds.ReplicationCount; round++ {
+		added := false
+		for _, provider := range order {
+			if len(destinations) < cds.ReplicationCount && round < cds.accountCount(provider) {
+				destinations = append(destinations, provider)
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return destinations
+}
+
+// providerOrder returns the distinct providers in the order load balancing
+// prefers them for a chunk. With weights, the order follows the weighted
+// schedule from the chunk's turn, or a random turn, and leaves out
+// providers weighing 0.
+func (cds *CloudDistributionStrategy) providerOrder(chunkIndex int) []CloudProvider {
+	if cds.Weights != nil {
+		schedule := cds.weightedSchedule()
+		if len(schedule) == 0 {
+			return nil
+		}
+		start := chunkIndex % len(schedule)
+		if cds.LoadBalancing == "random" {
+			start = cds.randomIndex(chunkIndex, len(schedule))
+		}
+		var order []CloudProvider
+		for i := range schedule {
+			if provider := schedule[(start+i)%len(schedule)]; !slices.Contains(order, provider) {
+				order = append(order, provider)
+			}
+		}
+		return order
+	}
+
+	var indexes []int
+	if cds.LoadBalancing == "random" {
+		indexes = cds.randomProviders(chunkIndex)
+	} else {
+		for i := range cds.Providers {
+			indexes = append(indexes, (chunkIndex+i)%len(cds.Providers))
+		}
+	}
+
+	var order []CloudProvider
+	for _, i := range indexes {
+		if !slices.Contains(order, cds.Providers[i]) {
+			order = append(order, cds.Providers[i])
+		}
+	}
+	return order
+}
+
+// accountCount returns how many accounts a provider has for distinct placement
+func (cds *CloudDistributionStrategy) accountCount(provider CloudProvider) int {
+	if n, ok := cds.AccountCounts[provider]; ok {
+		return n
+	}
+	return 1
+}
+
+// DistinctDomains returns how many distinct failure domains (accounts) the
+// strategy's providers have, the most replicas PlacementDistinct can place
+func (cds *CloudDistributionStrategy) DistinctDomains() int {
+	total := 0
+	for _, provider := range cds.providerOrder(0) {
+		total += cds.accountCount(provider)
+	}
+	return total
+}
+
+// replicaKey returns the CloudIDs key of the i-th copy in providers, see manifest.ReplicaKey
+func replicaKey(providers []string, i int) string {
+	return manifest.ReplicaKey(providers, i)
+}
+
+// replicaOrder returns the indices of the first n copies recorded in providers
+// in the order to download them: copies on the providers in preferred come
+// first, in that order, and the rest follow in recorded order
+func replicaOrder(providers []string, n int, preferred []CloudProvider) []int {
+	n = min(n, len(providers))
+	rank := func(i int) int {
+		for r, p := range preferred {
+			if string(p) == providers[i] {
+				return r
+			}
+		}
+		return len(preferred)
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return rank(order[a]) < rank(order[b])
+	})
+	return order
+}
+
+// GenerateCloudPathWithTemplates creates the cloud path for a chunk from the
+// provider's template in templates, replacing {id} with the chunk ID, and falls
+// back to GenerateCloudPath for providers without a template. Templates spell
+// out their own extension.
+func GenerateCloudPathWithTemplates(provider CloudProvider, chunkID, ext string, templates map[CloudProvider]string) string {
+	if template, ok := templates[provider]; ok {
+		return strings.ReplaceAll(template, "{id}", chunkID)
+	}
+	return GenerateCloudPath(provider, chunkID, ext)
+}
+
+// GenerateCloudPath creates a cloud-safe path for the chunk, a file with
+// extension ext (manifest.DefaultChunkExtension when empty)
+func GenerateCloudPath(provider CloudProvider, chunkID, ext string) string {
+	if ext == "" {
+		ext = manifest.DefaultChunkExtension
+	}
 	switch provider {
 	case GoogleDrive:
-		return fmt.Sprintf("distributed-chunks/%s.chunk", chunkID)
+		return fmt.Sprintf("distributed-chunks/%s%s", chunkID, ext)
 	case Dropbox:
-		return fmt.Sprintf("/Apps/DistributedChunks/%s.chunk", chunkID)
+		return fmt.Sprintf("%s/%s%s", DefaultDropboxPath, chunkID, ext)
 	case OneDrive:
-		return fmt.Sprintf("DistributedChunks/%s.chunk", chunkID)
+		return fmt.Sprintf("DistributedChunks/%s%s", chunkID, ext)
 	case MEGACloud:
-		return fmt.Sprintf("chunks/%s.chunk", chunkID)
+		return fmt.Sprintf("chunks/%s%s", chunkID, ext)
 	case IPFS:
 		return chunkID // IPFS uses content-based addressing
+	case WebDAV:
+		return fmt.Sprintf("%s/%s%s", DefaultWebDAVPath, chunkID, ext)
 	default:
-		return filepath.Join("chunks", chunkID+".chunk")
+		return filepath.Join("chunks", chunkID+ext)
 	}
 }
@@ -0,0 +1,539 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/config"
+)
+
+// DefaultDropboxPath is the folder used when an account doesn't set one
+const DefaultDropboxPath = "/distributed-chunks"
+
+// Dropbox upload limits. A single upload request takes at most
+// DropboxSingleUploadLimit bytes; larger files go through an upload session
+// in parts of DropboxPartSize, each retried on its own.
+const (
+	DropboxSingleUploadLimit = 150 << 20
+	DropboxPartSize          = 64 << 20
+	dropboxPartRetries       = 3
+)
+
+// Dropbox API endpoints
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com/2"
+	dropboxContentURL = "https://content.dropboxapi.com/2"
+	dropboxTokenURL   = "https://api.dropboxapi.com/oauth2/token"
+)
+
+// DropboxClient handles Dropbox API v2 operations. File IDs are Dropbox
+// file IDs ("id:..."), which the API accepts wherever it takes a path.
+type DropboxClient struct {
+	httpClient   *http.Client
+	apiURL       string
+	contentURL   string
+	tokenURL     string
+	appKey       string
+	appSecret    string
+	refreshToken string
+	tokenMu      sync.Mutex // Guards accessToken and expiry, refreshed by concurrent uploads
+	accessToken  string
+	expiry       time.Time // When accessToken expires, zero for a configured token
+	folder       string    // Folder chunks are stored in
+	name         string    // Account name for identification
+	singleLimit  int64     // Largest file uploaded in a single request
+	partSize     int64     // Size of each upload session part
+	progress     ProgressFunc
+	maxChunks    int   // Upload cap per run, 0 for no limit
+	maxBytes     int64 // Upload cap per run in bytes, 0 for no limit
+}
+
+func init() {
+	RegisterProvider(Dropbox, createDropboxClients)
+}
+
+// createDropboxClients creates a client for each enabled Dropbox account
+func createDropboxClients(cfg *config.Config) (map[string]CloudClient, error) {
+	clients := make(map[string]CloudClient)
+	for _, account := range cfg.GetEnabledDropboxAccounts() {
+		account.Path = cfg.DropboxFolder(account)
+		dropbox := CreateDropboxClient(account)
+		if err := dropbox.SetProxy(cfg.CloudConfig.Proxy); err != nil {
+			return nil, fmt.Errorf("failed to create Dropbox client for account '%s': %w", account.Name, err)
+		}
+		clients[account.Name] = dropbox
+	}
+	return clients, nil
+}
+
+// CreateDropboxClient creates a new Dropbox client from an account configuration
+func CreateDropboxClient(account config.DropboxAccount) *DropboxClient {
+	folder := "/" + strings.Trim(account.Path, "/")
+	if folder == "/" {
+		folder = DefaultDropboxPath
+	}
+	return &DropboxClient{
+		httpClient:   &http.Client{Timeout: 10 * time.Minute},
+		apiURL:       dropboxAPIURL,
+		contentURL:   dropboxContentURL,
+		tokenURL:     dropboxTokenURL,
+		appKey:       account.AppKey,
+		appSecret:    account.AppSecret,
+		refreshToken: account.RefreshToken,
+		accessToken:  account.AccessToken,
+		folder:       folder,
+		name:         account.Name,
+		singleLimit:  DropboxSingleUploadLimit,
+		partSize:     DropboxPartSize,
+		maxChunks:    account.MaxChunks,
+		maxBytes:     account.MaxBytes,
+	}
+}
+
+// CheckCredentials checks that there is a token, and an app key to refresh
+// it with
+func (db *DropboxClient) CheckCredentials() error {
+	if db.refreshToken == "" && db.accessToken == "" {
+		return fmt.Errorf("%w: no refresh_token or access_token", ErrBadCredentials)
+	}
+	if db.refreshToken != "" && db.appKey == "" {
+		return fmt.Errorf("%w: refresh_token needs the app_key it was issued to", ErrBadCredentials)
+	}
+	return nil
+}
+
+// SetProxy sends all requests through proxy, or through the proxy from
+// HTTP_PROXY/HTTPS_PROXY when proxy is empty
+func (db *DropboxClient) SetProxy(proxy string) error {
+	transport, err := newProxyTransport(proxy)
+	if err != nil {
+		return err
+	}
+	db.httpClient.Transport = transport
+	return nil
+}
+
+// SetProgressFunc sets a callback that receives upload progress within a
+// file, reported after each upload session part
+func (db *DropboxClient) SetProgressFunc(fn ProgressFunc) {
+	db.progress = fn
+}
+
+// Limits returns the per-run upload caps from the account configuration
+func (db *DropboxClient) Limits() (int, int64) {
+	return db.maxChunks, db.maxBytes
+}
+
+// Initialize checks the token and creates the folder if needed
+func (db *DropboxClient) Initialize() error {
+	var account struct {
+		Email string `json:"email"`
+	}
+	if err := db.rpc("/users/get_current_account", nil, &account); err != nil {
+		return fmt.Errorf("can't access Dropbox account: %w", err)
+	}
+
+	err := db.rpc("/files/create_folder_v2", map[string]any{"path": db.folder, "autorename": false}, nil)
+	if err != nil && !isDropboxError(err, "path/conflict") {
+		return fmt.Errorf("can't create folder %s: %w", db.folder, err)
+	}
+
+	fmt.Printf("Using Dropbox folder '%s' of %s for account '%s'\n", db.folder, account.Email, db.name)
+	return nil
+}
+
+// dropboxFile is the metadata Dropbox returns for a file
+type dropboxFile struct {
+	ID          string `json:"id"`
+	PathDisplay string `json:"path_display"`
+	Size        int64  `json:"size"`
+}
+
+// UploadFile uploads a file into the folder and returns its file ID. Files
+// over the single-request limit are uploaded in an upload session.
+func (db *DropboxClient) UploadFile(localPath, cloudPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("unable to get file info: %w", err)
+	}
+
+	fileName := path.Base(filepath.ToSlash(cloudPath))
+	commit := map[string]any{"path": path.Join(db.folder, fileName), "mode": "overwrite", "mute": true}
+	var uploaded dropboxFile
+	if fileInfo.Size() <= db.singleLimit {
+		err = db.content("/files/upload", commit, file, &uploaded)
+	} else {
+		uploaded, err = db.uploadSession(file, fileInfo.Size(), fileName, commit)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to upload file: %w", err)
+	}
+
+	fmt.Printf("Uploaded to Dropbox account '%s': %s (Size: %d bytes)\n", db.name, uploaded.PathDisplay, fileInfo.Size())
+	return uploaded.ID, nil
+}
+
+// uploadSession uploads file in parts through an upload session: start,
+// append for every further part and finish with the last one, which commits
+// the file. A failed part is retried; when Dropbox already received it, it
+// reports the offset to carry on from.
+func (db *DropboxClient) uploadSession(file *os.File, size int64, fileName string, commit map[string]any) (dropboxFile, error) {
+	var uploaded dropboxFile
+	var session struct {
+		SessionID string `json:"session_id"`
+	}
+	first := io.NewSectionReader(file, 0, min(db.partSize, size))
+	if err := db.retryPart(func() error {
+		first.Seek(0, io.SeekStart)
+		return db.content("/files/upload_session/start", map[string]any{"close": false}, first, &session)
+	}); err != nil {
+		return uploaded, fmt.Errorf("starting upload session: %w", err)
+	}
+
+	offset := min(db.partSize, size)
+	for offset < size {
+		db.reportProgress(fileName, offset, size)
+		n := min(db.partSize, size-offset)
+		last := offset+n == size
+		part := io.NewSectionReader(file, offset, n)
+		cursor := map[string]any{"session_id": session.SessionID, "offset": offset}
+
+		err := db.retryPart(func() error {
+			part.Seek(0, io.SeekStart)
+			if last {
+				return db.content("/files/upload_session/finish", map[string]any{"cursor": cursor, "commit": commit}, part, &uploaded)
+			}
+			return db.content("/files/upload_session/append_v2", map[string]any{"cursor": cursor, "close": false}, part, nil)
+		})
+
+		var apiErr *dropboxError
+		if errors.As(err, &apiErr) && apiErr.CorrectOffset > offset && apiErr.CorrectOffset <= size {
+			// A retried part whose earlier attempt arrived after all
+			offset = apiErr.CorrectOffset
+			continue
+		}
+		if err != nil {
+			return uploaded, fmt.Errorf("uploading part at offset %d: %w", offset, err)
+		}
+		offset += n
+	}
+
+	// A file that fit in the first part still has to be committed
+	if uploaded.ID == "" {
+		cursor := map[string]any{"session_id": session.SessionID, "offset": size}
+		if err := db.retryPart(func() error {
+			return db.content("/files/upload_session/finish", map[string]any{"cursor": cursor, "commit": commit}, bytes.NewReader(nil), &uploaded)
+		}); err != nil {
+			return uploaded, fmt.Errorf("finishing upload session: %w", err)
+		}
+	}
+	db.reportProgress(fileName, size, size)
+	return uploaded, nil
+}
+
+// retryPart runs send, retrying it with backoff while it fails with an error
+// that may go away: network errors, rate limits and server errors
+func (db *DropboxClient) retryPart(send func() error) error {
+	var err error
+	for attempt := 0; attempt <= dropboxPartRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<(attempt-1)) * time.Second)
+		}
+		err = send()
+		var apiErr *dropboxError
+		if err == nil || (errors.As(err, &apiErr) && !apiErr.retryable()) {
+			return err
+		}
+	}
+	return err
+}
+
+// reportProgress passes upload progress within a file to the progress func
+func (db *DropboxClient) reportProgress(fileName string, current, total int64) {
+	if db.progress != nil {
+		db.progress(fileName, current, total)
+	}
+}
+
+// DownloadFile downloads a file by its file ID
+func (db *DropboxClient) DownloadFile(fileID, localPath string) error {
+	body, err := db.OpenFile(fileID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+
+	outFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to create local file: %w", err)
+	}
+	defer outFile.Close()
+
+	_, err = io.Copy(outFile, body)
+	if err != nil {
+		return fmt.Errorf("unable to copy file content: %w", err)
+	}
+
+	fmt.Printf("Downloaded from Dropbox account '%s': %s\n", db.name, localPath)
+	return nil
+}
+
+// OpenFile starts downloading a file by its file ID
+func (db *DropboxClient) OpenFile(fileID string) (io.ReadCloser, error) {
+	resp, err := db.send(db.contentURL+"/files/download", map[string]any{"path": fileID}, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to download file: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// FindFileByName looks up a file in the folder and returns its file ID
+func (db *DropboxClient) FindFileByName(fileName string) (string, error) {
+	info, err := db.StatFile(path.Join(db.folder, fileName))
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+// StatFile returns a file's size. Dropbox reports its own content hash
+// rather than an MD5, so MD5 is left empty.
+func (db *DropboxClient) StatFile(fileID string) (RemoteFile, error) {
+	var info dropboxFile
+	err := db.rpc("/files/get_metadata", map[string]any{"path": fileID}, &info)
+	if isDropboxError(err, "path/not_found") {
+		return RemoteFile{}, fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("unable to get file info: %w", err)
+	}
+	return RemoteFile{ID: info.ID, Size: info.Size}, nil
+}
+
+// CloudFolder returns the folder chunks are uploaded into
+func (db *DropboxClient) CloudFolder() (string, string) {
+	return db.folder, ""
+}
+
+// PublicLink shares the file with anyone who has the link, reusing an
+// existing link, and returns its direct download URL
+func (db *DropboxClient) PublicLink(fileID string) (string, error) {
+	var link struct {
+		URL string `json:"url"`
+	}
+	err := db.rpc("/sharing/create_shared_link_with_settings", map[string]any{"path": fileID}, &link)
+	if isDropboxError(err, "shared_link_already_exists") {
+		var links struct {
+			Links []struct {
+				URL string `json:"url"`
+			} `json:"links"`
+		}
+		if err = db.rpc("/sharing/list_shared_links", map[string]any{"path": fileID, "direct_only": true}, &links); err == nil {
+			if len(links.Links) == 0 {
+				return "", fmt.Errorf("unable to share file: no shared link found")
+			}
+			link.URL = links.Links[0].URL
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to share file: %w", err)
+	}
+
+	// dl=1 downloads the file instead of showing a preview page
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return "", fmt.Errorf("unable to share file: %w", err)
+	}
+	q := u.Query()
+	q.Set("dl", "1")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// DeleteFile deletes a file by its file ID
+func (db *DropboxClient) DeleteFile(fileID string) error {
+	err := db.rpc("/files/delete_v2", map[string]any{"path": fileID}, nil)
+	if err != nil && !isDropboxError(err, "path_lookup/not_found") {
+		return fmt.Errorf("unable to delete file: %w", err)
+	}
+	return nil
+}
+
+// dropboxError is an error response of the Dropbox API
+type dropboxError struct {
+	Status  int
+	Summary string // error_summary, e.g. "path/not_found/.."
+	// CorrectOffset is the offset an upload session expected, for a part
+	// sent at the wrong offset
+	CorrectOffset int64
+}
+
+func (e *dropboxError) Error() string {
+	if e.Summary == "" {
+		return fmt.Sprintf("dropbox returned %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("dropbox returned %d: %s", e.Status, e.Summary)
+}
+
+func (e *dropboxError) Unwrap() error {
+	if e.Status == http.StatusUnauthorized {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// retryable reports whether the request may succeed if sent again
+func (e *dropboxError) retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// isDropboxError reports whether err is a Dropbox API error whose summary
+// contains tag, e.g. "path/not_found"
+func isDropboxError(err error, tag string) bool {
+	var apiErr *dropboxError
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Summary, tag)
+}
+
+// rpc calls an RPC endpoint with args as JSON and decodes the JSON response
+// into out, unless out is nil
+func (db *DropboxClient) rpc(endpoint string, args any, out any) error {
+	body := []byte("null")
+	if args != nil {
+		var err error
+		if body, err = json.Marshal(args); err != nil {
+			return err
+		}
+	}
+	resp, err := db.send(db.apiURL+endpoint, nil, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// content calls a content upload endpoint with args in the Dropbox-API-Arg
+// header and data as the body, decoding the JSON response into out, unless
+// out is nil
+func (db *DropboxClient) content(endpoint string, args any, data io.Reader, out any) error {
+	resp, err := db.send(db.contentURL+endpoint, args, data, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send posts an authenticated request, with args in the Dropbox-API-Arg
+// header unless they are nil, turning error responses into *dropboxError
+func (db *DropboxClient) send(target string, args any, body io.Reader, contentType string) (*http.Response, error) {
+	token, err := db.token()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if args != nil {
+		arg, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", string(arg))
+	}
+
+	resp, err := db.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	apiErr := &dropboxError{Status: resp.StatusCode}
+	var payload struct {
+		Summary string `json:"error_summary"`
+		Error   struct {
+			LookupFailed struct {
+				CorrectOffset int64 `json:"correct_offset"`
+			} `json:"lookup_failed"`
+			CorrectOffset int64 `json:"correct_offset"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&payload) == nil {
+		apiErr.Summary = payload.Summary
+		apiErr.CorrectOffset = max(payload.Error.CorrectOffset, payload.Error.LookupFailed.CorrectOffset)
+	}
+	return nil, apiErr
+}
+
+// token returns an access token, refreshing it with the refresh token when
+// there is none yet or it is about to expire
+func (db *DropboxClient) token() (string, error) {
+	db.tokenMu.Lock()
+	defer db.tokenMu.Unlock()
+	if db.refreshToken == "" || (db.accessToken != "" && time.Until(db.expiry) > time.Minute) {
+		return db.accessToken, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {db.refreshToken},
+		"client_id":     {db.appKey},
+	}
+	if db.appSecret != "" {
+		form.Set("client_secret", db.appSecret)
+	}
+	resp, err := db.httpClient.PostForm(db.tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("unable to refresh access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return "", fmt.Errorf("dropbox %w: refreshing the access token: %s %s", ErrAuthFailed, resp.Status, tok.Error)
+	}
+	db.accessToken = tok.AccessToken
+	db.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return db.accessToken, nil
+}
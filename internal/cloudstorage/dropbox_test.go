@@ -0,0 +1,128 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sessionServer is a fake Dropbox content API that keeps a single upload
+// session. When lostAppend is set, the first append is stored but answered
+// with incorrect_offset, as when a part arrived but its response didn't.
+type sessionServer struct {
+	t          *testing.T
+	lostAppend bool
+	data       []byte
+	calls      []string
+	commitPath string
+}
+
+func (s *sessionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/2/files/upload_session/")
+	s.calls = append(s.calls, endpoint)
+	var arg struct {
+		Cursor struct {
+			Offset int64 `json:"offset"`
+		} `json:"cursor"`
+		Commit struct {
+			Path string `json:"path"`
+		} `json:"commit"`
+	}
+	if err := json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), &arg); err != nil {
+		s.t.Errorf("%s: bad Dropbox-API-Arg: %v", endpoint, err)
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	if endpoint != "start" && arg.Cursor.Offset != int64(len(s.data)) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, `{"error_summary": "incorrect_offset/..", "error": {".tag": "incorrect_offset", "correct_offset": %d}}`, len(s.data))
+		return
+	}
+	s.data = append(s.data, body...)
+
+	switch endpoint {
+	case "start":
+		fmt.Fprint(w, `{"session_id": "session"}`)
+	case "append_v2":
+		if s.lostAppend {
+			s.lostAppend = false
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, `{"error_summary": "incorrect_offset/..", "error": {".tag": "incorrect_offset", "correct_offset": %d}}`, len(s.data))
+			return
+		}
+		fmt.Fprint(w, `null`)
+	case "finish":
+		s.commitPath = arg.Commit.Path
+		fmt.Fprintf(w, `{"id": "id:chunk", "path_display": %q, "size": %d}`, arg.Commit.Path, len(s.data))
+	default:
+		s.t.Errorf("unexpected endpoint %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// uploadThroughSession uploads data with a Dropbox client pointed at srv
+// whose limits force an upload session
+func uploadThroughSession(t *testing.T, srv *sessionServer, data []byte, partSize int64) string {
+	t.Helper()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	localPath := filepath.Join(t.TempDir(), "chunk.bin")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db := &DropboxClient{
+		httpClient:  ts.Client(),
+		apiURL:      ts.URL + "/2",
+		contentURL:  ts.URL + "/2",
+		accessToken: "token",
+		folder:      "/chunks",
+		name:        "test",
+		singleLimit: 2,
+		partSize:    partSize,
+	}
+	id, err := db.UploadFile(localPath, "distributed-chunks/chunk.bin")
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if !bytes.Equal(srv.data, data) {
+		t.Errorf("Dropbox received %q, want %q", srv.data, data)
+	}
+	if srv.commitPath != "/chunks/chunk.bin" {
+		t.Errorf("committed to %s, want /chunks/chunk.bin", srv.commitPath)
+	}
+	return id
+}
+
+func TestDropboxUploadSessionResumesAtCorrectOffset(t *testing.T) {
+	srv := &sessionServer{t: t, lostAppend: true}
+	id := uploadThroughSession(t, srv, []byte("0123456789"), 4)
+	if id != "id:chunk" {
+		t.Errorf("file ID = %q, want id:chunk", id)
+	}
+
+	// The lost append already delivered bytes 4-7, so the last part follows
+	// at offset 8 without sending them again
+	want := []string{"start", "append_v2", "finish"}
+	if strings.Join(srv.calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", srv.calls, want)
+	}
+}
+
+func TestDropboxUploadSessionFinishesSinglePart(t *testing.T) {
+	srv := &sessionServer{t: t}
+	uploadThroughSession(t, srv, []byte("0123"), 4)
+
+	// All of the file went with start, so finish commits it with no data
+	want := []string{"start", "finish"}
+	if strings.Join(srv.calls, ",") != strings.Join(want, ",") {
+		t.Errorf("calls = %v, want %v", srv.calls, want)
+	}
+}
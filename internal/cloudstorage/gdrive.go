@@ -2,30 +2,112 @@ package cloudstorage
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/probablysamir/chunk-store/internal/config"
+	"github.com/probablysamir/chunk-store/internal/manifest"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+// ProgressFunc receives progress of a single file transfer
+type ProgressFunc func(fileName string, current, total int64)
+
 // GoogleDriveClient handles Google Drive API operations
 type GoogleDriveClient struct {
-	service   *drive.Service
-	folderID  string
-	tokenFile string
-	credsFile string
-	name      string // Account name for identification
-	folderName string // Custom folder name
+	service         *drive.Service
+	folderID        string
+	folderMu        sync.Mutex // Guards folderID and folderTitle, which FolderConflictCreateNew only sets on the first upload
+	folderTitle     string     // Name of the folder in use, once found or created
+	folderConflict  string     // What to do when several folders have folderName (FolderConflict*)
+	tokenFile       string
+	credsFile       string
+	subject         string            // User a service account key acts as, empty for the service account itself
+	name            string            // Account name for identification
+	folderName      string            // Custom folder name
+	uploadChunkSize int               // Resumable upload request size in bytes
+	progress        ProgressFunc      // Optional in-file upload progress callback
+	limiter         *rate.Limiter     // Client-side API rate limit shared by all requests of this account
+	createLimiter   *rate.Limiter     // Limit on files created by uploads, on top of limiter, nil for none
+	maxChunks       int               // Upload cap per run, 0 for no limit
+	maxBytes        int64             // Upload cap per run in bytes, 0 for no limit
+	transport       http.RoundTripper // Base transport for API and OAuth requests, nil for the default
+}
+
+// Folder conflict policies, for when several Drive folders have the folder name
+const (
+	FolderConflictFirst     = "first"      // Use the oldest of them (default)
+	FolderConflictError     = "error"      // Fail, so folder_id has to pick one
+	FolderConflictCreateNew = "create-new" // Ignore them and upload to a new, uniquely named folder
+)
+
+// DefaultDriveRequestsPerSecond keeps each account well under Drive's per-user quota
+const DefaultDriveRequestsPerSecond = 10
+
+// rateLimitedTransport waits for the limiter before sending each request
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+func init() {
+	RegisterProvider(GoogleDrive, createGoogleDriveClients)
+}
+
+// createGoogleDriveClients creates a client for each enabled Google Drive account
+func createGoogleDriveClients(cfg *config.Config) (map[string]CloudClient, error) {
+	clients := make(map[string]CloudClient)
+	for _, account := range cfg.GetEnabledGoogleDriveAccounts() {
+		gdrive, err := CreateGoogleDriveClientWithName(
+			account.CredsFile,
+			account.TokenFile,
+			account.Name,
+			cfg.GoogleDriveFolder(account),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Google Drive client for account '%s': %w", account.Name, err)
+		}
+		if err := gdrive.SetProxy(cfg.CloudConfig.Proxy); err != nil {
+			return nil, fmt.Errorf("failed to create Google Drive client for account '%s': %w", account.Name, err)
+		}
+		gdrive.SetFolderID(account.FolderID)
+		gdrive.SetSubject(account.Subject)
+		gdrive.SetFolderConflict(cfg.CloudConfig.FolderConflict)
+		gdrive.SetLimits(account.MaxChunks, account.MaxBytes)
+		gdrive.SetUploadChunkSize(cfg.CloudConfig.UploadChunkSize)
+		gdrive.SetRequestsPerSecond(cfg.CloudConfig.DriveRequestsPerSecond)
+		gdrive.SetCreatesPerMinute(cfg.CloudConfig.DriveCreatesPerMinute)
+
+		clients[account.Name] = gdrive
+	}
+	return clients, nil
 }
 
 // CreateGoogleDriveClient creates a new Google Drive client
@@ -36,14 +118,96 @@ func CreateGoogleDriveClient(credsFile, tokenFile string) (*GoogleDriveClient, e
 // CreateGoogleDriveClientWithName creates a new Google Drive client with custom name and folder
 func CreateGoogleDriveClientWithName(credsFile, tokenFile, name, folderName string) (*GoogleDriveClient, error) {
 	return &GoogleDriveClient{
-		credsFile:  credsFile,
-		tokenFile:  tokenFile,
-		name:       name,
-		folderName: folderName,
-		folderID:   "", // Will be set when creating/finding the folder
+		credsFile:       credsFile,
+		tokenFile:       tokenFile,
+		name:            name,
+		folderName:      folderName,
+		folderID:        "", // Will be set when creating/finding the folder
+		uploadChunkSize: googleapi.DefaultUploadChunkSize,
+		limiter:         rate.NewLimiter(DefaultDriveRequestsPerSecond, DefaultDriveRequestsPerSecond),
 	}, nil
 }
 
+// SetFolderID makes the client use an existing folder, such as one on a shared
+// drive, instead of finding or creating a folder by name
+func (gd *GoogleDriveClient) SetFolderID(folderID string) {
+	gd.folderID = folderID
+}
+
+// SetSubject makes a client with a service account key act as the user with
+// the email address subject, through domain-wide delegation
+func (gd *GoogleDriveClient) SetSubject(subject string) {
+	gd.subject = subject
+}
+
+// SetFolderConflict sets what happens when several folders have the
+// client's folder name (FolderConflict*, empty for FolderConflictFirst). It
+// doesn't apply to a folder set with SetFolderID.
+func (gd *GoogleDriveClient) SetFolderConflict(policy string) {
+	gd.folderConflict = policy
+}
+
+// SetLimits caps how many chunks and bytes are uploaded to this account per run (0 for no limit)
+func (gd *GoogleDriveClient) SetLimits(maxChunks int, maxBytes int64) {
+	gd.maxChunks = maxChunks
+	gd.maxBytes = maxBytes
+}
+
+// Limits returns the per-run upload caps set by SetLimits
+func (gd *GoogleDriveClient) Limits() (int, int64) {
+	return gd.maxChunks, gd.maxBytes
+}
+
+// SetProxy sends all Drive and OAuth requests through proxy, or through the
+// proxy from HTTP_PROXY/HTTPS_PROXY when proxy is empty
+func (gd *GoogleDriveClient) SetProxy(proxy string) error {
+	transport, err := newProxyTransport(proxy)
+	if err != nil {
+		return err
+	}
+	gd.transport = transport
+	return nil
+}
+
+// oauthContext returns a context that makes the oauth2 package use gd.transport
+func (gd *GoogleDriveClient) oauthContext() context.Context {
+	ctx := context.Background()
+	if gd.transport != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: gd.transport})
+	}
+	return ctx
+}
+
+// SetRequestsPerSecond sets the client-side limit on Drive API requests for this account
+func (gd *GoogleDriveClient) SetRequestsPerSecond(rps float64) {
+	if rps > 0 {
+		gd.limiter = rate.NewLimiter(rate.Limit(rps), max(1, int(rps)))
+	}
+}
+
+// SetCreatesPerMinute limits how many files uploads create in this account
+// per minute, spread evenly, since Drive throttles rapid file creation below
+// its request quota. 0 means no limit beyond SetRequestsPerSecond.
+func (gd *GoogleDriveClient) SetCreatesPerMinute(perMinute float64) {
+	if perMinute > 0 {
+		gd.createLimiter = rate.NewLimiter(rate.Limit(perMinute/60), 1)
+	}
+}
+
+// SetUploadChunkSize sets the size of each resumable upload request. Files
+// larger than this are sent in several requests that can be retried individually.
+// The size is rounded up to a multiple of 256 KiB.
+func (gd *GoogleDriveClient) SetUploadChunkSize(size int) {
+	if size > 0 {
+		gd.uploadChunkSize = size
+	}
+}
+
+// SetProgressFunc sets a callback that receives upload progress within a file
+func (gd *GoogleDriveClient) SetProgressFunc(fn ProgressFunc) {
+	gd.progress = fn
+}
+
 // Initialize sets up the Google Drive service with authentication
 func (gd *GoogleDriveClient) Initialize() error {
 	ctx := context.Background()
@@ -51,37 +215,152 @@ func (gd *GoogleDriveClient) Initialize() error {
 	// Read credentials file
 	b, err := os.ReadFile(gd.credsFile)
 	if err != nil {
-		return fmt.Errorf("can't read credentials file: %v", err)
+		return fmt.Errorf("can't read credentials file: %w", err)
 	}
 
-	// Parse credentials
-	config, err := google.ConfigFromJSON(b, drive.DriveFileScope)
-	if err != nil {
-		return fmt.Errorf("credentials file format is invalid: %v", err)
+	// The drive.file scope only covers files this app created, so a folder
+	// chosen by ID needs access to the whole drive
+	scope := drive.DriveFileScope
+	if gd.folderID != "" {
+		scope = drive.DriveScope
+	}
+
+	// A service account key signs its own tokens, so there's no browser
+	// sign-in and no token file
+	var client *http.Client
+	if isServiceAccountKey(b) {
+		jwtConfig, err := google.JWTConfigFromJSON(b, scope)
+		if err != nil {
+			return fmt.Errorf("service account key is invalid: %w", err)
+		}
+		jwtConfig.Subject = gd.subject
+		client = jwtConfig.Client(gd.oauthContext())
+	} else {
+		if err := gd.checkOAuthSettings(); err != nil {
+			return err
+		}
+		config, err := google.ConfigFromJSON(b, scope)
+		if err != nil {
+			return fmt.Errorf("credentials file format is invalid: %w", err)
+		}
+		client = gd.getClient(config)
 	}
 
-	// Get OAuth2 client
-	client := gd.getClient(config)
+	// Rate limit every API request the client sends
+	client.Transport = &rateLimitedTransport{base: client.Transport, limiter: gd.limiter}
 
 	// Create Drive service
 	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		return fmt.Errorf("couldn't create Drive service: %v", err)
+		return fmt.Errorf("couldn't create Drive service: %w", err)
 	}
 
 	gd.service = srv
 
 	// Create or find the distributed-chunks folder
 	err = gd.setupFolder()
+	if isDriveAuthError(err) {
+		return fmt.Errorf("google Drive %w (check credentials and token): %w", ErrAuthFailed, err)
+	}
 	if err != nil {
-		return fmt.Errorf("google Drive setup failed (check credentials and API access): %v", err)
+		return fmt.Errorf("google Drive setup failed (check credentials and API access): %w", err)
 	}
 
 	return nil
 }
 
+// isDriveAuthError reports whether err means the account's token was rejected
+func isDriveAuthError(err error) bool {
+	var apiErr *googleapi.Error
+	var tokenErr *oauth2.RetrieveError
+	return errors.As(err, &tokenErr) || (errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized)
+}
+
+// tokenFileLocks holds a mutex per token file path, so accounts initialized
+// concurrently with the same token file don't authorize and write it at once
+var tokenFileLocks sync.Map
+
+// lockTokenFile locks the token file at path and returns the unlock function
+func lockTokenFile(path string) func() {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	mu, _ := tokenFileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// isServiceAccountKey reports whether b is a service account key rather than
+// OAuth client credentials
+func isServiceAccountKey(b []byte) bool {
+	var key struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(b, &key) == nil && key.Type == "service_account"
+}
+
+// checkOAuthSettings checks the account settings that depend on OAuth client
+// credentials: a token file to keep the token in, and no subject, which only
+// a service account can act as
+func (gd *GoogleDriveClient) checkOAuthSettings() error {
+	if gd.subject != "" {
+		return fmt.Errorf("%w: subject %s needs a service account key, %s is an OAuth client", ErrBadCredentials, gd.subject, gd.credsFile)
+	}
+	if gd.tokenFile == "" {
+		return fmt.Errorf("%w: OAuth client credentials need a token_file", ErrBadCredentials)
+	}
+	return nil
+}
+
+// CheckCredentials checks that the credentials file is a service account key
+// or OAuth client JSON and, for the latter, that the token file, if there is
+// one yet, holds a token
+func (gd *GoogleDriveClient) CheckCredentials() error {
+	b, err := os.ReadFile(gd.credsFile)
+	if err != nil {
+		return fmt.Errorf("%w: can't read credentials file: %w", ErrBadCredentials, err)
+	}
+	if isServiceAccountKey(b) {
+		jwtConfig, err := google.JWTConfigFromJSON(b, drive.DriveFileScope)
+		if err != nil {
+			return fmt.Errorf("%w: %s isn't a valid service account key: %v", ErrBadCredentials, gd.credsFile, err)
+		}
+		if jwtConfig.Email == "" || len(jwtConfig.PrivateKey) == 0 {
+			return fmt.Errorf("%w: service account key %s has no client email or private key", ErrBadCredentials, gd.credsFile)
+		}
+		return nil
+	}
+	if err := gd.checkOAuthSettings(); err != nil {
+		return err
+	}
+	config, err := google.ConfigFromJSON(b, drive.DriveFileScope)
+	if err != nil {
+		return fmt.Errorf("%w: %s isn't OAuth client credentials or a service account key (download one from the Google Cloud console): %v", ErrBadCredentials, gd.credsFile, err)
+	}
+	if config.ClientID == "" || config.ClientSecret == "" {
+		return fmt.Errorf("%w: %s has no client ID or secret", ErrBadCredentials, gd.credsFile)
+	}
+
+	// A missing token is fine, Initialize signs in for one
+	tok, err := gd.tokenFromFile()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: token file %s is malformed (delete it to sign in again): %v", ErrBadCredentials, gd.tokenFile, err)
+	}
+	if tok.AccessToken == "" && tok.RefreshToken == "" {
+		return fmt.Errorf("%w: token file %s holds no token (delete it to sign in again)", ErrBadCredentials, gd.tokenFile)
+	}
+	return nil
+}
+
 // getClient retrieves a token, saves the token, then returns the generated client
 func (gd *GoogleDriveClient) getClient(config *oauth2.Config) *http.Client {
+	// An account sharing the token file waits, then finds the token saved
+	unlock := lockTokenFile(gd.tokenFile)
+	defer unlock()
+
 	// Try to load token from file
 	tok, err := gd.tokenFromFile()
 	if err != nil {
@@ -89,35 +368,44 @@ func (gd *GoogleDriveClient) getClient(config *oauth2.Config) *http.Client {
 		tok = gd.getTokenFromWeb(config)
 		gd.saveToken(tok)
 	}
-	return config.Client(context.Background(), tok)
+	return config.Client(gd.oauthContext(), tok)
 }
 
 // getTokenFromWeb requests a token from the web
 func (gd *GoogleDriveClient) getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	// Start local server to catch redirect
-	codeChan := make(chan string)
-	server := &http.Server{Addr: ":8080"}
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	// Catch the redirect on a local server with its own port and mux, so
+	// several accounts can authorize at the same time
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Printf("Can't start the authentication server: %v\n", err)
+		return nil
+	}
+	codeChan := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		code := r.URL.Query().Get("code")
 		if code != "" {
 			w.Write([]byte("Authentication successful! You can close this tab."))
-			codeChan <- code
+			select {
+			case codeChan <- code:
+			default:
+			}
 		} else {
 			w.Write([]byte("Authentication failed. Please try again."))
 		}
 	})
+	server := &http.Server{Handler: mux}
 
 	// Start server
 	go func() {
-		server.ListenAndServe()
+		server.Serve(listener)
 	}()
 
 	// Set redirect URL and generate auth URL
-	config.RedirectURL = "http://localhost:8080"
+	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:%d", listener.Addr().(*net.TCPAddr).Port)
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 
-	fmt.Printf("Opening browser for Google Drive authentication...\n")
+	fmt.Printf("Opening browser for Google Drive authentication of account '%s'...\n", gd.name)
 	fmt.Printf("If browser doesn't open, go to: %s\n", authURL)
 
 	// Try to open browser
@@ -127,17 +415,17 @@ func (gd *GoogleDriveClient) getTokenFromWeb(config *oauth2.Config) *oauth2.Toke
 	select {
 	case authCode := <-codeChan:
 		server.Shutdown(context.Background())
-		tok, err := config.Exchange(context.TODO(), authCode)
+		tok, err := config.Exchange(gd.oauthContext(), authCode)
 		if err != nil {
 			fmt.Printf("Authentication failed: %v\n", err)
 			return nil
 		}
-		fmt.Println("Authentication complete!")
+		fmt.Printf("Authentication of account '%s' complete!\n", gd.name)
 		return tok
 
 	case <-time.After(2 * time.Minute):
 		server.Shutdown(context.Background())
-		fmt.Println("Authentication timed out.")
+		fmt.Printf("Authentication of account '%s' timed out.\n", gd.name)
 		return nil
 	}
 }
@@ -184,56 +472,155 @@ func (gd *GoogleDriveClient) saveToken(token *oauth2.Token) {
 
 // setupFolder creates or finds the distributed-chunks folder
 func (gd *GoogleDriveClient) setupFolder() error {
-	// Search for existing folder
-	folderName := gd.folderName
-	if folderName == "" {
-		folderName = "distributed-chunks"
-	}
-	
-	query := fmt.Sprintf("name='%s' and mimeType='application/vnd.google-apps.folder' and trashed=false", folderName)
-	r, err := gd.service.Files.List().Q(query).Do()
+	if gd.folderID != "" {
+		return gd.checkFolder()
+	}
+
+	// Search for existing folders, oldest first
+	folderName := gd.baseFolderName()
+	query := fmt.Sprintf("name='%s' and mimeType='application/vnd.google-apps.folder' and trashed=false", driveQuoted(folderName))
+	r, err := gd.service.Files.List().Q(query).OrderBy("createdTime").Do()
 	if err != nil {
-		return fmt.Errorf("can't search for folder: %v", err)
+		return fmt.Errorf("can't search for folder: %w", err)
 	}
 
-	if len(r.Files) > 0 {
+	switch {
+	case gd.folderConflict == FolderConflictCreateNew:
+		// Created on the first upload, so runs that only download don't leave empty folders behind
+		fmt.Printf("A new Google Drive folder will be created for account '%s' on its first upload\n", gd.name)
+		return nil
+	case len(r.Files) > 1 && gd.folderConflict == FolderConflictError:
+		ids := make([]string, len(r.Files))
+		for i, f := range r.Files {
+			ids[i] = f.Id
+		}
+		return fmt.Errorf("%d folders are named '%s' (IDs: %s), set folder_id to pick one", len(r.Files), folderName, strings.Join(ids, ", "))
+	case len(r.Files) > 0:
 		// Folder exists, use it
-		gd.folderID = r.Files[0].Id
-		fmt.Printf("Using existing Google Drive folder '%s' for account '%s': %s (ID: %s)\n", 
+		gd.folderID, gd.folderTitle = r.Files[0].Id, r.Files[0].Name
+		fmt.Printf("Using existing Google Drive folder '%s' for account '%s': %s (ID: %s)\n",
 			folderName, gd.name, r.Files[0].Name, gd.folderID)
+		if len(r.Files) > 1 {
+			fmt.Printf("⚠️  %d folders are named '%s' in account '%s', using the oldest; set folder_id or folder_conflict to choose\n",
+				len(r.Files), folderName, gd.name)
+		}
 		return nil
 	}
+	return gd.createFolder(folderName)
+}
+
+// driveQuoted escapes s for a quoted string in a Drive search query
+func driveQuoted(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
 
-	// Create new folder
+// CloudFolder returns the name and ID of the folder the client uploads to,
+// empty until it is known
+func (gd *GoogleDriveClient) CloudFolder() (string, string) {
+	gd.folderMu.Lock()
+	defer gd.folderMu.Unlock()
+	return gd.folderTitle, gd.folderID
+}
+
+// baseFolderName returns the name of the folder to find or create
+func (gd *GoogleDriveClient) baseFolderName() string {
+	if gd.folderName == "" {
+		return "distributed-chunks"
+	}
+	return gd.folderName
+}
+
+// createFolder creates a folder named name and makes it the client's folder
+func (gd *GoogleDriveClient) createFolder(name string) error {
 	folder := &drive.File{
-		Name:     folderName,
+		Name:     name,
 		MimeType: "application/vnd.google-apps.folder",
 	}
 
 	file, err := gd.service.Files.Create(folder).Do()
 	if err != nil {
-		return fmt.Errorf("can't create folder: %v", err)
+		return fmt.Errorf("can't create folder: %w", err)
 	}
 
-	gd.folderID = file.Id
-	fmt.Printf("Created Google Drive folder '%s' for account '%s': %s (ID: %s)\n", 
-		folderName, gd.name, file.Name, gd.folderID)
+	gd.folderID, gd.folderTitle = file.Id, file.Name
+	fmt.Printf("Created Google Drive folder '%s' for account '%s': %s (ID: %s)\n",
+		name, gd.name, file.Name, gd.folderID)
 	return nil
 }
 
-// UploadFile uploads a file to Google Drive
+// folder returns the ID of the client's folder. If there is none yet, as
+// under FolderConflictCreateNew before the first upload, a uniquely named one
+// is created when create is set, and the ID is empty otherwise.
+func (gd *GoogleDriveClient) folder(create bool) (string, error) {
+	gd.folderMu.Lock()
+	defer gd.folderMu.Unlock()
+	if gd.folderID == "" && create {
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		name := fmt.Sprintf("%s-%s-%x", gd.baseFolderName(), time.Now().UTC().Format("20060102-150405"), suffix)
+		if err := gd.createFolder(name); err != nil {
+			return "", err
+		}
+	}
+	return gd.folderID, nil
+}
+
+// checkFolder verifies that the configured folder ID exists and is a folder
+func (gd *GoogleDriveClient) checkFolder() error {
+	folder, err := gd.service.Files.Get(gd.folderID).Fields("id", "name", "mimeType", "trashed").SupportsAllDrives(true).Do()
+	if err != nil {
+		return fmt.Errorf("can't find folder %s (check the ID and that the account can access it): %w", gd.folderID, err)
+	}
+	if folder.MimeType != "application/vnd.google-apps.folder" {
+		return fmt.Errorf("%s (%s) is not a folder", gd.folderID, folder.Name)
+	}
+	if folder.Trashed {
+		return fmt.Errorf("folder %s (%s) is in the trash", gd.folderID, folder.Name)
+	}
+
+	gd.folderTitle = folder.Name
+	fmt.Printf("Using Google Drive folder '%s' for account '%s' (ID: %s)\n", folder.Name, gd.name, gd.folderID)
+	return nil
+}
+
+// UploadFile uploads a file to Google Drive and checks the MD5 Drive computed for it
 func (gd *GoogleDriveClient) UploadFile(localPath, cloudPath string) (string, error) {
+	fileID, _, err := gd.UploadFileChecked(localPath, cloudPath)
+	return fileID, err
+}
+
+// UploadFileChecked uploads a file to Google Drive and returns its ID and the
+// MD5 Drive computed, after checking it against the local file's. A copy that
+// arrived corrupted is deleted again.
+func (gd *GoogleDriveClient) UploadFileChecked(localPath, cloudPath string) (string, string, error) {
 	// Open local file
 	file, err := os.Open(localPath)
 	if err != nil {
-		return "", fmt.Errorf("unable to open file: %v", err)
+		return "", "", fmt.Errorf("unable to open file: %w", err)
 	}
 	defer file.Close()
 
 	// Get file info
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return "", fmt.Errorf("unable to get file info: %v", err)
+		return "", "", fmt.Errorf("unable to get file info: %w", err)
+	}
+
+	// Hash the local file first, then rewind it for the upload
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", "", fmt.Errorf("unable to read file: %w", err)
+	}
+	localMD5 := hex.EncodeToString(h.Sum(nil))
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", fmt.Errorf("unable to read file: %w", err)
+	}
+
+	folderID, err := gd.folder(true)
+	if err != nil {
+		return "", "", err
 	}
 
 	// Extract filename from cloudPath
@@ -242,82 +629,147 @@ func (gd *GoogleDriveClient) UploadFile(localPath, cloudPath string) (string, er
 	// Create file metadata
 	driveFile := &drive.File{
 		Name:    fileName,
-		Parents: []string{gd.folderID},
+		Parents: []string{folderID},
 	}
 
-	// Upload file
-	res, err := gd.service.Files.Create(driveFile).Media(file).Do()
+	// Upload file using a resumable upload in uploadChunkSize pieces
+	call := gd.service.Files.Create(driveFile).SupportsAllDrives(true).Fields("id,name,md5Checksum").
+		Media(file, googleapi.ChunkSize(gd.uploadChunkSize))
+	if gd.progress != nil {
+		total := fileInfo.Size()
+		call = call.ProgressUpdater(func(current, _ int64) {
+			gd.progress(fileName, current, total)
+		})
+	}
+	if gd.createLimiter != nil {
+		if err := gd.createLimiter.Wait(context.Background()); err != nil {
+			return "", "", err
+		}
+	}
+	res, err := call.Do()
 	if err != nil {
-		return "", fmt.Errorf("unable to upload file: %v", err)
+		return "", "", fmt.Errorf("unable to upload file: %w", err)
+	}
+
+	// Drive reports the MD5 of what it received, catching corruption in
+	// transit without downloading the file again
+	if res.Md5Checksum != "" && !strings.EqualFold(res.Md5Checksum, localMD5) {
+		gd.service.Files.Delete(res.Id).SupportsAllDrives(true).Do()
+		return "", "", fmt.Errorf("%w: Drive received %s with MD5 %s, the local file has %s", manifest.ErrHashMismatch, fileName, res.Md5Checksum, localMD5)
 	}
 
 	fmt.Printf("Uploaded to Google Drive account '%s': %s (ID: %s, Size: %d bytes)\n",
 		gd.name, res.Name, res.Id, fileInfo.Size())
 
-	return res.Id, nil
+	return res.Id, res.Md5Checksum, nil
 }
 
 // DownloadFile downloads a file from Google Drive
 func (gd *GoogleDriveClient) DownloadFile(fileID, localPath string) error {
 	// Get file content
-	resp, err := gd.service.Files.Get(fileID).Download()
+	body, err := gd.OpenFile(fileID)
 	if err != nil {
-		return fmt.Errorf("unable to download file: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
 	// Create local file
 	err = os.MkdirAll(filepath.Dir(localPath), 0755)
 	if err != nil {
-		return fmt.Errorf("unable to create directory: %v", err)
+		return fmt.Errorf("unable to create directory: %w", err)
 	}
 
 	outFile, err := os.Create(localPath)
 	if err != nil {
-		return fmt.Errorf("unable to create local file: %v", err)
+		return fmt.Errorf("unable to create local file: %w", err)
 	}
 	defer outFile.Close()
 
 	// Copy content
-	_, err = io.Copy(outFile, resp.Body)
+	_, err = io.Copy(outFile, body)
 	if err != nil {
-		return fmt.Errorf("unable to copy file content: %v", err)
+		return fmt.Errorf("unable to copy file content: %w", err)
 	}
 
 	fmt.Printf("Downloaded from Google Drive account '%s': %s\n", gd.name, localPath)
 	return nil
 }
 
+// OpenFile starts downloading a file's content
+func (gd *GoogleDriveClient) OpenFile(fileID string) (io.ReadCloser, error) {
+	resp, err := gd.service.Files.Get(fileID).SupportsAllDrives(true).Download()
+	if err != nil {
+		return nil, fmt.Errorf("unable to download file: %w", err)
+	}
+	return resp.Body, nil
+}
+
 // FindFileByName searches for a file by name in the distributed-chunks folder
 func (gd *GoogleDriveClient) FindFileByName(fileName string) (string, error) {
-	query := fmt.Sprintf("name='%s' and '%s' in parents and trashed=false", fileName, gd.folderID)
-	r, err := gd.service.Files.List().Q(query).Do()
+	folderID, _ := gd.folder(false)
+	if folderID == "" {
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+	query := fmt.Sprintf("name='%s' and '%s' in parents and trashed=false", fileName, folderID)
+	r, err := gd.service.Files.List().Q(query).SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Do()
 	if err != nil {
-		return "", fmt.Errorf("unable to search for file: %v", err)
+		return "", fmt.Errorf("unable to search for file: %w", err)
 	}
 
 	if len(r.Files) == 0 {
-		return "", fmt.Errorf("file not found: %s", fileName)
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
 	}
 
 	return r.Files[0].Id, nil
 }
 
+// StatFile returns a file's size and MD5 checksum from its metadata. A
+// trashed file counts as missing.
+func (gd *GoogleDriveClient) StatFile(fileID string) (RemoteFile, error) {
+	f, err := gd.service.Files.Get(fileID).Fields("id,size,md5Checksum,trashed").SupportsAllDrives(true).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return RemoteFile{}, fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+	}
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("unable to get file metadata: %w", err)
+	}
+	if f.Trashed {
+		return RemoteFile{}, fmt.Errorf("%w: %s is in the trash", ErrFileNotFound, fileID)
+	}
+	return RemoteFile{ID: f.Id, Size: f.Size, MD5: f.Md5Checksum}, nil
+}
+
 // DeleteFile deletes a file from Google Drive
 func (gd *GoogleDriveClient) DeleteFile(fileID string) error {
-	err := gd.service.Files.Delete(fileID).Do()
+	err := gd.service.Files.Delete(fileID).SupportsAllDrives(true).Do()
 	if err != nil {
-		return fmt.Errorf("unable to delete file: %v", err)
+		return fmt.Errorf("unable to delete file: %w", err)
 	}
 	return nil
 }
 
+// PublicLink shares a file with anyone who has the link and returns a URL
+// that downloads it directly, without Drive's virus scan warning page
+func (gd *GoogleDriveClient) PublicLink(fileID string) (string, error) {
+	perm := &drive.Permission{Type: "anyone", Role: "reader"}
+	_, err := gd.service.Permissions.Create(fileID, perm).SupportsAllDrives(true).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to share file: %w", err)
+	}
+	return "https://drive.usercontent.google.com/download?export=download&confirm=t&id=" + url.QueryEscape(fileID), nil
+}
+
 // ListFiles lists all files in the distributed-chunks folder
 func (gd *GoogleDriveClient) ListFiles() ([]*drive.File, error) {
-	query := fmt.Sprintf("'%s' in parents and trashed=false", gd.folderID)
-	r, err := gd.service.Files.List().Q(query).Do()
+	folderID, _ := gd.folder(false)
+	if folderID == "" {
+		return nil, nil
+	}
+	query := fmt.Sprintf("'%s' in parents and trashed=false", folderID)
+	r, err := gd.service.Files.List().Q(query).SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Do()
 	if err != nil {
-		return nil, fmt.Errorf("unable to list files: %v", err)
+		return nil, fmt.Errorf("unable to list files: %w", err)
 	}
 	return r.Files, nil
 }
@@ -0,0 +1,569 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/config"
+)
+
+// DefaultIPFSAPI is the Kubo RPC API used when an account doesn't set one
+const DefaultIPFSAPI = "http://127.0.0.1:5001"
+
+// Remote pin statuses of the IPFS Pinning Service API, recorded in manifests
+// as "<provider>_pin" in CloudIDs
+const (
+	PinQueued  = "queued"
+	PinPinning = "pinning"
+	PinPinned  = "pinned"
+	PinFailed  = "failed"
+)
+
+// errStreamFailed marks a Kubo command that failed after its response started,
+// reported in the X-Stream-Error trailer
+var errStreamFailed = errors.New("ipfs node failed mid-stream")
+
+// IPFSClient adds chunks to an IPFS node through its Kubo RPC API and pins
+// them on a remote pinning service, so they outlive the node's garbage
+// collection. File IDs are CIDs.
+type IPFSClient struct {
+	httpClient   *http.Client
+	apiURL       string
+	gatewayURL   string // Gateway chunks the node doesn't have are read from, empty for none
+	pinningURL   string // Pinning Service API endpoint, empty to only pin on the node
+	pinningToken string
+	name         string // Account name for identification
+	maxChunks    int    // Upload cap per run, 0 for no limit
+	maxBytes     int64  // Upload cap per run in bytes, 0 for no limit
+}
+
+func init() {
+	RegisterProvider(IPFS, createIPFSClients)
+}
+
+// createIPFSClients creates a client for each enabled IPFS account
+func createIPFSClients(cfg *config.Config) (map[string]CloudClient, error) {
+	clients := make(map[string]CloudClient)
+	for _, account := range cfg.GetEnabledIPFSAccounts() {
+		ipfs := CreateIPFSClient(account)
+		if err := ipfs.SetProxy(cfg.CloudConfig.Proxy); err != nil {
+			return nil, fmt.Errorf("failed to create IPFS client for account '%s': %w", account.Name, err)
+		}
+		clients[account.Name] = ipfs
+	}
+	return clients, nil
+}
+
+// CreateIPFSClient creates a new IPFS client from an account configuration
+func CreateIPFSClient(account config.IPFSAccount) *IPFSClient {
+	apiURL := account.APIURL
+	if apiURL == "" {
+		apiURL = DefaultIPFSAPI
+	}
+	return &IPFSClient{
+		httpClient:   &http.Client{Timeout: 10 * time.Minute},
+		apiURL:       strings.TrimRight(apiURL, "/"),
+		gatewayURL:   strings.TrimRight(account.GatewayURL, "/"),
+		pinningURL:   strings.TrimRight(account.PinningURL, "/"),
+		pinningToken: account.PinningToken,
+		name:         account.Name,
+		maxChunks:    account.MaxChunks,
+		maxBytes:     account.MaxBytes,
+	}
+}
+
+// CheckCredentials checks that every configured URL is an http(s) URL and
+// that the pinning service has a token
+func (ic *IPFSClient) CheckCredentials() error {
+	for _, u := range []string{ic.apiURL, ic.gatewayURL, ic.pinningURL} {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%w: url %q isn't an http(s) URL", ErrBadCredentials, u)
+		}
+	}
+	if ic.pinningURL != "" && ic.pinningToken == "" {
+		return fmt.Errorf("%w: pinning service %s has no pinning_token", ErrBadCredentials, ic.pinningURL)
+	}
+	return nil
+}
+
+// SetProxy sends all requests through proxy, or through the proxy from
+// HTTP_PROXY/HTTPS_PROXY when proxy is empty
+func (ic *IPFSClient) SetProxy(proxy string) error {
+	transport, err := newProxyTransport(proxy)
+	if err != nil {
+		return err
+	}
+	ic.httpClient.Transport = transport
+	return nil
+}
+
+// Limits returns the per-run upload caps from the account configuration
+func (ic *IPFSClient) Limits() (int, int64) {
+	return ic.maxChunks, ic.maxBytes
+}
+
+// Initialize checks that the node answers, and that the pinning service
+// accepts the token
+func (ic *IPFSClient) Initialize() error {
+	var version struct{ Version string }
+	if err := ic.call("version", nil, nil, &version); err != nil {
+		return fmt.Errorf("can't reach IPFS node %s: %w", ic.apiURL, err)
+	}
+	if ic.pinningURL != "" {
+		if _, err := ic.pins(url.Values{"limit": {"1"}}); err != nil {
+			return fmt.Errorf("can't reach pinning service %s: %w", ic.pinningURL, err)
+		}
+	}
+
+	fmt.Printf("Using IPFS node %s (Kubo %s) for account '%s'\n", ic.apiURL, version.Version, ic.name)
+	return nil
+}
+
+// UploadFile adds a file to the node, pinned there, and returns its CID
+func (ic *IPFSClient) UploadFile(localPath, cloudPath string) (string, error) {
+	cid, _, err := ic.UploadFilePinned(localPath, cloudPath)
+	return cid, err
+}
+
+// UploadFilePinned adds a file to the node like UploadFile and pins it on the
+// pinning service, returning the pin's status there. The status is usually
+// PinQueued, the service fetches the file from the node afterwards.
+func (ic *IPFSClient) UploadFilePinned(localPath, cloudPath string) (string, string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get file info: %w", err)
+	}
+
+	// Stream the file as multipart form data instead of buffering it
+	name := path.Base(filepath.ToSlash(cloudPath))
+	body, writer := io.Pipe()
+	defer body.Close()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	var added struct{ Hash string }
+	params := url.Values{"cid-version": {"1"}, "pin": {"true"}}
+	if err := ic.call("add", params, &multipartBody{body, form.FormDataContentType()}, &added); err != nil {
+		return "", "", fmt.Errorf("unable to upload file: %w", err)
+	}
+	if added.Hash == "" {
+		return "", "", fmt.Errorf("unable to upload file: node returned no CID")
+	}
+
+	status := ""
+	if ic.pinningURL != "" {
+		pin, err := ic.pin(added.Hash, name)
+		if err != nil {
+			return "", "", fmt.Errorf("added as %s but couldn't pin it remotely: %w", added.Hash, err)
+		}
+		if pin.Status == PinFailed {
+			return "", "", fmt.Errorf("added as %s but the pinning service failed to pin it", added.Hash)
+		}
+		status = pin.Status
+	}
+
+	fmt.Printf("Uploaded to IPFS account '%s': %s (Size: %d bytes)\n", ic.name, added.Hash, fileInfo.Size())
+	return added.Hash, status, nil
+}
+
+// DownloadFile downloads a file by its CID
+func (ic *IPFSClient) DownloadFile(fileID, localPath string) error {
+	body, err := ic.OpenFile(fileID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+
+	outFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to create local file: %w", err)
+	}
+	defer outFile.Close()
+
+	_, err = io.Copy(outFile, body)
+	if err != nil {
+		return fmt.Errorf("unable to copy file content: %w", err)
+	}
+
+	fmt.Printf("Downloaded from IPFS account '%s': %s\n", ic.name, localPath)
+	return nil
+}
+
+// OpenFile starts downloading a file by its CID. The node is only asked for
+// blocks it has itself; without them the file is read through the gateway,
+// or by the node from the network when there is no gateway. When the node
+// runs out of blocks partway through, reading carries on the same way.
+func (ic *IPFSClient) OpenFile(fileID string) (io.ReadCloser, error) {
+	body, err := ic.stream("cat", url.Values{"arg": {fileID}, "offline": {"true"}})
+	if err != nil {
+		return ic.openFallback(fileID, err)
+	}
+	return &fallbackReader{body: body, fallback: func(err error) (io.ReadCloser, error) {
+		return ic.openFallback(fileID, err)
+	}}, nil
+}
+
+// openFallback opens a file the node couldn't read from its own blocks,
+// through the gateway, or through the node from the network when there is no
+// gateway
+func (ic *IPFSClient) openFallback(fileID string, nodeErr error) (io.ReadCloser, error) {
+	if ic.gatewayURL == "" {
+		body, err := ic.stream("cat", url.Values{"arg": {fileID}})
+		if err != nil {
+			return nil, fmt.Errorf("unable to download file: %w", err)
+		}
+		return body, nil
+	}
+
+	resp, err := ic.httpClient.Get(ic.gatewayURL + "/ipfs/" + url.PathEscape(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to download file: node: %v; gateway: %w", nodeErr, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to download file: node: %v; gateway: %s", nodeErr, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fallbackReader reads a file from body and, when the node fails partway
+// through, from the body fallback opens instead, skipping what was read
+type fallbackReader struct {
+	body     io.ReadCloser
+	fallback func(error) (io.ReadCloser, error) // nil once used
+	read     int64
+}
+
+func (r *fallbackReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.read += int64(n)
+	if !errors.Is(err, errStreamFailed) || r.fallback == nil {
+		return n, err
+	}
+
+	next, err := r.fallback(err)
+	r.fallback = nil
+	if err != nil {
+		return n, err
+	}
+	r.body.Close()
+	r.body = next
+	if _, err := io.CopyN(io.Discard, next, r.read); err != nil {
+		return n, fmt.Errorf("unable to download file: skipping the %d bytes the node sent: %w", r.read, err)
+	}
+	return n, nil
+}
+
+func (r *fallbackReader) Close() error {
+	return r.body.Close()
+}
+
+// FindFileByName can't find anything: IPFS addresses files by their CID,
+// which manifests record
+func (ic *IPFSClient) FindFileByName(fileName string) (string, error) {
+	return "", fmt.Errorf("%w: %s (IPFS finds files by CID, not by name)", ErrFileNotFound, fileName)
+}
+
+// StatFile returns a file's size from the node. A file whose remote pin is
+// missing or failed is reported as not found, since the node may drop it.
+func (ic *IPFSClient) StatFile(fileID string) (RemoteFile, error) {
+	if ic.pinningURL != "" {
+		status, err := ic.PinStatus(fileID)
+		if err != nil {
+			return RemoteFile{}, fmt.Errorf("unable to get pin status: %w", err)
+		}
+		if status == "" || status == PinFailed {
+			return RemoteFile{}, fmt.Errorf("%w: %s isn't pinned on %s", ErrFileNotFound, fileID, ic.pinningURL)
+		}
+	}
+
+	var stat struct{ Size int64 }
+	if err := ic.call("files/stat", url.Values{"arg": {"/ipfs/" + fileID}}, nil, &stat); err != nil {
+		return RemoteFile{}, fmt.Errorf("unable to get file info: %w", err)
+	}
+	return RemoteFile{ID: fileID, Size: stat.Size}, nil
+}
+
+// PinStatus returns the status of a file's remote pin, the most advanced one
+// when it was pinned more than once, or empty when it isn't pinned remotely
+func (ic *IPFSClient) PinStatus(fileID string) (string, error) {
+	pins, err := ic.pins(url.Values{"cid": {fileID}})
+	if err != nil {
+		return "", err
+	}
+	rank := map[string]int{PinFailed: 1, PinQueued: 2, PinPinning: 3, PinPinned: 4}
+	status := ""
+	for _, p := range pins {
+		if rank[p.Status] > rank[status] {
+			status = p.Status
+		}
+	}
+	return status, nil
+}
+
+// PublicLink returns the file's URL on the gateway
+func (ic *IPFSClient) PublicLink(fileID string) (string, error) {
+	if ic.gatewayURL == "" {
+		return "", fmt.Errorf("ipfs account '%s' has no gateway_url to link to", ic.name)
+	}
+	return ic.gatewayURL + "/ipfs/" + url.PathEscape(fileID), nil
+}
+
+// DeleteFile unpins a file on the node and removes its remote pins. The node
+// frees the blocks at its next garbage collection, unless something else
+// pins them.
+func (ic *IPFSClient) DeleteFile(fileID string) error {
+	err := ic.call("pin/rm", url.Values{"arg": {fileID}}, nil, nil)
+	if err != nil && !strings.Contains(err.Error(), "not pinned") {
+		return fmt.Errorf("unable to delete file: %w", err)
+	}
+	if ic.pinningURL == "" {
+		return nil
+	}
+
+	pins, err := ic.pins(url.Values{"cid": {fileID}})
+	if err != nil {
+		return fmt.Errorf("unable to delete file: %w", err)
+	}
+	for _, p := range pins {
+		resp, err := ic.pinningRequest(http.MethodDelete, "/pins/"+url.PathEscape(p.RequestID), nil)
+		if err != nil {
+			return fmt.Errorf("unable to remove pin %s: %w", p.RequestID, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("unable to remove pin %s: %s", p.RequestID, resp.Status)
+		}
+	}
+	return nil
+}
+
+// multipartBody is a request body with its content type
+type multipartBody struct {
+	io.Reader
+	contentType string
+}
+
+// call sends a Kubo RPC API command and decodes its JSON response into out,
+// unless out is nil. Commands that stream several objects leave the last one.
+func (ic *IPFSClient) call(command string, params url.Values, body *multipartBody, out any) error {
+	resp, err := ic.send(command, params, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			return err
+		}
+		return streamError(resp)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		if err := dec.Decode(out); err == io.EOF {
+			return streamError(resp)
+		} else if err != nil {
+			return fmt.Errorf("invalid response from %s: %w", command, err)
+		}
+	}
+}
+
+// stream sends a Kubo RPC API command and returns its raw response body,
+// which fails at the end with errStreamFailed when the command did
+func (ic *IPFSClient) stream(command string, params url.Values) (io.ReadCloser, error) {
+	resp, err := ic.send(command, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &streamBody{resp}, nil
+}
+
+// streamBody is a response body that turns the X-Stream-Error trailer into
+// an error at its end, instead of a short read ending in io.EOF
+type streamBody struct {
+	resp *http.Response
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	if err == io.EOF {
+		if streamErr := streamError(b.resp); streamErr != nil {
+			return n, streamErr
+		}
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	return b.resp.Body.Close()
+}
+
+// streamError returns the error Kubo reported in the X-Stream-Error trailer
+// of a fully read response, nil when it finished the command. Kubo sends a
+// 200 status before streaming, so failures later on only show up there.
+func streamError(resp *http.Response) error {
+	if msg := resp.Trailer.Get("X-Stream-Error"); msg != "" {
+		return fmt.Errorf("%w: %s", errStreamFailed, msg)
+	}
+	return nil
+}
+
+// send posts a Kubo RPC API command, turning error responses into errors
+// carrying the node's message
+func (ic *IPFSClient) send(command string, params url.Values, body *multipartBody) (*http.Response, error) {
+	target := ic.apiURL + "/api/v0/" + command
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = body
+	}
+	req, err := http.NewRequest(http.MethodPost, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", body.contentType)
+	}
+
+	resp, err := ic.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	msg := resp.Status
+	var apiErr struct{ Message string }
+	if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Message != "" {
+		msg += ": " + apiErr.Message
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("ipfs node %w: %s", ErrAuthFailed, msg)
+	}
+	return nil, fmt.Errorf("%s", msg)
+}
+
+// pinStatus is a pin request on the pinning service
+type pinStatus struct {
+	RequestID string `json:"requestid"`
+	Status    string `json:"status"`
+}
+
+// pin asks the pinning service to pin a CID under name
+func (ic *IPFSClient) pin(cid, name string) (pinStatus, error) {
+	payload, err := json.Marshal(map[string]string{"cid": cid, "name": name})
+	if err != nil {
+		return pinStatus{}, err
+	}
+	resp, err := ic.pinningRequest(http.MethodPost, "/pins", bytes.NewReader(payload))
+	if err != nil {
+		return pinStatus{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return pinStatus{}, pinningError(resp)
+	}
+
+	var status pinStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return pinStatus{}, fmt.Errorf("invalid response from pinning service: %w", err)
+	}
+	return status, nil
+}
+
+// pins lists the pin requests on the pinning service matching query, in any
+// status
+func (ic *IPFSClient) pins(query url.Values) ([]pinStatus, error) {
+	query.Set("status", strings.Join([]string{PinQueued, PinPinning, PinPinned, PinFailed}, ","))
+	resp, err := ic.pinningRequest(http.MethodGet, "/pins?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, pinningError(resp)
+	}
+
+	var list struct {
+		Results []pinStatus `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid response from pinning service: %w", err)
+	}
+	return list.Results, nil
+}
+
+// pinningRequest sends an authenticated request to the pinning service
+func (ic *IPFSClient) pinningRequest(method, endpoint string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, ic.pinningURL+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+ic.pinningToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return ic.httpClient.Do(req)
+}
+
+// pinningError describes an error response of the pinning service
+func pinningError(resp *http.Response) error {
+	var apiErr struct {
+		Error struct {
+			Reason  string `json:"reason"`
+			Details string `json:"details"`
+		} `json:"error"`
+	}
+	msg := resp.Status
+	if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Reason != "" {
+		msg += ": " + apiErr.Error.Reason
+		if apiErr.Error.Details != "" {
+			msg += " (" + apiErr.Error.Details + ")"
+		}
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("pinning service %w: %s", ErrAuthFailed, msg)
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,42 @@
+package cloudstorage
+
+import (
+	"fmt"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// ChunkLinks returns links anyone can download the uploaded copies of c
+// from, in the configured provider order. Copies on providers that can't give
+// out such links, or fail to, are skipped as long as another copy has a link.
+func (cu *CloudUploader) ChunkLinks(c manifest.ChunkInfo) ([]string, error) {
+	if len(c.CloudPaths) == 0 {
+		return nil, fmt.Errorf("%w: chunk %s has no cloud paths", manifest.ErrChunkMissing, c.ID)
+	}
+
+	var links []string
+	var lastErr error
+	for _, i := range replicaOrder(c.Providers, len(c.CloudPaths), cu.config.CloudConfig.ProviderOrder) {
+		provider := CloudProvider(c.Providers[i])
+		client, fileID, err := cu.locateFile(provider, c.CloudIDs, replicaKey(c.Providers, i), c.CloudPaths[i])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		sharer, ok := client.(linkSharer)
+		if !ok {
+			lastErr = fmt.Errorf("%s can't give out public links", provider)
+			continue
+		}
+		link, err := sharer.PublicLink(fileID)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		links = append(links, link)
+	}
+	if len(links) == 0 {
+		return nil, fmt.Errorf("no public link for chunk %s: %w", c.ID, lastErr)
+	}
+	return links, nil
+}
@@ -0,0 +1,119 @@
+package cloudstorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// CloudCopy is one uploaded copy of a chunk
+type CloudCopy struct {
+	ChunkID   string
+	Provider  string
+	Account   string // Empty when the manifest doesn't record it
+	CloudPath string
+	FileID    string // Empty when the manifest doesn't record it
+}
+
+// key identifies the stored file, so two manifests pointing at the same copy agree
+func (c CloudCopy) key() string {
+	id := c.FileID
+	if id == "" {
+		id = c.CloudPath
+	}
+	return c.Provider + "/" + c.Account + "/" + id
+}
+
+// chunkCopies returns the uploaded copies of c
+func chunkCopies(c manifest.ChunkInfo) []CloudCopy {
+	n := min(len(c.Providers), len(c.CloudPaths))
+	copies := make([]CloudCopy, 0, n)
+	for i := 0; i < n; i++ {
+		key := replicaKey(c.Providers, i)
+		copies = append(copies, CloudCopy{
+			ChunkID:   c.ID,
+			Provider:  c.Providers[i],
+			Account:   c.CloudIDs[key+"_account"],
+			CloudPath: c.CloudPaths[i],
+			FileID:    c.CloudIDs[key],
+		})
+	}
+	return copies
+}
+
+// Orphans returns the cloud copies recorded in prev that m no longer points
+// at, such as those of chunks whose content changed since prev was split.
+// Copies m reuses, recorded by UploadChunksIncremental, aren't orphans.
+func Orphans(m, prev manifest.Manifest) []CloudCopy {
+	current := make(map[string]bool)
+	for _, c := range m.StoredChunks() {
+		for _, cp := range chunkCopies(c) {
+			current[cp.key()] = true
+		}
+	}
+
+	var orphans []CloudCopy
+	for _, c := range prev.StoredChunks() {
+		for _, cp := range chunkCopies(c) {
+			if !current[cp.key()] {
+				// Chunks repeated in prev share their copies
+				current[cp.key()] = true
+				orphans = append(orphans, cp)
+			}
+		}
+	}
+	return orphans
+}
+
+// ReadOrphans returns the Orphans of the previous manifest at
+// prevManifestPath left by the manifest at manifestPath
+func ReadOrphans(manifestPath, prevManifestPath string) ([]CloudCopy, error) {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	prev, err := manifest.ReadManifest(prevManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read previous manifest: %w", err)
+	}
+	return Orphans(m, prev), nil
+}
+
+// DeleteOrphans deletes the cloud copies of the previous manifest at
+// prevManifestPath that the manifest at manifestPath no longer points at, see
+// Orphans. Every copy is tried, and the error joins the failures of those that
+// couldn't be deleted. It returns the number of copies deleted.
+func (cu *CloudUploader) DeleteOrphans(manifestPath, prevManifestPath string) (int, error) {
+	orphans, err := ReadOrphans(manifestPath, prevManifestPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	for _, o := range orphans {
+		if err := cu.deleteCopy(o); err != nil {
+			fmt.Printf("⚠️  Failed to delete chunk %s from %s: %v\n", o.ChunkID, o.Provider, err)
+			errs = append(errs, fmt.Errorf("chunk %s on %s: %w", o.ChunkID, o.Provider, err))
+		}
+	}
+	deleted := len(orphans) - len(errs)
+	if len(errs) > 0 {
+		return deleted, fmt.Errorf("failed to delete %d of %d orphaned copies: %w", len(errs), len(orphans), errors.Join(errs...))
+	}
+	return deleted, nil
+}
+
+// deleteCopy deletes an uploaded copy from the account storing it
+func (cu *CloudUploader) deleteCopy(c CloudCopy) error {
+	provider := CloudProvider(c.Provider)
+	cloudIDs := map[string]string{"copy": c.FileID, "copy_account": c.Account}
+	if c.FileID == "" {
+		delete(cloudIDs, "copy")
+	}
+	client, fileID, err := cu.locateFile(provider, cloudIDs, "copy", c.CloudPath)
+	if err != nil {
+		return err
+	}
+	return client.DeleteFile(fileID)
+}
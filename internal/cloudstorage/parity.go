@@ -0,0 +1,146 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// uploadParity uploads the parity chunks of m from localChunksDir, spread
+// across providers like chunks that come after the last one, and records
+// where they were stored. A parity chunk that can't be uploaded only leaves
+// its group with less redundancy, so it is reported but isn't an error.
+func (cu *CloudUploader) uploadParity(m manifest.Manifest, localChunksDir string) {
+	failed := 0
+	for i, p := range m.Erasure.Parity {
+		cu.waitIfPaused()
+		localPath := m.ChunkPath(localChunksDir, p)
+
+		var cloudPaths, providers []string
+		cloudIDs := make(map[string]string)
+		destinations := cu.Strategy.GetChunkDestination(len(m.Chunks) + i)
+		for _, provider := range destinations {
+			cloudPath := GenerateCloudPathWithTemplates(provider, p.ID, m.Extension(), cu.config.CloudConfig.PathTemplates)
+			up, err := cu.uploadToProvider(provider, localPath, cloudPath, len(m.Chunks)+i, nil)
+			if err != nil {
+				fmt.Printf("⚠️  Failed to upload parity chunk %s to %s: %v\n", p.ID, provider, err)
+				continue
+			}
+
+			cloudPaths = append(cloudPaths, cloudPath)
+			providers = append(providers, string(provider))
+			if up.fileID != "" {
+				key := replicaKey(providers, len(providers)-1)
+				cloudIDs[key] = up.fileID
+				if up.account != "" {
+					cloudIDs[key+"_account"] = up.account
+				}
+				if up.md5 != "" {
+					cloudIDs[key+"_md5"] = up.md5
+				}
+				if up.pin != "" {
+					cloudIDs[key+"_pin"] = up.pin
+				}
+			}
+		}
+
+		m.Erasure.Parity[i].CloudPaths = cloudPaths
+		m.Erasure.Parity[i].Providers = providers
+		if len(cloudIDs) > 0 {
+			m.Erasure.Parity[i].CloudIDs = cloudIDs
+		}
+		switch {
+		case len(providers) == 0:
+			m.Erasure.Parity[i].Status = manifest.ChunkStatusFailed
+			failed++
+		case len(providers) < len(destinations):
+			m.Erasure.Parity[i].Status = manifest.ChunkStatusPartial
+			m.Erasure.Parity[i].UploadTime = time.Now().Format(time.RFC3339)
+		default:
+			m.Erasure.Parity[i].Status = manifest.ChunkStatusUploaded
+			m.Erasure.Parity[i].UploadTime = time.Now().Format(time.RFC3339)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("⚠️  %d of %d parity chunks couldn't be uploaded, their groups can lose fewer chunks\n", failed, len(m.Erasure.Parity))
+	}
+}
+
+// recoverFromParity downloads the parity chunks of the groups of m with
+// unrecoverable chunks into downloadDir and returns the chunks that still
+// can't be restored. Chunks whose group has at least DataShards of its
+// chunks and parity chunks left are dropped, assembly rebuilds them (see
+// chunker.RepairChunks). For every group that has too few, the second result
+// names the chunks and parity chunks it is missing.
+func (cu *CloudUploader) recoverFromParity(m manifest.Manifest, downloadDir string, unrecoverable []UnrecoverableChunk) ([]UnrecoverableChunk, []string) {
+	k := m.Erasure.DataShards
+	lost := make(map[int][]UnrecoverableChunk)
+	var order []int
+	for _, c := range unrecoverable {
+		g := c.Index / k
+		if _, ok := lost[g]; !ok {
+			order = append(order, g)
+		}
+		lost[g] = append(lost[g], c)
+	}
+
+	var remaining []UnrecoverableChunk
+	var groups []string
+	for _, g := range order {
+		var missingParity []string
+		for _, p := range m.Erasure.GroupParity(g) {
+			if err := cu.downloadStored(p, m.ChunkPath(downloadDir, p)); err != nil {
+				fmt.Printf("Failed to download parity chunk %s: %v\n", p.ID, err)
+				missingParity = append(missingParity, p.ID)
+			}
+		}
+
+		// Every chunk of the group not listed as lost was downloaded
+		have := k - len(lost[g]) + m.Erasure.ParityShards - len(missingParity)
+		if have >= k {
+			fmt.Printf("%d chunks of parity group %d will be rebuilt from parity\n", len(lost[g]), g)
+			continue
+		}
+
+		remaining = append(remaining, lost[g]...)
+		var chunks []string
+		for _, c := range lost[g] {
+			chunks = append(chunks, fmt.Sprintf("%d (%s)", c.Index, c.ID))
+		}
+		desc := fmt.Sprintf("group %d: %d of the %d needed shards left, missing chunks %s", g, have, k, strings.Join(chunks, ", "))
+		if len(missingParity) > 0 {
+			desc += " and parity " + strings.Join(missingParity, ", ")
+		}
+		groups = append(groups, desc)
+	}
+	return remaining, groups
+}
+
+// downloadStored downloads a stored chunk to localPath from the first of its
+// copies that is intact, in the configured provider order. A copy already at
+// localPath is kept.
+func (cu *CloudUploader) downloadStored(c manifest.ChunkInfo, localPath string) error {
+	if haveLocalChunk(localPath, c) {
+		return nil
+	}
+	var copyErrs []string
+	for _, i := range replicaOrder(c.Providers, len(c.CloudPaths), cu.config.CloudConfig.ProviderOrder) {
+		key := replicaKey(c.Providers, i)
+		err := cu.downloadFromProvider(CloudProvider(c.Providers[i]), c.CloudIDs, key, c.CloudPaths[i], localPath)
+		if err == nil && haveLocalChunk(localPath, c) {
+			return nil
+		}
+		if err == nil {
+			os.Remove(localPath)
+			err = fmt.Errorf("%w: downloaded copy doesn't match the manifest", manifest.ErrHashMismatch)
+		}
+		copyErrs = append(copyErrs, fmt.Sprintf("%s: %v", key, err))
+	}
+	if len(copyErrs) == 0 {
+		return fmt.Errorf("no cloud copies recorded")
+	}
+	return fmt.Errorf("%s", strings.Join(copyErrs, "; "))
+}
@@ -0,0 +1,32 @@
+package cloudstorage
+
+import "fmt"
+
+// TogglePause pauses an upload in progress, or resumes a paused one, and
+// reports whether it is now paused. A paused upload finishes the chunk it is
+// uploading and waits before starting the next. It is safe to call from
+// another goroutine, e.g. a signal handler.
+func (cu *CloudUploader) TogglePause() bool {
+	cu.pauseMu.Lock()
+	defer cu.pauseMu.Unlock()
+
+	if cu.resumed != nil {
+		close(cu.resumed)
+		cu.resumed = nil
+		fmt.Println("\n▶️  Upload resumed")
+		return false
+	}
+	cu.resumed = make(chan struct{})
+	fmt.Println("\n⏸️  Upload paused after the chunk in progress, signal again to resume")
+	return true
+}
+
+// waitIfPaused blocks while the upload is paused
+func (cu *CloudUploader) waitIfPaused() {
+	cu.pauseMu.Lock()
+	resumed := cu.resumed
+	cu.pauseMu.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
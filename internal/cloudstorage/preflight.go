@@ -0,0 +1,126 @@
+package cloudstorage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// ChunkCheck checks a chunk of m downloaded back from the cloud in its stored
+// form, e.g. by decrypting it and comparing it with its hash
+type ChunkCheck func(m manifest.Manifest, c manifest.ChunkInfo, stored []byte) error
+
+// errFirstChunk stops streaming a chunk list once the first chunk is found
+var errFirstChunk = errors.New("first chunk found")
+
+// Preflight tries the whole round trip of an upload with the first chunk of
+// the manifest at manifestPath before uploading the rest: on every account of
+// the strategy's providers, the chunk is uploaded under a temporary name,
+// downloaded back, compared with its stored hash, checked with check if set,
+// and deleted again. Every account is tried, and the error joins the
+// failures of all those that didn't pass.
+func (cu *CloudUploader) Preflight(localChunksDir, manifestPath string, check ChunkCheck) error {
+	m, err := manifest.ReadManifestHeader(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var chunk manifest.ChunkInfo
+	err = manifest.StreamChunks(manifestPath, func(c manifest.ChunkInfo) error {
+		chunk = c
+		return errFirstChunk
+	})
+	if err == nil {
+		return fmt.Errorf("manifest has no chunks to try an upload with")
+	}
+	if !errors.Is(err, errFirstChunk) {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	localPath := m.ChunkPath(localChunksDir, chunk)
+	expected := chunk.CipherHash
+	if expected == "" {
+		if expected, err = fileSHA256(localPath); err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "chunk-store-preflight-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A name of its own keeps the trial copy apart from the real upload
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("preflight-%x-%s", suffix, chunk.ID)
+
+	var errs []error
+	tried := 0
+	seen := make(map[CloudProvider]bool)
+	for _, provider := range cu.Strategy.Providers {
+		if seen[provider] {
+			continue
+		}
+		seen[provider] = true
+		cloudPath := GenerateCloudPathWithTemplates(provider, name, m.Extension(), cu.config.CloudConfig.PathTemplates)
+
+		for _, account := range sortedAccountNames(cu.clients[provider]) {
+			tried++
+			err := preflightAccount(cu.clients[provider][account], localPath, cloudPath, filepath.Join(tmpDir, name), expected, func(stored []byte) error {
+				if check == nil {
+					return nil
+				}
+				return check(m, chunk, stored)
+			})
+			if err != nil {
+				fmt.Printf("⚠️  Preflight failed on %s account '%s': %v\n", provider, account, err)
+				errs = append(errs, fmt.Errorf("%s account '%s': %w", provider, account, err))
+				continue
+			}
+			fmt.Printf("✓ Preflight passed on %s account '%s'\n", provider, account)
+		}
+	}
+
+	if tried == 0 {
+		return fmt.Errorf("%w: no accounts to upload to for %v", ErrProviderUnavailable, cu.Strategy.Providers)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("preflight failed on %d of %d accounts: %w", len(errs), tried, errors.Join(errs...))
+	}
+	return nil
+}
+
+// preflightAccount uploads localPath to cloudPath with client, downloads it
+// back to tmpPath, checks it and deletes the uploaded copy again
+func preflightAccount(client CloudClient, localPath, cloudPath, tmpPath, expected string, check func([]byte) error) (err error) {
+	fileID, err := client.UploadFile(localPath, cloudPath)
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	defer func() {
+		if deleteErr := client.DeleteFile(fileID); deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete: %w", deleteErr)
+		}
+	}()
+
+	if err := client.DownloadFile(fileID, tmpPath); err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	defer os.Remove(tmpPath)
+	stored, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	if fmt.Sprintf("%x", sha256.Sum256(stored)) != expected {
+		return fmt.Errorf("%w: the downloaded copy differs from what was uploaded", manifest.ErrHashMismatch)
+	}
+	return check(stored)
+}
@@ -0,0 +1,110 @@
+package cloudstorage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+	"github.com/schollz/progressbar/v3"
+)
+
+// VerifyCloudPresence checks that every recorded copy of each chunk exists in
+// the cloud with the stored chunk's size, and with the MD5 recorded under
+// "<provider>_md5" in CloudIDs when both the manifest and the provider have
+// one, without downloading any content. It is a quick check before removing
+// local chunks; VerifyUploads also proves the copies can be read back intact.
+func (cu *CloudUploader) VerifyCloudPresence(manifestPath string) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	chunks := m.StoredChunks()
+	bar := progressbar.NewOptions(len(chunks),
+		progressbar.OptionSetDescription("Checking cloud copies..."),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionShowCount(),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Println()
+		}),
+	)
+
+	var failed []string
+	missing, mismatched := 0, 0
+	checked := make(map[string]bool)
+	for _, chunk := range chunks {
+		bar.Add(1)
+
+		// Repeated chunks share one upload
+		if checked[chunk.ID] {
+			continue
+		}
+		checked[chunk.ID] = true
+
+		if len(chunk.Providers) == 0 {
+			failed = append(failed, fmt.Sprintf("chunk %s was not uploaded", chunk.ID))
+			missing++
+			continue
+		}
+
+		for i, cloudPath := range chunk.CloudPaths {
+			if i >= len(chunk.Providers) {
+				break
+			}
+			key := replicaKey(chunk.Providers, i)
+			err := cu.checkPresence(CloudProvider(chunk.Providers[i]), chunk, key, cloudPath)
+			switch {
+			case err == nil:
+				continue
+			case errors.Is(err, ErrFileNotFound):
+				missing++
+			case errors.Is(err, manifest.ErrHashMismatch):
+				mismatched++
+			}
+			failed = append(failed, fmt.Sprintf("chunk %s on %s: %v", chunk.ID, key, err))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	for _, f := range failed {
+		fmt.Printf("⚠️  %s\n", f)
+	}
+	switch {
+	case mismatched > 0:
+		return fmt.Errorf("%d chunk copies failed the presence check (%d missing, %d mismatched): %w", len(failed), missing, mismatched, manifest.ErrHashMismatch)
+	case missing > 0:
+		return fmt.Errorf("%d chunk copies failed the presence check (%d missing): %w", len(failed), missing, manifest.ErrChunkMissing)
+	default:
+		return fmt.Errorf("%d chunk copies couldn't be checked", len(failed))
+	}
+}
+
+// checkPresence checks one copy of a chunk, stored on provider under key, from
+// its metadata. Clients that can't describe a file only confirm it exists.
+func (cu *CloudUploader) checkPresence(provider CloudProvider, c manifest.ChunkInfo, key, cloudPath string) error {
+	client, fileID, err := cu.locateFile(provider, c.CloudIDs, key, cloudPath)
+	if err != nil {
+		return err
+	}
+
+	statter, ok := client.(fileStatter)
+	if !ok {
+		_, err := client.FindFileByName(filepath.Base(cloudPath))
+		return err
+	}
+	info, err := statter.StatFile(fileID)
+	if err != nil {
+		return err
+	}
+	if c.Size > 0 && info.Size != c.Size {
+		return fmt.Errorf("%w: stored copy is %d bytes, expected %d", manifest.ErrHashMismatch, info.Size, c.Size)
+	}
+	if want := c.CloudIDs[key+"_md5"]; want != "" && info.MD5 != "" && !strings.EqualFold(want, info.MD5) {
+		return fmt.Errorf("%w: stored copy has MD5 %s, expected %s", manifest.ErrHashMismatch, info.MD5, want)
+	}
+	return nil
+}
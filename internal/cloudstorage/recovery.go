@@ -0,0 +1,42 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// UnrecoverableChunk is a chunk none of whose copies could be restored
+type UnrecoverableChunk struct {
+	Index  int
+	ID     string
+	Errors []string // Why each copy failed, as "provider: error" ("provider#2" for a second copy on the same provider)
+}
+
+// RecoveryError lists every chunk a download couldn't recover from any of its
+// replicas, or from parity. It matches manifest.ErrChunkMissing with errors.Is.
+type RecoveryError struct {
+	Chunks []UnrecoverableChunk
+	Total  int      // Chunks in the manifest
+	Parity []string // Parity groups with too few shards left to rebuild their chunks, and which they are missing
+}
+
+func (e *RecoveryError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d chunks couldn't be recovered from any replica:", len(e.Chunks), e.Total)
+	for _, c := range e.Chunks {
+		fmt.Fprintf(&b, "\n  chunk %d (%s): %s", c.Index, c.ID, strings.Join(c.Errors, "; "))
+	}
+	if len(e.Parity) > 0 {
+		b.WriteString("\nand parity couldn't rebuild them:")
+		for _, g := range e.Parity {
+			fmt.Fprintf(&b, "\n  %s", g)
+		}
+	}
+	return b.String()
+}
+
+func (e *RecoveryError) Unwrap() error {
+	return manifest.ErrChunkMissing
+}
@@ -0,0 +1,139 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/probablysamir/chunk-store/internal/config"
+)
+
+// ClientFactory creates an uninitialized client for every enabled account of a
+// provider, keyed by account name
+type ClientFactory func(cfg *config.Config) (map[string]CloudClient, error)
+
+// registry maps each implemented provider to the factory for its clients.
+// Provider implementations register themselves from an init function.
+var registry = make(map[CloudProvider]ClientFactory)
+
+// RegisterProvider makes a provider available to the uploader
+func RegisterProvider(provider CloudProvider, factory ClientFactory) {
+	registry[provider] = factory
+}
+
+// IsImplemented reports whether a provider has a registered client
+func IsImplemented(provider CloudProvider) bool {
+	_, ok := registry[provider]
+	return ok
+}
+
+// CreateClients creates uninitialized clients for every enabled account of a provider
+func CreateClients(provider CloudProvider, cfg *config.Config) (map[string]CloudClient, error) {
+	factory, ok := registry[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s not implemented yet", ErrProviderUnavailable, provider)
+	}
+	return factory(cfg)
+}
+
+// KnownProviders lists every provider chunk-store knows about, implemented or planned
+func KnownProviders() []CloudProvider {
+	return []CloudProvider{GoogleDrive, WebDAV, Dropbox, OneDrive, MEGACloud, IPFS}
+}
+
+// DisplayName returns a human-friendly name for a provider
+func DisplayName(provider CloudProvider) string {
+	switch provider {
+	case GoogleDrive:
+		return "Google Drive"
+	case WebDAV:
+		return "WebDAV / Nextcloud"
+	case Dropbox:
+		return "Dropbox"
+	case OneDrive:
+		return "OneDrive"
+	case MEGACloud:
+		return "MEGA"
+	case IPFS:
+		return "IPFS"
+	default:
+		return string(provider)
+	}
+}
+
+// progressReporter is implemented by clients that can report in-file upload progress
+type progressReporter interface {
+	SetProgressFunc(fn ProgressFunc)
+}
+
+// accountLimiter is implemented by clients with per-run upload caps
+type accountLimiter interface {
+	Limits() (maxChunks int, maxBytes int64)
+}
+
+// credentialChecker is implemented by clients that can check their credentials
+// locally, before Initialize makes a network call or opens a browser
+type credentialChecker interface {
+	// CheckCredentials returns an error wrapping ErrBadCredentials if the
+	// account's credentials can't work as configured
+	CheckCredentials() error
+}
+
+// linkSharer is implemented by clients that can give a stored file a link
+// anyone can download it from
+type linkSharer interface {
+	// PublicLink makes the file identified by fileID downloadable without
+	// credentials, if it isn't already, and returns its download URL
+	PublicLink(fileID string) (string, error)
+}
+
+// folderReporter is implemented by clients that upload into a folder of
+// their own, so manifests can record where each account's chunks are
+type folderReporter interface {
+	// CloudFolder returns the folder's name or path, and its ID on providers
+	// that have them. The name is empty while there is no folder yet.
+	CloudFolder() (name, id string)
+}
+
+// RemoteFile describes a stored file without its content
+type RemoteFile struct {
+	ID   string
+	Size int64
+	MD5  string // Hex MD5 of the content, empty when the provider doesn't report one
+}
+
+// fileStatter is implemented by clients that can describe a stored file
+// without downloading it
+type fileStatter interface {
+	// StatFile returns the size and checksum of the file identified by
+	// fileID, or an error wrapping ErrFileNotFound if it doesn't exist
+	StatFile(fileID string) (RemoteFile, error)
+}
+
+// checksumUploader is implemented by clients whose provider computes an MD5
+// of each upload, which is checked against the local file
+type checksumUploader interface {
+	// UploadFileChecked uploads like UploadFile and also returns the MD5 the
+	// provider computed, empty when it reported none. A mismatch is an error
+	// wrapping manifest.ErrHashMismatch, and the bad copy is removed.
+	UploadFileChecked(localPath, cloudPath string) (fileID, md5 string, err error)
+}
+
+// pinningUploader is implemented by clients that pin each upload on a remote
+// service, whose status is recorded in the manifest
+type pinningUploader interface {
+	// UploadFilePinned uploads like UploadFile and also returns the status
+	// of the remote pin, empty when the account pins nothing remotely. An
+	// upload that couldn't be pinned is an error.
+	UploadFilePinned(localPath, cloudPath string) (fileID, pinStatus string, err error)
+}
+
+// sortedAccountNames returns the account names of a provider's clients in a
+// stable order, so round-robin selection doesn't depend on map iteration
+func sortedAccountNames(clients map[string]CloudClient) []string {
+	names := make([]string, 0, len(clients))
+	for name := range clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
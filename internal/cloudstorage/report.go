@@ -0,0 +1,96 @@
+package cloudstorage
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Report operations
+const (
+	ReportUpload   = "upload"
+	ReportDownload = "download"
+)
+
+// Chunk outcomes in a report
+const (
+	ReportUploaded   = "uploaded"   // Copy uploaded
+	ReportReused     = "reused"     // Copy of an earlier manifest reused by an incremental upload
+	ReportDownloaded = "downloaded" // Chunk downloaded from this copy
+	ReportPresent    = "present"    // Chunk already downloaded intact by an earlier run
+	ReportFailed     = "failed"     // Every attempt failed, see Error
+)
+
+// Report records what happened to every chunk during an upload or download,
+// for debugging flaky accounts. Unlike the manifest it isn't needed to
+// restore anything. It is safe for concurrent use.
+type Report struct {
+	Operation string        `json:"operation"` // ReportUpload or ReportDownload
+	Manifest  string        `json:"manifest"`
+	Started   string        `json:"started"`
+	Finished  string        `json:"finished"`
+	Chunks    []ChunkReport `json:"chunks"` // Sorted by Index
+
+	mu sync.Mutex
+}
+
+// ChunkReport is what happened to a chunk on one copy: one entry per
+// destination of an upload, one per chunk of a download
+type ChunkReport struct {
+	Index    int     `json:"index"`
+	ID       string  `json:"id"`
+	Provider string  `json:"provider,omitempty"`
+	Account  string  `json:"account,omitempty"`
+	Status   string  `json:"status"`        // One of the Report* outcomes
+	Retries  int     `json:"retries"`       // Attempts after the first, on other accounts for uploads and other copies for downloads
+	Time     string  `json:"time"`          // When the chunk finished
+	Seconds  float64 `json:"seconds"`       // How long it took, retries included
+	Bytes    int64   `json:"bytes"`         // Stored size
+	MD5      string  `json:"md5,omitempty"` // Checksum the provider computed for the copy, where it reports one
+	Error    string  `json:"error,omitempty"`
+}
+
+// NewReport starts a report of operation on the manifest at manifestPath
+func NewReport(operation, manifestPath string) *Report {
+	return &Report{
+		Operation: operation,
+		Manifest:  manifestPath,
+		Started:   time.Now().Format(time.RFC3339),
+		Chunks:    []ChunkReport{},
+	}
+}
+
+// add records c, finished now after taking since started. Nothing is
+// recorded on a nil report.
+func (r *Report) add(c ChunkReport, started time.Time) {
+	if r == nil {
+		return
+	}
+	c.Time = time.Now().Format(time.RFC3339)
+	c.Seconds = time.Since(started).Seconds()
+	r.mu.Lock()
+	r.Chunks = append(r.Chunks, c)
+	r.mu.Unlock()
+}
+
+// Save writes the report to path as JSON
+func (r *Report) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now().Format(time.RFC3339)
+	sort.SliceStable(r.Chunks, func(i, j int) bool {
+		return r.Chunks[i].Index < r.Chunks[j].Index
+	})
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// SetReport makes the uploader record every chunk it uploads or downloads in r
+func (cu *CloudUploader) SetReport(r *Report) {
+	cu.report = r
+}
@@ -1,64 +1,198 @@
 package cloudstorage
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/probablysamir/chunk-store/internal/config"
 	"github.com/probablysamir/chunk-store/internal/manifest"
 	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
 )
 
 // CloudUploader handles uploading chunks to cloud services
 type CloudUploader struct {
-	Strategy       CloudDistributionStrategy
-	googleDrives   map[string]*GoogleDriveClient // Map of account name to client
-	config         *config.Config
+	Strategy CloudDistributionStrategy
+	clients  map[CloudProvider]map[string]CloudClient // Provider -> account name -> client
+	config   *config.Config
+	bar      *progressbar.ProgressBar // Active upload bar, updated with in-file progress
+	accounts *accountBars             // Per-account progress lines, replacing bar when enabled
+	usage    map[string]*accountUsage // Uploads this run per "provider/account"
+	breaker  *circuitBreaker          // Takes failing accounts out of rotation
+	disabled map[string]error         // Accounts that failed to initialize, by "provider/account"
+	started  time.Time                // When the current upload started, for upload_deadline
+	deadline time.Duration            // How long the current upload may run, 0 for no limit
+	retries  int                      // Retries used by the current upload, for upload_retry_budget
+	report   *Report                  // Records every chunk uploaded or downloaded, nil when not asked for
+	pauseMu  sync.Mutex               // Guards resumed
+	resumed  chan struct{}            // Closed when a paused upload resumes, nil when not paused
+}
+
+// accountUsage counts what has been uploaded to one account during a run
+type accountUsage struct {
+	Chunks int
+	Bytes  int64
 }
 
 // CreateCloudUploader creates uploader with configuration
 func CreateCloudUploader(strategy CloudDistributionStrategy, cfg *config.Config) (*CloudUploader, error) {
 	uploader := &CloudUploader{
-		Strategy:     strategy,
-		googleDrives: make(map[string]*GoogleDriveClient),
-		config:       cfg,
-	}
-
-	// Set up Google Drive clients if needed
-	if cfg.HasGoogleDriveProvider() {
-		for _, account := range cfg.GetEnabledGoogleDriveAccounts() {
-			gdrive, err := CreateGoogleDriveClientWithName(
-				account.CredsFile,
-				account.TokenFile,
-				account.Name,
-				account.FolderName,
-			)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create Google Drive client for account '%s': %w", account.Name, err)
+		Strategy: strategy,
+		clients:  make(map[CloudProvider]map[string]CloudClient),
+		config:   cfg,
+		usage:    make(map[string]*accountUsage),
+		breaker:  newCircuitBreaker(cfg.CloudConfig.BreakerThreshold),
+	}
+
+	// Set up clients for every provider used by this run or the configuration
+	type account struct {
+		provider CloudProvider
+		name     string
+		client   CloudClient
+	}
+	var accounts []account
+	for _, provider := range append(strategy.Providers, cfg.CloudConfig.Providers...) {
+		factory, ok := registry[provider]
+		if !ok || uploader.clients[provider] != nil {
+			continue
+		}
+
+		clients, err := factory(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range sortedAccountNames(clients) {
+			client := clients[name]
+			if reporter, ok := client.(progressReporter); ok {
+				reporter.SetProgressFunc(uploader.reportUploadProgress)
 			}
+			accounts = append(accounts, account{provider, name, client})
+		}
 
-			err = gdrive.Initialize()
-			if err != nil {
-				return nil, fmt.Errorf("failed to initialize Google Drive for account '%s': %w", account.Name, err)
+		uploader.clients[provider] = clients
+	}
+
+	// Catch malformed credentials of every account before any of them goes to
+	// the network or asks for a browser sign-in
+	var bad []error
+	for _, a := range accounts {
+		if checker, ok := a.client.(credentialChecker); ok {
+			if err := checker.CheckCredentials(); err != nil {
+				bad = append(bad, fmt.Errorf("%s account '%s': %w", a.provider, a.name, err))
 			}
+		}
+	}
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("%d misconfigured account(s):\n%w", len(bad), errors.Join(bad...))
+	}
 
-			uploader.googleDrives[account.Name] = gdrive
+	// Each Initialize is a network round trip or two, so accounts start
+	// concurrently. An account that fails, e.g. because it can't reach its
+	// folder, is disabled for the run as long as another account works.
+	errs := make([]error, len(accounts))
+	var wg sync.WaitGroup
+	for i, a := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.client.Initialize(); err != nil {
+				errs[i] = fmt.Errorf("failed to initialize %s for account '%s': %w", a.provider, a.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(accounts) {
+		return nil, errors.Join(errs...)
+	}
+	for i, a := range accounts {
+		if errs[i] != nil {
+			err := errors.Unwrap(errs[i])
+			fmt.Printf("⚠️  Disabling %s account '%s' for this run: %v\n", a.provider, a.name, err)
+			if uploader.disabled == nil {
+				uploader.disabled = make(map[string]error)
+			}
+			uploader.disabled[string(a.provider)+"/"+a.name] = err
+			delete(uploader.clients[a.provider], a.name)
 		}
 	}
 
+	// Distinct placement needs to know how many accounts each provider has
+	uploader.Strategy.AccountCounts = make(map[CloudProvider]int)
+	for provider, clients := range uploader.clients {
+		uploader.Strategy.AccountCounts[provider] = len(clients)
+	}
+
 	return uploader, nil
 }
 
+// uploadCheckpointInterval is how many chunks are uploaded between manifest checkpoints
+const uploadCheckpointInterval = 10
+
 // UploadChunks uploads all chunks from local storage to cloud services
 func (cu *CloudUploader) UploadChunks(localChunksDir, manifestPath string) error {
+	return cu.uploadChunks(localChunksDir, manifestPath, nil)
+}
+
+// UploadChunksIncremental uploads the chunks of the manifest at manifestPath
+// like UploadChunks, except chunks already uploaded for the previous manifest
+// at prevManifestPath, matched by full hash, aren't uploaded again: their
+// cloud copies are recorded in the current manifest instead. Both manifests
+// must use the same hash algorithm and encryption key.
+func (cu *CloudUploader) UploadChunksIncremental(localChunksDir, manifestPath, prevManifestPath string) error {
+	prev, err := manifest.ReadManifest(prevManifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read previous manifest: %w", err)
+	}
+	return cu.uploadChunks(localChunksDir, manifestPath, &prev)
+}
+
+// uploadChunks implements UploadChunks, reusing the uploaded chunks of prev if set
+func (cu *CloudUploader) uploadChunks(localChunksDir, manifestPath string, prev *manifest.Manifest) error {
 	// Read the current manifest
 	m, err := manifest.ReadManifest(manifestPath)
 	if err != nil {
 		return fmt.Errorf("failed to read manifest: %w", err)
 	}
 
+	var previous map[string]manifest.ChunkInfo
+	if prev != nil {
+		if previous, err = uploadedChunks(m, *prev); err != nil {
+			return err
+		}
+	}
+
+	cu.started, cu.retries = time.Now(), 0
+	if cu.deadline, err = cu.config.CloudConfig.UploadDeadlineDuration(); err != nil {
+		return err
+	}
+
+	if len(cu.Strategy.Uploadable()) == 0 {
+		return fmt.Errorf("every provider of %v has weight 0, so no chunk can be uploaded", cu.Strategy.Providers)
+	}
+
+	// Refuse to start rather than put two replicas on the same account
+	distinct := cu.Strategy.Placement == PlacementDistinct
+	if domains := cu.Strategy.DistinctDomains(); distinct && domains < cu.Strategy.ReplicationCount {
+		return fmt.Errorf("placement \"distinct\" needs a separate account for each of the %d replicas, but %v have only %d accounts between them",
+			cu.Strategy.ReplicationCount, cu.Strategy.Providers, domains)
+	}
+
 	// Create progress bar for uploads
 	bar := progressbar.NewOptions(len(m.Chunks),
 		progressbar.OptionSetDescription("Uploading to cloud..."),
@@ -69,55 +203,138 @@ func (cu *CloudUploader) UploadChunks(localChunksDir, manifestPath string) error
 			fmt.Println("\nUpload done!")
 		}),
 	)
+	cu.bar = bar
+
+	// One progress line per account instead of the single bar, when enabled on a
+	// terminal. Client messages would break the redrawn lines, so they are
+	// silenced meanwhile; failures show up on the account's line instead.
+	restoreStdout := func() {}
+	if cu.config.CloudConfig.AccountProgress && term.IsTerminal(int(os.Stdout.Fd())) {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err == nil {
+			stdout := os.Stdout
+			bar = progressbar.DefaultSilent(int64(len(m.Chunks)))
+			cu.accounts = newAccountBars(stdout, cu.accountKeys(), len(m.Chunks))
+			os.Stdout = devNull
+			restoreStdout = func() {
+				os.Stdout = stdout
+				devNull.Close()
+				restoreStdout = func() {}
+			}
+		}
+	}
+	defer func() {
+		restoreStdout()
+		cu.bar, cu.accounts = nil, nil
+	}()
+
+	// Stop once the job's budget is used up, saving what was uploaded so far
+	stop := func(budgetErr error) error {
+		if err := manifest.Save(m, manifestPath); err != nil {
+			return fmt.Errorf("failed to checkpoint manifest: %w", err)
+		}
+		restoreStdout()
+		cu.printAccountUsage()
+		remaining := 0
+		for _, c := range m.Chunks {
+			if c.Status != manifest.ChunkStatusUploaded {
+				remaining++
+			}
+		}
+		return fmt.Errorf("%w; stopped after %s and %d retries with %d of %d chunks left to upload. The manifest records what was uploaded, copy it and split again with -since-manifest to upload only the rest",
+			budgetErr, time.Since(cu.started).Round(time.Second), cu.retries, remaining, len(m.Chunks))
+	}
 
 	// Upload each chunk to designated cloud services
+	reused := 0
 	for i, chunk := range m.Chunks {
+		if p, ok := previous[chunk.Hash]; ok && canReuseUpload(chunk, p) {
+			m.Chunks[i] = reuseUpload(chunk, p)
+			reused++
+			for _, cp := range chunkCopies(m.Chunks[i]) {
+				cu.report.add(ChunkReport{Index: chunk.Index, ID: chunk.ID, Provider: cp.Provider, Account: cp.Account, Status: ReportReused, Bytes: p.Size}, time.Now())
+			}
+			bar.Add(1)
+			if cu.accounts != nil {
+				cu.accounts.chunkDone()
+			}
+			continue
+		}
+		cu.waitIfPaused()
+		if err := cu.checkBudget(false); err != nil {
+			return stop(err)
+		}
+
 		destinations := cu.Strategy.GetChunkDestination(chunk.Index)
+		wanted := len(destinations)
+		m.Chunks[i].Status = manifest.ChunkStatusUploading
 
 		// Local chunk path
-		localPath := filepath.Join(localChunksDir, chunk.ID+".chunk")
+		localPath := m.ChunkPath(localChunksDir, chunk)
 
 		var cloudPaths []string
 		var providers []string
 		cloudIDs := make(map[string]string)
 
+		// Accounts holding a copy of this chunk, never reused with distinct placement
+		var used map[string]bool
+		if distinct {
+			used = make(map[string]bool)
+		}
+
+		var budgetErr error
 		for _, provider := range destinations {
-			cloudPath := GenerateCloudPath(provider, chunk.ID)
-
-			var fileID string
-			var err error
-			var accountName string
-
-			switch provider {
-			case GoogleDrive:
-				// Select Google Drive account based on chunk index
-				accountName, fileID, err = cu.uploadToGoogleDriveMultiAccount(localPath, cloudPath, chunk.Index)
-			case Dropbox:
-				err = fmt.Errorf("dropbox not implemented yet")
-			case OneDrive:
-				err = fmt.Errorf("oneDrive not implemented yet")
-			case MEGACloud:
-				err = fmt.Errorf("mEGA not implemented yet")
-			case IPFS:
-				err = fmt.Errorf("iPFS not implemented yet")
-			default:
-				err = fmt.Errorf("unsupported cloud provider: %s", provider)
-			}
+			cloudPath := GenerateCloudPathWithTemplates(provider, chunk.ID, m.Extension(), cu.config.CloudConfig.PathTemplates)
 
+			started := time.Now()
+			up, err := cu.uploadToProvider(provider, localPath, cloudPath, chunk.Index, used)
+			if errors.Is(err, ErrProviderUnavailable) {
+				// No account of this provider can take the chunk, so store this copy elsewhere
+				if alt, ok := cu.fallbackProvider(destinations, chunk.Size, used); ok {
+					fmt.Printf("Redistributing chunk %s from %s to %s\n", chunk.ID, provider, alt)
+					destinations = append(destinations, alt)
+					provider = alt
+					cloudPath = GenerateCloudPathWithTemplates(provider, chunk.ID, m.Extension(), cu.config.CloudConfig.PathTemplates)
+					retries := up.retries + 1
+					up, err = cu.uploadToProvider(provider, localPath, cloudPath, chunk.Index, used)
+					up.retries += retries
+				}
+			}
+			entry := ChunkReport{Index: chunk.Index, ID: chunk.ID, Provider: string(provider), Account: up.account, Status: ReportUploaded, Retries: up.retries, Bytes: chunk.Size, MD5: up.md5}
+			if err != nil {
+				entry.Status, entry.Error = ReportFailed, err.Error()
+			}
+			cu.report.add(entry, started)
+			if errors.Is(err, ErrBudgetExceeded) {
+				budgetErr = err
+				break
+			}
 			if err != nil {
 				fmt.Printf("⚠️  Failed to upload chunk %s to %s: %v\n", chunk.ID, provider, err)
 				continue
 			}
+			if used != nil {
+				used[string(provider)+"/"+up.account] = true
+			}
 
 			cloudPaths = append(cloudPaths, cloudPath)
 			providers = append(providers, string(provider))
 
-			// Store file ID if available
-			if fileID != "" {
-				cloudIDs[string(provider)] = fileID
-				// Also store account name for Google Drive
-				if accountName != "" {
-					cloudIDs[string(provider)+"_account"] = accountName
+			// Store file ID if available, keyed per copy when a provider holds several
+			if up.fileID != "" {
+				key := replicaKey(providers, len(providers)-1)
+				cloudIDs[key] = up.fileID
+				// Also store account name for multi-account providers
+				if up.account != "" {
+					cloudIDs[key+"_account"] = up.account
+				}
+				// And the provider's checksum, for checking the copy later
+				if up.md5 != "" {
+					cloudIDs[key+"_md5"] = up.md5
+				}
+				// And the status of its remote pin, on providers that pin
+				if up.pin != "" {
+					cloudIDs[key+"_pin"] = up.pin
 				}
 			}
 		}
@@ -125,56 +342,568 @@ func (cu *CloudUploader) UploadChunks(localChunksDir, manifestPath string) error
 		// Update chunk info with cloud details
 		m.Chunks[i].CloudPaths = cloudPaths
 		m.Chunks[i].Providers = providers
-		m.Chunks[i].UploadTime = time.Now().Format(time.RFC3339)
 		if len(cloudIDs) > 0 {
 			m.Chunks[i].CloudIDs = cloudIDs
 		}
 
+		switch {
+		case len(providers) == 0 && budgetErr != nil:
+			m.Chunks[i].Status = chunk.Status // Not tried on every destination, so it hasn't failed
+		case len(providers) == 0:
+			m.Chunks[i].Status = manifest.ChunkStatusFailed
+		case len(providers) < wanted:
+			m.Chunks[i].Status = manifest.ChunkStatusPartial
+			m.Chunks[i].UploadTime = time.Now().Format(time.RFC3339)
+		default:
+			m.Chunks[i].Status = manifest.ChunkStatusUploaded
+			m.Chunks[i].UploadTime = time.Now().Format(time.RFC3339)
+		}
+		if budgetErr != nil {
+			return stop(budgetErr)
+		}
+
+		// Stop when a chunk didn't get the required number of copies, saving
+		// what was uploaded so far
+		if minReplicas := min(cu.config.CloudConfig.MinReplicas, wanted); len(providers) < minReplicas {
+			if err := manifest.Save(m, manifestPath); err != nil {
+				return fmt.Errorf("failed to checkpoint manifest: %w", err)
+			}
+			cu.printAccountUsage()
+			return fmt.Errorf("%w: chunk %s was stored %d times, min_replicas is %d", ErrProviderUnavailable, chunk.ID, len(providers), minReplicas)
+		}
+
+		// Checkpoint progress so an interrupted upload records which chunks made it
+		if (i+1)%uploadCheckpointInterval == 0 {
+			if err := manifest.Save(m, manifestPath); err != nil {
+				return fmt.Errorf("failed to checkpoint manifest: %w", err)
+			}
+		}
+
 		// Update progress bar
 		bar.Add(1)
+		if cu.accounts != nil {
+			cu.accounts.chunkDone()
+		}
+	}
+	if cu.accounts != nil {
+		restoreStdout()
+		fmt.Println("Upload done!")
+	}
+	if prev != nil {
+		fmt.Printf("%d of %d chunks were already uploaded for the previous manifest, only %d uploaded\n", reused, len(m.Chunks), len(m.Chunks)-reused)
+	}
+
+	if m.Erasure != nil {
+		cu.uploadParity(m, localChunksDir)
 	}
 
 	// Update distribution mode and save manifest
-	return manifest.WriteManifestWithMode(m.Chunks, manifestPath, m.OriginalName, m.Encrypted, "cloud")
+	m.DistributionMode = "cloud"
+	m.CloudFolders = cu.cloudFolders(m.StoredChunks())
+	err = manifest.Save(m, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	// A sharded manifest's chunk lists are stored alongside the chunks
+	if len(m.Shards) > 0 {
+		err = cu.uploadManifestShards(m, manifestPath)
+		if err != nil {
+			return fmt.Errorf("failed to upload manifest shards: %w", err)
+		}
+	}
+
+	cu.printAccountUsage()
+	return nil
+}
+
+// uploadedChunks checks that the chunks of prev can stand in for those of m
+// and returns prev's uploaded chunks by hash
+func uploadedChunks(m, prev manifest.Manifest) (map[string]manifest.ChunkInfo, error) {
+	algo, prevAlgo := m.HashAlgo, prev.HashAlgo
+	if algo == "" {
+		algo = manifest.HashSHA256
+	}
+	if prevAlgo == "" {
+		prevAlgo = manifest.HashSHA256
+	}
+	if algo != prevAlgo {
+		return nil, fmt.Errorf("previous manifest uses hash algorithm %s, this one %s", prevAlgo, algo)
+	}
+	if m.Encrypted != prev.Encrypted {
+		return nil, fmt.Errorf("previous manifest has encrypted=%t, this one %t", prev.Encrypted, m.Encrypted)
+	}
+	// Chunks are encrypted with the file key, or the password itself when there is none
+	if m.WrappedKey != prev.WrappedKey {
+		return nil, fmt.Errorf("previous manifest encrypts its chunks with a different file key, split with the previous manifest's key to upload incrementally")
+	}
+	if m.Encrypted && m.AAD != prev.AAD {
+		return nil, fmt.Errorf("previous manifest binds its chunks to %q, this one to %q", prev.AAD, m.AAD)
+	}
+	// A nonce size of 0 is the default of 12 bytes
+	if m.Encrypted && m.NonceSize != prev.NonceSize {
+		return nil, fmt.Errorf("previous manifest encrypts its chunks with %d byte nonces, this one with %d", max(prev.NonceSize, 12), max(m.NonceSize, 12))
+	}
+
+	uploaded := make(map[string]manifest.ChunkInfo)
+	for _, c := range prev.Chunks {
+		if len(c.Providers) > 0 {
+			uploaded[c.Hash] = c
+		}
+	}
+	return uploaded, nil
 }
 
-// uploadToGoogleDriveMultiAccount uploads to one of the available Google Drive accounts using round-robin
-func (cu *CloudUploader) uploadToGoogleDriveMultiAccount(localPath, cloudPath string, chunkIndex int) (string, string, error) {
-	if len(cu.googleDrives) == 0 {
-		return "", "", fmt.Errorf("no Google Drive clients initialized - check credentials")
+// canReuseUpload reports whether the uploaded copies of prev can be used for c.
+// A chunk whose nonce is kept in the manifest only decrypts with its own
+// nonce, which differs from the local file's, so those are uploaded again.
+func canReuseUpload(c, prev manifest.ChunkInfo) bool {
+	return c.Nonce == "" && prev.Nonce == "" && c.Compression == prev.Compression
+}
+
+// reuseUpload returns c pointing at the cloud copies of prev, describing
+// prev's stored form since that is what the cloud holds
+func reuseUpload(c, prev manifest.ChunkInfo) manifest.ChunkInfo {
+	c.Size = prev.Size
+	c.CipherHash = prev.CipherHash
+	c.CompressDecision = prev.CompressDecision
+	c.CompressRatio = prev.CompressRatio
+	c.CloudPaths = prev.CloudPaths
+	c.Providers = prev.Providers
+	c.CloudIDs = prev.CloudIDs
+	c.Status = prev.Status
+	c.UploadTime = prev.UploadTime
+	return c
+}
+
+// reportUploadProgress shows progress within the file currently being uploaded
+func (cu *CloudUploader) reportUploadProgress(fileName string, current, total int64) {
+	if cu.accounts != nil {
+		cu.accounts.progress(current, total)
+		return
+	}
+	if cu.bar == nil || total <= 0 {
+		return
 	}
+	cu.bar.Describe(fmt.Sprintf("Uploading to cloud... %s %d%%", fileName, current*100/total))
+}
+
+// upload is where uploadToProvider stored a file
+type upload struct {
+	account string
+	fileID  string
+	md5     string // MD5 the provider computed for the upload, if it checks uploads that way
+	pin     string // Status of the upload's remote pin, on providers that pin uploads
+	retries int    // Attempts after the first, also counted when the upload failed
+}
 
-	// Get list of account names for round-robin selection
-	var accountNames []string
-	for name := range cu.googleDrives {
-		accountNames = append(accountNames, name)
+// uploadToProvider uploads a local file to one of the provider's accounts,
+// chosen round-robin by index and skipping "provider/account" keys in exclude
+func (cu *CloudUploader) uploadToProvider(provider CloudProvider, localPath, cloudPath string, index int, exclude map[string]bool) (upload, error) {
+	var up upload
+	if !IsImplemented(provider) {
+		return up, fmt.Errorf("%w: %s not implemented yet", ErrProviderUnavailable, provider)
 	}
 
-	// Select account based on chunk index (round-robin)
-	selectedAccount := accountNames[chunkIndex%len(accountNames)]
-	client := cu.googleDrives[selectedAccount]
+	clients := cu.clients[provider]
+	if len(clients) == 0 {
+		return up, fmt.Errorf("%w: no %s clients initialized - check credentials and configuration", ErrProviderUnavailable, provider)
+	}
 
-	// Upload to the selected account
-	fileID, err := client.UploadFile(localPath, cloudPath)
+	info, err := os.Stat(localPath)
 	if err != nil {
-		return "", "", fmt.Errorf("google Drive upload failed to account '%s': %w", selectedAccount, err)
+		return up, err
 	}
 
-	return selectedAccount, fileID, nil
+	// Select accounts round-robin by index, skipping accounts that reached their
+	// cap or were tripped by the circuit breaker; a failed upload is retried on
+	// the next account
+	accountNames := sortedAccountNames(clients)
+	attempts := 1 + cu.config.CloudConfig.UploadRetries
+	next := index
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err := cu.checkBudget(attempt > 0); err != nil {
+			if lastErr != nil {
+				return up, fmt.Errorf("%w (last error: %v)", err, lastErr)
+			}
+			return up, err
+		}
+		pos, selectedAccount := cu.selectAccount(provider, clients, accountNames, next, info.Size(), exclude)
+		if selectedAccount == "" {
+			break
+		}
+		next = pos + 1
+		up.retries = attempt
+
+		key := string(provider) + "/" + selectedAccount
+		if cu.accounts != nil {
+			cu.accounts.start(key, filepath.Base(localPath))
+		}
+		var fileID, md5, pin string
+		switch client := clients[selectedAccount].(type) {
+		case checksumUploader:
+			fileID, md5, err = client.UploadFileChecked(localPath, cloudPath)
+		case pinningUploader:
+			fileID, pin, err = client.UploadFilePinned(localPath, cloudPath)
+		default:
+			fileID, err = client.UploadFile(localPath, cloudPath)
+		}
+		if cu.accounts != nil {
+			cu.accounts.finish(key, info.Size(), err)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s upload failed to account '%s': %w", provider, selectedAccount, err)
+			if cu.breaker.failure(key, err) {
+				fmt.Printf("⚠️  %s failed %d times in a row, skipping it for the rest of the run\n", key, cu.breaker.threshold)
+			}
+			continue
+		}
+		cu.breaker.success(key)
+
+		usage := cu.accountUsage(provider, selectedAccount)
+		usage.Chunks++
+		usage.Bytes += info.Size()
+
+		up.account, up.fileID, up.md5, up.pin = selectedAccount, fileID, md5, pin
+		return up, nil
+	}
+
+	if lastErr != nil {
+		return up, lastErr
+	}
+	return up, fmt.Errorf("%w: all %s accounts have reached their max_chunks/max_bytes limit or were skipped after repeated failures", ErrProviderUnavailable, provider)
+}
+
+// checkBudget returns an error wrapping ErrBudgetExceeded once the upload has
+// run past upload_deadline, or for a retry once upload_retry_budget is used up.
+// Otherwise a retry is counted against the budget.
+func (cu *CloudUploader) checkBudget(retry bool) error {
+	if cu.deadline > 0 && time.Since(cu.started) > cu.deadline {
+		return fmt.Errorf("%w: upload_deadline of %s reached", ErrBudgetExceeded, cu.deadline)
+	}
+	if !retry {
+		return nil
+	}
+	if budget := cu.config.CloudConfig.UploadRetryBudget; budget > 0 && cu.retries >= budget {
+		return fmt.Errorf("%w: all %d retries of upload_retry_budget used", ErrBudgetExceeded, budget)
+	}
+	cu.retries++
+	return nil
+}
+
+// selectAccount returns the first account at or after position start (wrapping
+// around) that is under its caps, not tripped and not in exclude, with its
+// position, or "" if none is
+func (cu *CloudUploader) selectAccount(provider CloudProvider, clients map[string]CloudClient, names []string, start int, size int64, exclude map[string]bool) (int, string) {
+	for i := range names {
+		pos := start + i
+		name := names[pos%len(names)]
+		key := string(provider) + "/" + name
+		if !exclude[key] && cu.breaker.allow(key) && cu.hasCapacity(provider, name, clients[name], size) {
+			return pos, name
+		}
+	}
+	return 0, ""
 }
 
-func (cu *CloudUploader) uploadToGoogleDriveWithID(localPath, cloudPath string) (string, error) {
-	// Legacy method for backward compatibility
-	accountName, fileID, err := cu.uploadToGoogleDriveMultiAccount(localPath, cloudPath, 0)
+// cloudFolders returns the folder of every account holding a copy of chunks,
+// sorted by provider and account
+func (cu *CloudUploader) cloudFolders(chunks []manifest.ChunkInfo) []manifest.CloudFolder {
+	seen := make(map[string]bool)
+	var folders []manifest.CloudFolder
+	for _, c := range chunks {
+		for _, cp := range chunkCopies(c) {
+			key := cp.Provider + "/" + cp.Account
+			if cp.Account == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			reporter, ok := cu.clients[CloudProvider(cp.Provider)][cp.Account].(folderReporter)
+			if !ok {
+				continue
+			}
+			if name, id := reporter.CloudFolder(); name != "" {
+				folders = append(folders, manifest.CloudFolder{
+					Provider: cp.Provider,
+					Account:  cp.Account,
+					Folder:   name,
+					ID:       id,
+				})
+			}
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Provider != folders[j].Provider {
+			return folders[i].Provider < folders[j].Provider
+		}
+		return folders[i].Account < folders[j].Account
+	})
+	return folders
+}
+
+// fallbackProvider picks a provider of the strategy that isn't a destination
+// of the chunk and still has accounts to take it, for redistributing a copy
+// whose provider has none left. With distinct placement (used set) any
+// provider with an account not holding the chunk yet qualifies.
+func (cu *CloudUploader) fallbackProvider(destinations []CloudProvider, size int64, used map[string]bool) (CloudProvider, bool) {
+	for _, provider := range cu.Strategy.Providers {
+		if (used == nil && slices.Contains(destinations, provider)) || !IsImplemented(provider) || cu.Strategy.Weight(provider) == 0 {
+			continue
+		}
+		clients := cu.clients[provider]
+		names := sortedAccountNames(clients)
+		if _, name := cu.selectAccount(provider, clients, names, 0, size, used); name != "" {
+			return provider, true
+		}
+	}
+	return "", false
+}
+
+// accountKeys returns "provider/account" for every account of the strategy's providers
+func (cu *CloudUploader) accountKeys() []string {
+	var keys []string
+	seen := make(map[CloudProvider]bool)
+	for _, provider := range cu.Strategy.Providers {
+		if seen[provider] {
+			continue
+		}
+		seen[provider] = true
+		for _, name := range sortedAccountNames(cu.clients[provider]) {
+			keys = append(keys, string(provider)+"/"+name)
+		}
+	}
+	return keys
+}
+
+// accountUsage returns the upload counters of an account for this run
+func (cu *CloudUploader) accountUsage(provider CloudProvider, account string) *accountUsage {
+	key := string(provider) + "/" + account
+	if cu.usage[key] == nil {
+		cu.usage[key] = &accountUsage{}
+	}
+	return cu.usage[key]
+}
+
+// hasCapacity reports whether another file of size bytes fits under an account's upload caps
+func (cu *CloudUploader) hasCapacity(provider CloudProvider, account string, client CloudClient, size int64) bool {
+	limiter, ok := client.(accountLimiter)
+	if !ok {
+		return true
+	}
+	maxChunks, maxBytes := limiter.Limits()
+	usage := cu.accountUsage(provider, account)
+	if maxChunks > 0 && usage.Chunks >= maxChunks {
+		return false
+	}
+	if maxBytes > 0 && usage.Bytes+size > maxBytes {
+		return false
+	}
+	return true
+}
+
+// printAccountUsage prints how many chunks and bytes went to each account this run
+func (cu *CloudUploader) printAccountUsage() {
+	keys := make([]string, 0, len(cu.usage))
+	for key := range cu.usage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println("Uploads per account:")
+	for _, key := range keys {
+		fmt.Printf("  %s: %d files, %.1f MB\n", key, cu.usage[key].Chunks, float64(cu.usage[key].Bytes)/(1024*1024))
+	}
+
+	if tripped := cu.breaker.trippedAccounts(); len(tripped) > 0 {
+		fmt.Println("Accounts skipped after repeated failures:")
+		for _, t := range tripped {
+			fmt.Printf("  %s: %d consecutive failures, last: %s\n", t.Key, t.Failures, t.LastErr)
+		}
+	}
+
+	if len(cu.disabled) > 0 {
+		keys := make([]string, 0, len(cu.disabled))
+		for key := range cu.disabled {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Println("Accounts disabled because they failed to initialize:")
+		for _, key := range keys {
+			fmt.Printf("  %s: %v\n", key, cu.disabled[key])
+		}
+	}
+}
+
+// uploadManifestShards uploads each shard file of a sharded manifest, spreading
+// them across providers like chunks, and records where they were stored
+func (cu *CloudUploader) uploadManifestShards(m manifest.Manifest, manifestPath string) error {
+	for i, shard := range m.Shards {
+		var providers []string
+		cloudIDs := make(map[string]string)
+
+		for _, provider := range cu.Strategy.GetChunkDestination(i) {
+			up, err := cu.uploadToProvider(provider, manifest.ShardPath(manifestPath, shard), shard.File, i, nil)
+			if err != nil {
+				fmt.Printf("⚠️  Failed to upload manifest shard %s to %s: %v\n", shard.File, provider, err)
+				continue
+			}
+
+			providers = append(providers, string(provider))
+			if up.fileID != "" {
+				key := replicaKey(providers, len(providers)-1)
+				cloudIDs[key] = up.fileID
+				if up.account != "" {
+					cloudIDs[key+"_account"] = up.account
+				}
+				if up.md5 != "" {
+					cloudIDs[key+"_md5"] = up.md5
+				}
+				if up.pin != "" {
+					cloudIDs[key+"_pin"] = up.pin
+				}
+			}
+		}
+
+		if len(providers) == 0 {
+			return fmt.Errorf("shard %s could not be uploaded to any provider", shard.File)
+		}
+
+		m.Shards[i].Providers = providers
+		m.Shards[i].CloudIDs = cloudIDs
+	}
+
+	// Rewriting keeps the shard files as they are and records the new cloud locations
+	return manifest.Save(m, manifestPath)
+}
+
+// VerifyUploads downloads every uploaded copy of each chunk and checks it
+// against the stored chunk's SHA-256, so local chunks are only removed once
+// the cloud copies are known to be retrievable. Chunks without a recorded
+// stored-file hash are compared against the local chunk file.
+func (cu *CloudUploader) VerifyUploads(localChunksDir, manifestPath string) error {
+	m, err := manifest.ReadManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "chunk-store-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	chunks := m.StoredChunks()
+	bar := progressbar.NewOptions(len(chunks),
+		progressbar.OptionSetDescription("Verifying uploads..."),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Println("\nVerification done!")
+		}),
+	)
+
+	var failed []string
+	mismatched := 0
+	verified := make(map[string]bool)
+	for _, chunk := range chunks {
+		bar.Add(1)
+
+		// Repeated chunks share one upload
+		if verified[chunk.ID] {
+			continue
+		}
+		verified[chunk.ID] = true
+
+		if len(chunk.Providers) == 0 {
+			failed = append(failed, fmt.Sprintf("chunk %s was not uploaded", chunk.ID))
+			continue
+		}
+
+		expected := chunk.CipherHash
+		if expected == "" {
+			expected, err = fileSHA256(m.ChunkPath(localChunksDir, chunk))
+			if err != nil {
+				return fmt.Errorf("no stored hash for chunk %s and the local file can't be read: %w", chunk.ID, err)
+			}
+		}
+
+		for i, cloudPath := range chunk.CloudPaths {
+			if i >= len(chunk.Providers) {
+				break
+			}
+			provider := CloudProvider(chunk.Providers[i])
+
+			tmpPath := filepath.Join(tmpDir, chunk.ID+m.Extension())
+			err := cu.downloadFromProvider(provider, chunk.CloudIDs, replicaKey(chunk.Providers, i), cloudPath, tmpPath)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("chunk %s on %s: %v", chunk.ID, provider, err))
+				continue
+			}
+
+			hash, err := fileSHA256(tmpPath)
+			os.Remove(tmpPath)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("chunk %s on %s: %v", chunk.ID, provider, err))
+			} else if hash != expected {
+				failed = append(failed, fmt.Sprintf("chunk %s on %s: hash mismatch", chunk.ID, provider))
+				mismatched++
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		for _, f := range failed {
+			fmt.Printf("⚠️  %s\n", f)
+		}
+		if mismatched > 0 {
+			return fmt.Errorf("%d chunk copies failed verification: %w", len(failed), manifest.ErrHashMismatch)
+		}
+		return fmt.Errorf("%d chunk copies failed verification", len(failed))
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's contents
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
-	_ = accountName // Ignore account name in legacy method
-	return fileID, nil
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// DownloadOptions tunes how chunks are downloaded
+type DownloadOptions struct {
+	Force bool // Download every chunk, even ones already present and intact in the download directory
 }
 
 // DownloadChunks downloads chunks from cloud services for assembly
 func (cu *CloudUploader) DownloadChunks(manifestPath, downloadDir string) error {
+	return cu.DownloadChunksWithOptions(manifestPath, downloadDir, DownloadOptions{})
+}
+
+// DownloadChunksWithOptions downloads chunks from cloud services for assembly.
+// Each chunk's copies are tried in the configured provider order.
+// Chunks left in downloadDir by an earlier, interrupted run are kept if they
+// match the manifest, so only the missing ones are fetched again. When no
+// replica of a chunk can be downloaded and the manifest has parity, its
+// group's parity chunks are downloaded instead for assembly to rebuild it.
+func (cu *CloudUploader) DownloadChunksWithOptions(manifestPath, downloadDir string, opts DownloadOptions) error {
+	// Fetch any manifest shards that aren't available locally first
+	err := cu.DownloadManifestShards(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to download manifest shards: %w", err)
+	}
+
 	m, err := manifest.ReadManifest(manifestPath)
 	if err != nil {
 		return fmt.Errorf("failed to read manifest: %w", err)
@@ -194,92 +923,223 @@ func (cu *CloudUploader) DownloadChunks(manifestPath, downloadDir string) error
 	// Create download directory
 	os.MkdirAll(downloadDir, 0755)
 
+	// Every chunk is attempted, so a failure reports all unrecoverable chunks at once
+	skipped := 0
+	var unrecoverable []UnrecoverableChunk
 	for _, chunk := range m.Chunks {
-		if len(chunk.CloudPaths) == 0 {
-			return fmt.Errorf("chunk %s has no cloud paths", chunk.ID)
+		started := time.Now()
+		entry := ChunkReport{Index: chunk.Index, ID: chunk.ID, Status: ReportDownloaded, Bytes: chunk.Size}
+		localPath := m.ChunkPath(downloadDir, chunk)
+		if !opts.Force && haveLocalChunk(localPath, chunk) {
+			skipped++
+			entry.Status = ReportPresent
+			cu.report.add(entry, started)
+			bar.Add(1)
+			continue
 		}
 
-		// Try to download from the first available provider
-		for i, cloudPath := range chunk.CloudPaths {
-			if i >= len(chunk.Providers) {
-				break
-			}
+		if len(chunk.CloudPaths) == 0 {
+			unrecoverable = append(unrecoverable, UnrecoverableChunk{Index: chunk.Index, ID: chunk.ID, Errors: []string{"no cloud copies recorded"}})
+			entry.Status, entry.Error = ReportFailed, "no cloud copies recorded"
+			cu.report.add(entry, started)
+			continue
+		}
 
+		// Try each replica in turn until one downloads intact
+		var copyErrs []string
+		recovered := false
+		for _, i := range replicaOrder(chunk.Providers, len(chunk.CloudPaths), cu.config.CloudConfig.ProviderOrder) {
+			cloudPath := chunk.CloudPaths[i]
 			provider := CloudProvider(chunk.Providers[i])
-			localPath := filepath.Join(downloadDir, chunk.ID+".chunk")
-
-			var err error
-			switch provider {
-			case GoogleDrive:
-				if len(cu.googleDrives) > 0 {
-					// Try to get the specific account used for this chunk
-					var targetClient *GoogleDriveClient
-					if chunk.CloudIDs != nil {
-						if accountName, exists := chunk.CloudIDs[string(provider)+"_account"]; exists {
-							if client, found := cu.googleDrives[accountName]; found {
-								targetClient = client
-							}
-						}
-					}
-
-					// If no specific account found, use the first available
-					if targetClient == nil {
-						for _, client := range cu.googleDrives {
-							targetClient = client
-							break
-						}
-					}
-
-					// Use real Google Drive download with file ID
-					if chunk.CloudIDs != nil {
-						if fileID, exists := chunk.CloudIDs[string(provider)]; exists {
-							err = targetClient.DownloadFile(fileID, localPath)
-						} else {
-							// Fallback: try to find file by name
-							fileName := filepath.Base(cloudPath)
-							fileID, findErr := targetClient.FindFileByName(fileName)
-							if findErr == nil {
-								err = targetClient.DownloadFile(fileID, localPath)
-							} else {
-								err = findErr
-							}
-						}
-					} else {
-						// Fallback: try to find file by name
-						fileName := filepath.Base(cloudPath)
-						fileID, findErr := targetClient.FindFileByName(fileName)
-						if findErr == nil {
-							err = targetClient.DownloadFile(fileID, localPath)
-						} else {
-							err = findErr
-						}
-					}
-				} else {
-					err = fmt.Errorf("no Google Drive clients initialized")
-				}
-			case Dropbox:
-				err = fmt.Errorf("dropbox not implemented yet")
-			case OneDrive:
-				err = fmt.Errorf("oneDrive not implemented yet")
-			case MEGACloud:
-				err = fmt.Errorf("mega not implemented yet")
-			case IPFS:
-				err = fmt.Errorf("ipfs not implemented yet")
-			default:
-				err = fmt.Errorf("unsupported cloud provider: %s", provider)
+			key := replicaKey(chunk.Providers, i)
+			entry.Provider, entry.Account, entry.MD5 = string(provider), chunk.CloudIDs[key+"_account"], chunk.CloudIDs[key+"_md5"]
+			err := cu.downloadFromProvider(provider, chunk.CloudIDs, key, cloudPath, localPath)
+			if err == nil && !haveLocalChunk(localPath, chunk) {
+				os.Remove(localPath)
+				err = fmt.Errorf("%w: downloaded copy doesn't match the manifest", manifest.ErrHashMismatch)
 			}
-
 			if err != nil {
 				fmt.Printf("Failed to download chunk %s from %s: %v\n", chunk.ID, provider, err)
+				copyErrs = append(copyErrs, fmt.Sprintf("%s: %v", key, err))
 				continue
 			}
 
+			recovered = true
 			break // Successfully downloaded, move to next chunk
 		}
+		if !recovered {
+			unrecoverable = append(unrecoverable, UnrecoverableChunk{Index: chunk.Index, ID: chunk.ID, Errors: copyErrs})
+			entry.Status, entry.Retries, entry.Error = ReportFailed, max(len(copyErrs)-1, 0), strings.Join(copyErrs, "; ")
+			cu.report.add(entry, started)
+			continue
+		}
+		entry.Retries = len(copyErrs)
+		cu.report.add(entry, started)
 
 		// Update progress bar
 		bar.Add(1)
 	}
 
+	if skipped > 0 {
+		fmt.Printf("%d of %d chunks were already downloaded and intact\n", skipped, len(m.Chunks))
+	}
+	// Chunks lost from every replica may still be rebuilt from parity
+	var lostGroups []string
+	if len(unrecoverable) > 0 && m.Erasure != nil {
+		unrecoverable, lostGroups = cu.recoverFromParity(m, downloadDir, unrecoverable)
+	}
+	if len(unrecoverable) > 0 {
+		return &RecoveryError{Chunks: unrecoverable, Total: len(m.Chunks), Parity: lostGroups}
+	}
+	return nil
+}
+
+// haveLocalChunk reports whether path holds an intact copy of a stored chunk,
+// checked by its SHA-256, or by size for manifests without one
+func haveLocalChunk(path string, c manifest.ChunkInfo) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != c.Size {
+		return false
+	}
+	if c.CipherHash == "" {
+		return true
+	}
+	hash, err := fileSHA256(path)
+	return err == nil && hash == c.CipherHash
+}
+
+// downloadFromProvider downloads a copy of a file from the given provider, using
+// the file ID and account recorded under key when available and a name lookup otherwise
+func (cu *CloudUploader) downloadFromProvider(provider CloudProvider, cloudIDs map[string]string, key, cloudPath, localPath string) error {
+	client, fileID, err := cu.locateFile(provider, cloudIDs, key, cloudPath)
+	if err != nil {
+		return err
+	}
+	return client.DownloadFile(fileID, localPath)
+}
+
+// locateFile returns the client of the account storing a copy of a file on a
+// provider and the file's ID there, from the cloudIDs recorded under key
+func (cu *CloudUploader) locateFile(provider CloudProvider, cloudIDs map[string]string, key, cloudPath string) (CloudClient, string, error) {
+	if !IsImplemented(provider) {
+		return nil, "", fmt.Errorf("%w: %s not implemented yet", ErrProviderUnavailable, provider)
+	}
+
+	clients := cu.clients[provider]
+	if len(clients) == 0 {
+		return nil, "", fmt.Errorf("%w: no %s clients initialized", ErrProviderUnavailable, provider)
+	}
+
+	// Use the account that stored this file, or the first available
+	client := clients[cloudIDs[key+"_account"]]
+	if client == nil {
+		client = clients[sortedAccountNames(clients)[0]]
+	}
+
+	// Use the stored file ID, falling back to finding the file by name
+	fileID, exists := cloudIDs[key]
+	if !exists {
+		var err error
+		fileID, err = client.FindFileByName(filepath.Base(cloudPath))
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return client, fileID, nil
+}
+
+// ReadChunk downloads a stored chunk into memory, trying each provider holding
+// a copy in turn, in the configured provider order. Copies that don't match
+// the recorded CipherHash are skipped.
+// It can be used as a chunk source for assembly, so a cloud restore never
+// stages chunks on disk, and is safe for concurrent use.
+func (cu *CloudUploader) ReadChunk(c manifest.ChunkInfo) ([]byte, error) {
+	if len(c.CloudPaths) == 0 {
+		return nil, fmt.Errorf("%w: chunk %s has no cloud paths", manifest.ErrChunkMissing, c.ID)
+	}
+
+	started := time.Now()
+	entry := ChunkReport{Index: c.Index, ID: c.ID, Status: ReportDownloaded, Bytes: c.Size}
+	var lastErr error
+	for n, i := range replicaOrder(c.Providers, len(c.CloudPaths), cu.config.CloudConfig.ProviderOrder) {
+		cloudPath := c.CloudPaths[i]
+		provider := CloudProvider(c.Providers[i])
+		key := replicaKey(c.Providers, i)
+		entry.Provider, entry.Account, entry.MD5, entry.Retries = string(provider), c.CloudIDs[key+"_account"], c.CloudIDs[key+"_md5"], n
+
+		data, err := cu.readFromProvider(provider, c.CloudIDs, key, cloudPath)
+		if err == nil && c.CipherHash != "" && fmt.Sprintf("%x", sha256.Sum256(data)) != c.CipherHash {
+			err = fmt.Errorf("%w: downloaded copy doesn't match the manifest", manifest.ErrHashMismatch)
+		}
+		if err != nil {
+			lastErr = err
+			fmt.Printf("Failed to download chunk %s from %s: %v\n", c.ID, provider, err)
+			continue
+		}
+		cu.report.add(entry, started)
+		return data, nil
+	}
+	err := fmt.Errorf("chunk %s couldn't be downloaded from any provider: %w", c.ID, lastErr)
+	entry.Status, entry.Error = ReportFailed, err.Error()
+	cu.report.add(entry, started)
+	return nil, err
+}
+
+// readFromProvider downloads a copy of a file from the given provider into memory
+func (cu *CloudUploader) readFromProvider(provider CloudProvider, cloudIDs map[string]string, key, cloudPath string) ([]byte, error) {
+	client, fileID, err := cu.locateFile(provider, cloudIDs, key, cloudPath)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := client.OpenFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file content: %w", err)
+	}
+	return data, nil
+}
+
+// DownloadManifestShards fetches the shard files of a sharded manifest that are
+// missing locally, so ReadManifest can load the full chunk list. A manifest
+// read from a URL has its shards fetched from next to it instead.
+func (cu *CloudUploader) DownloadManifestShards(manifestPath string) error {
+	if manifest.IsURL(manifestPath) {
+		return nil
+	}
+	root, err := manifest.ReadManifestRoot(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	for _, shard := range root.Shards {
+		localPath := manifest.ShardPath(manifestPath, shard)
+		if _, err := os.Stat(localPath); err == nil {
+			continue
+		}
+
+		var lastErr error
+		for _, i := range replicaOrder(shard.Providers, len(shard.Providers), cu.config.CloudConfig.ProviderOrder) {
+			provider := shard.Providers[i]
+			lastErr = cu.downloadFromProvider(CloudProvider(provider), shard.CloudIDs, replicaKey(shard.Providers, i), shard.File, localPath)
+			if lastErr == nil {
+				break
+			}
+			fmt.Printf("Failed to download manifest shard %s from %s: %v\n", shard.File, provider, lastErr)
+		}
+
+		if len(shard.Providers) == 0 {
+			return fmt.Errorf("manifest shard %s is missing locally and has no cloud copies", shard.File)
+		}
+		if lastErr != nil {
+			return fmt.Errorf("manifest shard %s could not be downloaded: %w", shard.File, lastErr)
+		}
+	}
+
 	return nil
 }
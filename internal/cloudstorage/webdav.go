@@ -0,0 +1,296 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/config"
+)
+
+// DefaultWebDAVPath is the base collection used when an account doesn't set one
+const DefaultWebDAVPath = "distributed-chunks"
+
+// WebDAVClient handles WebDAV (Nextcloud, ownCloud, etc.) operations
+type WebDAVClient struct {
+	httpClient  *http.Client
+	baseURL     string
+	basePath    string // Base collection chunks are stored under
+	username    string
+	password    string
+	bearerToken string
+	name        string // Account name for identification
+	maxChunks   int    // Upload cap per run, 0 for no limit
+	maxBytes    int64  // Upload cap per run in bytes, 0 for no limit
+}
+
+func init() {
+	RegisterProvider(WebDAV, createWebDAVClients)
+}
+
+// createWebDAVClients creates a client for each enabled WebDAV account
+func createWebDAVClients(cfg *config.Config) (map[string]CloudClient, error) {
+	clients := make(map[string]CloudClient)
+	for _, account := range cfg.GetEnabledWebDAVAccounts() {
+		account.Path = cfg.WebDAVFolder(account)
+		webdav, err := CreateWebDAVClient(account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create WebDAV client for account '%s': %w", account.Name, err)
+		}
+		if err := webdav.SetProxy(cfg.CloudConfig.Proxy); err != nil {
+			return nil, fmt.Errorf("failed to create WebDAV client for account '%s': %w", account.Name, err)
+		}
+		clients[account.Name] = webdav
+	}
+	return clients, nil
+}
+
+// CreateWebDAVClient creates a new WebDAV client from an account configuration
+func CreateWebDAVClient(account config.WebDAVAccount) (*WebDAVClient, error) {
+	if account.URL == "" {
+		return nil, fmt.Errorf("webdav url cannot be empty")
+	}
+
+	basePath := strings.Trim(account.Path, "/")
+	if basePath == "" {
+		basePath = DefaultWebDAVPath
+	}
+
+	return &WebDAVClient{
+		httpClient:  &http.Client{Timeout: 10 * time.Minute},
+		baseURL:     strings.TrimRight(account.URL, "/"),
+		basePath:    basePath,
+		username:    account.Username,
+		password:    account.Password,
+		bearerToken: account.BearerToken,
+		name:        account.Name,
+		maxChunks:   account.MaxChunks,
+		maxBytes:    account.MaxBytes,
+	}, nil
+}
+
+// CheckCredentials checks that the URL is an http(s) URL and that a username
+// comes with a password
+func (wd *WebDAVClient) CheckCredentials() error {
+	u, err := url.Parse(wd.baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url %q isn't an http(s) URL", ErrBadCredentials, wd.baseURL)
+	}
+	if wd.bearerToken == "" && wd.username != "" && wd.password == "" {
+		return fmt.Errorf("%w: username %q has no password (use an app password for Nextcloud)", ErrBadCredentials, wd.username)
+	}
+	return nil
+}
+
+// SetProxy sends all requests through proxy, or through the proxy from
+// HTTP_PROXY/HTTPS_PROXY when proxy is empty
+func (wd *WebDAVClient) SetProxy(proxy string) error {
+	transport, err := newProxyTransport(proxy)
+	if err != nil {
+		return err
+	}
+	wd.httpClient.Transport = transport
+	return nil
+}
+
+// Limits returns the per-run upload caps from the account configuration
+func (wd *WebDAVClient) Limits() (int, int64) {
+	return wd.maxChunks, wd.maxBytes
+}
+
+// Initialize checks the endpoint and creates the base collection if needed
+func (wd *WebDAVClient) Initialize() error {
+	if _, err := url.Parse(wd.baseURL); err != nil {
+		return fmt.Errorf("invalid webdav url: %w", err)
+	}
+
+	// MKCOL doesn't create parents, so create each level of the base path in turn
+	var current string
+	for _, segment := range strings.Split(wd.basePath, "/") {
+		current = path.Join(current, segment)
+
+		resp, err := wd.do("MKCOL", current, nil)
+		if err != nil {
+			return fmt.Errorf("can't create collection %s: %w", current, err)
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusCreated, http.StatusMethodNotAllowed:
+			// Created, or it already exists
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("webdav %w (check username/password or token): %s", ErrAuthFailed, resp.Status)
+		default:
+			return fmt.Errorf("can't create collection %s: %s", current, resp.Status)
+		}
+	}
+
+	fmt.Printf("Using WebDAV collection '%s' for account '%s'\n", wd.basePath, wd.name)
+	return nil
+}
+
+// UploadFile uploads a file into the base collection and returns its remote path
+func (wd *WebDAVClient) UploadFile(localPath, cloudPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("unable to get file info: %w", err)
+	}
+
+	remotePath := path.Join(wd.basePath, path.Base(filepath.ToSlash(cloudPath)))
+
+	resp, err := wd.do(http.MethodPut, remotePath, file)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload file: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	default:
+		return "", fmt.Errorf("unable to upload file: %s", resp.Status)
+	}
+
+	fmt.Printf("Uploaded to WebDAV account '%s': %s (Size: %d bytes)\n", wd.name, remotePath, fileInfo.Size())
+	return remotePath, nil
+}
+
+// DownloadFile downloads a file by its remote path
+func (wd *WebDAVClient) DownloadFile(fileID, localPath string) error {
+	body, err := wd.OpenFile(fileID)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+
+	outFile, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to create local file: %w", err)
+	}
+	defer outFile.Close()
+
+	_, err = io.Copy(outFile, body)
+	if err != nil {
+		return fmt.Errorf("unable to copy file content: %w", err)
+	}
+
+	fmt.Printf("Downloaded from WebDAV account '%s': %s\n", wd.name, localPath)
+	return nil
+}
+
+// OpenFile starts downloading a file by its remote path
+func (wd *WebDAVClient) OpenFile(fileID string) (io.ReadCloser, error) {
+	resp, err := wd.do(http.MethodGet, fileID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download file: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unable to download file: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// FindFileByName checks that a file exists in the base collection and returns its remote path
+func (wd *WebDAVClient) FindFileByName(fileName string) (string, error) {
+	remotePath := path.Join(wd.basePath, fileName)
+
+	resp, err := wd.do(http.MethodHead, remotePath, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to search for file: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return remotePath, nil
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	default:
+		return "", fmt.Errorf("unable to search for file: %s", resp.Status)
+	}
+}
+
+// StatFile returns a file's size from a HEAD request. WebDAV has no standard
+// checksum, so MD5 is left empty.
+func (wd *WebDAVClient) StatFile(fileID string) (RemoteFile, error) {
+	resp, err := wd.do(http.MethodHead, fileID, nil)
+	if err != nil {
+		return RemoteFile{}, fmt.Errorf("unable to get file info: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return RemoteFile{ID: fileID, Size: resp.ContentLength}, nil
+	case http.StatusNotFound:
+		return RemoteFile{}, fmt.Errorf("%w: %s", ErrFileNotFound, fileID)
+	default:
+		return RemoteFile{}, fmt.Errorf("unable to get file info: %s", resp.Status)
+	}
+}
+
+// CloudFolder returns the base collection chunks are uploaded into
+func (wd *WebDAVClient) CloudFolder() (string, string) {
+	return wd.basePath, ""
+}
+
+// PublicLink returns the file's URL. Nothing is shared: it only downloads
+// without credentials if the server allows anonymous reads.
+func (wd *WebDAVClient) PublicLink(fileID string) (string, error) {
+	return url.JoinPath(wd.baseURL, strings.Split(fileID, "/")...)
+}
+
+// DeleteFile deletes a file by its remote path
+func (wd *WebDAVClient) DeleteFile(fileID string) error {
+	resp, err := wd.do(http.MethodDelete, fileID, nil)
+	if err != nil {
+		return fmt.Errorf("unable to delete file: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("unable to delete file: %s", resp.Status)
+	}
+}
+
+// do sends an authenticated request for a path relative to the endpoint
+func (wd *WebDAVClient) do(method, remotePath string, body io.Reader) (*http.Response, error) {
+	target, err := url.JoinPath(wd.baseURL, strings.Split(remotePath, "/")...)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if wd.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+wd.bearerToken)
+	} else if wd.username != "" {
+		req.SetBasicAuth(wd.username, wd.password)
+	}
+
+	return wd.httpClient.Do(req)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables that override the config file. Command-line flags
+// still take precedence over them.
+const (
+	EnvChunkSize     = "CHUNKSTORE_CHUNK_SIZE"     // Bytes, or "auto"
+	EnvProviders     = "CHUNKSTORE_PROVIDERS"      // Comma-separated, e.g. "gdrive,webdav"
+	EnvReplication   = "CHUNKSTORE_REPLICATION"    // Copies of each chunk
+	EnvLoadBalancing = "CHUNKSTORE_LOAD_BALANCING" // round_robin, random or size_based
+	EnvHashAlgo      = "CHUNKSTORE_HASH_ALGO"      // sha256 or blake3
+	EnvCompression   = "CHUNKSTORE_COMPRESSION"    // deflate, or empty for none
+)
+
+// ErrInvalidEnv means a CHUNKSTORE_* environment variable couldn't be parsed
+var ErrInvalidEnv = errors.New("invalid environment variable")
+
+// ApplyEnv overlays the CHUNKSTORE_* environment variables that are set on c.
+// Values are checked for their type here; Validate checks the merged config.
+func (c *Config) ApplyEnv() error {
+	if v, ok := os.LookupEnv(EnvChunkSize); ok {
+		if strings.TrimSpace(v) == "auto" {
+			c.ChunkConfig.ChunkSize = 0
+		} else {
+			size, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %s must be a number of bytes or \"auto\", got %q", ErrInvalidEnv, EnvChunkSize, v)
+			}
+			c.ChunkConfig.ChunkSize = size
+		}
+	}
+
+	if v, ok := os.LookupEnv(EnvProviders); ok {
+		var providers []CloudProvider
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				providers = append(providers, CloudProvider(strings.ToLower(name)))
+			}
+		}
+		c.CloudConfig.Providers = providers
+	}
+
+	if v, ok := os.LookupEnv(EnvReplication); ok {
+		count, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%w: %s must be a number, got %q", ErrInvalidEnv, EnvReplication, v)
+		}
+		c.CloudConfig.ReplicationCount = count
+	}
+
+	if v, ok := os.LookupEnv(EnvLoadBalancing); ok {
+		c.CloudConfig.LoadBalancing = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv(EnvHashAlgo); ok {
+		c.ChunkConfig.HashAlgo = strings.TrimSpace(v)
+	}
+	if v, ok := os.LookupEnv(EnvCompression); ok {
+		c.ChunkConfig.Compression = strings.TrimSpace(v)
+	}
+	return nil
+}
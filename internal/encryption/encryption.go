@@ -1,18 +1,60 @@
 package encryption
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 )
 
+// Errors returned when data can't be decrypted; check for them with errors.Is
+var (
+	// ErrDecryptFailed means ciphertext didn't decrypt, because of a wrong key or corrupted data
+	ErrDecryptFailed = errors.New("failed to decrypt")
+	// ErrIncorrectPassword means the password doesn't match the one data was encrypted with
+	ErrIncorrectPassword = errors.New("incorrect password")
+	// ErrSchemeMismatch means data was encrypted with a cipher or key derivation this config doesn't use
+	ErrSchemeMismatch = errors.New("encryption scheme mismatch")
+)
+
+// Cipher and key derivation names recorded in manifests
+const (
+	CipherAES256GCM = "aes-256-gcm" // AES-256 in GCM mode, the only cipher so far
+	KDFSHA256       = "sha256"      // Key is the SHA-256 of the password
+)
+
+// AADChunkHash is the additional authenticated data scheme recorded in
+// manifests whose chunks are bound to their plaintext hash, so a chunk stored
+// in another chunk's place fails to decrypt instead of only failing the hash
+// check afterwards. Manifests without a scheme use no AAD.
+const AADChunkHash = "chunk-hash"
+
+// GCM nonce sizes chunks can be encrypted with. Nonces are random, and a
+// longer one makes a repeat under the same key less likely, though GCM hashes
+// nonces other than DefaultNonceSize into its counter, so it gains less than
+// the extra bytes suggest.
+const (
+	DefaultNonceSize = 12 // GCM's standard nonce size, the fastest
+	MaxNonceSize     = 32
+)
+
+// tagSize is the size of the GCM authentication tag
+const tagSize = 16
+
+// passwordCheckPlaintext is the known value encrypted into a manifest's password check
+const passwordCheckPlaintext = "chunk-store password check v1"
+
 // EncryptionConfig holds encryption settings
 type EncryptionConfig struct {
-	Enabled bool
-	Key     []byte
+	Enabled   bool
+	Key       []byte
+	AAD       string // What ChunkAAD binds chunks to, AADChunkHash or empty for nothing
+	NonceSize int    // Bytes of GCM nonce chunks are encrypted with, 0 for DefaultNonceSize. Password checks and wrapped keys always use DefaultNonceSize.
 }
 
 // CreateEncryptionConfig creates encryption config from password
@@ -29,66 +71,308 @@ func CreateEncryptionConfig(password string, enabled bool) *EncryptionConfig {
 	}
 }
 
-// Encrypt encrypts data using AES-256-GCM
-func (ec *EncryptionConfig) Encrypt(plaintext []byte) ([]byte, error) {
-	if !ec.Enabled {
-		return plaintext, nil
+// Cipher returns the name of the cipher this config encrypts with
+func (ec *EncryptionConfig) Cipher() string {
+	return CipherAES256GCM
+}
+
+// KDF returns the name of the key derivation this config's key comes from
+func (ec *EncryptionConfig) KDF() string {
+	return KDFSHA256
+}
+
+// CheckScheme checks that data recorded as encrypted with cipherName under a
+// key derived with kdf can be decrypted with this config. Empty names are from
+// manifests written before they were recorded and mean the defaults.
+func (ec *EncryptionConfig) CheckScheme(cipherName, kdf string) error {
+	if cipherName == "" {
+		cipherName = CipherAES256GCM
 	}
+	if kdf == "" {
+		kdf = KDFSHA256
+	}
+	if cipherName != ec.Cipher() {
+		return fmt.Errorf("%w: manifest uses cipher %s but configured for %s", ErrSchemeMismatch, cipherName, ec.Cipher())
+	}
+	if kdf != ec.KDF() {
+		return fmt.Errorf("%w: manifest uses key derivation %s but configured for %s", ErrSchemeMismatch, kdf, ec.KDF())
+	}
+	return nil
+}
 
+// CheckAAD checks that chunks bound to additional authenticated data with
+// scheme, as recorded in a manifest, can be decrypted
+func CheckAAD(scheme string) error {
+	if scheme != "" && scheme != AADChunkHash {
+		return fmt.Errorf("%w: manifest binds chunks to %s, which this version doesn't know", ErrSchemeMismatch, scheme)
+	}
+	return nil
+}
+
+// ValidateNonceSize checks a chunk nonce size, 0 meaning DefaultNonceSize
+func ValidateNonceSize(size int) error {
+	if size != 0 && (size < DefaultNonceSize || size > MaxNonceSize) {
+		return fmt.Errorf("nonce size must be from %d to %d bytes, got %d", DefaultNonceSize, MaxNonceSize, size)
+	}
+	return nil
+}
+
+// CheckNonce checks that a nonce stored apart from its chunk, base64 encoded
+// as in manifests, has size bytes, the size recorded in the manifest (0 for
+// DefaultNonceSize)
+func CheckNonce(encoded string, size int) error {
+	nonce, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid nonce: %w", err)
+	}
+	if size == 0 {
+		size = DefaultNonceSize
+	}
+	if len(nonce) != size {
+		return fmt.Errorf("%w: nonce is %d bytes, expected %d", ErrSchemeMismatch, len(nonce), size)
+	}
+	return nil
+}
+
+// WithNonceSize returns a copy of the config that encrypts chunks with size
+// byte nonces, 0 for DefaultNonceSize
+func (ec *EncryptionConfig) WithNonceSize(size int) *EncryptionConfig {
+	c := *ec
+	c.NonceSize = size
+	return &c
+}
+
+// WithAAD returns a copy of the config that binds chunks to scheme, see ChunkAAD
+func (ec *EncryptionConfig) WithAAD(scheme string) *EncryptionConfig {
+	c := *ec
+	c.AAD = scheme
+	return &c
+}
+
+// ChunkAAD returns the additional authenticated data of the chunk with the
+// given plaintext hash under the config's scheme, nil for none
+func (ec *EncryptionConfig) ChunkAAD(hash string) []byte {
+	if ec.AAD == "" {
+		return nil
+	}
+	return []byte(hash)
+}
+
+// nonceSize returns the size of the nonces the config encrypts with
+func (ec *EncryptionConfig) nonceSize() int {
+	if ec.NonceSize == 0 {
+		return DefaultNonceSize
+	}
+	return ec.NonceSize
+}
+
+// newGCM creates the AES-256-GCM cipher for this config's key and nonce size
+func (ec *EncryptionConfig) newGCM() (cipher.AEAD, error) {
+	if err := ValidateNonceSize(ec.NonceSize); err != nil {
+		return nil, err
+	}
 	block, err := aes.NewCipher(ec.Key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	var gcm cipher.AEAD
+	if ec.nonceSize() == DefaultNonceSize {
+		gcm, err = cipher.NewGCM(block)
+	} else {
+		gcm, err = cipher.NewGCMWithNonceSize(block, ec.nonceSize())
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
+	return gcm, nil
+}
 
-	// Generate a random nonce
+// newNonce generates a random nonce for gcm
+func newNonce(gcm cipher.AEAD) ([]byte, error) {
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
+	return nonce, nil
+}
+
+// Overhead returns how many bytes Encrypt adds to the plaintext: the nonce
+// and the GCM tag
+func (ec *EncryptionConfig) Overhead() int {
+	return ec.nonceSize() + tagSize
+}
+
+// Encrypt encrypts data using AES-256-GCM, prepending the nonce to the ciphertext
+func (ec *EncryptionConfig) Encrypt(plaintext []byte) ([]byte, error) {
+	return ec.EncryptTo(nil, plaintext, nil)
+}
+
+// EncryptTo is Encrypt appending the nonce and ciphertext to dst, e.g. a
+// reused buffer's dst[:0], which must not overlap plaintext. aad, if not nil,
+// is authenticated along with it and must be given again to decrypt.
+func (ec *EncryptionConfig) EncryptTo(dst, plaintext, aad []byte) ([]byte, error) {
+	if !ec.Enabled {
+		return plaintext, nil
+	}
+
+	gcm, err := ec.newGCM()
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate a random nonce
+	nonce, err := newNonce(gcm)
+	if err != nil {
+		return nil, err
+	}
 
 	// Encrypt the data
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(append(dst, nonce...), nonce, plaintext, aad)
 	return ciphertext, nil
 }
 
-// Decrypt decrypts data using AES-256-GCM
-func (ec *EncryptionConfig) Decrypt(ciphertext []byte) ([]byte, error) {
+// EncryptDetached encrypts data like Encrypt but returns the nonce separately,
+// so the ciphertext can be stored on its own
+func (ec *EncryptionConfig) EncryptDetached(plaintext []byte) (ciphertext, nonce []byte, err error) {
+	return ec.EncryptDetachedTo(nil, plaintext, nil)
+}
+
+// EncryptDetachedTo is EncryptDetached appending the ciphertext to dst, which
+// must not overlap plaintext, authenticating aad like EncryptTo
+func (ec *EncryptionConfig) EncryptDetachedTo(dst, plaintext, aad []byte) (ciphertext, nonce []byte, err error) {
 	if !ec.Enabled {
-		return ciphertext, nil
+		return plaintext, nil, nil
 	}
 
-	block, err := aes.NewCipher(ec.Key)
+	gcm, err := ec.newGCM()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	nonce, err = newNonce(gcm)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, nil, err
+	}
+	return gcm.Seal(dst, nonce, plaintext, aad), nonce, nil
+}
+
+// Decrypt decrypts data using AES-256-GCM
+func (ec *EncryptionConfig) Decrypt(ciphertext []byte) ([]byte, error) {
+	return ec.DecryptTo(nil, ciphertext, nil)
+}
+
+// DecryptTo is Decrypt appending the plaintext to dst, e.g. a reused buffer's
+// dst[:0], which must not overlap ciphertext. aad must be what it was
+// encrypted with.
+func (ec *EncryptionConfig) DecryptTo(dst, ciphertext, aad []byte) ([]byte, error) {
+	if !ec.Enabled {
+		return ciphertext, nil
+	}
+
+	gcm, err := ec.newGCM()
+	if err != nil {
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, fmt.Errorf("ciphertext too short")
+	if len(ciphertext) < nonceSize+gcm.Overhead() {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrDecryptFailed)
 	}
 
 	// Extract nonce and encrypted data
 	nonce, encryptedData := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
 	// Decrypt the data
-	plaintext, err := gcm.Open(nil, nonce, encryptedData, nil)
+	plaintext, err := gcm.Open(dst, nonce, encryptedData, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptFailed, err)
+	}
+
+	return plaintext, nil
+}
+
+// DecryptWithNonce decrypts ciphertext produced by EncryptDetached
+func (ec *EncryptionConfig) DecryptWithNonce(ciphertext, nonce []byte) ([]byte, error) {
+	return ec.DecryptWithNonceTo(nil, ciphertext, nonce, nil)
+}
+
+// DecryptWithNonceTo is DecryptWithNonce appending the plaintext to dst,
+// which must not overlap ciphertext, with aad like DecryptTo
+func (ec *EncryptionConfig) DecryptWithNonceTo(dst, ciphertext, nonce, aad []byte) ([]byte, error) {
+	if !ec.Enabled {
+		return ciphertext, nil
+	}
+
+	gcm, err := ec.newGCM()
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt: %w", err)
+		return nil, err
 	}
 
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size: %d", len(nonce))
+	}
+
+	plaintext, err := gcm.Open(dst, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptFailed, err)
+	}
 	return plaintext, nil
 }
 
+// CreatePasswordCheck encrypts a known value so a password can later be
+// verified without touching any chunk data
+func (ec *EncryptionConfig) CreatePasswordCheck() (string, error) {
+	ciphertext, err := ec.WithNonceSize(0).Encrypt([]byte(passwordCheckPlaintext))
+	if err != nil {
+		return "", fmt.Errorf("failed to create password check: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// VerifyPasswordCheck reports whether the key decrypts a value created by CreatePasswordCheck
+func (ec *EncryptionConfig) VerifyPasswordCheck(check string) error {
+	ciphertext, err := base64.StdEncoding.DecodeString(check)
+	if err != nil {
+		return fmt.Errorf("invalid password check: %w", err)
+	}
+
+	plaintext, err := ec.WithNonceSize(0).Decrypt(ciphertext)
+	if err != nil || !bytes.Equal(plaintext, []byte(passwordCheckPlaintext)) {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+// WithKey returns an enabled config that encrypts with key instead of the
+// password-derived key, binding chunks to the same AAD with the same nonce size
+func (ec *EncryptionConfig) WithKey(key []byte) *EncryptionConfig {
+	return &EncryptionConfig{Enabled: true, Key: key, AAD: ec.AAD, NonceSize: ec.NonceSize}
+}
+
+// WrapKey encrypts a file key with this config's key so it can be stored in a manifest
+func (ec *EncryptionConfig) WrapKey(fileKey []byte) (string, error) {
+	ciphertext, err := ec.WithNonceSize(0).Encrypt(fileKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap file key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// UnwrapKey decrypts a file key created by WrapKey
+func (ec *EncryptionConfig) UnwrapKey(wrapped string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped file key: %w", err)
+	}
+
+	fileKey, err := ec.WithNonceSize(0).Decrypt(ciphertext)
+	if err != nil || len(fileKey) != 32 {
+		return nil, ErrIncorrectPassword
+	}
+	return fileKey, nil
+}
+
 // GenerateRandomKey generates a random 256-bit key for encryption
 func GenerateRandomKey() ([]byte, error) {
 	key := make([]byte, 32) // 256 bits
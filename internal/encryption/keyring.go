@@ -0,0 +1,49 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// KeyringService is the service per-file passwords are stored under in the
+// OS keyring (Keychain, Windows Credential Manager or the Secret Service)
+const KeyringService = "chunk-store"
+
+// NewKeyringPassword generates a random password for a single file, stores it
+// in the OS keyring and returns it with the random ID it is stored under,
+// which the file's manifest records as its keyring ID
+func NewKeyringPassword() (id, password string, err error) {
+	idBytes := make([]byte, 16)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(idBytes)
+	password = base64.RawURLEncoding.EncodeToString(secret)
+
+	if err := keyring.Set(KeyringService, id, password); err != nil {
+		return "", "", fmt.Errorf("can't store the password in the OS keyring: %w", err)
+	}
+	return id, password, nil
+}
+
+// KeyringPassword returns the password stored in the OS keyring under id by NewKeyringPassword
+func KeyringPassword(id string) (string, error) {
+	password, err := keyring.Get(KeyringService, id)
+	if err != nil {
+		return "", fmt.Errorf("can't read password %s from the OS keyring: %w", id, err)
+	}
+	return password, nil
+}
+
+// DeleteKeyringPassword removes the password stored under id from the OS keyring
+func DeleteKeyringPassword(id string) error {
+	return keyring.Delete(KeyringService, id)
+}
@@ -0,0 +1,138 @@
+// Package erasure implements systematic Reed-Solomon erasure coding over
+// GF(2^8). Data shards are kept as they are and parity shards are added, so
+// any DataShards of the DataShards+ParityShards shards rebuild all of them.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the most data and parity shards a code can have together
+const MaxShards = 256
+
+// ErrTooFewShards is returned when fewer shards are present than there are
+// data shards, so the missing ones can't be rebuilt
+var ErrTooFewShards = errors.New("too few shards to reconstruct")
+
+// Coder encodes and reconstructs groups of DataShards data shards with
+// ParityShards parity shards
+type Coder struct {
+	DataShards   int
+	ParityShards int
+	matrix       matrix // (DataShards+ParityShards) x DataShards, identity on top
+}
+
+// Validate checks that data data shards with parity parity shards can be coded
+func Validate(data, parity int) error {
+	switch {
+	case data < 1 || parity < 1:
+		return fmt.Errorf("erasure coding needs at least 1 data and 1 parity shard, got %d and %d", data, parity)
+	case data+parity > MaxShards:
+		return fmt.Errorf("erasure coding supports at most %d shards, got %d data and %d parity", MaxShards, data, parity)
+	}
+	return nil
+}
+
+// New returns a coder for data data shards and parity parity shards
+func New(data, parity int) (*Coder, error) {
+	if err := Validate(data, parity); err != nil {
+		return nil, err
+	}
+
+	// Any DataShards rows of a Vandermonde matrix are independent. Multiplying
+	// by the inverse of its top square keeps that and turns the top into the
+	// identity, so data shards encode to themselves.
+	vm := vandermonde(data+parity, data)
+	top, err := vm.subMatrix(0, data).invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Coder{DataShards: data, ParityShards: parity, matrix: vm.multiply(top)}, nil
+}
+
+// Encode computes the parity shards of shards, which holds DataShards data
+// shards of equal length followed by ParityShards parity shards. Parity
+// shards are allocated unless they already have the right length.
+func (c *Coder) Encode(shards [][]byte) error {
+	if len(shards) != c.DataShards+c.ParityShards {
+		return fmt.Errorf("expected %d shards, got %d", c.DataShards+c.ParityShards, len(shards))
+	}
+	size := len(shards[0])
+	for i, s := range shards[:c.DataShards] {
+		if len(s) != size {
+			return fmt.Errorf("data shard %d is %d bytes, expected %d", i, len(s), size)
+		}
+	}
+	for i := c.DataShards; i < len(shards); i++ {
+		if len(shards[i]) != size {
+			shards[i] = make([]byte, size)
+		}
+		c.codeShard(c.matrix[i], shards[:c.DataShards], shards[i])
+	}
+	return nil
+}
+
+// Reconstruct rebuilds the missing shards of shards, the nil or empty ones,
+// from the others, which must all have the same length. It fails with
+// ErrTooFewShards when fewer than DataShards are present.
+func (c *Coder) Reconstruct(shards [][]byte) error {
+	if len(shards) != c.DataShards+c.ParityShards {
+		return fmt.Errorf("expected %d shards, got %d", c.DataShards+c.ParityShards, len(shards))
+	}
+
+	size := -1
+	var present []int
+	for i, s := range shards {
+		if len(s) == 0 {
+			continue
+		}
+		if size >= 0 && len(s) != size {
+			return fmt.Errorf("shard %d is %d bytes, expected %d", i, len(s), size)
+		}
+		size = len(s)
+		present = append(present, i)
+	}
+	if len(present) == len(shards) {
+		return nil
+	}
+	if len(present) < c.DataShards {
+		return fmt.Errorf("%w: %d of %d shards present, %d needed", ErrTooFewShards, len(present), len(shards), c.DataShards)
+	}
+
+	// The rows of the first DataShards present shards map the data shards to
+	// them, so their inverse maps them back to the data shards
+	rows := make(matrix, c.DataShards)
+	inputs := make([][]byte, c.DataShards)
+	for i, shard := range present[:c.DataShards] {
+		rows[i] = c.matrix[shard]
+		inputs[i] = shards[shard]
+	}
+	decode, err := rows.invert()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < c.DataShards; i++ {
+		if len(shards[i]) == 0 {
+			shards[i] = make([]byte, size)
+			c.codeShard(decode[i], inputs, shards[i])
+		}
+	}
+
+	// With the data complete, missing parity is encoded again
+	for i := c.DataShards; i < len(shards); i++ {
+		if len(shards[i]) == 0 {
+			shards[i] = make([]byte, size)
+			c.codeShard(c.matrix[i], shards[:c.DataShards], shards[i])
+		}
+	}
+	return nil
+}
+
+// codeShard sets out to the sum of inputs weighted by row
+func (c *Coder) codeShard(row []byte, inputs [][]byte, out []byte) {
+	clear(out)
+	for j, in := range inputs {
+		mulAdd(row[j], in, out)
+	}
+}
@@ -0,0 +1,78 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomShards(r *rand.Rand, data, parity, size int) [][]byte {
+	shards := make([][]byte, data+parity)
+	for i := 0; i < data; i++ {
+		shards[i] = make([]byte, size)
+		r.Read(shards[i])
+	}
+	return shards
+}
+
+func TestReconstruct(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tc := range []struct{ data, parity int }{{1, 1}, {4, 2}, {10, 4}, {3, 5}, {200, 56}} {
+		c, err := New(tc.data, tc.parity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards := randomShards(r, tc.data, tc.parity, 1000)
+		if err := c.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+		want := make([][]byte, len(shards))
+		for i := range shards {
+			want[i] = append([]byte(nil), shards[i]...)
+		}
+
+		// Lose as many shards as there are parity shards, at random
+		for round := 0; round < 20; round++ {
+			damaged := make([][]byte, len(want))
+			copy(damaged, want)
+			for _, i := range r.Perm(len(damaged))[:tc.parity] {
+				damaged[i] = nil
+			}
+			if err := c.Reconstruct(damaged); err != nil {
+				t.Fatalf("%d+%d: %v", tc.data, tc.parity, err)
+			}
+			for i := range want {
+				if !bytes.Equal(damaged[i], want[i]) {
+					t.Fatalf("%d+%d: shard %d rebuilt wrongly", tc.data, tc.parity, i)
+				}
+			}
+		}
+	}
+}
+
+func TestReconstructTooFewShards(t *testing.T) {
+	c, err := New(4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := randomShards(rand.New(rand.NewSource(2)), 4, 2, 64)
+	if err := c.Encode(shards); err != nil {
+		t.Fatal(err)
+	}
+	shards[0], shards[3], shards[5] = nil, nil, nil
+	if err := c.Reconstruct(shards); !errors.Is(err, ErrTooFewShards) {
+		t.Fatalf("got %v, want ErrTooFewShards", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		data, parity int
+		ok           bool
+	}{{1, 1, true}, {0, 1, false}, {1, 0, false}, {128, 128, true}, {200, 57, false}} {
+		if err := Validate(tc.data, tc.parity); (err == nil) != tc.ok {
+			t.Errorf("Validate(%d, %d) = %v", tc.data, tc.parity, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package erasure
+
+import "errors"
+
+// GF(2^8) arithmetic with the generator polynomial x^8+x^4+x^3+x^2+1 (0x11d),
+// using log and exp tables. Addition is XOR.
+var (
+	gfExp [510]byte // gfExp[i] = 2^i, doubled so products of logs need no modulo
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// gfPow returns a to the power n
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]*n%255]
+}
+
+// mulAdd adds c times in to out, byte by byte
+func mulAdd(c byte, in, out []byte) {
+	switch c {
+	case 0:
+		return
+	case 1:
+		for i, b := range in {
+			out[i] ^= b
+		}
+		return
+	}
+	var table [256]byte
+	for b := 1; b < 256; b++ {
+		table[b] = gfMul(c, byte(b))
+	}
+	for i, b := range in {
+		out[i] ^= table[b]
+	}
+}
+
+// matrix is a matrix over GF(2^8), by rows
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde returns the rows x cols matrix with r^c in row r, column c
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+// subMatrix returns rows from up to, not including, to
+func (m matrix) subMatrix(from, to int) matrix {
+	sub := newMatrix(to-from, len(m[0]))
+	for i := range sub {
+		copy(sub[i], m[from+i])
+	}
+	return sub
+}
+
+// multiply returns m times o
+func (m matrix) multiply(o matrix) matrix {
+	out := newMatrix(len(m), len(o[0]))
+	for r := range out {
+		for c := range out[r] {
+			var v byte
+			for k := range o {
+				v ^= gfMul(m[r][k], o[k][c])
+			}
+			out[r][c] = v
+		}
+	}
+	return out
+}
+
+// errSingular is returned when inverting a matrix that has no inverse
+var errSingular = errors.New("matrix is singular")
+
+// invert returns the inverse of the square matrix m by Gauss-Jordan
+// elimination, leaving m unchanged
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	work := newMatrix(n, 2*n)
+	for i := range m {
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errSingular
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		if inv := gfInv(work[col][col]); inv != 1 {
+			for c := range work[col] {
+				work[col][c] = gfMul(work[col][c], inv)
+			}
+		}
+		for r := 0; r < n; r++ {
+			if f := work[r][col]; r != col && f != 0 {
+				for c := range work[r] {
+					work[r][c] ^= gfMul(f, work[col][c])
+				}
+			}
+		}
+	}
+
+	inv := newMatrix(n, n)
+	for i := range inv {
+		copy(inv[i], work[i][n:])
+	}
+	return inv, nil
+}
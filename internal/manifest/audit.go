@@ -0,0 +1,53 @@
+package manifest
+
+import "time"
+
+// maxVerifications is how many verification runs a manifest keeps
+const maxVerifications = 20
+
+// Verification records one run of -mode verify
+type Verification struct {
+	Time  string `json:"time"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"` // Why verification failed
+}
+
+// RecordVerification adds the result of a verification run to the manifest
+// at path, where verifyErr is nil if the file verified. The last
+// maxVerifications runs are kept, and LastVerified is updated on success.
+func RecordVerification(path string, at time.Time, verifyErr error) error {
+	m, err := ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	v := Verification{Time: at.Format(time.RFC3339), OK: verifyErr == nil}
+	if verifyErr != nil {
+		v.Error = verifyErr.Error()
+	} else {
+		m.LastVerified = v.Time
+	}
+
+	m.Verifications = append(m.Verifications, v)
+	if len(m.Verifications) > maxVerifications {
+		m.Verifications = m.Verifications[len(m.Verifications)-maxVerifications:]
+	}
+	return Save(m, path)
+}
+
+// VerifiedSince reports whether the file last verified successfully at or after t
+func (m Manifest) VerifiedSince(t time.Time) bool {
+	if m.LastVerified == "" {
+		return false
+	}
+	verified, err := time.Parse(time.RFC3339, m.LastVerified)
+	return err == nil && !verified.Before(t)
+}
+
+// LastVerification returns the most recent verification run, if any
+func (m Manifest) LastVerification() (Verification, bool) {
+	if len(m.Verifications) == 0 {
+		return Verification{}, false
+	}
+	return m.Verifications[len(m.Verifications)-1], true
+}
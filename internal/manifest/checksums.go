@@ -0,0 +1,59 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// Checksum export formats
+const (
+	ChecksumFormatSHA256Sum = "sha256sum" // "<hash>  <file>", checkable with sha256sum -c
+	ChecksumFormatBagIt     = "bagit"     // BagIt manifest-sha256.txt, paths under data/
+)
+
+// ExportChecksums writes the SHA-256 of every stored chunk file in m to w, so
+// the chunk directory can be validated by external tools. Paths are relative
+// to the chunk directory (or the bag's data/ directory for BagIt).
+func ExportChecksums(m Manifest, w io.Writer, format string) error {
+	var prefix string
+	switch format {
+	case ChecksumFormatSHA256Sum, "":
+	case ChecksumFormatBagIt:
+		prefix = "data/"
+	default:
+		return fmt.Errorf("unknown checksum format: %s (expected %s or %s)", format, ChecksumFormatSHA256Sum, ChecksumFormatBagIt)
+	}
+
+	written := make(map[string]bool)
+	for _, c := range m.StoredChunks() {
+		// Repeated chunks share a file, list it once
+		name := filepath.ToSlash(m.ChunkPath("", c))
+		if written[name] {
+			continue
+		}
+		written[name] = true
+
+		hash, err := storedHash(c)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "%s  %s%s\n", hash, prefix, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storedHash returns the SHA-256 of a chunk's file as stored on disk
+func storedHash(c ChunkInfo) (string, error) {
+	if c.CipherHash != "" {
+		return c.CipherHash, nil
+	}
+	if !c.Encrypted {
+		return c.Hash, nil
+	}
+	return "", fmt.Errorf("chunk %s is encrypted but the manifest has no stored-file hash (re-split with a newer version)", c.ID)
+}
@@ -0,0 +1,148 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CompactResult counts what Compact removed
+type CompactResult struct {
+	Entries  int // Chunk entries merged into another entry for the same index
+	Replicas int // Duplicate records of the same cloud copy
+}
+
+// ReplicaKey returns the CloudIDs key of the i-th copy in providers. The first
+// copy on a provider uses the provider name; later copies on the same provider
+// (on other accounts) add "#2", "#3" and so on.
+func ReplicaKey(providers []string, i int) string {
+	n := 1
+	for _, p := range providers[:i] {
+		if p == providers[i] {
+			n++
+		}
+	}
+	if n == 1 {
+		return providers[i]
+	}
+	return fmt.Sprintf("%s#%d", providers[i], n)
+}
+
+// Compact normalizes the chunk list of m: entries are sorted by Index, several
+// entries for the same index (left by partial uploads, repairs or merges) are
+// folded into the one with the most copies, dropping failed entries without
+// any, and repeated records of the same cloud copy are removed. The result
+// must still have exactly one chunk for every index from 0 to N-1.
+func Compact(m Manifest) (Manifest, CompactResult, error) {
+	var res CompactResult
+
+	chunks := append([]ChunkInfo(nil), m.Chunks...)
+	sort.SliceStable(chunks, func(a, b int) bool {
+		return chunks[a].Index < chunks[b].Index
+	})
+
+	var compacted []ChunkInfo
+	for i := 0; i < len(chunks); {
+		// Collect every entry for this index
+		j := i + 1
+		for j < len(chunks) && chunks[j].Index == chunks[i].Index {
+			j++
+		}
+		group := chunks[i:j]
+		i = j
+
+		best := 0
+		for k, c := range group {
+			if c.Hash != group[0].Hash {
+				return Manifest{}, res, fmt.Errorf("chunk %d has entries with different hashes (%s and %s)", c.Index, group[0].Hash, c.Hash)
+			}
+			if len(c.Providers) > len(group[best].Providers) {
+				best = k
+			}
+		}
+
+		chunk := group[best]
+		var removed int
+		chunk, removed = dedupReplicas(chunk, group, best)
+		res.Entries += len(group) - 1
+		res.Replicas += removed
+		compacted = append(compacted, chunk)
+	}
+
+	// Every index has to be covered once for the file to assemble
+	for i, c := range compacted {
+		if c.Index != i {
+			return Manifest{}, res, fmt.Errorf("%w: no entry for chunk %d", ErrChunkMissing, i)
+		}
+	}
+
+	m.Chunks = compacted
+	return m, res, nil
+}
+
+// dedupReplicas returns chunk, which is group[best], with the cloud copies of
+// every entry in group, each copy recorded once, and how many repeated
+// records were dropped. A copy is identified by its provider, account and
+// file ID, or its path when no file ID was recorded.
+func dedupReplicas(chunk ChunkInfo, group []ChunkInfo, best int) (ChunkInfo, int) {
+	type replica struct {
+		provider, path string
+		ids            map[string]string // CloudIDs entries of the copy, by suffix
+	}
+
+	var replicas []replica
+	seen := make(map[string]bool)
+	removed := 0
+	add := func(c ChunkInfo) {
+		for i, provider := range c.Providers {
+			if i >= len(c.CloudPaths) {
+				break
+			}
+			key := ReplicaKey(c.Providers, i)
+			r := replica{provider: provider, path: c.CloudPaths[i], ids: make(map[string]string)}
+			for _, suffix := range []string{"", "_account", "_md5", "_pin"} {
+				if v, ok := c.CloudIDs[key+suffix]; ok {
+					r.ids[suffix] = v
+				}
+			}
+
+			id := provider + "\x00" + r.ids["_account"] + "\x00" + r.ids[""]
+			if r.ids[""] == "" {
+				id += "\x00" + r.path
+			}
+			if seen[id] {
+				removed++
+				continue
+			}
+			seen[id] = true
+			replicas = append(replicas, r)
+		}
+	}
+	add(chunk)
+	for k, c := range group {
+		if k != best {
+			add(c)
+		}
+	}
+
+	chunk.Providers = make([]string, 0, len(replicas))
+	chunk.CloudPaths = make([]string, 0, len(replicas))
+	var cloudIDs map[string]string
+	for _, r := range replicas {
+		chunk.Providers = append(chunk.Providers, r.provider)
+		chunk.CloudPaths = append(chunk.CloudPaths, r.path)
+		key := ReplicaKey(chunk.Providers, len(chunk.Providers)-1)
+		for suffix, v := range r.ids {
+			if cloudIDs == nil {
+				cloudIDs = make(map[string]string)
+			}
+			cloudIDs[key+suffix] = v
+		}
+	}
+	chunk.CloudIDs = cloudIDs
+
+	// Copies from other entries may have completed a failed upload
+	if len(replicas) > 0 && chunk.Status == ChunkStatusFailed {
+		chunk.Status = ChunkStatusPartial
+	}
+	return chunk, removed
+}
@@ -0,0 +1,72 @@
+package manifest
+
+import "fmt"
+
+// Erasure describes the Reed-Solomon parity of a manifest's chunks. Chunks are
+// coded in groups of DataShards by Index, so chunk i is in group
+// i/DataShards, and every group has ParityShards parity chunks. Any
+// DataShards of a group's chunks and parity chunks rebuild the rest.
+type Erasure struct {
+	DataShards   int         `json:"data_shards"`
+	ParityShards int         `json:"parity_shards"`
+	Parity       []ChunkInfo `json:"parity"` // Parity chunks by group, parity chunk j of group g has Index g*ParityShards+j
+}
+
+// ParityIDPrefix starts the IDs of parity chunks, so they can't be mistaken
+// for data chunks
+const ParityIDPrefix = "parity-"
+
+// Groups returns the number of parity groups of n chunks
+func (e Erasure) Groups(n int) int {
+	return (n + e.DataShards - 1) / e.DataShards
+}
+
+// GroupParity returns the parity chunks of group g
+func (e Erasure) GroupParity(g int) []ChunkInfo {
+	start := g * e.ParityShards
+	if start >= len(e.Parity) {
+		return nil
+	}
+	return e.Parity[start:min(start+e.ParityShards, len(e.Parity))]
+}
+
+// ChunkGroups returns the chunks of m by parity group, each ordered by
+// position in the group. Positions of the last group past the final chunk are
+// left empty, with an ID of "".
+func (m Manifest) ChunkGroups() [][]ChunkInfo {
+	k := m.Erasure.DataShards
+	groups := make([][]ChunkInfo, m.Erasure.Groups(len(m.Chunks)))
+	for g := range groups {
+		groups[g] = make([]ChunkInfo, k)
+	}
+	for _, c := range m.Chunks {
+		if c.Index >= 0 && c.Index/k < len(groups) {
+			groups[c.Index/k][c.Index%k] = c
+		}
+	}
+	return groups
+}
+
+// ValidateErasure checks the parity chunks of m against its chunk list
+func ValidateErasure(m Manifest) error {
+	e := m.Erasure
+	if e == nil {
+		return nil
+	}
+	if e.DataShards < 1 || e.ParityShards < 1 {
+		return fmt.Errorf("invalid erasure coding: %d data and %d parity shards", e.DataShards, e.ParityShards)
+	}
+	if want := e.Groups(len(m.Chunks)) * e.ParityShards; len(e.Parity) != want {
+		return fmt.Errorf("invalid erasure coding: expected %d parity chunks, found %d", want, len(e.Parity))
+	}
+	return nil
+}
+
+// StoredChunks returns the chunks of m followed by its parity chunks, so
+// every file stored for m
+func (m Manifest) StoredChunks() []ChunkInfo {
+	if m.Erasure == nil {
+		return m.Chunks
+	}
+	return append(m.Chunks[:len(m.Chunks):len(m.Chunks)], m.Erasure.Parity...)
+}
@@ -0,0 +1,15 @@
+package manifest
+
+import "errors"
+
+// Errors shared by the packages that store and restore chunks. They are
+// wrapped with details, so check for them with errors.Is.
+var (
+	// ErrHashMismatch means data doesn't match the hash recorded for it
+	ErrHashMismatch = errors.New("hash mismatch")
+	// ErrChunkMissing means a chunk the manifest references isn't available
+	ErrChunkMissing = errors.New("chunk missing")
+	// ErrBadIndex means the chunk indexes don't number the chunks 0 to N-1
+	// exactly once each, see CheckIndexes
+	ErrBadIndex = errors.New("bad chunk index")
+)
@@ -0,0 +1,61 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest file formats
+const (
+	FormatJSON    = "json"         // Indented JSON (default)
+	FormatCompact = "compact-json" // JSON without indentation
+	FormatBinary  = "binary"       // binaryMagic followed by a gob stream, for manifests with very many chunks
+)
+
+// binaryMagic starts every FormatBinary file, so its format can be told from JSON on read
+var binaryMagic = []byte("CSMB\x01")
+
+// formatFor returns the format m is saved in at path: m.Format, which is the
+// format it was read in unless changed, or else the one its extension implies
+func formatFor(m Manifest, path string) string {
+	if m.Format != "" {
+		return m.Format
+	}
+	if strings.EqualFold(filepath.Ext(path), ".bin") {
+		return FormatBinary
+	}
+	return FormatJSON
+}
+
+// encode serializes v, a manifest or shard, in format
+func encode(v any, format string) ([]byte, error) {
+	switch format {
+	case FormatCompact:
+		return json.Marshal(v)
+	case FormatBinary:
+		var buf bytes.Buffer
+		buf.Write(binaryMagic)
+		if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return json.MarshalIndent(v, "", "	")
+	}
+}
+
+// decode parses data, in any format, into v and returns which format it was.
+// Compact JSON is told apart by having no line breaks.
+func decode(data []byte, v any) (string, error) {
+	if rest, ok := bytes.CutPrefix(data, binaryMagic); ok {
+		return FormatBinary, gob.NewDecoder(bytes.NewReader(rest)).Decode(v)
+	}
+	format := FormatJSON
+	if !bytes.Contains(bytes.TrimSpace(data), []byte("\n")) {
+		format = FormatCompact
+	}
+	return format, json.Unmarshal(data, v)
+}
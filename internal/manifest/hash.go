@@ -0,0 +1,79 @@
+package manifest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"github.com/zeebo/blake3"
+)
+
+// Hash algorithms for chunk IDs, chunk hashes and the whole-file hash
+const (
+	HashSHA256 = "sha256"
+	HashBLAKE3 = "blake3"
+)
+
+// ValidateHashAlgo checks that algo is a supported hash algorithm. An empty
+// algo means SHA-256, as used by manifests written before HashAlgo existed.
+func ValidateHashAlgo(algo string) error {
+	switch algo {
+	case "", HashSHA256, HashBLAKE3:
+		return nil
+	default:
+		return fmt.Errorf("unsupported hash algorithm: %s (expected %s or %s)", algo, HashSHA256, HashBLAKE3)
+	}
+}
+
+// NewHasher returns a streaming hash for algo
+func NewHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "", HashSHA256:
+		return sha256.New(), nil
+	case HashBLAKE3:
+		return blake3.New(), nil
+	default:
+		return nil, ValidateHashAlgo(algo)
+	}
+}
+
+// HashData returns the hex digest of data using algo
+func HashData(algo string, data []byte) (string, error) {
+	switch algo {
+	case "", HashSHA256:
+		sum := sha256.Sum256(data)
+		return fmt.Sprintf("%x", sum[:]), nil
+	case HashBLAKE3:
+		sum := blake3.Sum256(data)
+		return fmt.Sprintf("%x", sum[:]), nil
+	default:
+		return "", ValidateHashAlgo(algo)
+	}
+}
+
+// ChunkName returns the ID of a chunk with hash hexHash under the naming
+// scheme, the full digest if full is set and its first 16 hex characters
+// otherwise. NamingHMAC IDs are keyed by the hex key, so equal chunks still get
+// equal IDs, but the stored names don't reveal the hashes to anyone without it.
+func ChunkName(naming, key, hexHash string, full bool) (string, error) {
+	id := hexHash
+	switch naming {
+	case NamingHash:
+	case NamingHMAC:
+		rawKey, err := hex.DecodeString(key)
+		if err != nil || len(rawKey) == 0 {
+			return "", fmt.Errorf("invalid chunk name key: expected a hex key")
+		}
+		mac := hmac.New(sha256.New, rawKey)
+		mac.Write([]byte(hexHash))
+		id = fmt.Sprintf("%x", mac.Sum(nil))
+	default:
+		return "", fmt.Errorf("unsupported chunk naming: %s (expected %s)", naming, NamingHMAC)
+	}
+	if !full {
+		id = id[:16]
+	}
+	return id, nil
+}
@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+)
+
+// How FixIndexes treats a chunk list whose indexes are wrong
+const (
+	FixIndexReject   = "reject"   // Report the problems and change nothing
+	FixIndexRenumber = "renumber" // Number the chunks 0 to N-1 in Index order
+)
+
+// ValidateFixIndex checks that strategy is a FixIndex* strategy
+func ValidateFixIndex(strategy string) error {
+	switch strategy {
+	case FixIndexReject, FixIndexRenumber:
+		return nil
+	}
+	return fmt.Errorf("invalid index fix: %s (expected %s or %s)", strategy, FixIndexReject, FixIndexRenumber)
+}
+
+// IndexProblems describes how the chunk indexes of a manifest are wrong
+type IndexProblems struct {
+	Duplicates []int // Indexes used by more than one chunk
+	Missing    []int // Indexes from 0 to N-1 no chunk uses
+	OutOfRange []int // Indexes below 0 or from N on
+}
+
+// OK reports whether every chunk has its own index from 0 to N-1
+func (p IndexProblems) OK() bool {
+	return len(p.Duplicates) == 0 && len(p.Missing) == 0 && len(p.OutOfRange) == 0
+}
+
+// FindIndexProblems returns the duplicate, missing and out of range chunk
+// indexes of m, each sorted
+func FindIndexProblems(m Manifest) IndexProblems {
+	var p IndexProblems
+	n := len(m.Chunks)
+	uses := make([]int, n)
+	for _, c := range m.Chunks {
+		if c.Index < 0 || c.Index >= n {
+			p.OutOfRange = append(p.OutOfRange, c.Index)
+			continue
+		}
+		uses[c.Index]++
+		if uses[c.Index] == 2 {
+			p.Duplicates = append(p.Duplicates, c.Index)
+		}
+	}
+	for i, u := range uses {
+		if u == 0 {
+			p.Missing = append(p.Missing, i)
+		}
+	}
+	sort.Ints(p.Duplicates)
+	sort.Ints(p.OutOfRange)
+	return p
+}
+
+// CheckIndexes checks that every chunk of m has its own index from 0 to N-1,
+// so assembling it writes every chunk once, at its place. Duplicates left by
+// merges or manual edits would otherwise write the same offset twice and
+// drop data.
+func CheckIndexes(m Manifest) error {
+	p := FindIndexProblems(m)
+	switch {
+	case len(p.Duplicates) > 0:
+		return fmt.Errorf("%w: %d indexes are used by more than one chunk, the first is %d (fix with -mode fix-index)", ErrBadIndex, len(p.Duplicates), p.Duplicates[0])
+	case len(p.OutOfRange) > 0:
+		return fmt.Errorf("%w: chunk index %d is outside 0 to %d (fix with -mode fix-index)", ErrBadIndex, p.OutOfRange[0], len(m.Chunks)-1)
+	case len(p.Missing) > 0:
+		return fmt.Errorf("%w: %d indexes have no chunk, the first is %d", ErrBadIndex, len(p.Missing), p.Missing[0])
+	}
+	return nil
+}
+
+// FixIndexResult counts what FixIndexes changed
+type FixIndexResult struct {
+	Folded     int // Entries for the same index and hash merged into one, like Compact
+	Renumbered int // Chunks given a new index
+}
+
+// FixIndexes numbers the chunks of m 0 to N-1 in Index order, keeping the
+// chunk list order between chunks with the same index. Entries with the same
+// index and hash are the same chunk recorded twice and are merged first.
+// The result is refused unless it still adds up to the file: the stored chunk
+// sizes must sum to TotalSize, which Save computes from them, with or without
+// the merged entries, and it must have the manifest's Merkle root, which was
+// computed over the chunks in their original order.
+func FixIndexes(m Manifest) (Manifest, FixIndexResult, error) {
+	var res FixIndexResult
+
+	chunks := append([]ChunkInfo(nil), m.Chunks...)
+	sort.SliceStable(chunks, func(a, b int) bool {
+		return chunks[a].Index < chunks[b].Index
+	})
+
+	var fixed []ChunkInfo
+	for i := 0; i < len(chunks); {
+		j := i + 1
+		for j < len(chunks) && chunks[j].Index == chunks[i].Index {
+			j++
+		}
+		group := chunks[i:j]
+		i = j
+
+		// Distinct hashes at one index are different chunks, kept in list order
+		for k := 0; k < len(group); k++ {
+			same := []ChunkInfo{group[k]}
+			for l := k + 1; l < len(group); l++ {
+				if group[l].Hash == group[k].Hash {
+					same = append(same, group[l])
+					group = append(group[:l], group[l+1:]...)
+					l--
+				}
+			}
+			chunk, _ := dedupReplicas(group[k], same, 0)
+			res.Folded += len(same) - 1
+			fixed = append(fixed, chunk)
+		}
+	}
+	for i := range fixed {
+		if fixed[i].Index != i {
+			fixed[i].Index = i
+			res.Renumbered++
+		}
+	}
+
+	listed, kept := storedSize(m.Chunks), storedSize(fixed)
+	if listed != m.TotalSize && kept != m.TotalSize {
+		return Manifest{}, res, fmt.Errorf("renumbered chunks add up to %d bytes (%d with the merged entries) but the manifest records %d, a chunk is missing or extra", kept, listed, m.TotalSize)
+	}
+
+	m.Chunks = fixed
+	if m.MerkleRoot != "" {
+		if err := VerifyMerkle(m); err != nil {
+			return Manifest{}, res, fmt.Errorf("renumbered chunks aren't in the file's order: %w", err)
+		}
+	}
+	return m, res, nil
+}
+
+// storedSize returns the sum of the stored sizes of chunks, as Save computes
+// TotalSize
+func storedSize(chunks []ChunkInfo) int64 {
+	var total int64
+	for _, c := range chunks {
+		total += c.Size
+	}
+	return total
+}
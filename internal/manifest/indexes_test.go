@@ -0,0 +1,153 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// indexTestManifest saves and reads back a manifest of three chunks, so
+// TotalSize is computed by Save, with a Merkle root over them. Encrypted
+// chunks are stored 28 bytes larger than their plaintext, like AES-GCM with a
+// prepended nonce.
+func indexTestManifest(t *testing.T, encrypted bool) Manifest {
+	t.Helper()
+	var chunks []ChunkInfo
+	for i := 0; i < 3; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("chunk %d", i)))
+		c := ChunkInfo{
+			ID:        fmt.Sprintf("chunk-%d", i),
+			Hash:      hex.EncodeToString(sum[:]),
+			Index:     i,
+			Size:      100,
+			PlainSize: 100,
+		}
+		if encrypted {
+			c.Encrypted = true
+			c.Size = 128
+		}
+		chunks = append(chunks, c)
+	}
+
+	m := NewManifest(chunks, "file.bin", encrypted, "local")
+	root, err := ComputeMerkleRoot(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.MerkleRoot = root
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := Save(m, path); err != nil {
+		t.Fatal(err)
+	}
+	m, err = ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func chunkIndexes(m Manifest) []int {
+	indexes := make([]int, len(m.Chunks))
+	for i, c := range m.Chunks {
+		indexes[i] = c.Index
+	}
+	return indexes
+}
+
+func TestCheckIndexesDuplicate(t *testing.T) {
+	m := indexTestManifest(t, false)
+	m.Chunks[2].Index = 1
+
+	if err := CheckIndexes(m); !errors.Is(err, ErrBadIndex) {
+		t.Fatalf("CheckIndexes = %v, want ErrBadIndex", err)
+	}
+	p := FindIndexProblems(m)
+	if !slices.Equal(p.Duplicates, []int{1}) || !slices.Equal(p.Missing, []int{2}) {
+		t.Errorf("problems = %+v, want duplicate 1 and missing 2", p)
+	}
+}
+
+func TestCheckIndexesGap(t *testing.T) {
+	m := indexTestManifest(t, false)
+	m.Chunks[2].Index = 3
+
+	if err := CheckIndexes(m); !errors.Is(err, ErrBadIndex) {
+		t.Fatalf("CheckIndexes = %v, want ErrBadIndex", err)
+	}
+	p := FindIndexProblems(m)
+	if !slices.Equal(p.Missing, []int{2}) || !slices.Equal(p.OutOfRange, []int{3}) {
+		t.Errorf("problems = %+v, want missing 2 and out of range 3", p)
+	}
+}
+
+func TestFixIndexes(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		for _, strategy := range []string{FixIndexReject, FixIndexRenumber} {
+			t.Run(fmt.Sprintf("encrypted=%t/%s", encrypted, strategy), func(t *testing.T) {
+				m := indexTestManifest(t, encrypted)
+				m.Chunks[2].Index = 1
+
+				if strategy == FixIndexReject {
+					if err := CheckIndexes(m); !errors.Is(err, ErrBadIndex) {
+						t.Fatalf("CheckIndexes = %v, want ErrBadIndex", err)
+					}
+					if got := chunkIndexes(m); !slices.Equal(got, []int{0, 1, 1}) {
+						t.Errorf("indexes = %v, rejecting changed them", got)
+					}
+					return
+				}
+
+				fixed, res, err := FixIndexes(m)
+				if err != nil {
+					t.Fatalf("FixIndexes: %v", err)
+				}
+				if res.Renumbered != 1 || res.Folded != 0 {
+					t.Errorf("result = %+v, want 1 renumbered and none folded", res)
+				}
+				if got := chunkIndexes(fixed); !slices.Equal(got, []int{0, 1, 2}) {
+					t.Errorf("indexes = %v, want [0 1 2]", got)
+				}
+				if fixed.Chunks[2].ID != "chunk-2" {
+					t.Errorf("last chunk is %s, want chunk-2", fixed.Chunks[2].ID)
+				}
+				if err := CheckIndexes(fixed); err != nil {
+					t.Errorf("CheckIndexes after renumbering: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestFixIndexesFoldsRepeatedEntry(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%t", encrypted), func(t *testing.T) {
+			m := indexTestManifest(t, encrypted)
+			m.Chunks = append(m.Chunks, m.Chunks[1])
+			if err := CheckIndexes(m); !errors.Is(err, ErrBadIndex) {
+				t.Fatalf("CheckIndexes = %v, want ErrBadIndex", err)
+			}
+
+			fixed, res, err := FixIndexes(m)
+			if err != nil {
+				t.Fatalf("FixIndexes: %v", err)
+			}
+			if res.Folded != 1 || len(fixed.Chunks) != 3 {
+				t.Errorf("result = %+v with %d chunks, want 1 folded and 3 chunks", res, len(fixed.Chunks))
+			}
+		})
+	}
+}
+
+func TestFixIndexesRefusesMissingChunk(t *testing.T) {
+	m := indexTestManifest(t, true)
+	m.Chunks = []ChunkInfo{m.Chunks[0], m.Chunks[2]}
+
+	if _, _, err := FixIndexes(m); err == nil {
+		t.Fatal("FixIndexes renumbered a manifest with a chunk missing")
+	}
+}
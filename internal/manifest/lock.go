@@ -0,0 +1,103 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned by AcquireLock when another process holds the manifest's lock
+var ErrLocked = errors.New("manifest is locked")
+
+// staleLockAge is how old a lock held from another host must be before it is
+// considered abandoned, since its process can't be checked from here
+const staleLockAge = 24 * time.Hour
+
+// Lock keeps other chunk-store processes from writing a manifest at the same
+// time. It is held by creating <manifest>.lock, which records the owner's PID,
+// host and start time.
+type Lock struct {
+	path string
+}
+
+// AcquireLock locks the manifest at manifestPath, failing fast with ErrLocked
+// if another live process holds it. A lock left behind by a process that has
+// exited is removed and taken over.
+func AcquireLock(manifestPath string) (*Lock, error) {
+	path := manifestPath + ".lock"
+	host, _ := os.Hostname()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n%s\n%s\n", os.Getpid(), host, time.Now().Format(time.RFC3339))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file: %w", err)
+			}
+			return &Lock{path: path}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		pid, owner, since, err := readLock(path)
+		if err == nil && !lockIsStale(pid, owner, host, since) {
+			return nil, fmt.Errorf("%w by PID %d on %s since %s (remove %s if that process is gone)",
+				ErrLocked, pid, owner, since.Format(time.RFC3339), path)
+		}
+
+		// The owner is gone, or the lock is unreadable: take it over
+		fmt.Printf("Removing stale lock %s\n", path)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale lock: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s was recreated by another process", ErrLocked, path)
+}
+
+// Release removes the lock file
+func (l *Lock) Release() error {
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readLock parses the PID, host and start time recorded in a lock file
+func readLock(path string) (int, string, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+	fields := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(fields) != 3 {
+		return 0, "", time.Time{}, fmt.Errorf("malformed lock file")
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+	since, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+	return pid, fields[1], since, nil
+}
+
+// lockIsStale reports whether a lock's owner has gone: its process no longer
+// exists on this host or, when that can't be checked, the lock is older than staleLockAge
+func lockIsStale(pid int, owner, host string, since time.Time) bool {
+	if owner == host {
+		if alive, known := processAlive(pid); known {
+			return !alive
+		}
+	}
+	return time.Since(since) > staleLockAge
+}
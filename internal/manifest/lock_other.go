@@ -0,0 +1,9 @@
+//go:build !unix
+
+package manifest
+
+// processAlive can't check processes on this platform, so locks are only
+// considered stale by age
+func processAlive(pid int) (alive, known bool) {
+	return false, false
+}
@@ -1,31 +1,141 @@
 package manifest
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 type ChunkInfo struct {
-	ID         string            `json:"id"`
-	Hash       string            `json:"hash"`
-	Index      int               `json:"index"`
-	Encrypted  bool              `json:"encrypted"`
-	CloudPaths []string          `json:"cloud_paths"` // Multiple cloud storage paths
-	Providers  []string          `json:"providers"`   // Cloud providers storing this chunk
-	Size       int64             `json:"size"`
-	UploadTime string            `json:"upload_time"`
-	CloudIDs   map[string]string `json:"cloud_ids,omitempty"` // Map of provider -> file ID (e.g., "gdrive" -> "1ABC123...")
+	ID               string            `json:"id"`
+	Hash             string            `json:"hash"`                  // SHA-256 of the plaintext
+	CipherHash       string            `json:"cipher_hash,omitempty"` // SHA-256 of the stored chunk file
+	Index            int               `json:"index"`
+	Encrypted        bool              `json:"encrypted"`
+	Nonce            string            `json:"nonce,omitempty"`             // Base64 encryption nonce when it isn't prepended to the stored chunk
+	CloudPaths       []string          `json:"cloud_paths"`                 // Multiple cloud storage paths
+	Providers        []string          `json:"providers"`                   // Cloud providers storing this chunk
+	Size             int64             `json:"size"`                        // Stored (possibly compressed and encrypted) size
+	HeaderSize       int64             `json:"header_size,omitempty"`       // Bytes of chunk header in front of the stored chunk, 0 without one
+	PlainSize        int64             `json:"plain_size,omitempty"`        // Original plaintext size
+	Zero             bool              `json:"zero,omitempty"`              // Chunk is all zero bytes and can be written as a hole
+	Compression      string            `json:"compression,omitempty"`       // What the chunk was compressed with before encryption, empty if stored as-is
+	CompressDecision string            `json:"compress_decision,omitempty"` // Why the chunk was or wasn't compressed (CompressDecision*), empty when compression was off
+	CompressRatio    float64           `json:"compress_ratio,omitempty"`    // Compressed / plain size, of the sample when the sample ruled compression out
+	UploadTime       string            `json:"upload_time"`                 // When the chunk finished uploading
+	Status           string            `json:"status,omitempty"`            // Where the chunk is in the upload process, see ChunkStatus*
+	CloudIDs         map[string]string `json:"cloud_ids,omitempty"`         // Map of provider -> file ID (e.g., "gdrive" -> "1ABC123...")
+}
+
+// Chunk upload states
+const (
+	ChunkStatusLocal     = "local"     // Stored locally, not uploaded yet
+	ChunkStatusUploading = "uploading" // Upload in progress
+	ChunkStatusUploaded  = "uploaded"  // Stored on every destination provider
+	ChunkStatusPartial   = "partial"   // Stored on some but not all destination providers
+	ChunkStatusFailed    = "failed"    // Upload failed on every destination provider
+)
+
+// Chunk compression
+const (
+	CompressionDeflate = "deflate" // DEFLATE (RFC 1951)
+
+	CompressDecisionCompressed     = "compressed"     // Stored compressed
+	CompressDecisionIncompressible = "incompressible" // A sample of the chunk barely compressed, so it wasn't tried
+	CompressDecisionNoGain         = "no_gain"        // Compressing the chunk didn't make it smaller
+)
+
+// Chunking modes
+const (
+	ChunkingFixed  = "fixed"  // Every chunk is ChunkSize bytes except the last
+	ChunkingRecord = "record" // Chunks are extended from ChunkSize bytes to the end of a line, so records aren't split
+)
+
+// Chunk naming schemes
+const (
+	NamingHash = ""            // Chunk IDs are the chunk hash, or its first 16 hex characters
+	NamingHMAC = "hmac-sha256" // Chunk IDs are an HMAC-SHA256 of the chunk hash under NameKey, see ChunkName
+)
+
+// Chunk file layouts
+const (
+	LayoutFlat = ""    // <dir>/<id>.chunk, one directory per file
+	LayoutCAS  = "cas" // <store>/<hash[:2]>/<hash>.chunk, shared content-addressed store
+)
+
+// DefaultChunkExtension is the extension of chunk files when the manifest doesn't record one
+const DefaultChunkExtension = ".chunk"
+
+// ShardInfo references a slice of the chunk list stored in its own file
+type ShardInfo struct {
+	File       string            `json:"file"`        // Shard file name, relative to the root manifest
+	FirstIndex int               `json:"first_index"` // Index of the first chunk in the shard
+	ChunkCount int               `json:"chunk_count"`
+	Hash       string            `json:"hash"`                // SHA-256 of the shard file
+	Providers  []string          `json:"providers,omitempty"` // Cloud providers storing this shard
+	CloudIDs   map[string]string `json:"cloud_ids,omitempty"` // Map of provider -> file ID
 }
 
 type Manifest struct {
-	OriginalName     string      `json:"original_name"`
-	Chunks           []ChunkInfo `json:"chunks"`
-	Encrypted        bool        `json:"encrypted"`
-	CreatedTime      string      `json:"created_time"`
-	TotalSize        int64       `json:"total_size"`
-	ChunkCount       int         `json:"chunk_count"`
-	DistributionMode string      `json:"distribution_mode"` // "local", "cloud", "hybrid"
+	OriginalName     string            `json:"original_name"`
+	Chunks           []ChunkInfo       `json:"chunks"`
+	Encrypted        bool              `json:"encrypted"`
+	PasswordCheck    string            `json:"password_check,omitempty"` // Encrypted known value for verifying the password up front
+	WrappedKey       string            `json:"wrapped_key,omitempty"`    // Random file key the chunks are encrypted with, encrypted by the password-derived key
+	KeyringID        string            `json:"keyring_id,omitempty"`     // ID of the file's own random password in the OS keyring, see encryption.NewKeyringPassword
+	Cipher           string            `json:"cipher,omitempty"`         // Cipher of encrypted chunks; empty means AES-256-GCM
+	KDF              string            `json:"kdf,omitempty"`            // How the key is derived from the password; empty means SHA-256
+	AAD              string            `json:"aad,omitempty"`            // What encrypted chunks are bound to as GCM additional data; empty means nothing
+	NonceSize        int               `json:"nonce_size,omitempty"`     // Bytes of GCM nonce each encrypted chunk was encrypted with; 0 means 12
+	HashAlgo         string            `json:"hash_algo,omitempty"`      // Algorithm of chunk IDs, chunk hashes and FileHash; empty means SHA-256
+	FileHash         string            `json:"file_hash,omitempty"`      // Hash of the whole original file
+	MerkleRoot       string            `json:"merkle_root,omitempty"`    // Root of a Merkle tree over the chunk hashes, see MerkleRoot
+	CreatedTime      string            `json:"created_time"`
+	ToolVersion      string            `json:"tool_version,omitempty"` // Version of chunk-store that created the manifest, see Version
+	TotalSize        int64             `json:"total_size"`
+	BaseOffset       int64             `json:"base_offset,omitempty"` // Offset in the file where a range manifest's chunks start
+	FileSize         int64             `json:"file_size,omitempty"`   // Size of the whole file a range manifest was split from; 0 when the chunks cover all of it
+	ChunkCount       int               `json:"chunk_count"`
+	DistributionMode string            `json:"distribution_mode"`         // "local", "cloud", "hybrid"
+	CloudFolders     []CloudFolder     `json:"cloud_folders,omitempty"`   // Folder each account's chunks were uploaded into, for finding them by hand
+	Erasure          *Erasure          `json:"erasure,omitempty"`         // Reed-Solomon parity chunks the chunks can be rebuilt from, nil without parity
+	ChunkLayout      string            `json:"chunk_layout,omitempty"`    // How chunk files are laid out on disk (LayoutFlat or LayoutCAS)
+	ChunkExtension   string            `json:"chunk_extension,omitempty"` // Extension of LayoutFlat chunk files, with the dot; empty means DefaultChunkExtension
+	ChunkSize        int64             `json:"chunk_size,omitempty"`      // Chunk size the file was split with, 0 if unknown or mixed
+	NumChunks        int               `json:"num_chunks,omitempty"`      // Number of chunks the split was asked for, which ChunkSize was computed from; 0 when split by size
+	ChunkingMode     string            `json:"chunking_mode,omitempty"`   // How chunk boundaries were chosen (ChunkingFixed or ChunkingRecord)
+	ChunkNaming      string            `json:"chunk_naming,omitempty"`    // How chunk IDs are derived from chunk hashes (NamingHash or NamingHMAC)
+	NameKey          string            `json:"name_key,omitempty"`        // Hex key of NamingHMAC chunk names
+	Tags             map[string]string `json:"tags,omitempty"`            // Free-form key/value labels for downstream tooling
+	LastVerified     string            `json:"last_verified,omitempty"`   // When the file last passed -mode verify
+	Verifications    []Verification    `json:"verifications,omitempty"`   // Recent verification runs, oldest first
+	ShardSize        int               `json:"shard_size,omitempty"`      // Max chunks per shard; 0 keeps the chunk list inline
+	Shards           []ShardInfo       `json:"shards,omitempty"`          // Chunk-list shards when the manifest is sharded
+	StripMetadata    bool              `json:"strip_metadata,omitempty"`  // Leave out the file name and timestamps whenever the manifest is saved
+	Format           string            `json:"-"`                         // File format to save in (Format*), set to the one it was read in
+}
+
+// CloudFolder is the folder an account's chunks were uploaded into. Chunks are
+// found by their CloudIDs, so it is only informational.
+type CloudFolder struct {
+	Provider string `json:"provider"`
+	Account  string `json:"account"`
+	Folder   string `json:"folder"`       // Name or path of the folder
+	ID       string `json:"id,omitempty"` // ID of the folder, on providers that have them
+}
+
+// IsRange reports whether m only covers TotalSize bytes of a larger file,
+// starting at BaseOffset
+func (m Manifest) IsRange() bool {
+	return m.FileSize > 0
+}
+
+// shardFile is the on-disk form of a single manifest shard
+type shardFile struct {
+	Chunks []ChunkInfo `json:"chunks"`
 }
 
 func WriteManifest(chunks []ChunkInfo, path string, original string, encrypted bool) error {
@@ -33,38 +143,232 @@ func WriteManifest(chunks []ChunkInfo, path string, original string, encrypted b
 }
 
 func WriteManifestWithMode(chunks []ChunkInfo, path string, original string, encrypted bool, distributionMode string) error {
-	m := Manifest{
+	return Save(NewManifest(chunks, original, encrypted, distributionMode), path)
+}
+
+// NewManifest builds a manifest for the given chunks, stamped with the current
+// time and the version of this build
+func NewManifest(chunks []ChunkInfo, original string, encrypted bool, distributionMode string) Manifest {
+	return Manifest{
 		OriginalName:     original,
 		Chunks:           chunks,
 		Encrypted:        encrypted,
 		CreatedTime:      time.Now().Format(time.RFC3339),
-		ChunkCount:       len(chunks),
+		ToolVersion:      Version(),
 		DistributionMode: distributionMode,
 	}
+}
+
+// Save writes a manifest to path. When ShardSize is set and the chunk list is
+// larger than it, the chunks are written to separate shard files next to path
+// and the root manifest only references them. A StripMetadata manifest is
+// saved without its file name and timestamps.
+func Save(m Manifest, path string) error {
+	if m.StripMetadata {
+		m = m.stripped()
+	}
+	m.ChunkCount = len(m.Chunks)
 
 	// Calculate total size
 	var totalSize int64
-	for _, chunk := range chunks {
+	for _, chunk := range m.Chunks {
 		totalSize += chunk.Size
 	}
 	m.TotalSize = totalSize
 
-	data, err := json.MarshalIndent(m, "", "	")
+	format := formatFor(m, path)
+	var shards []ShardInfo
+	if m.ShardSize > 0 && len(m.Chunks) > m.ShardSize {
+		var err error
+		shards, err = writeShards(m, path, format)
+		if err != nil {
+			return err
+		}
+		m.Chunks = nil
+	}
+	m.Shards = shards
+
+	if err := removeStaleShards(path, shards); err != nil {
+		return err
+	}
+
+	data, err := encode(m, format)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed write never leaves a truncated manifest
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeShards writes the chunk list in ShardSize pieces and returns their references.
+// Cloud locations of unchanged shards are carried over from m.Shards.
+func writeShards(m Manifest, path, format string) ([]ShardInfo, error) {
+	previous := make(map[string]ShardInfo)
+	for _, shard := range m.Shards {
+		previous[shard.File] = shard
+	}
+
+	var shards []ShardInfo
+	for start := 0; start < len(m.Chunks); start += m.ShardSize {
+		end := min(start+m.ShardSize, len(m.Chunks))
+
+		data, err := encode(shardFile{Chunks: m.Chunks[start:end]}, format)
+		if err != nil {
+			return nil, err
+		}
+
+		name := shardName(path, len(shards), format)
+		if err := os.WriteFile(filepath.Join(filepath.Dir(path), name), data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write manifest shard %s: %w", name, err)
+		}
+
+		hash := sha256.Sum256(data)
+		shard := ShardInfo{
+			File:       name,
+			FirstIndex: m.Chunks[start].Index,
+			ChunkCount: end - start,
+			Hash:       fmt.Sprintf("%x", hash[:]),
+		}
+		if prev, ok := previous[name]; ok && prev.Hash == shard.Hash {
+			shard.Providers = prev.Providers
+			shard.CloudIDs = prev.CloudIDs
+		}
+		shards = append(shards, shard)
+	}
+
+	return shards, nil
+}
+
+// shardName returns the file name of the n-th shard for the manifest at path,
+// saved in format
+func shardName(path string, n int, format string) string {
+	base := filepath.Base(path)
+	ext := ".json"
+	if format == FormatBinary {
+		ext = ".bin"
+	}
+	return fmt.Sprintf("%s.shard-%04d%s", strings.TrimSuffix(base, filepath.Ext(base)), n, ext)
+}
+
+// removeStaleShards deletes shard files left over from an earlier, larger write
+func removeStaleShards(path string, keep []ShardInfo) error {
+	base := filepath.Base(path)
+	pattern := filepath.Join(filepath.Dir(path), strings.TrimSuffix(base, filepath.Ext(base))+".shard-*")
+	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	kept := make(map[string]bool)
+	for _, shard := range keep {
+		kept[shard.File] = true
+	}
+
+	for _, match := range matches {
+		if !kept[filepath.Base(match)] {
+			if err := os.Remove(match); err != nil {
+				return fmt.Errorf("failed to remove stale manifest shard: %w", err)
+			}
+		}
+	}
+	return nil
 }
 
+// ReadManifest reads a manifest and the chunk lists of its shards. path may be
+// an http(s) URL, see ReadManifestRoot.
 func ReadManifest(path string) (Manifest, error) {
+	m, err := ReadManifestRoot(path)
+	if err != nil {
+		return m, err
+	}
+
+	// Load the chunk list from shards if the manifest is sharded
+	for _, shard := range m.Shards {
+		chunks, err := readShard(ShardPath(path, shard), shard.Hash)
+		if err != nil {
+			return m, err
+		}
+		if len(chunks) != shard.ChunkCount {
+			return m, fmt.Errorf("manifest shard %s: expected %d chunks, found %d", shard.File, shard.ChunkCount, len(chunks))
+		}
+		m.Chunks = append(m.Chunks, chunks...)
+	}
+
+	return m, nil
+}
+
+// ReadManifestRoot reads a manifest without loading any shard files it
+// references. path may be an http(s) URL, with shards next to it.
+func ReadManifestRoot(path string) (Manifest, error) {
 	var m Manifest
 
-	data, err := os.ReadFile(path)
+	data, err := readFile(path)
 	if err != nil {
 		return m, err
 	}
 
-	err = json.Unmarshal(data, &m)
+	m.Format, err = decode(data, &m)
+	if err != nil && IsURL(path) {
+		err = fmt.Errorf("%s isn't a manifest: %w", path, err)
+	}
+	if err == nil {
+		warnIncompatible(m)
+	}
 	return m, err
 }
+
+// ChunkPath returns the local path of a chunk file under dir for this manifest's layout
+func (m Manifest) ChunkPath(dir string, c ChunkInfo) string {
+	if m.ChunkLayout == LayoutCAS {
+		return StorePath(dir, c.ID)
+	}
+	return filepath.Join(dir, c.ID+m.Extension())
+}
+
+// Extension returns the extension of the manifest's chunk files, with the dot
+func (m Manifest) Extension() string {
+	if m.ChunkExtension == "" || m.ChunkLayout == LayoutCAS {
+		return DefaultChunkExtension
+	}
+	return m.ChunkExtension
+}
+
+// StorePath returns the path of a chunk in a shared content-addressed store,
+// fanned out by the first two hex characters of its hash
+func StorePath(store, hash string) string {
+	return filepath.Join(store, hash[:2], hash+".chunk")
+}
+
+// ShardPath returns the local path of a shard belonging to the manifest at
+// manifestPath, or its URL when the manifest is read from one
+func ShardPath(manifestPath string, shard ShardInfo) string {
+	if IsURL(manifestPath) {
+		return resolveURL(manifestPath, shard.File)
+	}
+	return filepath.Join(filepath.Dir(manifestPath), shard.File)
+}
+
+// readShard reads the chunk list from a single shard file after checking its hash
+func readShard(path, expectedHash string) ([]ChunkInfo, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest shard: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	if fmt.Sprintf("%x", hash[:]) != expectedHash {
+		return nil, fmt.Errorf("%w on manifest shard: %s", ErrHashMismatch, filepath.Base(path))
+	}
+
+	var shard shardFile
+	if _, err := decode(data, &shard); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest shard %s: %w", filepath.Base(path), err)
+	}
+	return shard.Chunks, nil
+}
@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Merge concatenates the chunk lists of manifests produced by separate runs
+// over the same file, in the order given, and renumbers Index contiguously.
+// All manifests must describe the same file with the same encryption and
+// chunk layout settings. The merged manifest has no FileHash, since the
+// concatenation's hash isn't known without reading the data, but its
+// MerkleRoot is recomputed from the chunk hashes. Parity chunks are left
+// out, renumbering breaks up their groups.
+func Merge(manifests ...Manifest) (Manifest, error) {
+	if len(manifests) == 0 {
+		return Manifest{}, fmt.Errorf("no manifests to merge")
+	}
+
+	first := manifests[0]
+	merged := Manifest{
+		OriginalName:     first.OriginalName,
+		Encrypted:        first.Encrypted,
+		PasswordCheck:    first.PasswordCheck,
+		WrappedKey:       first.WrappedKey,
+		Cipher:           first.Cipher,
+		KDF:              first.KDF,
+		AAD:              first.AAD,
+		NonceSize:        first.NonceSize,
+		HashAlgo:         first.HashAlgo,
+		CreatedTime:      time.Now().Format(time.RFC3339),
+		ToolVersion:      Version(),
+		DistributionMode: first.DistributionMode,
+		ChunkLayout:      first.ChunkLayout,
+		ShardSize:        first.ShardSize,
+		ChunkSize:        first.ChunkSize,
+		ChunkingMode:     first.ChunkingMode,
+	}
+
+	for i, m := range manifests {
+		if m.OriginalName != first.OriginalName {
+			return Manifest{}, fmt.Errorf("manifest %d is for %q, expected %q", i+1, m.OriginalName, first.OriginalName)
+		}
+		if m.IsRange() {
+			return Manifest{}, fmt.Errorf("manifest %d only covers a range of the file and can't be merged", i+1)
+		}
+		if err := CheckIndexes(m); err != nil {
+			return Manifest{}, fmt.Errorf("manifest %d: %w", i+1, err)
+		}
+		if m.Encrypted != first.Encrypted {
+			return Manifest{}, fmt.Errorf("manifest %d has encrypted=%t, expected %t", i+1, m.Encrypted, first.Encrypted)
+		}
+		if m.WrappedKey != first.WrappedKey {
+			return Manifest{}, fmt.Errorf("manifest %d encrypts its chunks with a different file key", i+1)
+		}
+		if m.Cipher != first.Cipher || m.KDF != first.KDF || m.AAD != first.AAD || m.NonceSize != first.NonceSize {
+			return Manifest{}, fmt.Errorf("manifest %d uses a different cipher, key derivation, AAD or nonce size", i+1)
+		}
+		if m.HashAlgo != first.HashAlgo {
+			return Manifest{}, fmt.Errorf("manifest %d uses hash algorithm %q, expected %q", i+1, m.HashAlgo, first.HashAlgo)
+		}
+		if m.ChunkLayout != first.ChunkLayout {
+			return Manifest{}, fmt.Errorf("manifest %d uses chunk layout %q, expected %q", i+1, m.ChunkLayout, first.ChunkLayout)
+		}
+		if m.DistributionMode != merged.DistributionMode {
+			merged.DistributionMode = "hybrid"
+		}
+		if m.ChunkSize != merged.ChunkSize || m.ChunkingMode != merged.ChunkingMode {
+			// Parts split with different settings have no single chunk size
+			merged.ChunkSize = 0
+		}
+
+		// Tags are combined, the first manifest wins on conflicting values
+		for k, v := range m.Tags {
+			if _, ok := merged.Tags[k]; ok {
+				continue
+			}
+			if merged.Tags == nil {
+				merged.Tags = make(map[string]string)
+			}
+			merged.Tags[k] = v
+		}
+
+		chunks := append([]ChunkInfo(nil), m.Chunks...)
+		sort.Slice(chunks, func(a, b int) bool {
+			return chunks[a].Index < chunks[b].Index
+		})
+		for _, c := range chunks {
+			c.Index = len(merged.Chunks)
+			merged.Chunks = append(merged.Chunks, c)
+		}
+	}
+
+	root, err := ComputeMerkleRoot(merged)
+	if err != nil {
+		return Manifest{}, err
+	}
+	merged.MerkleRoot = root
+	return merged, nil
+}
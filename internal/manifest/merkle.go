@@ -0,0 +1,174 @@
+package manifest
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Merkle tree node prefixes, so a leaf can never be passed off as an inner node
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// ProofStep is one sibling on the path from a chunk's leaf to the Merkle root
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // The sibling is on the left, so it is hashed first
+}
+
+// MerkleRoot computes the root of a Merkle tree over chunk hashes, in chunk
+// order, using algo. Leaves hash the chunk hash with a 0x00 prefix and inner
+// nodes hash their children with a 0x01 prefix. A node without a sibling is
+// carried up a level unchanged. There is no root without chunks.
+func MerkleRoot(algo string, hashes []string) (string, error) {
+	if len(hashes) == 0 {
+		return "", nil
+	}
+	level, err := merkleLeaves(algo, hashes)
+	if err != nil {
+		return "", err
+	}
+	for len(level) > 1 {
+		if level, err = merkleParents(algo, level); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(level[0]), nil
+}
+
+// ComputeMerkleRoot computes the Merkle root of a manifest's chunks, ordered
+// by Index, with the manifest's hash algorithm
+func ComputeMerkleRoot(m Manifest) (string, error) {
+	return MerkleRoot(m.HashAlgo, orderedHashes(m.Chunks))
+}
+
+// VerifyMerkle recomputes the Merkle root from the manifest's chunks and
+// checks it against MerkleRoot. The full chunk list must be loaded, as
+// ReadManifest does.
+func VerifyMerkle(m Manifest) error {
+	if m.MerkleRoot == "" {
+		return fmt.Errorf("manifest has no Merkle root")
+	}
+	root, err := ComputeMerkleRoot(m)
+	if err != nil {
+		return err
+	}
+	if root != m.MerkleRoot {
+		return fmt.Errorf("%w: Merkle root is %s, manifest records %s", ErrHashMismatch, root, m.MerkleRoot)
+	}
+	return nil
+}
+
+// MerkleProof returns the proof of inclusion of the chunk at index, the
+// siblings from its leaf up to the root, for VerifyMerkleProof
+func MerkleProof(m Manifest, index int) ([]ProofStep, error) {
+	hashes := orderedHashes(m.Chunks)
+	if index < 0 || index >= len(hashes) {
+		return nil, fmt.Errorf("chunk index %d out of range (%d chunks)", index, len(hashes))
+	}
+
+	level, err := merkleLeaves(m.HashAlgo, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	var proof []ProofStep
+	for pos := index; len(level) > 1; pos /= 2 {
+		sibling := pos ^ 1
+		if sibling < len(level) {
+			proof = append(proof, ProofStep{Hash: hex.EncodeToString(level[sibling]), Left: sibling < pos})
+		}
+		if level, err = merkleParents(m.HashAlgo, level); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that a chunk hash is part of the tree with the
+// given root, using a proof from MerkleProof
+func VerifyMerkleProof(algo, chunkHash string, proof []ProofStep, root string) error {
+	node, err := merkleLeaves(algo, []string{chunkHash})
+	if err != nil {
+		return err
+	}
+	current := node[0]
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return fmt.Errorf("invalid proof hash %q: %w", step.Hash, err)
+		}
+		if step.Left {
+			current, err = merkleHash(algo, merkleNodePrefix, sibling, current)
+		} else {
+			current, err = merkleHash(algo, merkleNodePrefix, current, sibling)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if got := hex.EncodeToString(current); got != root {
+		return fmt.Errorf("%w: proof leads to Merkle root %s, expected %s", ErrHashMismatch, got, root)
+	}
+	return nil
+}
+
+// orderedHashes returns the chunk hashes sorted by chunk Index
+func orderedHashes(chunks []ChunkInfo) []string {
+	sorted := append([]ChunkInfo(nil), chunks...)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Index < sorted[b].Index
+	})
+	hashes := make([]string, len(sorted))
+	for i, c := range sorted {
+		hashes[i] = c.Hash
+	}
+	return hashes
+}
+
+// merkleLeaves hashes each hex chunk hash into a leaf
+func merkleLeaves(algo string, hashes []string) ([][]byte, error) {
+	leaves := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		raw, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk hash %q: %w", h, err)
+		}
+		if leaves[i], err = merkleHash(algo, merkleLeafPrefix, raw); err != nil {
+			return nil, err
+		}
+	}
+	return leaves, nil
+}
+
+// merkleParents hashes a tree level into the level above it
+func merkleParents(algo string, level [][]byte) ([][]byte, error) {
+	parents := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+			continue
+		}
+		parent, err := merkleHash(algo, merkleNodePrefix, level[i], level[i+1])
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, parent)
+	}
+	return parents, nil
+}
+
+// merkleHash hashes a prefix byte followed by parts
+func merkleHash(algo string, prefix byte, parts ...[]byte) ([]byte, error) {
+	h, err := NewHasher(algo)
+	if err != nil {
+		return nil, err
+	}
+	h.Write([]byte{prefix})
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,170 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Recipe export formats
+const (
+	RecipeFormatShell = "sh"   // POSIX shell script that restores the file with curl and openssl
+	RecipeFormatURLs  = "urls" // One download link per chunk, in file order
+)
+
+// LinkFunc returns links anyone can download the stored copies of a chunk from
+type LinkFunc func(c ChunkInfo) ([]string, error)
+
+// ValidateRecipeFormat checks that format is one ExportRecipe writes
+func ValidateRecipeFormat(format string) error {
+	switch format {
+	case RecipeFormatShell, RecipeFormatURLs, "":
+		return nil
+	}
+	return fmt.Errorf("unknown recipe format: %s (expected %s or %s)", format, RecipeFormatShell, RecipeFormatURLs)
+}
+
+// ExportRecipe writes a recipe for restoring the file m describes without
+// chunk-store to w: a shell script that downloads every chunk from the links
+// returned by links, checks it against its hash and appends it to the output,
+// or just the links. Only chunks stored as-is can be restored that way:
+// openssl can't decrypt AES-GCM from the command line, and DEFLATE chunks
+// aren't zlib streams, so encrypted and compressed chunks are refused, as are
+// range manifests, which only patch an existing copy of the file.
+func ExportRecipe(m Manifest, w io.Writer, format string, links LinkFunc) error {
+	if err := ValidateRecipeFormat(format); err != nil {
+		return err
+	}
+	if m.Encrypted {
+		return fmt.Errorf("file is encrypted, which a recipe can't restore: openssl can't decrypt AES-GCM")
+	}
+	if m.IsRange() {
+		return fmt.Errorf("manifest covers only part of a file, a recipe can only restore whole files")
+	}
+
+	chunks := append([]ChunkInfo(nil), m.Chunks...)
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+	for _, c := range chunks {
+		if c.Compression != "" {
+			return fmt.Errorf("chunk %s is compressed with %s, which a recipe can't restore", c.ID, c.Compression)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if format == RecipeFormatURLs {
+		for _, c := range chunks {
+			if c.Zero {
+				return fmt.Errorf("chunk %s is a hole that isn't stored, use the sh recipe", c.ID)
+			}
+			if c.HeaderSize > 0 {
+				return fmt.Errorf("chunk %s has a chunk header, use the sh recipe", c.ID)
+			}
+			l, err := links(c)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(bw, l[0])
+		}
+		return bw.Flush()
+	}
+
+	// Chunk hashes can only be checked with openssl when they're SHA-256
+	checked := m.HashAlgo == "" || m.HashAlgo == HashSHA256
+	name := filepath.Base(m.OriginalName)
+	if m.OriginalName == "" {
+		name = "restored.bin"
+	}
+
+	fmt.Fprintf(bw, "#!/bin/sh\n# Restores %s (%d bytes in %d chunks) with curl and openssl.\n", name, m.TotalSize, len(chunks))
+	bw.WriteString("# Usage: sh <this script> [output file]\n")
+	fmt.Fprintf(bw, "set -eu\nout=${1:-%s}\n", shellQuote(name))
+	bw.WriteString(recipePrelude)
+	if checked {
+		bw.WriteString(recipeCheck)
+	} else {
+		bw.WriteString("check() { :; } # Chunk hashes are BLAKE3, which openssl can't check\n")
+	}
+	bw.WriteString("\n")
+
+	for _, c := range chunks {
+		if c.Zero {
+			size := c.PlainSize
+			if size == 0 {
+				size = c.Size
+			}
+			fmt.Fprintf(bw, "zero %d\n", size)
+			continue
+		}
+		l, err := links(c)
+		if err != nil {
+			return err
+		}
+		quoted := make([]string, len(l))
+		for i, link := range l {
+			quoted[i] = shellQuote(link)
+		}
+		fmt.Fprintf(bw, "chunk %d %s %d %s\n", c.Index, c.Hash, c.HeaderSize, strings.Join(quoted, " "))
+	}
+
+	if checked && m.FileHash != "" {
+		fmt.Fprintf(bw, "\ncheck \"$out\" %s \"$out\"\n", m.FileHash)
+	}
+	bw.WriteString("restored=1\necho \"Restored $out\"\n")
+	return bw.Flush()
+}
+
+// recipePrelude defines the functions the lines of a shell recipe call: chunk
+// downloads a chunk from the first of its links that works, checks it, strips
+// its header and appends it to the output, and zero appends zero bytes. The
+// output is removed again unless the script gets to the end.
+const recipePrelude = `
+if [ -e "$out" ]; then
+	echo "$out already exists" >&2
+	exit 1
+fi
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"; [ -n "${restored:-}" ] || rm -f "$out"' EXIT
+: > "$out"
+
+# chunk <index> <hash> <header size> <link>...
+chunk() {
+	index=$1 hash=$2 header=$3
+	shift 3
+	for link; do
+		if curl -fsSL --retry 3 -o "$tmp/chunk" "$link"; then
+			tail -c +$((header + 1)) "$tmp/chunk" > "$tmp/data"
+			check "$tmp/data" "$hash" "Chunk $index"
+			cat "$tmp/data" >> "$out"
+			echo "Chunk $index done" >&2
+			return
+		fi
+	done
+	echo "Chunk $index couldn't be downloaded from any of its links" >&2
+	exit 1
+}
+
+# zero <size>
+zero() {
+	head -c "$1" /dev/zero >> "$out"
+}
+`
+
+// recipeCheck defines check <file> <hash> <what>, which stops the script if
+// the file doesn't have the SHA-256 hash
+const recipeCheck = `
+check() {
+	sum=$(openssl dgst -sha256 "$1" | sed 's/^.*= //')
+	if [ "$sum" != "$2" ]; then
+		echo "$3 doesn't match its hash $2" >&2
+		exit 1
+	fi
+}
+`
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
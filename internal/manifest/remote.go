@@ -0,0 +1,65 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// maxRemoteManifestSize caps how much of a manifest or shard is read from a URL
+const maxRemoteManifestSize = 256 << 20
+
+// IsURL reports whether a manifest path is an http(s) URL rather than a local file
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// readFile reads a manifest or shard from a local path or an http(s) URL
+func readFile(path string) ([]byte, error) {
+	if !IsURL(path) {
+		return os.ReadFile(path)
+	}
+
+	resp, err := http.Get(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", path, resp.Status)
+	}
+
+	// A share link that needs signing in serves an HTML page instead
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ := mime.ParseMediaType(ct)
+		if mediaType == "text/html" {
+			return nil, fmt.Errorf("%s returned a web page instead of a manifest (is it a direct download link?)", path)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	if len(data) > maxRemoteManifestSize {
+		return nil, fmt.Errorf("%s is larger than %d MB, too large for a manifest", path, maxRemoteManifestSize>>20)
+	}
+	return data, nil
+}
+
+// resolveURL resolves a shard file name against the URL of its manifest
+func resolveURL(manifestURL, name string) string {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return manifestURL
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return manifestURL
+	}
+	return base.ResolveReference(ref).String()
+}
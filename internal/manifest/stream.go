@@ -0,0 +1,191 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ReadManifestHeader reads the manifest at path without its chunk list, which
+// is skipped one chunk at a time instead of being held in memory, so huge
+// manifests can be inspected and their chunks passed to StreamChunks.
+// Binary manifests are decoded whole.
+func ReadManifestHeader(path string) (Manifest, error) {
+	var m Manifest
+	err := streamFile(path, &m, nil)
+	m.Chunks = nil
+	if err == nil {
+		warnIncompatible(m)
+	}
+	return m, err
+}
+
+// StreamChunks calls fn with each chunk of the manifest at path, in the order
+// they are stored, decoding JSON manifests and their shards one chunk at a
+// time so memory use doesn't grow with the number of chunks. ReadManifest is
+// still the way to get the whole chunk list. A shard's hash is only checked
+// once all of its chunks have been passed to fn. Binary manifests and shards
+// are decoded whole, then streamed.
+func StreamChunks(path string, fn func(ChunkInfo) error) error {
+	var m Manifest
+	if err := streamFile(path, &m, fn); err != nil {
+		return err
+	}
+
+	for _, shard := range m.Shards {
+		count := 0
+		var s shardFile
+		err := streamFile(ShardPath(path, shard), &s, func(c ChunkInfo) error {
+			count++
+			return fn(c)
+		}, shard.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest shard: %w", err)
+		}
+		if count != shard.ChunkCount {
+			return fmt.Errorf("manifest shard %s: expected %d chunks, found %d", shard.File, shard.ChunkCount, count)
+		}
+	}
+	return nil
+}
+
+// streamFile decodes the manifest or shard at path into v, passing the
+// entries of its chunk list to fn instead when fn is set. If expectedHash is
+// given, the file's SHA-256 must match it.
+func streamFile(path string, v any, fn func(ChunkInfo) error, expectedHash ...string) error {
+	var r io.Reader
+	if IsURL(path) {
+		data, err := readFile(path)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(r, h))
+	format, err := streamDecode(br, v, fn)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	if m, ok := v.(*Manifest); ok {
+		m.Format = format
+	}
+
+	if len(expectedHash) > 0 {
+		// Hash whatever follows the decoded value too
+		if _, err := io.Copy(io.Discard, br); err != nil {
+			return err
+		}
+		if fmt.Sprintf("%x", h.Sum(nil)) != expectedHash[0] {
+			return fmt.Errorf("%w on manifest shard: %s", ErrHashMismatch, filepath.Base(path))
+		}
+	}
+	return nil
+}
+
+// streamDecode is decode for a reader, handing the chunks of a JSON chunk list
+// to fn as they are decoded when fn is set
+func streamDecode(br *bufio.Reader, v any, fn func(ChunkInfo) error) (string, error) {
+	if magic, _ := br.Peek(len(binaryMagic)); bytes.Equal(magic, binaryMagic) {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return "", err
+		}
+		format, err := decode(data, v)
+		if err != nil || fn == nil {
+			return format, err
+		}
+		return format, eachChunk(v, fn)
+	}
+
+	// Indented JSON breaks the line right after the opening brace
+	format := FormatCompact
+	if start, _ := br.Peek(2); bytes.Contains(start, []byte("\n")) {
+		format = FormatJSON
+	}
+
+	dec := json.NewDecoder(br)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return "", fmt.Errorf("not a JSON object")
+	}
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		key, _ := t.(string)
+		if key != "chunks" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return "", err
+			}
+			fields[key] = raw
+			continue
+		}
+
+		if t, err = dec.Token(); err != nil {
+			return "", err
+		}
+		if t == nil {
+			continue
+		}
+		if t != json.Delim('[') {
+			return "", fmt.Errorf("chunks is not a list")
+		}
+		for dec.More() {
+			var c ChunkInfo
+			if err := dec.Decode(&c); err != nil {
+				return "", err
+			}
+			if fn != nil {
+				if err := fn(c); err != nil {
+					return "", err
+				}
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return "", err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return "", err
+	}
+
+	// Decode everything but the chunk list the usual way
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return format, json.Unmarshal(data, v)
+}
+
+// eachChunk passes the chunk list of a decoded manifest or shard to fn
+func eachChunk(v any, fn func(ChunkInfo) error) error {
+	var chunks []ChunkInfo
+	switch v := v.(type) {
+	case *Manifest:
+		chunks, v.Chunks = v.Chunks, nil
+	case *shardFile:
+		chunks, v.Chunks = v.Chunks, nil
+	}
+	for _, c := range chunks {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package manifest
+
+// stripped returns a copy of m without the metadata a StripMetadata manifest
+// leaves out: the file name and every timestamp. Nothing assembly needs is
+// removed.
+func (m Manifest) stripped() Manifest {
+	m.OriginalName = ""
+	m.CreatedTime = ""
+	m.LastVerified = ""
+	m.Verifications = nil
+
+	// Copy the chunks, they are shared with the caller
+	chunks := make([]ChunkInfo, len(m.Chunks))
+	for i, c := range m.Chunks {
+		c.UploadTime = ""
+		chunks[i] = c
+	}
+	m.Chunks = chunks
+	if m.Erasure != nil {
+		e := *m.Erasure
+		e.Parity = make([]ChunkInfo, len(m.Erasure.Parity))
+		for i, c := range m.Erasure.Parity {
+			c.UploadTime = ""
+			e.Parity[i] = c
+		}
+		m.Erasure = &e
+	}
+	return m
+}
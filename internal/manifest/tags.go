@@ -0,0 +1,16 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseTag splits a "key=value" tag. The key can't be empty, the value can.
+func ParseTag(tag string) (string, string, error) {
+	key, value, ok := strings.Cut(tag, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid tag %q, expected key=value", tag)
+	}
+	return key, value, nil
+}
@@ -0,0 +1,57 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// ToolVersion is the chunk-store version recorded in the manifests it
+// creates. Release builds set it at link time:
+//
+//	go build -ldflags "-X github.com/probablysamir/chunk-store/internal/manifest.ToolVersion=v1.2.0" ./cmd
+//
+// When it isn't set, Version falls back to the version go install records.
+var ToolVersion string
+
+// Version returns the version of this build: ToolVersion, the module version
+// of a go install build, or "dev"
+func Version() string {
+	if ToolVersion != "" {
+		return ToolVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// incompatibleVersions maps tool versions known to write manifests this
+// version can't fully trust to why, for CompatibilityWarning. Add an entry
+// when a released version turns out to record something wrongly.
+var incompatibleVersions = map[string]string{}
+
+// CompatibilityWarning returns why m, as written by the tool version it
+// records, may not be read correctly, or "" if nothing is known against it.
+// Manifests from before versions were recorded have no warning.
+func (m Manifest) CompatibilityWarning() string {
+	if reason, ok := incompatibleVersions[m.ToolVersion]; ok {
+		return fmt.Sprintf("manifest was created by chunk-store %s: %s", m.ToolVersion, reason)
+	}
+	return ""
+}
+
+// warnedVersions holds the tool versions already warned about in this run
+var warnedVersions sync.Map
+
+// warnIncompatible prints m's CompatibilityWarning to stderr, once per tool version
+func warnIncompatible(m Manifest) {
+	warning := m.CompatibilityWarning()
+	if warning == "" {
+		return
+	}
+	if _, warned := warnedVersions.LoadOrStore(m.ToolVersion, true); !warned {
+		fmt.Fprintf(os.Stderr, "⚠️  %s\n", warning)
+	}
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/probablysamir/chunk-store/internal/chunker"
+	"github.com/probablysamir/chunk-store/internal/cloudstorage"
+	"github.com/probablysamir/chunk-store/internal/config"
+	"github.com/probablysamir/chunk-store/internal/encryption"
+	"github.com/probablysamir/chunk-store/internal/manifest"
+)
+
+// PasswordHeader carries the encryption password of a request. Without it,
+// files are split unencrypted.
+const PasswordHeader = "X-Chunk-Store-Password"
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// validID matches the IDs the server hands out, so IDs can't escape DataDir
+var validID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Options configures a Server
+type Options struct {
+	DataDir         string                                 // Where each file's chunks and manifest are kept, in <DataDir>/<id>/
+	Strategy        cloudstorage.CloudDistributionStrategy // Providers uploads go to
+	SplitOptions    chunker.SplitOptions
+	AssembleOptions chunker.AssembleOptions
+}
+
+// Server exposes split, upload and assemble over HTTP. Cloud clients are
+// initialized once, on first use, and shared by every request, so tokens
+// aren't set up again per call.
+type Server struct {
+	cfg  *config.Config
+	opts Options
+
+	mu       sync.Mutex // Guards uploader creation and serializes uploads, which share progress state
+	uploader *cloudstorage.CloudUploader
+}
+
+// fileInfo describes a stored file in responses
+type fileInfo struct {
+	ID               string            `json:"id"`
+	OriginalName     string            `json:"original_name"`
+	TotalSize        int64             `json:"total_size"`
+	ChunkCount       int               `json:"chunk_count"`
+	Encrypted        bool              `json:"encrypted"`
+	DistributionMode string            `json:"distribution_mode"`
+	CreatedTime      string            `json:"created_time"`
+	Tags             map[string]string `json:"tags,omitempty"`
+}
+
+// New creates a server for cfg
+func New(cfg *config.Config, opts Options) *Server {
+	return &Server{cfg: cfg, opts: opts}
+}
+
+// Handler returns the server's routes:
+//
+//	POST /files?name=NAME          split the request body, returns the file's ID
+//	GET  /files/{id}               describe a file
+//	POST /files/{id}/upload        upload a file's chunks to the cloud
+//	GET  /files/{id}/content       stream the assembled file, from the cloud for chunks not kept locally
+//	GET  /healthz                  liveness check
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /files", s.handleSplit)
+	mux.HandleFunc("GET /files/{id}", s.handleInfo)
+	mux.HandleFunc("POST /files/{id}/upload", s.handleUpload)
+	mux.HandleFunc("GET /files/{id}/content", s.handleAssemble)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// ListenAndServe serves on addr until ctx is done, then stops accepting
+// connections and waits for in-flight requests to finish
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if err := os.MkdirAll(s.opts.DataDir, 0755); err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	fmt.Printf("Serving on %s, storing files in %s\n", addr, s.opts.DataDir)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("Shutting down, waiting for requests in progress...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// handleSplit splits the request body into a new file
+func (s *Server) handleSplit(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	dir := s.fileDir(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	encConfig := requestEncryption(r)
+	err = chunker.SplitReaderToDir(r.Body, filepath.Base(name), s.chunksDir(id), s.manifestPath(id), encConfig, s.opts.SplitOptions)
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, err)
+		return
+	}
+
+	s.writeInfo(w, id, http.StatusCreated)
+}
+
+// handleInfo describes a stored file
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	s.writeInfo(w, id, http.StatusOK)
+}
+
+// handleUpload uploads a stored file's chunks to the cloud
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	lock, err := manifest.AcquireLock(s.manifestPath(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer lock.Release()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploader, err := s.cloudUploader()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := uploader.UploadChunks(s.chunksDir(id), s.manifestPath(id)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.writeInfo(w, id, http.StatusOK)
+}
+
+// handleAssemble streams a stored file. Chunks missing locally are read from
+// the cloud into memory. Once streaming has started an error can't change the
+// status any more, so the connection is aborted to show the output is incomplete.
+func (s *Server) handleAssemble(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	m, err := manifest.ReadManifestRoot(s.manifestPath(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// A password sent for an unencrypted file is ignored
+	encConfig := requestEncryption(r)
+	if m.Encrypted && !encConfig.Enabled {
+		http.Error(w, "file is encrypted, send the password in "+PasswordHeader, http.StatusUnauthorized)
+		return
+	}
+	if !m.Encrypted {
+		encConfig = encryption.CreateEncryptionConfig("", false)
+	}
+
+	chunksDir := s.chunksDir(id)
+	var uploader *cloudstorage.CloudUploader
+	if m.DistributionMode != "local" {
+		s.mu.Lock()
+		uploader, err = s.cloudUploader()
+		s.mu.Unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := uploader.DownloadManifestShards(s.manifestPath(id)); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	opts := s.opts.AssembleOptions
+	opts.Source = func(c manifest.ChunkInfo) ([]byte, error) {
+		data, err := os.ReadFile(m.ChunkPath(chunksDir, c))
+		if errors.Is(err, fs.ErrNotExist) && uploader != nil {
+			return uploader.ReadChunk(c)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", manifest.ErrChunkMissing, c.ID)
+		}
+		return data, err
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", m.OriginalName))
+	out := &trackingWriter{w: w}
+	err = chunker.AssembleFileToWriter(s.manifestPath(id), chunksDir, out, encConfig, opts)
+	if err != nil && !out.written {
+		w.Header().Del("Content-Disposition")
+		writeError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Assembling %s failed after streaming started: %v", id, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// cloudUploader returns the shared uploader, creating it on first use. The caller holds mu.
+func (s *Server) cloudUploader() (*cloudstorage.CloudUploader, error) {
+	if s.uploader != nil {
+		return s.uploader, nil
+	}
+	uploader, err := cloudstorage.CreateCloudUploader(s.opts.Strategy, s.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", cloudstorage.ErrProviderUnavailable, err)
+	}
+	s.uploader = uploader
+	return uploader, nil
+}
+
+// writeInfo responds with the description of a stored file
+func (s *Server) writeInfo(w http.ResponseWriter, id string, status int) {
+	m, err := manifest.ReadManifestRoot(s.manifestPath(id))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(fileInfo{
+		ID:               id,
+		OriginalName:     m.OriginalName,
+		TotalSize:        m.TotalSize,
+		ChunkCount:       m.ChunkCount,
+		Encrypted:        m.Encrypted,
+		DistributionMode: m.DistributionMode,
+		CreatedTime:      m.CreatedTime,
+		Tags:             m.Tags,
+	})
+}
+
+func (s *Server) fileDir(id string) string      { return filepath.Join(s.opts.DataDir, id) }
+func (s *Server) chunksDir(id string) string    { return filepath.Join(s.fileDir(id), "chunks") }
+func (s *Server) manifestPath(id string) string { return filepath.Join(s.fileDir(id), "manifest.json") }
+
+// trackingWriter records whether anything was written to the response yet
+type trackingWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.w.Write(p)
+}
+
+// pathID returns the {id} of the request, responding with an error if it isn't valid
+func pathID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.PathValue("id")
+	if !validID.MatchString(id) {
+		http.Error(w, "invalid file id", http.StatusBadRequest)
+		return "", false
+	}
+	return id, true
+}
+
+// newID returns a random file ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// requestEncryption returns the encryption config for the request's password header
+func requestEncryption(r *http.Request) *encryption.EncryptionConfig {
+	password := r.Header.Get(PasswordHeader)
+	return encryption.CreateEncryptionConfig(password, password != "")
+}
+
+// writeError responds with err and a status matching its cause
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, manifest.ErrLocked):
+		status = http.StatusConflict
+	case errors.Is(err, encryption.ErrIncorrectPassword), errors.Is(err, encryption.ErrDecryptFailed):
+		status = http.StatusForbidden
+	case errors.Is(err, cloudstorage.ErrProviderUnavailable), errors.Is(err, cloudstorage.ErrAuthFailed):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}